@@ -1,19 +1,26 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"modular-erp/internal/core/config"
 	"modular-erp/internal/core/database"
+	"modular-erp/internal/core/events"
 	"modular-erp/internal/core/handlers"
+	"modular-erp/internal/core/jobs"
 	"modular-erp/internal/core/middleware"
+	"modular-erp/internal/core/notifications"
+	coreserver "modular-erp/internal/core/server"
+	"modular-erp/internal/core/webhooks"
 	"modular-erp/internal/modules/attendance"
 )
 
@@ -35,18 +42,28 @@ func main() {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
+	// Background workers stop when this context is cancelled on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Domain event bus; subscribers register before it starts dispatching
+	bus := events.NewBus(database.DB)
+
+	// Background job runner; modules register handlers and schedules with it
+	jobRunner := jobs.NewRunner(database.DB, cfg.Jobs.Workers)
+
 	// Create router
 	router := mux.NewRouter()
 
 	// Apply global middleware
-	router.Use(middleware.CORS)
+	router.Use(middleware.SecurityHeaders(cfg.Security))
+	router.Use(middleware.CORS(cfg.CORS))
+	router.Use(middleware.ClientIP(cfg.Server.TrustedProxies))
 
-	// Handle preflight OPTIONS requests globally
+	// Match preflight OPTIONS requests on any path so the CORS middleware,
+	// which answers them, runs even for routes without an OPTIONS method
 	router.Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(http.StatusNoContent)
 	})
 
 	// Health check endpoint
@@ -61,9 +78,30 @@ func main() {
 	router.HandleFunc("/api/auth/login", authHandler.Login).Methods("POST", "OPTIONS")
 	router.HandleFunc("/api/auth/register", authHandler.Register).Methods("POST", "OPTIONS")
 
+	// Company settings endpoints
+	handlers.RegisterCompanyRoutes(router, database.DB, cfg.JWT.Secret)
+
+	// Organization structure endpoints
+	handlers.RegisterOrgRoutes(router, database.DB, cfg.JWT.Secret)
+
+	// Client certificate identity endpoints
+	handlers.RegisterClientIdentityRoutes(router, database.DB, cfg.JWT.Secret)
+
+	// Outbound webhooks for domain events
+	webhookService := webhooks.NewService(database.DB)
+	webhookService.AllowPrivateNetworks = cfg.Webhooks.AllowPrivateNetworks
+	webhookService.Subscribe(bus)
+	webhooks.RegisterRoutes(router, webhookService, cfg.JWT.Secret)
+
+	// Background job admin endpoints
+	jobs.RegisterRoutes(router, database.DB, cfg.JWT.Secret)
+
+	// In-app notifications
+	notifications.RegisterRoutes(router, database.DB, cfg.JWT.Secret)
+
 	// Register module routes based on configuration
 	if cfg.Modules.Attendance {
-		attendance.RegisterRoutes(router, database.DB, cfg.JWT.Secret)
+		attendance.RegisterRoutes(router, database.DB, cfg.JWT.Secret, cfg.Attendance, jobRunner)
 		log.Println("✓ Attendance module enabled")
 	}
 
@@ -75,27 +113,98 @@ func main() {
 		log.Println("   - Attendance Management")
 	}
 
+	// Deliver outbox events once every subscriber is registered
+	go bus.Run(ctx)
+	go webhookService.Run(ctx)
+
+	jobsDone := make(chan struct{})
+	go func() {
+		jobRunner.Run(ctx)
+		close(jobsDone)
+	}()
+
 	server := &http.Server{
 		Addr:    addr,
 		Handler: router,
 	}
 
-	// Graceful shutdown
+	// Configure native TLS
+	var redirectServer *http.Server
+	stopReload := make(chan struct{})
+	if cfg.Server.TLS.Enabled() {
+		reloader, err := coreserver.NewCertReloader(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		server.TLSConfig, err = coreserver.NewTLSConfig(cfg.Server.TLS, reloader)
+		if err != nil {
+			log.Fatalf("Failed to configure TLS: %v", err)
+		}
+		if cfg.Server.TLS.ReloadInterval > 0 {
+			go reloader.Watch(time.Duration(cfg.Server.TLS.ReloadInterval)*time.Second, stopReload)
+		}
+		log.Printf("🔒 TLS enabled (minimum version %s)", cfg.Server.TLS.MinVersion)
+		if cfg.Server.TLS.ClientCAFile != "" {
+			log.Println("🔒 Client certificate authentication enabled")
+		}
+
+		if cfg.Server.TLS.RedirectHTTP {
+			redirectServer = &http.Server{
+				Addr:    fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.TLS.RedirectPort),
+				Handler: coreserver.RedirectHandler(cfg.Server.Port),
+			}
+			go func() {
+				log.Printf("↪ HTTP redirect listener on %s", redirectServer.Addr)
+				if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Printf("Redirect listener failed: %v", err)
+				}
+			}()
+		}
+	}
+
+	// Graceful shutdown: stop accepting requests, cancel background workers
+	// and wait for in-flight requests and jobs up to the shutdown timeout
+	shutdownComplete := make(chan struct{})
 	go func() {
+		defer close(shutdownComplete)
+
 		sigint := make(chan os.Signal, 1)
 		signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
 		<-sigint
 
 		log.Println("\n🛑 Shutting down server...")
-		if err := server.Close(); err != nil {
+		cancel()
+		close(stopReload)
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(),
+			time.Duration(cfg.Server.ShutdownTimeout)*time.Second)
+		defer shutdownCancel()
+
+		if redirectServer != nil {
+			redirectServer.Shutdown(shutdownCtx)
+		}
+		if err := server.Shutdown(shutdownCtx); err != nil {
 			log.Printf("Server shutdown error: %v", err)
 		}
+
+		select {
+		case <-jobsDone:
+		case <-shutdownCtx.Done():
+			log.Println("Timed out waiting for background jobs to stop")
+		}
 	}()
 
 	// Start listening
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if cfg.Server.TLS.Enabled() {
+		// Certificates come from TLSConfig.GetCertificate
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server failed to start: %v", err)
 	}
+	<-shutdownComplete
 
 	log.Println("✓ Server stopped gracefully")
 }
@@ -3,22 +3,53 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
+	"modular-erp/pkg/utils"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	JWT      JWTConfig
-	Modules  ModulesConfig
+	Server     ServerConfig
+	Database   DatabaseConfig
+	JWT        JWTConfig
+	CORS       CORSConfig
+	Security   SecurityConfig
+	Jobs       JobsConfig
+	Webhooks   WebhooksConfig
+	Attendance AttendanceConfig
+	Modules    ModulesConfig
 }
 
 // ServerConfig holds server-related configuration
 type ServerConfig struct {
-	Port string
-	Host string
+	Port            string
+	Host            string
+	ShutdownTimeout int // seconds to wait for requests and jobs to finish
+	// TrustedProxies lists the IPs or CIDR ranges of reverse proxies whose
+	// X-Forwarded-For header is used to find the client address
+	TrustedProxies []string
+	TLS            TLSConfig
+}
+
+// TLSConfig holds native TLS and client-certificate settings.
+// TLS is enabled when both CertFile and KeyFile are set.
+type TLSConfig struct {
+	CertFile       string
+	KeyFile        string
+	ReloadInterval int      // seconds between checks for changed cert/key files, 0 disables reloading
+	MinVersion     string   // "1.2" or "1.3"
+	CipherSuites   []string // IANA names, empty for Go's secure defaults
+	RedirectHTTP   bool     // serve an HTTP listener that redirects to HTTPS
+	RedirectPort   string
+	ClientCAFile   string // enables client-certificate authentication
+}
+
+// Enabled reports whether the server should serve HTTPS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
 }
 
 // DatabaseConfig holds database connection configuration
@@ -37,6 +68,46 @@ type JWTConfig struct {
 	Expiration int // in hours
 }
 
+// CORSConfig holds the cross-origin resource sharing policy
+type CORSConfig struct {
+	AllowedOrigins   []string // exact origins, "*" or wildcard subdomains like "https://*.example.com"
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int // preflight cache lifetime in seconds
+}
+
+// SecurityConfig holds security response header settings.
+// An empty value disables the corresponding header.
+type SecurityConfig struct {
+	HSTSMaxAge            int // in seconds, 0 disables HSTS
+	HSTSIncludeSubdomains bool
+	ContentSecurityPolicy string
+	FrameOptions          string
+	ReferrerPolicy        string
+}
+
+// JobsConfig holds background job runner configuration
+type JobsConfig struct {
+	Workers int
+}
+
+// WebhooksConfig holds outbound webhook settings
+type WebhooksConfig struct {
+	// AllowPrivateNetworks permits webhook URLs on loopback, private and
+	// link-local addresses; off by default to prevent server-side request forgery
+	AllowPrivateNetworks bool
+}
+
+// AttendanceConfig holds attendance module settings
+type AttendanceConfig struct {
+	// KioskCredentialKey keys the hashes of kiosk PINs and badge IDs; when
+	// empty a key is derived from the JWT secret, and rotating that secret
+	// invalidates every PIN and badge
+	KioskCredentialKey string
+}
+
 // ModulesConfig defines which modules are enabled
 type ModulesConfig struct {
 	Attendance bool
@@ -53,8 +124,20 @@ func Load() (*Config, error) {
 
 	config := &Config{
 		Server: ServerConfig{
-			Port: getEnv("SERVER_PORT", "8080"),
-			Host: getEnv("SERVER_HOST", "0.0.0.0"),
+			Port:            getEnv("SERVER_PORT", "8080"),
+			Host:            getEnv("SERVER_HOST", "0.0.0.0"),
+			ShutdownTimeout: getEnvInt("SERVER_SHUTDOWN_TIMEOUT", 30),
+			TrustedProxies:  getEnvList("TRUSTED_PROXIES", ""),
+			TLS: TLSConfig{
+				CertFile:       getEnv("TLS_CERT_FILE", ""),
+				KeyFile:        getEnv("TLS_KEY_FILE", ""),
+				ReloadInterval: getEnvInt("TLS_RELOAD_INTERVAL", 30),
+				MinVersion:     getEnv("TLS_MIN_VERSION", "1.2"),
+				CipherSuites:   getEnvList("TLS_CIPHER_SUITES", ""),
+				RedirectHTTP:   getEnvBool("TLS_REDIRECT_HTTP", false),
+				RedirectPort:   getEnv("TLS_REDIRECT_PORT", "8081"),
+				ClientCAFile:   getEnv("TLS_CLIENT_CA_FILE", ""),
+			},
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -68,12 +151,53 @@ func Load() (*Config, error) {
 			Secret:     getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
 			Expiration: 24, // 24 hours
 		},
+		CORS: CORSConfig{
+			AllowedOrigins:   getEnvList("CORS_ALLOWED_ORIGINS", "*"),
+			AllowedMethods:   getEnvList("CORS_ALLOWED_METHODS", "GET, POST, PUT, DELETE, OPTIONS"),
+			AllowedHeaders:   getEnvList("CORS_ALLOWED_HEADERS", "Content-Type, Authorization"),
+			ExposedHeaders:   getEnvList("CORS_EXPOSED_HEADERS", ""),
+			AllowCredentials: getEnvBool("CORS_ALLOW_CREDENTIALS", false),
+			MaxAge:           getEnvInt("CORS_MAX_AGE", 600),
+		},
+		Security: SecurityConfig{
+			HSTSMaxAge:            getEnvInt("SECURITY_HSTS_MAX_AGE", 31536000),
+			HSTSIncludeSubdomains: getEnvBool("SECURITY_HSTS_INCLUDE_SUBDOMAINS", true),
+			ContentSecurityPolicy: getEnvAllowEmpty("SECURITY_CSP", "default-src 'none'; frame-ancestors 'none'"),
+			FrameOptions:          getEnvAllowEmpty("SECURITY_FRAME_OPTIONS", "DENY"),
+			ReferrerPolicy:        getEnvAllowEmpty("SECURITY_REFERRER_POLICY", "no-referrer"),
+		},
+		Jobs: JobsConfig{
+			Workers: getEnvInt("JOBS_WORKERS", 4),
+		},
+		Webhooks: WebhooksConfig{
+			AllowPrivateNetworks: getEnvBool("WEBHOOKS_ALLOW_PRIVATE_NETWORKS", false),
+		},
+		Attendance: AttendanceConfig{
+			KioskCredentialKey: getEnv("KIOSK_CREDENTIAL_KEY", ""),
+		},
 		Modules: ModulesConfig{
 			Attendance: getEnv("MODULE_ATTENDANCE", "true") == "true",
 			// Add more modules as they're developed
 		},
 	}
 
+	if _, err := utils.ParseNetworks(config.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+
+	if config.Server.TLS.ReloadInterval < 0 {
+		return nil, fmt.Errorf("TLS_RELOAD_INTERVAL must be 0 (disabled) or a positive number of seconds")
+	}
+
+	// Any origin with credentials would let every site act as the signed-in user
+	if config.CORS.AllowCredentials {
+		for _, origin := range config.CORS.AllowedOrigins {
+			if origin == "*" {
+				return nil, fmt.Errorf("CORS_ALLOW_CREDENTIALS cannot be used with CORS_ALLOWED_ORIGINS=*; list the allowed origins instead")
+			}
+		}
+	}
+
 	return config, nil
 }
 
@@ -98,3 +222,41 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvAllowEmpty is like getEnv but keeps an explicitly empty value,
+// which lets settings such as security headers be switched off
+func getEnvAllowEmpty(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvInt gets an integer environment variable or returns a default value
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvBool gets a boolean environment variable or returns a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvList gets a comma-separated environment variable as a list
+func getEnvList(key, defaultValue string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
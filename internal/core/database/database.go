@@ -81,6 +81,583 @@ func RunMigrations() error {
 		`CREATE INDEX IF NOT EXISTS idx_shifts_company_id ON shifts(company_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_shifts_clock_in ON shifts(clock_in)`,
 		`CREATE INDEX IF NOT EXISTS idx_users_company_id ON users(company_id)`,
+
+		// Organization structure
+		`CREATE TABLE IF NOT EXISTS departments (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (company_id, name)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS teams (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			department_id INTEGER REFERENCES departments(id) ON DELETE SET NULL,
+			name VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (company_id, name)
+		)`,
+
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS department_id INTEGER REFERENCES departments(id) ON DELETE SET NULL`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS team_id INTEGER REFERENCES teams(id) ON DELETE SET NULL`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS manager_id INTEGER REFERENCES users(id) ON DELETE SET NULL`,
+
+		`CREATE INDEX IF NOT EXISTS idx_departments_company_id ON departments(company_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_teams_company_id ON teams(company_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_users_department_id ON users(department_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_users_manager_id ON users(manager_id)`,
+
+		// Client certificate identities for mutual TLS
+		`CREATE TABLE IF NOT EXISTS client_identities (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			kind VARCHAR(50) NOT NULL CHECK (kind IN ('device', 'service')),
+			fingerprint VARCHAR(64) UNIQUE NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			is_active BOOLEAN DEFAULT true,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Transactional outbox for domain events
+		`CREATE TABLE IF NOT EXISTS outbox (
+			id BIGSERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			event_name VARCHAR(100) NOT NULL,
+			payload JSONB NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			available_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			locked_until TIMESTAMP,
+			processed_at TIMESTAMP,
+			failed_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS outbox_deliveries (
+			outbox_id BIGINT REFERENCES outbox(id) ON DELETE CASCADE,
+			subscriber VARCHAR(100) NOT NULL,
+			delivered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (outbox_id, subscriber)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(available_at) WHERE processed_at IS NULL AND failed_at IS NULL`,
+
+		// Outbound webhooks
+		`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			event_types TEXT[] NOT NULL,
+			secret VARCHAR(255) NOT NULL,
+			description TEXT,
+			is_active BOOLEAN DEFAULT true,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id BIGSERIAL PRIMARY KEY,
+			subscription_id INTEGER REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			event_id BIGINT,
+			event_name VARCHAR(100) NOT NULL,
+			payload JSONB NOT NULL,
+			status VARCHAR(50) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'dead')),
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			locked_until TIMESTAMP,
+			last_status_code INTEGER,
+			last_error TEXT,
+			delivered_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (subscription_id, event_id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_company_id ON webhook_subscriptions(company_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending'`,
+
+		// Background jobs
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id BIGSERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			job_type VARCHAR(100) NOT NULL,
+			payload JSONB NOT NULL DEFAULT '{}',
+			status VARCHAR(50) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+			attempts INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL DEFAULT 5,
+			timeout_seconds INTEGER NOT NULL DEFAULT 300,
+			schedule_name VARCHAR(100),
+			run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			started_at TIMESTAMP,
+			finished_at TIMESTAMP,
+			last_error TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS job_schedules (
+			name VARCHAR(100) PRIMARY KEY,
+			cron_expr VARCHAR(100) NOT NULL,
+			job_type VARCHAR(100) NOT NULL,
+			payload JSONB NOT NULL DEFAULT '{}',
+			next_run_at TIMESTAMP NOT NULL,
+			last_run_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(run_at) WHERE status = 'pending'`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status)`,
+
+		// Company settings
+		`ALTER TABLE companies ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'UTC'`,
+
+		// In-app notifications
+		`CREATE TABLE IF NOT EXISTS notifications (
+			id BIGSERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			kind VARCHAR(100) NOT NULL,
+			title VARCHAR(255) NOT NULL,
+			body TEXT,
+			data JSONB NOT NULL DEFAULT '{}',
+			read_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id, created_at DESC)`,
+
+		// Attendance auto-close policy and review flags
+		`CREATE TABLE IF NOT EXISTS attendance_policies (
+			company_id INTEGER PRIMARY KEY REFERENCES companies(id) ON DELETE CASCADE,
+			auto_close_enabled BOOLEAN NOT NULL DEFAULT false,
+			auto_close_mode VARCHAR(50) NOT NULL DEFAULT 'after_hours' CHECK (auto_close_mode IN ('after_hours', 'fixed_time')),
+			auto_close_after_hours NUMERIC(5,2) NOT NULL DEFAULT 12,
+			auto_close_time VARCHAR(5) NOT NULL DEFAULT '23:59',
+			max_shift_hours NUMERIC(5,2) NOT NULL DEFAULT 16,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS auto_closed BOOLEAN NOT NULL DEFAULT false`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMP`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL`,
+
+		// Breaks within a shift
+		`CREATE TABLE IF NOT EXISTS shift_breaks (
+			id SERIAL PRIMARY KEY,
+			shift_id INTEGER REFERENCES shifts(id) ON DELETE CASCADE,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			break_type VARCHAR(50) NOT NULL CHECK (break_type IN ('paid', 'unpaid')),
+			start_time TIMESTAMP NOT NULL,
+			end_time TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CHECK (end_time IS NULL OR end_time >= start_time)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_shift_breaks_shift_id ON shift_breaks(shift_id, start_time)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_shift_breaks_open ON shift_breaks(shift_id) WHERE end_time IS NULL`,
+
+		// Audit trail of manager corrections to shifts
+		`CREATE TABLE IF NOT EXISTS shift_revisions (
+			id SERIAL PRIMARY KEY,
+			shift_id INTEGER REFERENCES shifts(id) ON DELETE CASCADE,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			action VARCHAR(50) NOT NULL CHECK (action IN ('create', 'update', 'cancel')),
+			reason_code VARCHAR(50) NOT NULL,
+			comment TEXT,
+			changed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			before_data JSONB,
+			after_data JSONB NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_shift_revisions_shift_id ON shift_revisions(shift_id, created_at)`,
+
+		// Employee missed-punch and correction requests
+		`CREATE TABLE IF NOT EXISTS punch_requests (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			shift_id INTEGER REFERENCES shifts(id) ON DELETE CASCADE,
+			clock_in TIMESTAMP NOT NULL,
+			clock_out TIMESTAMP,
+			reason_code VARCHAR(50) NOT NULL,
+			comment TEXT,
+			status VARCHAR(50) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected', 'withdrawn')),
+			reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			reviewed_at TIMESTAMP,
+			review_comment TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_punch_requests_company_status ON punch_requests(company_id, status)`,
+		`CREATE INDEX IF NOT EXISTS idx_punch_requests_user_id ON punch_requests(user_id)`,
+
+		// Work sites and geofenced punches
+		`CREATE TABLE IF NOT EXISTS work_sites (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			latitude DOUBLE PRECISION NOT NULL,
+			longitude DOUBLE PRECISION NOT NULL,
+			radius_meters INTEGER NOT NULL CHECK (radius_meters > 0),
+			is_active BOOLEAN NOT NULL DEFAULT true,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_work_sites_company_id ON work_sites(company_id)`,
+
+		`ALTER TABLE attendance_policies ADD COLUMN IF NOT EXISTS geofence_mode VARCHAR(50) NOT NULL DEFAULT 'off' CHECK (geofence_mode IN ('off', 'flag', 'block'))`,
+		`ALTER TABLE attendance_policies ADD COLUMN IF NOT EXISTS geofence_max_accuracy INTEGER NOT NULL DEFAULT 100`,
+
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS flags TEXT[] NOT NULL DEFAULT '{}'`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_in_latitude DOUBLE PRECISION`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_in_longitude DOUBLE PRECISION`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_in_accuracy DOUBLE PRECISION`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_in_site_id INTEGER REFERENCES work_sites(id) ON DELETE SET NULL`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_out_latitude DOUBLE PRECISION`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_out_longitude DOUBLE PRECISION`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_out_accuracy DOUBLE PRECISION`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_out_site_id INTEGER REFERENCES work_sites(id) ON DELETE SET NULL`,
+
+		// Network allowlist for punches
+		`ALTER TABLE attendance_policies ADD COLUMN IF NOT EXISTS network_mode VARCHAR(50) NOT NULL DEFAULT 'off' CHECK (network_mode IN ('off', 'flag', 'block'))`,
+		`ALTER TABLE attendance_policies ADD COLUMN IF NOT EXISTS allowed_networks TEXT[] NOT NULL DEFAULT '{}'`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_in_ip VARCHAR(45)`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_out_ip VARCHAR(45)`,
+
+		// Shared kiosks with PIN or badge punches
+		`CREATE TABLE IF NOT EXISTS kiosks (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			site_id INTEGER REFERENCES work_sites(id) ON DELETE SET NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			is_active BOOLEAN NOT NULL DEFAULT true,
+			failed_attempts INTEGER NOT NULL DEFAULT 0,
+			locked_until TIMESTAMP,
+			last_seen_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_kiosks_company_id ON kiosks(company_id)`,
+
+		`CREATE TABLE IF NOT EXISTS kiosk_credentials (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			kind VARCHAR(50) NOT NULL CHECK (kind IN ('pin', 'badge')),
+			credential_hash VARCHAR(64) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, kind),
+			UNIQUE (company_id, kind, credential_hash)
+		)`,
+
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_in_kiosk_id INTEGER REFERENCES kiosks(id) ON DELETE SET NULL`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_out_kiosk_id INTEGER REFERENCES kiosks(id) ON DELETE SET NULL`,
+
+		// Hardware time clocks posting badge events
+		`CREATE TABLE IF NOT EXISTS clock_devices (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			site_id INTEGER REFERENCES work_sites(id) ON DELETE SET NULL,
+			secret_hash VARCHAR(64) UNIQUE NOT NULL,
+			is_active BOOLEAN NOT NULL DEFAULT true,
+			last_seen_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_clock_devices_company_id ON clock_devices(company_id)`,
+
+		`CREATE TABLE IF NOT EXISTS device_events (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			device_id INTEGER REFERENCES clock_devices(id) ON DELETE CASCADE,
+			event_id VARCHAR(255) NOT NULL,
+			user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			occurred_at TIMESTAMP NOT NULL,
+			status VARCHAR(50) NOT NULL CHECK (status IN ('received', 'applied', 'ignored', 'unknown_badge')),
+			shift_id INTEGER REFERENCES shifts(id) ON DELETE SET NULL,
+			message TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (device_id, event_id)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_device_events_company_occurred ON device_events(company_id, occurred_at)`,
+
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_in_device_id INTEGER REFERENCES clock_devices(id) ON DELETE SET NULL`,
+		`ALTER TABLE shifts ADD COLUMN IF NOT EXISTS clock_out_device_id INTEGER REFERENCES clock_devices(id) ON DELETE SET NULL`,
+
+		// Shift scheduling
+		`CREATE TABLE IF NOT EXISTS scheduled_shifts (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			site_id INTEGER REFERENCES work_sites(id) ON DELETE SET NULL,
+			role VARCHAR(100),
+			start_time TIMESTAMP NOT NULL,
+			end_time TIMESTAMP NOT NULL,
+			notes TEXT,
+			status VARCHAR(50) NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'published', 'cancelled')),
+			published_at TIMESTAMP,
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CHECK (end_time > start_time)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_shifts_company_start ON scheduled_shifts(company_id, start_time)`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_shifts_user_start ON scheduled_shifts(user_id, start_time)`,
+
+		`ALTER TABLE attendance_policies ADD COLUMN IF NOT EXISTS lateness_grace_minutes INTEGER NOT NULL DEFAULT 5`,
+
+		`CREATE TABLE IF NOT EXISTS shift_templates (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			start_time TIME NOT NULL,
+			end_time TIME NOT NULL,
+			site_id INTEGER REFERENCES work_sites(id) ON DELETE SET NULL,
+			role VARCHAR(100),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS shift_rotations (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			days JSONB NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS company_holidays (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			holiday_date DATE NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (company_id, holiday_date)
+		)`,
+
+		`ALTER TABLE attendance_policies ADD COLUMN IF NOT EXISTS swap_approval_required BOOLEAN NOT NULL DEFAULT true`,
+		`ALTER TABLE attendance_policies ADD COLUMN IF NOT EXISTS max_weekly_hours DECIMAL(5,2) NOT NULL DEFAULT 0`,
+
+		`CREATE TABLE IF NOT EXISTS user_qualifications (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			role VARCHAR(100) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, role)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS shift_offers (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			scheduled_shift_id INTEGER REFERENCES scheduled_shifts(id) ON DELETE CASCADE,
+			offered_by INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			kind VARCHAR(50) NOT NULL CHECK (kind IN ('giveaway', 'swap')),
+			comment TEXT,
+			status VARCHAR(50) NOT NULL DEFAULT 'open'
+				CHECK (status IN ('open', 'pending_approval', 'approved', 'rejected', 'cancelled')),
+			claimed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			claimed_at TIMESTAMP,
+			swap_shift_id INTEGER REFERENCES scheduled_shifts(id) ON DELETE SET NULL,
+			reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			reviewed_at TIMESTAMP,
+			review_comment TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_shift_offers_company_status ON shift_offers(company_id, status)`,
+
+		`CREATE TABLE IF NOT EXISTS overtime_rules (
+			company_id INTEGER PRIMARY KEY REFERENCES companies(id) ON DELETE CASCADE,
+			daily_overtime_hours DECIMAL(5,2) NOT NULL DEFAULT 8,
+			daily_double_time_hours DECIMAL(5,2) NOT NULL DEFAULT 12,
+			weekly_overtime_hours DECIMAL(5,2) NOT NULL DEFAULT 40,
+			week_start_day INTEGER NOT NULL DEFAULT 1 CHECK (week_start_day BETWEEN 1 AND 7),
+			split_at_midnight BOOLEAN NOT NULL DEFAULT true,
+			overtime_multiplier DECIMAL(4,2) NOT NULL DEFAULT 1.5,
+			double_time_multiplier DECIMAL(4,2) NOT NULL DEFAULT 2,
+			holiday_multiplier DECIMAL(4,2) NOT NULL DEFAULT 2,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`ALTER TABLE attendance_policies ADD COLUMN IF NOT EXISTS pay_period VARCHAR(50) NOT NULL DEFAULT 'weekly' CHECK (pay_period IN ('weekly', 'biweekly', 'semimonthly', 'monthly'))`,
+		`ALTER TABLE attendance_policies ADD COLUMN IF NOT EXISTS pay_period_anchor VARCHAR(10) NOT NULL DEFAULT '2024-01-01'`,
+
+		`CREATE TABLE IF NOT EXISTS timesheets (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			period_start DATE NOT NULL,
+			period_end DATE NOT NULL,
+			status VARCHAR(50) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'submitted', 'approved', 'rejected')),
+			submitted_hours DECIMAL(8,2),
+			approved_hours DECIMAL(8,2),
+			comment TEXT,
+			submitted_at TIMESTAMP,
+			reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			reviewed_at TIMESTAMP,
+			review_comment TEXT,
+			reopened_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			reopened_at TIMESTAMP,
+			reopen_comment TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, period_start)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_timesheets_company_status ON timesheets(company_id, status)`,
+
+		`ALTER TABLE attendance_policies ADD COLUMN IF NOT EXISTS leave_hours_per_day DECIMAL(4,2) NOT NULL DEFAULT 8`,
+
+		`CREATE TABLE IF NOT EXISTS leave_types (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			paid BOOLEAN NOT NULL DEFAULT true,
+			accrual_method VARCHAR(50) NOT NULL DEFAULT 'none' CHECK (accrual_method IN ('none', 'manual', 'per_pay_period', 'per_hour_worked', 'annual_grant')),
+			accrual_rate DECIMAL(8,4) NOT NULL DEFAULT 0,
+			carry_over_cap DECIMAL(8,2),
+			allow_negative BOOLEAN NOT NULL DEFAULT false,
+			is_active BOOLEAN NOT NULL DEFAULT true,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (company_id, name)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS leave_requests (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			leave_type_id INTEGER NOT NULL REFERENCES leave_types(id),
+			start_date DATE NOT NULL,
+			end_date DATE NOT NULL,
+			hours DECIMAL(8,2) NOT NULL,
+			comment TEXT,
+			status VARCHAR(50) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected', 'cancelled')),
+			reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			reviewed_at TIMESTAMP,
+			review_comment TEXT,
+			cancelled_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			cancelled_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_leave_requests_company_status ON leave_requests(company_id, status)`,
+
+		`CREATE TABLE IF NOT EXISTS leave_days (
+			leave_request_id INTEGER REFERENCES leave_requests(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			leave_date DATE NOT NULL,
+			hours DECIMAL(6,2) NOT NULL,
+			PRIMARY KEY (leave_request_id, leave_date)
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_leave_days_user_date ON leave_days(user_id, leave_date)`,
+
+		`CREATE TABLE IF NOT EXISTS leave_ledger (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			leave_type_id INTEGER REFERENCES leave_types(id) ON DELETE CASCADE,
+			entry_date DATE NOT NULL,
+			kind VARCHAR(50) NOT NULL CHECK (kind IN ('accrual', 'grant', 'carry_over', 'usage', 'reversal', 'adjustment')),
+			hours DECIMAL(8,2) NOT NULL,
+			leave_request_id INTEGER REFERENCES leave_requests(id) ON DELETE SET NULL,
+			reference VARCHAR(50),
+			note TEXT,
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, leave_type_id, reference)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS holiday_calendars (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			country VARCHAR(2) NOT NULL DEFAULT '',
+			region VARCHAR(50) NOT NULL DEFAULT '',
+			description TEXT,
+			assigned BOOLEAN NOT NULL DEFAULT false,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (company_id, name)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS holiday_calendar_entries (
+			id SERIAL PRIMARY KEY,
+			calendar_id INTEGER NOT NULL REFERENCES holiday_calendars(id) ON DELETE CASCADE,
+			holiday_date DATE NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			source VARCHAR(20) NOT NULL DEFAULT 'manual' CHECK (source IN ('manual', 'ics')),
+			uid VARCHAR(255),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (calendar_id, holiday_date)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS projects (
+			id SERIAL PRIMARY KEY,
+			company_id INTEGER REFERENCES companies(id) ON DELETE CASCADE,
+			code VARCHAR(50) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			client VARCHAR(255) NOT NULL DEFAULT '',
+			description TEXT,
+			billable BOOLEAN NOT NULL DEFAULT true,
+			is_active BOOLEAN NOT NULL DEFAULT true,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (company_id, code)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS shift_allocations (
+			id SERIAL PRIMARY KEY,
+			shift_id INTEGER NOT NULL REFERENCES shifts(id) ON DELETE CASCADE,
+			project_id INTEGER NOT NULL REFERENCES projects(id),
+			start_time TIMESTAMP,
+			end_time TIMESTAMP,
+			hours DECIMAL(6,2),
+			created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CHECK ((start_time IS NOT NULL AND hours IS NULL) OR (start_time IS NULL AND end_time IS NULL AND hours IS NOT NULL))
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_shift_allocations_shift ON shift_allocations(shift_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_shift_allocations_project ON shift_allocations(project_id)`,
+
+		// Kiosk failures count within a window that starts at the first failure
+		`ALTER TABLE kiosks ADD COLUMN IF NOT EXISTS failures_since TIMESTAMP`,
+
+		// An employee has at most one shift in progress
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_shifts_user_in_progress ON shifts(user_id) WHERE status = 'in_progress'`,
+
+		// Time clocks may authenticate with a registered client certificate
+		`ALTER TABLE clock_devices ADD COLUMN IF NOT EXISTS client_identity_id INTEGER UNIQUE REFERENCES client_identities(id) ON DELETE SET NULL`,
+
+		// A shift has at most one pending correction request
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_punch_requests_shift_pending ON punch_requests(shift_id) WHERE status = 'pending'`,
 	}
 
 	for i, migration := range migrations {
@@ -0,0 +1,162 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Handler processes a delivered event. Delivery is at-least-once, so
+// handlers must tolerate seeing the same event more than once.
+type Handler func(ctx context.Context, env Envelope) error
+
+// subscription binds a named subscriber to an event
+type subscription struct {
+	name    string
+	handler Handler
+}
+
+// Bus dispatches outbox events to in-process subscribers
+type Bus struct {
+	db *sql.DB
+
+	mu          sync.RWMutex
+	subscribers map[string][]subscription
+
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	Lease        time.Duration // how long a claimed event is reserved for this dispatcher
+}
+
+// NewBus creates an event bus backed by the outbox table
+func NewBus(db *sql.DB) *Bus {
+	return &Bus{
+		db:           db,
+		subscribers:  make(map[string][]subscription),
+		PollInterval: time.Second,
+		BatchSize:    50,
+		MaxAttempts:  10,
+		Lease:        time.Minute,
+	}
+}
+
+// AllEvents subscribes a handler to every event name
+const AllEvents = "*"
+
+// Subscribe registers a handler for an event name, or AllEvents. The subscriber name must
+// be unique per event; it is used to avoid redelivering to subscribers that
+// already succeeded when another one fails.
+func (b *Bus) Subscribe(eventName, subscriber string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[eventName] = append(b.subscribers[eventName], subscription{name: subscriber, handler: handler})
+}
+
+// On registers a typed handler; the payload is decoded into T before the call
+func On[T Event](b *Bus, subscriber string, handler func(ctx context.Context, env Envelope, event T) error) {
+	var zero T
+	b.Subscribe(zero.EventName(), subscriber, func(ctx context.Context, env Envelope) error {
+		var event T
+		if err := env.Decode(&event); err != nil {
+			return fmt.Errorf("error decoding %s: %w", env.Name, err)
+		}
+		return handler(ctx, env, event)
+	})
+}
+
+// Run dispatches pending events until ctx is cancelled
+func (b *Bus) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.dispatchBatch(ctx); err != nil {
+				log.Printf("Event dispatch error: %v", err)
+			}
+		}
+	}
+}
+
+// dispatchBatch claims and delivers one batch of events
+func (b *Bus) dispatchBatch(ctx context.Context) error {
+	batch, err := claimBatch(b.db, b.BatchSize, b.Lease)
+	if err != nil {
+		return err
+	}
+
+	for _, env := range batch {
+		if ctx.Err() != nil {
+			// Leftover leases expire and the events are picked up again
+			return nil
+		}
+		if err := b.deliver(ctx, env); err != nil {
+			log.Printf("Event %d (%s) delivery error: %v", env.ID, env.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// deliver hands an event to every subscriber that has not yet handled it
+func (b *Bus) deliver(ctx context.Context, env Envelope) error {
+	b.mu.RLock()
+	subs := append(append([]subscription{}, b.subscribers[env.Name]...), b.subscribers[AllEvents]...)
+	b.mu.RUnlock()
+
+	delivered, err := deliveredSubscribers(b.db, env.ID)
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, sub := range subs {
+		if delivered[sub.name] {
+			continue
+		}
+		if err := safeCall(ctx, sub.handler, env); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", sub.name, err))
+			continue
+		}
+		if err := markDelivered(b.db, env.ID, sub.name); err != nil {
+			return err
+		}
+	}
+
+	if len(failures) == 0 {
+		return markProcessed(b.db, env.ID)
+	}
+
+	attempts := env.Attempts + 1
+	return markRetry(b.db, env.ID, attempts, b.MaxAttempts, backoff(attempts), strings.Join(failures, "; "))
+}
+
+// safeCall runs a handler, turning a panic into an error
+func safeCall(ctx context.Context, handler Handler, env Envelope) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, env)
+}
+
+// backoff returns an exponential retry delay capped at one hour
+func backoff(attempts int) time.Duration {
+	if attempts > 12 {
+		return time.Hour
+	}
+	delay := time.Duration(1<<uint(attempts)) * time.Second
+	if delay > time.Hour {
+		return time.Hour
+	}
+	return delay
+}
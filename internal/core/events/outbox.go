@@ -0,0 +1,140 @@
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Event is a domain event that can be written to the outbox
+type Event interface {
+	EventName() string
+}
+
+// Envelope is an event as stored in the outbox and handed to subscribers
+type Envelope struct {
+	ID         int64           `json:"id"`
+	Name       string          `json:"event"`
+	CompanyID  int             `json:"company_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempts   int             `json:"attempts"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// Decode unmarshals the event payload into v
+func (e Envelope) Decode(v interface{}) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// Publish writes an event to the outbox within the caller's transaction, so
+// it is only delivered if the state change it describes is committed
+func Publish(tx *sql.Tx, companyID int, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding event %s: %w", event.EventName(), err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO outbox (company_id, event_name, payload, available_at, created_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, companyID, event.EventName(), payload)
+	if err != nil {
+		return fmt.Errorf("error writing event %s to outbox: %w", event.EventName(), err)
+	}
+
+	return nil
+}
+
+// claimBatch leases up to limit pending events so concurrent dispatchers
+// (e.g. several server instances) never work on the same event at once
+func claimBatch(db *sql.DB, limit int, lease time.Duration) ([]Envelope, error) {
+	rows, err := db.Query(`
+		UPDATE outbox
+		SET locked_until = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE processed_at IS NULL AND failed_at IS NULL
+			  AND available_at <= CURRENT_TIMESTAMP
+			  AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, company_id, event_name, payload, attempts, created_at
+	`, limit, lease.Seconds())
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []Envelope
+	for rows.Next() {
+		var env Envelope
+		if err := rows.Scan(&env.ID, &env.CompanyID, &env.Name, &env.Payload, &env.Attempts, &env.OccurredAt); err != nil {
+			return nil, err
+		}
+		batch = append(batch, env)
+	}
+
+	return batch, rows.Err()
+}
+
+// deliveredSubscribers returns the subscribers that already handled an event
+func deliveredSubscribers(db *sql.DB, outboxID int64) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT subscriber FROM outbox_deliveries WHERE outbox_id = $1`, outboxID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	delivered := make(map[string]bool)
+	for rows.Next() {
+		var subscriber string
+		if err := rows.Scan(&subscriber); err != nil {
+			return nil, err
+		}
+		delivered[subscriber] = true
+	}
+
+	return delivered, rows.Err()
+}
+
+// markDelivered records that a subscriber handled an event
+func markDelivered(db *sql.DB, outboxID int64, subscriber string) error {
+	_, err := db.Exec(`
+		INSERT INTO outbox_deliveries (outbox_id, subscriber, delivered_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT DO NOTHING
+	`, outboxID, subscriber)
+	return err
+}
+
+// markProcessed completes an event once every subscriber handled it
+func markProcessed(db *sql.DB, outboxID int64) error {
+	_, err := db.Exec(`
+		UPDATE outbox SET processed_at = CURRENT_TIMESTAMP, locked_until = NULL WHERE id = $1
+	`, outboxID)
+	return err
+}
+
+// markRetry schedules another attempt, or gives up after maxAttempts
+func markRetry(db *sql.DB, outboxID int64, attempts, maxAttempts int, delay time.Duration, lastErr string) error {
+	if attempts >= maxAttempts {
+		_, err := db.Exec(`
+			UPDATE outbox
+			SET attempts = $1, last_error = $2, failed_at = CURRENT_TIMESTAMP, locked_until = NULL
+			WHERE id = $3
+		`, attempts, lastErr, outboxID)
+		return err
+	}
+
+	_, err := db.Exec(`
+		UPDATE outbox
+		SET attempts = $1, last_error = $2, locked_until = NULL,
+		    available_at = CURRENT_TIMESTAMP + $3 * INTERVAL '1 second'
+		WHERE id = $4
+	`, attempts, lastErr, delay.Seconds(), outboxID)
+	return err
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"modular-erp/internal/core/middleware"
+	"modular-erp/internal/core/models"
+	"modular-erp/pkg/utils"
+)
+
+// ClientIdentityHandler handles client certificate identity requests
+type ClientIdentityHandler struct {
+	db *sql.DB
+}
+
+// NewClientIdentityHandler creates a new client identity handler
+func NewClientIdentityHandler(db *sql.DB) *ClientIdentityHandler {
+	return &ClientIdentityHandler{db: db}
+}
+
+// ClientIdentityRequest represents a client certificate registration request
+type ClientIdentityRequest struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`        // device, service
+	Certificate string `json:"certificate"` // PEM encoded
+}
+
+// RegisterClientIdentityRoutes registers client certificate identity routes
+func RegisterClientIdentityRoutes(router *mux.Router, db *sql.DB, jwtSecret string) {
+	handler := NewClientIdentityHandler(db)
+
+	// Admin management of registered certificates
+	adminRouter := router.PathPrefix("/api/client-identities").Subrouter()
+	adminRouter.Use(middleware.AuthMiddleware(jwtSecret))
+	adminRouter.Use(middleware.RequireRole("admin"))
+	adminRouter.HandleFunc("", handler.List).Methods("GET", "OPTIONS")
+	adminRouter.HandleFunc("", handler.Create).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/{id}", handler.Revoke).Methods("DELETE", "OPTIONS")
+
+	// Lets a device or service verify its certificate is recognized
+	certRouter := router.PathPrefix("/api/client-auth").Subrouter()
+	certRouter.Use(middleware.ClientCertAuth(db))
+	certRouter.HandleFunc("/whoami", handler.WhoAmI).Methods("GET", "OPTIONS")
+}
+
+// List lists the company's client identities (admin only)
+func (h *ClientIdentityHandler) List(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	identities, err := models.GetClientIdentities(h.db, claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve client identities")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"client_identities": identities,
+		"count":             len(identities),
+	})
+}
+
+// Create registers a client certificate (admin only)
+func (h *ClientIdentityHandler) Create(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req ClientIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Name == "" || req.Certificate == "" {
+		respondWithError(w, http.StatusBadRequest, "Name and certificate are required")
+		return
+	}
+
+	identity, err := models.CreateClientIdentity(h.db, claims.CompanyID, req.Name, req.Kind, req.Certificate)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"client_identity": identity,
+	})
+}
+
+// Revoke deactivates a client certificate (admin only)
+func (h *ClientIdentityHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid client identity ID")
+		return
+	}
+
+	err = models.RevokeClientIdentity(h.db, claims.CompanyID, id)
+	if err == models.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Client identity not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke client identity")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Client identity revoked",
+	})
+}
+
+// WhoAmI returns the identity mapped from the presented client certificate
+func (h *ClientIdentityHandler) WhoAmI(w http.ResponseWriter, r *http.Request) {
+	identity, ok := r.Context().Value(middleware.ClientIdentityKey).(*models.ClientIdentity)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"client_identity": identity,
+	})
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"modular-erp/internal/core/middleware"
+	"modular-erp/internal/core/models"
+	"modular-erp/pkg/utils"
+)
+
+// CompanyHandler handles company settings requests
+type CompanyHandler struct {
+	db *sql.DB
+}
+
+// NewCompanyHandler creates a new company handler
+func NewCompanyHandler(db *sql.DB) *CompanyHandler {
+	return &CompanyHandler{db: db}
+}
+
+// CompanyRequest represents a company settings update
+type CompanyRequest struct {
+	Name     string `json:"name"`
+	Timezone string `json:"timezone"`
+}
+
+// RegisterCompanyRoutes registers company settings routes
+func RegisterCompanyRoutes(router *mux.Router, db *sql.DB, jwtSecret string) {
+	handler := NewCompanyHandler(db)
+
+	companyRouter := router.PathPrefix("/api/company").Subrouter()
+	companyRouter.Use(middleware.AuthMiddleware(jwtSecret))
+	companyRouter.HandleFunc("", handler.Get).Methods("GET", "OPTIONS")
+
+	adminRouter := companyRouter.PathPrefix("").Subrouter()
+	adminRouter.Use(middleware.RequireRole("admin"))
+	adminRouter.HandleFunc("", handler.Update).Methods("PUT", "OPTIONS")
+}
+
+// Get returns the caller's company
+func (h *CompanyHandler) Get(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	company, err := models.GetCompany(h.db, claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Company not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"company": company,
+	})
+}
+
+// Update changes the company's name and timezone (admin only)
+func (h *CompanyHandler) Update(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req CompanyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Company name is required")
+		return
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+
+	company, err := models.UpdateCompany(h.db, claims.CompanyID, req.Name, req.Timezone)
+	if err == models.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Company not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"company": company,
+	})
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"modular-erp/internal/core/middleware"
+	"modular-erp/internal/core/models"
+	"modular-erp/pkg/utils"
+)
+
+// OrgHandler handles organization structure requests
+type OrgHandler struct {
+	db *sql.DB
+}
+
+// NewOrgHandler creates a new organization handler
+func NewOrgHandler(db *sql.DB) *OrgHandler {
+	return &OrgHandler{db: db}
+}
+
+// DepartmentRequest represents a create/update department request
+type DepartmentRequest struct {
+	Name string `json:"name"`
+}
+
+// TeamRequest represents a create/update team request
+type TeamRequest struct {
+	Name         string `json:"name"`
+	DepartmentID *int   `json:"department_id"`
+}
+
+// RegisterOrgRoutes registers organization structure routes
+func RegisterOrgRoutes(router *mux.Router, db *sql.DB, jwtSecret string) {
+	handler := NewOrgHandler(db)
+
+	orgRouter := router.PathPrefix("/api/org").Subrouter()
+	orgRouter.Use(middleware.AuthMiddleware(jwtSecret))
+	orgRouter.Use(middleware.RequireRole("manager", "admin"))
+
+	// Read endpoints - managers see their own subtree
+	orgRouter.HandleFunc("/departments", handler.ListDepartments).Methods("GET", "OPTIONS")
+	orgRouter.HandleFunc("/teams", handler.ListTeams).Methods("GET", "OPTIONS")
+	orgRouter.HandleFunc("/users", handler.ListUsers).Methods("GET", "OPTIONS")
+	orgRouter.HandleFunc("/users/{id}/reports", handler.GetReports).Methods("GET", "OPTIONS")
+
+	// Maintenance endpoints - admin only
+	adminRouter := orgRouter.PathPrefix("").Subrouter()
+	adminRouter.Use(middleware.RequireRole("admin"))
+	adminRouter.HandleFunc("/departments", handler.CreateDepartment).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/departments/{id}", handler.UpdateDepartment).Methods("PUT", "OPTIONS")
+	adminRouter.HandleFunc("/departments/{id}", handler.DeleteDepartment).Methods("DELETE", "OPTIONS")
+	adminRouter.HandleFunc("/teams", handler.CreateTeam).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/teams/{id}", handler.UpdateTeam).Methods("PUT", "OPTIONS")
+	adminRouter.HandleFunc("/teams/{id}", handler.DeleteTeam).Methods("DELETE", "OPTIONS")
+	adminRouter.HandleFunc("/users/{id}", handler.AssignUser).Methods("PUT", "OPTIONS")
+}
+
+// ListDepartments lists the company's departments; managers only see
+// those of their reporting line
+func (h *OrgHandler) ListDepartments(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	visible, err := models.GetVisibleUserIDs(h.db, claims.UserID, claims.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to resolve reporting lines")
+		return
+	}
+
+	departments, err := models.GetDepartments(h.db, claims.CompanyID, visible)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve departments")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"departments": departments,
+		"count":       len(departments),
+	})
+}
+
+// CreateDepartment creates a department (admin only)
+func (h *OrgHandler) CreateDepartment(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req DepartmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Department name is required")
+		return
+	}
+
+	dept, err := models.CreateDepartment(h.db, claims.CompanyID, req.Name)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"department": dept,
+	})
+}
+
+// UpdateDepartment renames a department (admin only)
+func (h *OrgHandler) UpdateDepartment(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid department ID")
+		return
+	}
+
+	var req DepartmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Department name is required")
+		return
+	}
+
+	dept, err := models.UpdateDepartment(h.db, claims.CompanyID, id, req.Name)
+	if err == models.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Department not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"department": dept,
+	})
+}
+
+// DeleteDepartment deletes a department (admin only)
+func (h *OrgHandler) DeleteDepartment(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid department ID")
+		return
+	}
+
+	err = models.DeleteDepartment(h.db, claims.CompanyID, id)
+	if err == models.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Department not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete department")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Department deleted",
+	})
+}
+
+// ListTeams lists the company's teams; managers only see those of their
+// reporting line
+func (h *OrgHandler) ListTeams(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	visible, err := models.GetVisibleUserIDs(h.db, claims.UserID, claims.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to resolve reporting lines")
+		return
+	}
+
+	teams, err := models.GetTeams(h.db, claims.CompanyID, visible)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve teams")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"teams": teams,
+		"count": len(teams),
+	})
+}
+
+// CreateTeam creates a team (admin only)
+func (h *OrgHandler) CreateTeam(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req TeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Team name is required")
+		return
+	}
+
+	team, err := models.CreateTeam(h.db, claims.CompanyID, req.Name, req.DepartmentID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"team": team,
+	})
+}
+
+// UpdateTeam updates a team (admin only)
+func (h *OrgHandler) UpdateTeam(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid team ID")
+		return
+	}
+
+	var req TeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Team name is required")
+		return
+	}
+
+	team, err := models.UpdateTeam(h.db, claims.CompanyID, id, req.Name, req.DepartmentID)
+	if err == models.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Team not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"team": team,
+	})
+}
+
+// DeleteTeam deletes a team (admin only)
+func (h *OrgHandler) DeleteTeam(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid team ID")
+		return
+	}
+
+	err = models.DeleteTeam(h.db, claims.CompanyID, id)
+	if err == models.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Team not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete team")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Team deleted",
+	})
+}
+
+// ListUsers lists users visible to the caller with their org placement
+func (h *OrgHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	visible, err := models.GetVisibleUserIDs(h.db, claims.UserID, claims.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to resolve reporting lines")
+		return
+	}
+
+	users, err := models.GetCompanyUsers(h.db, claims.CompanyID, visible)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve users")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"users": users,
+		"count": len(users),
+	})
+}
+
+// GetReports lists everyone reporting to a user, directly or indirectly
+func (h *OrgHandler) GetReports(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	visible, err := models.GetVisibleUserIDs(h.db, claims.UserID, claims.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to resolve reporting lines")
+		return
+	}
+	if visible != nil && !containsID(visible, id) {
+		respondWithError(w, http.StatusForbidden, "User is outside your reporting line")
+		return
+	}
+
+	subtree, err := models.GetReportingSubtree(h.db, id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to resolve reporting lines")
+		return
+	}
+
+	// The subtree includes the user themselves; reports are everyone below
+	reportIDs := []int{}
+	for _, userID := range subtree {
+		if userID != id {
+			reportIDs = append(reportIDs, userID)
+		}
+	}
+
+	users, err := models.GetCompanyUsers(h.db, claims.CompanyID, reportIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve users")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"reports": users,
+		"count":   len(users),
+	})
+}
+
+// AssignUser sets a user's department, team and manager (admin only)
+func (h *OrgHandler) AssignUser(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req models.OrgAssignment
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	user, err := models.AssignUserOrg(h.db, claims.CompanyID, id, req)
+	if err == models.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"user": user,
+	})
+}
+
+// containsID reports whether id is present in ids
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"modular-erp/internal/core/middleware"
+	"modular-erp/pkg/utils"
+)
+
+// Handler handles HTTP requests for the job admin API
+type Handler struct {
+	db *sql.DB
+}
+
+// NewHandler creates a new job handler
+func NewHandler(db *sql.DB) *Handler {
+	return &Handler{db: db}
+}
+
+// ListJobs lists jobs, optionally filtered by status and type
+func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if limit == 0 {
+		limit = 50
+	}
+
+	jobs, err := GetJobs(h.db, claims.CompanyID, r.URL.Query().Get("status"), r.URL.Query().Get("type"), limit, offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve jobs")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"jobs":  jobs,
+		"count": len(jobs),
+	})
+}
+
+// GetJob retrieves a single job
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID")
+		return
+	}
+
+	job, err := GetJob(h.db, claims.CompanyID, id)
+	if err == ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve job")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"job": job,
+	})
+}
+
+// RetryJob requeues a failed job
+func (h *Handler) RetryJob(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID")
+		return
+	}
+
+	job, err := RetryJob(h.db, claims.CompanyID, id)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Job requeued",
+		"job":     job,
+	})
+}
+
+// Helper functions
+func respondWithError(w http.ResponseWriter, code int, message string) {
+	respondWithJSON(w, code, map[string]string{"error": message})
+}
+
+func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	response, _ := json.Marshal(payload)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(response)
+}
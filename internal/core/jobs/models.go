@@ -0,0 +1,339 @@
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Job is a unit of background work stored in the jobs table
+type Job struct {
+	ID             int64           `json:"id"`
+	CompanyID      *int            `json:"company_id,omitempty"`
+	Type           string          `json:"type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"` // pending, running, succeeded, failed
+	Attempts       int             `json:"attempts"`
+	MaxAttempts    int             `json:"max_attempts"`
+	TimeoutSeconds int             `json:"timeout_seconds"`
+	ScheduleName   *string         `json:"schedule_name,omitempty"`
+	RunAt          time.Time       `json:"run_at"`
+	StartedAt      *time.Time      `json:"started_at,omitempty"`
+	FinishedAt     *time.Time      `json:"finished_at,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// Decode unmarshals the job payload into v
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// EnqueueOptions controls how a job is queued; zero values use the defaults
+type EnqueueOptions struct {
+	CompanyID   int           // 0 for system-wide jobs
+	RunAt       time.Time     // zero runs as soon as possible
+	MaxAttempts int           // default 5
+	Timeout     time.Duration // default 5 minutes
+}
+
+// Querier is satisfied by *sql.DB and *sql.Tx, so jobs can be enqueued
+// atomically with the change that triggers them
+type Querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ErrNotFound is returned when a job does not exist
+var ErrNotFound = errors.New("job not found")
+
+const jobColumns = `id, company_id, job_type, payload, status, attempts, max_attempts, timeout_seconds,
+	schedule_name, run_at, started_at, finished_at, COALESCE(last_error, ''), created_at, updated_at`
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row scanner) (*Job, error) {
+	j := &Job{}
+	err := row.Scan(
+		&j.ID, &j.CompanyID, &j.Type, &j.Payload, &j.Status, &j.Attempts, &j.MaxAttempts, &j.TimeoutSeconds,
+		&j.ScheduleName, &j.RunAt, &j.StartedAt, &j.FinishedAt, &j.LastError, &j.CreatedAt, &j.UpdatedAt,
+	)
+	return j, err
+}
+
+// Enqueue adds a job to the queue
+func Enqueue(q Querier, jobType string, payload interface{}, opts EnqueueOptions) (*Job, error) {
+	return enqueue(q, jobType, payload, opts, nil)
+}
+
+func enqueue(q Querier, jobType string, payload interface{}, opts EnqueueOptions, scheduleName *string) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Minute
+	}
+	if opts.RunAt.IsZero() {
+		opts.RunAt = time.Now().UTC()
+	}
+
+	var companyID *int
+	if opts.CompanyID != 0 {
+		companyID = &opts.CompanyID
+	}
+
+	return scanJob(q.QueryRow(`
+		INSERT INTO jobs (company_id, job_type, payload, status, max_attempts, timeout_seconds,
+		                  schedule_name, run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, 'pending', $4, $5, $6, $7, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+jobColumns,
+		companyID, jobType, data, opts.MaxAttempts, int(opts.Timeout.Seconds()), scheduleName, opts.RunAt,
+	))
+}
+
+// claimJob locks the next due job and marks it running. SKIP LOCKED lets
+// any number of workers and server instances poll the queue concurrently.
+func claimJob(db *sql.DB, jobTypes []string) (*Job, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+		SELECT id FROM jobs
+		WHERE status = 'pending' AND run_at <= $1 AND job_type = ANY($2)
+		ORDER BY run_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, time.Now().UTC(), pq.Array(jobTypes)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := scanJob(tx.QueryRow(`
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, started_at = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		RETURNING `+jobColumns,
+		time.Now().UTC(), id,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	return job, tx.Commit()
+}
+
+// completeJob marks a job as succeeded
+func completeJob(db *sql.DB, id int64) error {
+	_, err := db.Exec(`
+		UPDATE jobs
+		SET status = 'succeeded', finished_at = $1, last_error = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`, time.Now().UTC(), id)
+	return err
+}
+
+// failJob schedules a retry, or marks the job failed once attempts are exhausted
+func failJob(db *sql.DB, job *Job, cause error) error {
+	if job.Attempts >= job.MaxAttempts {
+		_, err := db.Exec(`
+			UPDATE jobs
+			SET status = 'failed', finished_at = $1, last_error = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $3
+		`, time.Now().UTC(), cause.Error(), job.ID)
+		return err
+	}
+
+	_, err := db.Exec(`
+		UPDATE jobs
+		SET status = 'pending', run_at = $1, last_error = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, time.Now().UTC().Add(retryDelay(job.Attempts)), cause.Error(), job.ID)
+	return err
+}
+
+// releaseJob returns an interrupted job to the queue without using up an attempt
+func releaseJob(db *sql.DB, id int64) error {
+	_, err := db.Exec(`
+		UPDATE jobs
+		SET status = 'pending', attempts = GREATEST(attempts - 1, 0), started_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'running'
+	`, id)
+	return err
+}
+
+// requeueStaleJobs recovers jobs left running by a crashed process. A job is
+// stale once it has been running well past its own timeout.
+func requeueStaleJobs(db *sql.DB) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE jobs
+		SET status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'pending' END,
+		    last_error = 'worker stopped before the job finished',
+		    finished_at = CASE WHEN attempts >= max_attempts THEN $1::timestamp END,
+		    run_at = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE status = 'running'
+		  AND started_at + (timeout_seconds + 60) * INTERVAL '1 second' < $1
+	`, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetJobs lists a company's jobs. System-wide jobs are not listed, since
+// they belong to no tenant and may carry other companies' data.
+func GetJobs(db *sql.DB, companyID int, status, jobType string, limit, offset int) ([]Job, error) {
+	rows, err := db.Query(`
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE company_id = $1
+		  AND ($2 = '' OR status = $2)
+		  AND ($3 = '' OR job_type = $3)
+		ORDER BY id DESC
+		LIMIT $4 OFFSET $5
+	`, companyID, status, jobType, limit, offset)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// GetJob retrieves one of a company's jobs
+func GetJob(db *sql.DB, companyID int, id int64) (*Job, error) {
+	job, err := scanJob(db.QueryRow(`
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE id = $1 AND company_id = $2
+	`, id, companyID))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return job, err
+}
+
+// RetryJob requeues one of a company's failed jobs with a fresh attempt budget
+func RetryJob(db *sql.DB, companyID int, id int64) (*Job, error) {
+	job, err := scanJob(db.QueryRow(`
+		UPDATE jobs
+		SET status = 'pending', attempts = 0, run_at = $1, finished_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND company_id = $3 AND status = 'failed'
+		RETURNING `+jobColumns,
+		time.Now().UTC(), id, companyID,
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, errors.New("job not found or not in failed state")
+	}
+	return job, err
+}
+
+// upsertSchedule registers a recurring schedule. The next run time is kept
+// across restarts unless the cron expression changed.
+func upsertSchedule(db *sql.DB, name, cronExpr, jobType string, payload []byte, nextRun time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO job_schedules (name, cron_expr, job_type, payload, next_run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (name) DO UPDATE
+		SET job_type = EXCLUDED.job_type,
+		    payload = EXCLUDED.payload,
+		    next_run_at = CASE WHEN job_schedules.cron_expr = EXCLUDED.cron_expr
+		                       THEN job_schedules.next_run_at ELSE EXCLUDED.next_run_at END,
+		    cron_expr = EXCLUDED.cron_expr,
+		    updated_at = CURRENT_TIMESTAMP
+	`, name, cronExpr, jobType, payload, nextRun)
+	return err
+}
+
+// fireDueSchedules enqueues a job for every schedule whose time has come and
+// advances it to its next run. Missed runs (e.g. while the server was down)
+// collapse into a single job.
+func fireDueSchedules(db *sql.DB) (int, error) {
+	now := time.Now().UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT name, cron_expr, job_type, payload
+		FROM job_schedules
+		WHERE next_run_at <= $1
+		FOR UPDATE SKIP LOCKED
+	`, now)
+	if err != nil {
+		return 0, err
+	}
+
+	type due struct {
+		name, cronExpr, jobType string
+		payload                 json.RawMessage
+	}
+	var dueSchedules []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.name, &d.cronExpr, &d.jobType, &d.payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		dueSchedules = append(dueSchedules, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, d := range dueSchedules {
+		cron, err := ParseCron(d.cronExpr)
+		if err != nil {
+			return 0, fmt.Errorf("schedule %s: %w", d.name, err)
+		}
+
+		name := d.name
+		if _, err := enqueue(tx, d.jobType, d.payload, EnqueueOptions{}, &name); err != nil {
+			return 0, err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE job_schedules
+			SET next_run_at = $1, last_run_at = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE name = $3
+		`, cron.Next(now), now, d.name)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(dueSchedules), tx.Commit()
+}
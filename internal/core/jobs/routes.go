@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"database/sql"
+
+	"github.com/gorilla/mux"
+	"modular-erp/internal/core/middleware"
+)
+
+// RegisterRoutes registers the job admin routes (admin only). Admins see
+// their own company's jobs; system-wide jobs and schedules are not exposed.
+func RegisterRoutes(router *mux.Router, db *sql.DB, jwtSecret string) {
+	handler := NewHandler(db)
+
+	jobRouter := router.PathPrefix("/api/jobs").Subrouter()
+	jobRouter.Use(middleware.AuthMiddleware(jwtSecret))
+	jobRouter.Use(middleware.RequireRole("admin"))
+
+	jobRouter.HandleFunc("", handler.ListJobs).Methods("GET", "OPTIONS")
+	jobRouter.HandleFunc("/{id:[0-9]+}", handler.GetJob).Methods("GET", "OPTIONS")
+	jobRouter.HandleFunc("/{id:[0-9]+}/retry", handler.RetryJob).Methods("POST", "OPTIONS")
+}
@@ -0,0 +1,189 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// HandlerFunc runs a job. It must return promptly once ctx is cancelled,
+// which happens when the job times out or the server shuts down.
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+// Runner executes queued jobs and fires recurring schedules
+type Runner struct {
+	db *sql.DB
+
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+
+	Workers      int
+	PollInterval time.Duration
+}
+
+// NewRunner creates a job runner backed by the jobs table
+func NewRunner(db *sql.DB, workers int) *Runner {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Runner{
+		db:           db,
+		handlers:     make(map[string]HandlerFunc),
+		Workers:      workers,
+		PollInterval: time.Second,
+	}
+}
+
+// Register sets the handler for a job type. Only registered types are
+// claimed, so several services can share one queue.
+func (r *Runner) Register(jobType string, handler HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[jobType] = handler
+}
+
+// Schedule registers a recurring job using a cron expression (UTC)
+func (r *Runner) Schedule(name, cronExpr, jobType string, payload interface{}) error {
+	cron, err := ParseCron(cronExpr)
+	if err != nil {
+		return fmt.Errorf("schedule %s: %w", name, err)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return upsertSchedule(r.db, name, cronExpr, jobType, data, cron.Next(time.Now()))
+}
+
+// Run processes jobs until ctx is cancelled. In-flight jobs see their context
+// cancelled and are returned to the queue; Run returns once they have stopped.
+func (r *Runner) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < r.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.schedule(ctx)
+	}()
+
+	wg.Wait()
+}
+
+// work claims and runs jobs one at a time
+func (r *Runner) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := claimJob(r.db, r.jobTypes())
+		if err != nil {
+			log.Printf("Job claim error: %v", err)
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(r.PollInterval):
+			}
+			continue
+		}
+
+		r.execute(ctx, job)
+	}
+}
+
+// execute runs a claimed job under its timeout and records the outcome
+func (r *Runner) execute(ctx context.Context, job *Job) {
+	r.mu.RLock()
+	handler := r.handlers[job.Type]
+	r.mu.RUnlock()
+
+	jobCtx, cancel := context.WithTimeout(ctx, time.Duration(job.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	err := safeRun(jobCtx, handler, job)
+
+	switch {
+	case err == nil:
+		err = completeJob(r.db, job.ID)
+	case ctx.Err() != nil:
+		// Interrupted by shutdown rather than a failure of the job itself
+		log.Printf("Job %d (%s) interrupted by shutdown, requeued", job.ID, job.Type)
+		err = releaseJob(r.db, job.ID)
+	default:
+		if jobCtx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %ds: %w", job.TimeoutSeconds, err)
+		}
+		log.Printf("Job %d (%s) attempt %d failed: %v", job.ID, job.Type, job.Attempts, err)
+		err = failJob(r.db, job, err)
+	}
+
+	if err != nil {
+		log.Printf("Job %d status update error: %v", job.ID, err)
+	}
+}
+
+// schedule fires due cron schedules and recovers stale jobs
+func (r *Runner) schedule(ctx context.Context) {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if _, err := fireDueSchedules(r.db); err != nil {
+			log.Printf("Job schedule error: %v", err)
+		}
+		if n, err := requeueStaleJobs(r.db); err != nil {
+			log.Printf("Stale job recovery error: %v", err)
+		} else if n > 0 {
+			log.Printf("Recovered %d stale jobs", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// jobTypes lists the registered job types
+func (r *Runner) jobTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]string, 0, len(r.handlers))
+	for jobType := range r.handlers {
+		types = append(types, jobType)
+	}
+	return types
+}
+
+// safeRun calls a handler, turning a panic into an error
+func safeRun(ctx context.Context, handler HandlerFunc, job *Job) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// retryDelay returns the exponential backoff before the next attempt:
+// 10s, 20s, 40s, ... capped at one hour
+func retryDelay(attempts int) time.Duration {
+	delay := 10 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
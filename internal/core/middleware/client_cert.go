@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"modular-erp/internal/core/models"
+)
+
+const (
+	// ClientIdentityKey is the key for the client certificate identity in context
+	ClientIdentityKey ContextKey = "clientIdentity"
+)
+
+// ClientCertAuth requires a verified TLS client certificate registered as a
+// client identity of any kind
+func ClientCertAuth(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "OPTIONS" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// VerifiedChains is only populated once the TLS stack checked the
+			// certificate against the configured client CA
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				respondWithError(w, http.StatusUnauthorized, "Client certificate required")
+				return
+			}
+
+			fingerprint := models.CertificateFingerprint(r.TLS.VerifiedChains[0][0])
+			identity, err := models.GetClientIdentityByFingerprint(db, fingerprint)
+			if err != nil {
+				respondWithError(w, http.StatusUnauthorized, "Unknown client certificate")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ClientIdentityKey, identity)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
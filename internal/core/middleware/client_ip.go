@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"modular-erp/pkg/utils"
+)
+
+const (
+	// ClientIPKey is the key for the resolved client IP address in context
+	ClientIPKey ContextKey = "clientIP"
+)
+
+// ClientIP creates a middleware that resolves the address of the client
+// behind any trusted proxies. X-Forwarded-For is only believed when the
+// connection comes from a trusted proxy, and is read from the right, skipping
+// further trusted hops, so a client cannot spoof its address by sending the
+// header itself. Invalid trusted proxy entries are rejected by config.Load.
+func ClientIP(trustedProxies []string) func(http.Handler) http.Handler {
+	trusted, _ := utils.ParseNetworks(trustedProxies)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := resolveClientIP(r, trusted)
+			ctx := context.WithValue(r.Context(), ClientIPKey, ip)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetClientIP returns the client IP address resolved by the ClientIP middleware,
+// or the connection's remote address if the middleware did not run
+func GetClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(ClientIPKey).(string); ok {
+		return ip
+	}
+	return resolveClientIP(r, nil)
+}
+
+func resolveClientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if remote == nil {
+		return host
+	}
+	if !utils.NetworksContain(trusted, remote) {
+		return remote.String()
+	}
+
+	// Walk the hops right to left; the first one not added by a trusted
+	// proxy is the client
+	client := remote
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !utils.NetworksContain(trusted, ip) {
+			break
+		}
+	}
+
+	return client.String()
+}
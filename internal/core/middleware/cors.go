@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"modular-erp/internal/core/config"
+)
+
+// CORS creates a middleware that applies the configured cross-origin policy.
+// Preflight requests are answered directly and never reach the next handler.
+func CORS(cfg config.CORSConfig) func(http.Handler) http.Handler {
+	allowMethods := strings.Join(cfg.AllowedMethods, ", ")
+	allowHeaders := strings.Join(cfg.AllowedHeaders, ", ")
+	exposeHeaders := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(cfg.MaxAge)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+
+			// Not a cross-origin request
+			if origin == "" {
+				if r.Method == "OPTIONS" {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+
+			if !originAllowed(cfg.AllowedOrigins, origin) {
+				if preflight {
+					respondWithError(w, http.StatusForbidden, "Origin not allowed")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Credentials are never allowed together with "*"; config.Load rejects it
+			if containsOrigin(cfg.AllowedOrigins, "*") {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials && !containsOrigin(cfg.AllowedOrigins, "*") {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if r.Method == "OPTIONS" {
+				if preflight {
+					w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+					w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+					if cfg.MaxAge > 0 {
+						w.Header().Set("Access-Control-Max-Age", maxAge)
+					}
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if exposeHeaders != "" {
+				w.Header().Set("Access-Control-Expose-Headers", exposeHeaders)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// originAllowed checks an origin against the allowed list.
+// Entries may be "*", an exact origin, or a wildcard subdomain such as
+// "https://*.example.com", which matches any subdomain but not the apex.
+func originAllowed(allowed []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+
+		scheme, host, ok := strings.Cut(pattern, "://*.")
+		if !ok {
+			continue
+		}
+		prefix := scheme + "://"
+		if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, "."+host) &&
+			len(origin) > len(prefix)+len(host)+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// containsOrigin reports whether the allowed list contains an exact entry
+func containsOrigin(allowed []string, entry string) bool {
+	for _, v := range allowed {
+		if v == entry {
+			return true
+		}
+	}
+	return false
+}
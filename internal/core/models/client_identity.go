@@ -0,0 +1,139 @@
+package models
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"time"
+)
+
+// ClientIdentity maps a client certificate to a device or service
+type ClientIdentity struct {
+	ID          int       `json:"id"`
+	CompanyID   int       `json:"company_id"`
+	Name        string    `json:"name"`
+	Kind        string    `json:"kind"` // device, service
+	Fingerprint string    `json:"fingerprint"`
+	Subject     string    `json:"subject"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	IsActive    bool      `json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// CertificateFingerprint returns the hex SHA-256 digest of a DER certificate
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateClientIdentity registers a PEM-encoded client certificate
+func CreateClientIdentity(db *sql.DB, companyID int, name, kind, certPEM string) (*ClientIdentity, error) {
+	if kind != "device" && kind != "service" {
+		return nil, errors.New("kind must be device or service")
+	}
+
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("certificate must be PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.New("invalid certificate")
+	}
+
+	identity := &ClientIdentity{
+		CompanyID:   companyID,
+		Name:        name,
+		Kind:        kind,
+		Fingerprint: CertificateFingerprint(cert),
+		Subject:     cert.Subject.String(),
+		ExpiresAt:   cert.NotAfter,
+		IsActive:    true,
+	}
+
+	err = db.QueryRow(`
+		INSERT INTO client_identities (company_id, name, kind, fingerprint, subject, expires_at, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, created_at, updated_at
+	`, identity.CompanyID, identity.Name, identity.Kind, identity.Fingerprint, identity.Subject, identity.ExpiresAt).Scan(
+		&identity.ID, &identity.CreatedAt, &identity.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, errors.New("certificate is already registered")
+	}
+
+	return identity, nil
+}
+
+// GetClientIdentities retrieves all client identities for a company
+func GetClientIdentities(db *sql.DB, companyID int) ([]ClientIdentity, error) {
+	rows, err := db.Query(`
+		SELECT id, company_id, name, kind, fingerprint, subject, expires_at, is_active, created_at, updated_at
+		FROM client_identities
+		WHERE company_id = $1
+		ORDER BY name
+	`, companyID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []ClientIdentity{}
+	for rows.Next() {
+		var identity ClientIdentity
+		err := rows.Scan(
+			&identity.ID, &identity.CompanyID, &identity.Name, &identity.Kind, &identity.Fingerprint,
+			&identity.Subject, &identity.ExpiresAt, &identity.IsActive, &identity.CreatedAt, &identity.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// GetClientIdentityByFingerprint retrieves an active client identity
+func GetClientIdentityByFingerprint(db *sql.DB, fingerprint string) (*ClientIdentity, error) {
+	identity := &ClientIdentity{}
+	err := db.QueryRow(`
+		SELECT id, company_id, name, kind, fingerprint, subject, expires_at, is_active, created_at, updated_at
+		FROM client_identities
+		WHERE fingerprint = $1 AND is_active = true
+	`, fingerprint).Scan(
+		&identity.ID, &identity.CompanyID, &identity.Name, &identity.Kind, &identity.Fingerprint,
+		&identity.Subject, &identity.ExpiresAt, &identity.IsActive, &identity.CreatedAt, &identity.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// RevokeClientIdentity deactivates a client identity
+func RevokeClientIdentity(db *sql.DB, companyID, id int) error {
+	result, err := db.Exec(`
+		UPDATE client_identities
+		SET is_active = false, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND company_id = $2
+	`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// GetCompany retrieves a company by ID
+func GetCompany(db *sql.DB, id int) (*Company, error) {
+	company := &Company{}
+	err := db.QueryRow(`
+		SELECT id, name, timezone, created_at, updated_at
+		FROM companies WHERE id = $1
+	`, id).Scan(&company.ID, &company.Name, &company.Timezone, &company.CreatedAt, &company.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return company, nil
+}
+
+// UpdateCompany updates a company's name and timezone
+func UpdateCompany(db *sql.DB, id int, name, timezone string) (*Company, error) {
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "" {
+		return nil, errors.New("invalid timezone")
+	}
+
+	company := &Company{}
+	err := db.QueryRow(`
+		UPDATE companies
+		SET name = $1, timezone = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		RETURNING id, name, timezone, created_at, updated_at
+	`, name, timezone, id).Scan(&company.ID, &company.Name, &company.Timezone, &company.CreatedAt, &company.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return company, nil
+}
+
+// GetCompanyLocation returns the company's time zone, falling back to UTC
+func GetCompanyLocation(db *sql.DB, companyID int) *time.Location {
+	var timezone string
+	err := db.QueryRow(`SELECT timezone FROM companies WHERE id = $1`, companyID).Scan(&timezone)
+	if err != nil {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,368 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Department represents a department within a company
+type Department struct {
+	ID        int       `json:"id"`
+	CompanyID int       `json:"company_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Team represents a team, optionally belonging to a department
+type Team struct {
+	ID           int       `json:"id"`
+	CompanyID    int       `json:"company_id"`
+	DepartmentID *int      `json:"department_id,omitempty"`
+	Name         string    `json:"name"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// OrgAssignment describes where a user sits in the organization
+type OrgAssignment struct {
+	DepartmentID *int `json:"department_id"`
+	TeamID       *int `json:"team_id"`
+	ManagerID    *int `json:"manager_id"`
+}
+
+// ErrNotFound is returned when a record does not exist within the company
+var ErrNotFound = errors.New("record not found")
+
+// CreateDepartment creates a new department
+func CreateDepartment(db *sql.DB, companyID int, name string) (*Department, error) {
+	dept := &Department{CompanyID: companyID, Name: name}
+	err := db.QueryRow(`
+		INSERT INTO departments (company_id, name, created_at, updated_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, created_at, updated_at
+	`, companyID, name).Scan(&dept.ID, &dept.CreatedAt, &dept.UpdatedAt)
+
+	if err != nil {
+		return nil, errors.New("department with this name already exists")
+	}
+
+	return dept, nil
+}
+
+// GetDepartments retrieves the departments of a company. When userIDs is not
+// nil only the departments those users belong to, directly or through their
+// team, are returned.
+func GetDepartments(db *sql.DB, companyID int, userIDs []int) ([]Department, error) {
+	rows, err := db.Query(`
+		SELECT d.id, d.company_id, d.name, d.created_at, d.updated_at
+		FROM departments d
+		WHERE d.company_id = $1
+		  AND ($2::int[] IS NULL OR EXISTS (
+		      SELECT 1 FROM users u
+		      LEFT JOIN teams t ON t.id = u.team_id
+		      WHERE u.id = ANY($2)
+		        AND (u.department_id = d.id OR t.department_id = d.id)
+		  ))
+		ORDER BY d.name
+	`, companyID, pq.Array(userIDs))
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	departments := []Department{}
+	for rows.Next() {
+		var dept Department
+		if err := rows.Scan(&dept.ID, &dept.CompanyID, &dept.Name, &dept.CreatedAt, &dept.UpdatedAt); err != nil {
+			return nil, err
+		}
+		departments = append(departments, dept)
+	}
+
+	return departments, rows.Err()
+}
+
+// UpdateDepartment renames a department
+func UpdateDepartment(db *sql.DB, companyID, id int, name string) (*Department, error) {
+	dept := &Department{}
+	err := db.QueryRow(`
+		UPDATE departments
+		SET name = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND company_id = $3
+		RETURNING id, company_id, name, created_at, updated_at
+	`, name, id, companyID).Scan(&dept.ID, &dept.CompanyID, &dept.Name, &dept.CreatedAt, &dept.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.New("department with this name already exists")
+	}
+
+	return dept, nil
+}
+
+// DeleteDepartment removes a department; its teams and users are detached
+func DeleteDepartment(db *sql.DB, companyID, id int) error {
+	result, err := db.Exec(`DELETE FROM departments WHERE id = $1 AND company_id = $2`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateTeam creates a new team
+func CreateTeam(db *sql.DB, companyID int, name string, departmentID *int) (*Team, error) {
+	if departmentID != nil {
+		if err := checkDepartment(db, companyID, *departmentID); err != nil {
+			return nil, err
+		}
+	}
+
+	team := &Team{CompanyID: companyID, Name: name, DepartmentID: departmentID}
+	err := db.QueryRow(`
+		INSERT INTO teams (company_id, department_id, name, created_at, updated_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id, created_at, updated_at
+	`, companyID, departmentID, name).Scan(&team.ID, &team.CreatedAt, &team.UpdatedAt)
+
+	if err != nil {
+		return nil, errors.New("team with this name already exists")
+	}
+
+	return team, nil
+}
+
+// GetTeams retrieves the teams of a company. When userIDs is not nil only
+// the teams those users belong to are returned.
+func GetTeams(db *sql.DB, companyID int, userIDs []int) ([]Team, error) {
+	rows, err := db.Query(`
+		SELECT t.id, t.company_id, t.department_id, t.name, t.created_at, t.updated_at
+		FROM teams t
+		WHERE t.company_id = $1
+		  AND ($2::int[] IS NULL OR EXISTS (
+		      SELECT 1 FROM users u WHERE u.id = ANY($2) AND u.team_id = t.id
+		  ))
+		ORDER BY t.name
+	`, companyID, pq.Array(userIDs))
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := []Team{}
+	for rows.Next() {
+		var team Team
+		err := rows.Scan(&team.ID, &team.CompanyID, &team.DepartmentID, &team.Name, &team.CreatedAt, &team.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+
+	return teams, rows.Err()
+}
+
+// UpdateTeam renames a team and moves it to another department
+func UpdateTeam(db *sql.DB, companyID, id int, name string, departmentID *int) (*Team, error) {
+	if departmentID != nil {
+		if err := checkDepartment(db, companyID, *departmentID); err != nil {
+			return nil, err
+		}
+	}
+
+	team := &Team{}
+	err := db.QueryRow(`
+		UPDATE teams
+		SET name = $1, department_id = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND company_id = $4
+		RETURNING id, company_id, department_id, name, created_at, updated_at
+	`, name, departmentID, id, companyID).Scan(
+		&team.ID, &team.CompanyID, &team.DepartmentID, &team.Name, &team.CreatedAt, &team.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.New("team with this name already exists")
+	}
+
+	return team, nil
+}
+
+// DeleteTeam removes a team; its members are detached
+func DeleteTeam(db *sql.DB, companyID, id int) error {
+	result, err := db.Exec(`DELETE FROM teams WHERE id = $1 AND company_id = $2`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetCompanyUsers retrieves active users of a company, optionally limited to the given IDs
+func GetCompanyUsers(db *sql.DB, companyID int, userIDs []int) ([]User, error) {
+	rows, err := db.Query(`
+		SELECT id, company_id, username, email, full_name, role,
+		       department_id, team_id, manager_id, is_active, created_at, updated_at
+		FROM users
+		WHERE company_id = $1 AND is_active = true
+		  AND ($2::int[] IS NULL OR id = ANY($2))
+		ORDER BY full_name
+	`, companyID, pq.Array(userIDs))
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID, &user.CompanyID, &user.Username, &user.Email, &user.FullName, &user.Role,
+			&user.DepartmentID, &user.TeamID, &user.ManagerID, &user.IsActive, &user.CreatedAt, &user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// AssignUserOrg sets a user's department, team and manager.
+// A team's department takes precedence when no department is given.
+func AssignUserOrg(db *sql.DB, companyID, userID int, a OrgAssignment) (*User, error) {
+	user, err := GetUserByID(db, userID)
+	if err != nil || user.CompanyID != companyID {
+		return nil, ErrNotFound
+	}
+
+	if a.DepartmentID != nil {
+		if err := checkDepartment(db, companyID, *a.DepartmentID); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.TeamID != nil {
+		var teamDept *int
+		err := db.QueryRow(`
+			SELECT department_id FROM teams WHERE id = $1 AND company_id = $2
+		`, *a.TeamID, companyID).Scan(&teamDept)
+		if err == sql.ErrNoRows {
+			return nil, errors.New("team not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if a.DepartmentID == nil {
+			a.DepartmentID = teamDept
+		} else if teamDept != nil && *teamDept != *a.DepartmentID {
+			return nil, errors.New("team does not belong to the given department")
+		}
+	}
+
+	if a.ManagerID != nil {
+		if *a.ManagerID == userID {
+			return nil, errors.New("user cannot be their own manager")
+		}
+		manager, err := GetUserByID(db, *a.ManagerID)
+		if err != nil || manager.CompanyID != companyID {
+			return nil, errors.New("manager not found")
+		}
+		if manager.Role == "employee" {
+			return nil, errors.New("manager must have the manager or admin role")
+		}
+
+		// Reject cycles: the new manager must not report to this user
+		subtree, err := GetReportingSubtree(db, userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range subtree {
+			if id == *a.ManagerID {
+				return nil, errors.New("assignment would create a reporting cycle")
+			}
+		}
+	}
+
+	_, err = db.Exec(`
+		UPDATE users
+		SET department_id = $1, team_id = $2, manager_id = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4 AND company_id = $5
+	`, a.DepartmentID, a.TeamID, a.ManagerID, userID, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetUserByID(db, userID)
+}
+
+// GetReportingSubtree returns the IDs of a user and everyone who reports
+// to them directly or indirectly
+func GetReportingSubtree(db *sql.DB, userID int) ([]int, error) {
+	rows, err := db.Query(`
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM users WHERE id = $1
+			UNION
+			SELECT u.id FROM users u
+			JOIN subtree st ON u.manager_id = st.id
+		)
+		SELECT id FROM subtree
+	`, userID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// checkDepartment verifies that a department exists within the company
+func checkDepartment(db *sql.DB, companyID, departmentID int) error {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM departments WHERE id = $1 AND company_id = $2)
+	`, departmentID, companyID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("department not found")
+	}
+	return nil
+}
+
+// GetVisibleUserIDs returns the users whose records the given user may see.
+// Admins see the whole company, signalled by a nil slice; everyone else is
+// limited to their own reporting subtree.
+func GetVisibleUserIDs(db *sql.DB, userID int, role string) ([]int, error) {
+	if role == "admin" {
+		return nil, nil
+	}
+	return GetReportingSubtree(db, userID)
+}
@@ -17,6 +17,9 @@ type User struct {
 	PasswordHash string    `json:"-"` // Never send password hash in JSON
 	FullName     string    `json:"full_name"`
 	Role         string    `json:"role"` // admin, manager, employee
+	DepartmentID *int      `json:"department_id,omitempty"`
+	TeamID       *int      `json:"team_id,omitempty"`
+	ManagerID    *int      `json:"manager_id,omitempty"`
 	IsActive     bool      `json:"is_active"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
@@ -26,6 +29,7 @@ type User struct {
 type Company struct {
 	ID        int       `json:"id"`
 	Name      string    `json:"name"`
+	Timezone  string    `json:"timezone"` // IANA name, e.g. "Europe/Berlin"
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -56,11 +60,13 @@ func ValidateRole(role string) bool {
 func GetUserByUsername(db *sql.DB, username string) (*User, error) {
 	user := &User{}
 	err := db.QueryRow(`
-		SELECT id, company_id, username, email, password_hash, full_name, role, is_active, created_at, updated_at
+		SELECT id, company_id, username, email, password_hash, full_name, role,
+		       department_id, team_id, manager_id, is_active, created_at, updated_at
 		FROM users WHERE username = $1 AND is_active = true
 	`, username).Scan(
 		&user.ID, &user.CompanyID, &user.Username, &user.Email, &user.PasswordHash,
-		&user.FullName, &user.Role, &user.IsActive, &user.CreatedAt, &user.UpdatedAt,
+		&user.FullName, &user.Role, &user.DepartmentID, &user.TeamID, &user.ManagerID,
+		&user.IsActive, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -77,11 +83,13 @@ func GetUserByUsername(db *sql.DB, username string) (*User, error) {
 func GetUserByID(db *sql.DB, id int) (*User, error) {
 	user := &User{}
 	err := db.QueryRow(`
-		SELECT id, company_id, username, email, password_hash, full_name, role, is_active, created_at, updated_at
+		SELECT id, company_id, username, email, password_hash, full_name, role,
+		       department_id, team_id, manager_id, is_active, created_at, updated_at
 		FROM users WHERE id = $1 AND is_active = true
 	`, id).Scan(
 		&user.ID, &user.CompanyID, &user.Username, &user.Email, &user.PasswordHash,
-		&user.FullName, &user.Role, &user.IsActive, &user.CreatedAt, &user.UpdatedAt,
+		&user.FullName, &user.Role, &user.DepartmentID, &user.TeamID, &user.ManagerID,
+		&user.IsActive, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -0,0 +1,116 @@
+package notifications
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"modular-erp/internal/core/middleware"
+	"modular-erp/pkg/utils"
+)
+
+// Handler handles HTTP requests for notifications
+type Handler struct {
+	db *sql.DB
+}
+
+// NewHandler creates a new notification handler
+func NewHandler(db *sql.DB) *Handler {
+	return &Handler{db: db}
+}
+
+// List returns the authenticated user's notifications
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if limit == 0 {
+		limit = 50
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	notifications, err := GetUserNotifications(h.db, claims.UserID, unreadOnly, limit, offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve notifications")
+		return
+	}
+
+	unread, err := CountUnread(h.db, claims.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve notifications")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"notifications": notifications,
+		"count":         len(notifications),
+		"unread":        unread,
+	})
+}
+
+// Read marks a notification as read
+func (h *Handler) Read(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid notification ID")
+		return
+	}
+
+	updated, err := MarkRead(h.db, claims.UserID, id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update notification")
+		return
+	}
+	if !updated {
+		respondWithError(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Notification marked as read",
+	})
+}
+
+// ReadAll marks all of the user's notifications as read
+func (h *Handler) ReadAll(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := MarkAllRead(h.db, claims.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update notifications")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "All notifications marked as read",
+	})
+}
+
+// Helper functions
+func respondWithError(w http.ResponseWriter, code int, message string) {
+	respondWithJSON(w, code, map[string]string{"error": message})
+}
+
+func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	response, _ := json.Marshal(payload)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(response)
+}
@@ -0,0 +1,121 @@
+package notifications
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Notification is an in-app message for a user
+type Notification struct {
+	ID        int64           `json:"id"`
+	CompanyID int             `json:"company_id"`
+	UserID    int             `json:"user_id"`
+	Kind      string          `json:"kind"`
+	Title     string          `json:"title"`
+	Body      string          `json:"body,omitempty"`
+	Data      json.RawMessage `json:"data"`
+	ReadAt    *time.Time      `json:"read_at,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Execer is satisfied by *sql.DB and *sql.Tx, so notifications can be
+// written atomically with the change they describe
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Notify creates a notification for a user
+func Notify(q Execer, companyID, userID int, kind, title, body string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		payload = []byte("{}")
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO notifications (company_id, user_id, kind, title, body, data, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+	`, companyID, userID, kind, title, body, payload)
+	return err
+}
+
+// NotifyManager creates a notification for a user's manager, if they have one
+func NotifyManager(q Execer, companyID, userID int, kind, title, body string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		payload = []byte("{}")
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO notifications (company_id, user_id, kind, title, body, data, created_at)
+		SELECT company_id, manager_id, $3, $4, $5, $6, CURRENT_TIMESTAMP
+		FROM users
+		WHERE id = $2 AND company_id = $1 AND manager_id IS NOT NULL
+	`, companyID, userID, kind, title, body, payload)
+	return err
+}
+
+// GetUserNotifications retrieves a user's notifications, newest first
+func GetUserNotifications(db *sql.DB, userID int, unreadOnly bool, limit, offset int) ([]Notification, error) {
+	rows, err := db.Query(`
+		SELECT id, company_id, user_id, kind, title, COALESCE(body, ''), data, read_at, created_at
+		FROM notifications
+		WHERE user_id = $1 AND (NOT $2 OR read_at IS NULL)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`, userID, unreadOnly, limit, offset)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		err := rows.Scan(&n.ID, &n.CompanyID, &n.UserID, &n.Kind, &n.Title, &n.Body, &n.Data, &n.ReadAt, &n.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, rows.Err()
+}
+
+// CountUnread returns the number of unread notifications for a user
+func CountUnread(db *sql.DB, userID int) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL
+	`, userID).Scan(&count)
+	return count, err
+}
+
+// MarkRead marks one of a user's notifications as read
+func MarkRead(db *sql.DB, userID int, id int64) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND read_at IS NULL
+	`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// MarkAllRead marks all of a user's notifications as read
+func MarkAllRead(db *sql.DB, userID int) error {
+	_, err := db.Exec(`
+		UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND read_at IS NULL
+	`, userID)
+	return err
+}
@@ -0,0 +1,20 @@
+package notifications
+
+import (
+	"database/sql"
+
+	"github.com/gorilla/mux"
+	"modular-erp/internal/core/middleware"
+)
+
+// RegisterRoutes registers notification routes for the authenticated user
+func RegisterRoutes(router *mux.Router, db *sql.DB, jwtSecret string) {
+	handler := NewHandler(db)
+
+	notificationRouter := router.PathPrefix("/api/notifications").Subrouter()
+	notificationRouter.Use(middleware.AuthMiddleware(jwtSecret))
+
+	notificationRouter.HandleFunc("", handler.List).Methods("GET", "OPTIONS")
+	notificationRouter.HandleFunc("/read-all", handler.ReadAll).Methods("POST", "OPTIONS")
+	notificationRouter.HandleFunc("/{id:[0-9]+}/read", handler.Read).Methods("POST", "OPTIONS")
+}
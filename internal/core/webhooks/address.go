@@ -0,0 +1,77 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// errBlockedAddress is returned for receivers on loopback, private,
+// link-local or otherwise internal addresses
+var errBlockedAddress = errors.New("webhook URLs may not point at loopback, private or link-local addresses")
+
+// carrierGradeNAT is the shared address space of RFC 6598, which net.IP
+// does not classify as private
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// blockedIP reports whether an address is internal to the server's network,
+// including the cloud metadata endpoint at 169.254.169.254
+func blockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsMulticast() || carrierGradeNAT.Contains(ip)
+}
+
+// checkHost resolves a URL host and rejects it when any of its addresses is blocked
+func checkHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if blockedIP(ip) {
+			return errBlockedAddress
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("url host %q cannot be resolved", host)
+	}
+	for _, addr := range addrs {
+		if blockedIP(addr.IP) {
+			return errBlockedAddress
+		}
+	}
+	return nil
+}
+
+// newClient returns the HTTP client used for deliveries. Unless allowPrivate
+// reports true, its dialer refuses blocked addresses after DNS resolution,
+// so a host that resolved to a public address when the subscription was
+// saved cannot be rebound to an internal one, nor redirected to one.
+func newClient(timeout time.Duration, allowPrivate func() bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowPrivate() {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blockedIP(ip) {
+				return errBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed instead of the receiver and bypass the check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
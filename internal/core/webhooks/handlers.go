@@ -0,0 +1,258 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"modular-erp/internal/core/middleware"
+	"modular-erp/pkg/utils"
+)
+
+// Handler handles HTTP requests for webhook subscriptions
+type Handler struct {
+	service *Service
+}
+
+// NewHandler creates a new webhook handler
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// SubscriptionRequest represents a create/update subscription request
+type SubscriptionRequest struct {
+	URL         string   `json:"url"`
+	EventTypes  []string `json:"event_types"` // event names, or "*" for all
+	Secret      string   `json:"secret"`      // optional on create, generated if empty
+	Description string   `json:"description"`
+	IsActive    *bool    `json:"is_active"`
+}
+
+// ListSubscriptions lists the company's webhook subscriptions
+func (h *Handler) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	subs, err := GetSubscriptions(h.service.db, claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve webhooks")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"webhooks": subs,
+		"count":    len(subs),
+	})
+}
+
+// CreateSubscription creates a webhook subscription. The signing secret is
+// only included in this response.
+func (h *Handler) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req SubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	sub, err := h.service.CreateSubscription(&Subscription{
+		CompanyID:   claims.CompanyID,
+		URL:         req.URL,
+		EventTypes:  req.EventTypes,
+		Secret:      req.Secret,
+		Description: req.Description,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"webhook": sub,
+	})
+}
+
+// UpdateSubscription updates a webhook subscription
+func (h *Handler) UpdateSubscription(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	var req SubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	sub, err := h.service.UpdateSubscription(&Subscription{
+		ID:          id,
+		CompanyID:   claims.CompanyID,
+		URL:         req.URL,
+		EventTypes:  req.EventTypes,
+		Description: req.Description,
+		IsActive:    isActive,
+	})
+	if err == ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"webhook": sub,
+	})
+}
+
+// DeleteSubscription deletes a webhook subscription
+func (h *Handler) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	err = DeleteSubscription(h.service.db, claims.CompanyID, id)
+	if err == ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Webhook deleted",
+	})
+}
+
+// ListDeliveries returns the delivery log of a subscription
+func (h *Handler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if limit == 0 {
+		limit = 50
+	}
+
+	deliveries, err := GetDeliveries(h.service.db, claims.CompanyID, id, r.URL.Query().Get("status"), limit, offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve deliveries")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"deliveries": deliveries,
+		"count":      len(deliveries),
+	})
+}
+
+// SendTestEvent sends a test event to a subscription and returns the outcome
+func (h *Handler) SendTestEvent(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	delivery, err := h.service.SendTestEvent(claims.CompanyID, id)
+	if err == ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to send test event")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"delivery": delivery,
+	})
+}
+
+// RetryDelivery requeues a dead-lettered delivery
+func (h *Handler) RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	delivery, err := RequeueDelivery(h.service.db, claims.CompanyID, id)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":  "Delivery requeued",
+		"delivery": delivery,
+	})
+}
+
+// Helper functions
+func respondWithError(w http.ResponseWriter, code int, message string) {
+	respondWithJSON(w, code, map[string]string{"error": message})
+}
+
+func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	response, _ := json.Marshal(payload)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(response)
+}
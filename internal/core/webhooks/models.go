@@ -0,0 +1,271 @@
+package webhooks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Subscription is a company's registration to receive events at a URL
+type Subscription struct {
+	ID          int       `json:"id"`
+	CompanyID   int       `json:"company_id"`
+	URL         string    `json:"url"`
+	EventTypes  []string  `json:"event_types"`
+	Secret      string    `json:"secret,omitempty"` // only returned on creation
+	Description string    `json:"description,omitempty"`
+	IsActive    bool      `json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Delivery is one event sent, or to be sent, to a subscription
+type Delivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int             `json:"subscription_id"`
+	CompanyID      int             `json:"company_id"`
+	EventID        *int64          `json:"event_id,omitempty"`
+	EventName      string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"` // pending, succeeded, dead
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// ErrNotFound is returned when a subscription or delivery does not exist
+var ErrNotFound = errors.New("not found")
+
+const subscriptionColumns = `id, company_id, url, event_types, secret, COALESCE(description, ''), is_active, created_at, updated_at`
+
+const deliveryColumns = `id, subscription_id, company_id, event_id, event_name, payload, status, attempts,
+	next_attempt_at, last_status_code, COALESCE(last_error, ''), delivered_at, created_at, updated_at`
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSubscription(row scanner) (*Subscription, error) {
+	sub := &Subscription{}
+	err := row.Scan(
+		&sub.ID, &sub.CompanyID, &sub.URL, pq.Array(&sub.EventTypes), &sub.Secret,
+		&sub.Description, &sub.IsActive, &sub.CreatedAt, &sub.UpdatedAt,
+	)
+	return sub, err
+}
+
+func scanDelivery(row scanner) (*Delivery, error) {
+	d := &Delivery{}
+	err := row.Scan(
+		&d.ID, &d.SubscriptionID, &d.CompanyID, &d.EventID, &d.EventName, &d.Payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.DeliveredAt, &d.CreatedAt, &d.UpdatedAt,
+	)
+	return d, err
+}
+
+// CreateSubscription creates a webhook subscription
+func CreateSubscription(db *sql.DB, sub *Subscription) (*Subscription, error) {
+	row := db.QueryRow(`
+		INSERT INTO webhook_subscriptions (company_id, url, event_types, secret, description, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+subscriptionColumns,
+		sub.CompanyID, sub.URL, pq.Array(sub.EventTypes), sub.Secret, sub.Description,
+	)
+	return scanSubscription(row)
+}
+
+// GetSubscriptions retrieves all webhook subscriptions for a company
+func GetSubscriptions(db *sql.DB, companyID int) ([]Subscription, error) {
+	rows, err := db.Query(`
+		SELECT `+subscriptionColumns+`
+		FROM webhook_subscriptions
+		WHERE company_id = $1
+		ORDER BY id
+	`, companyID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []Subscription{}
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		sub.Secret = ""
+		subs = append(subs, *sub)
+	}
+
+	return subs, rows.Err()
+}
+
+// GetSubscription retrieves a webhook subscription, including its secret
+func GetSubscription(db *sql.DB, companyID, id int) (*Subscription, error) {
+	sub, err := scanSubscription(db.QueryRow(`
+		SELECT `+subscriptionColumns+`
+		FROM webhook_subscriptions
+		WHERE id = $1 AND company_id = $2
+	`, id, companyID))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return sub, err
+}
+
+// UpdateSubscription updates a subscription's URL, event types, description and state
+func UpdateSubscription(db *sql.DB, sub *Subscription) (*Subscription, error) {
+	updated, err := scanSubscription(db.QueryRow(`
+		UPDATE webhook_subscriptions
+		SET url = $1, event_types = $2, description = $3, is_active = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5 AND company_id = $6
+		RETURNING `+subscriptionColumns,
+		sub.URL, pq.Array(sub.EventTypes), sub.Description, sub.IsActive, sub.ID, sub.CompanyID,
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	updated.Secret = ""
+	return updated, nil
+}
+
+// DeleteSubscription deletes a subscription and its delivery log
+func DeleteSubscription(db *sql.DB, companyID, id int) error {
+	result, err := db.Exec(`DELETE FROM webhook_subscriptions WHERE id = $1 AND company_id = $2`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// EnqueueEvent creates a pending delivery for every active subscription of the
+// company interested in the event. Re-enqueueing the same event is a no-op.
+func EnqueueEvent(db *sql.DB, companyID int, eventID int64, eventName string, payload []byte) error {
+	_, err := db.Exec(`
+		INSERT INTO webhook_deliveries (subscription_id, company_id, event_id, event_name, payload,
+		                                status, next_attempt_at, created_at, updated_at)
+		SELECT id, company_id, $2, $3, $4, 'pending', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		FROM webhook_subscriptions
+		WHERE company_id = $1 AND is_active = true
+		  AND ($3 = ANY(event_types) OR '*' = ANY(event_types))
+		ON CONFLICT (subscription_id, event_id) DO NOTHING
+	`, companyID, eventID, eventName, payload)
+	return err
+}
+
+// CreateTestDelivery creates a pending delivery that is not tied to an outbox event
+func CreateTestDelivery(db *sql.DB, sub *Subscription, eventName string, payload []byte) (*Delivery, error) {
+	return scanDelivery(db.QueryRow(`
+		INSERT INTO webhook_deliveries (subscription_id, company_id, event_name, payload,
+		                                status, next_attempt_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, 'pending', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+deliveryColumns,
+		sub.ID, sub.CompanyID, eventName, payload,
+	))
+}
+
+// GetDeliveries retrieves the delivery log of a subscription, newest first
+func GetDeliveries(db *sql.DB, companyID, subscriptionID int, status string, limit, offset int) ([]Delivery, error) {
+	rows, err := db.Query(`
+		SELECT `+deliveryColumns+`
+		FROM webhook_deliveries
+		WHERE company_id = $1 AND subscription_id = $2 AND ($3 = '' OR status = $3)
+		ORDER BY id DESC
+		LIMIT $4 OFFSET $5
+	`, companyID, subscriptionID, status, limit, offset)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// RequeueDelivery moves a dead delivery back to pending with a fresh attempt budget
+func RequeueDelivery(db *sql.DB, companyID int, id int64) (*Delivery, error) {
+	d, err := scanDelivery(db.QueryRow(`
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND company_id = $2 AND status = 'dead'
+		RETURNING `+deliveryColumns,
+		id, companyID,
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, errors.New("delivery not found or not in dead-letter state")
+	}
+	return d, err
+}
+
+// claimDueDeliveries leases pending deliveries whose next attempt is due
+func claimDueDeliveries(db *sql.DB, limit int, lease time.Duration) ([]Delivery, error) {
+	rows, err := db.Query(`
+		UPDATE webhook_deliveries
+		SET locked_until = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+			  AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+deliveryColumns,
+		limit, lease.Seconds(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// recordAttempt stores the outcome of a delivery attempt
+func recordAttempt(db *sql.DB, d *Delivery) error {
+	_, err := db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, next_attempt_at = $3, last_status_code = $4,
+		    last_error = NULLIF($5, ''), delivered_at = $6, locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $7
+	`, d.Status, d.Attempts, d.NextAttemptAt, d.LastStatusCode, d.LastError, d.DeliveredAt, d.ID)
+	return err
+}
@@ -0,0 +1,23 @@
+package webhooks
+
+import (
+	"github.com/gorilla/mux"
+	"modular-erp/internal/core/middleware"
+)
+
+// RegisterRoutes registers webhook management routes (admin only)
+func RegisterRoutes(router *mux.Router, service *Service, jwtSecret string) {
+	handler := NewHandler(service)
+
+	webhookRouter := router.PathPrefix("/api/webhooks").Subrouter()
+	webhookRouter.Use(middleware.AuthMiddleware(jwtSecret))
+	webhookRouter.Use(middleware.RequireRole("admin"))
+
+	webhookRouter.HandleFunc("", handler.ListSubscriptions).Methods("GET", "OPTIONS")
+	webhookRouter.HandleFunc("", handler.CreateSubscription).Methods("POST", "OPTIONS")
+	webhookRouter.HandleFunc("/{id}", handler.UpdateSubscription).Methods("PUT", "OPTIONS")
+	webhookRouter.HandleFunc("/{id}", handler.DeleteSubscription).Methods("DELETE", "OPTIONS")
+	webhookRouter.HandleFunc("/{id}/deliveries", handler.ListDeliveries).Methods("GET", "OPTIONS")
+	webhookRouter.HandleFunc("/{id}/test", handler.SendTestEvent).Methods("POST", "OPTIONS")
+	webhookRouter.HandleFunc("/deliveries/{id}/retry", handler.RetryDelivery).Methods("POST", "OPTIONS")
+}
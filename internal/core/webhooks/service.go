@@ -0,0 +1,293 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"modular-erp/internal/core/events"
+)
+
+// Signature headers sent with every delivery. The signature is the hex
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the subscription secret.
+const (
+	HeaderDeliveryID = "X-Webhook-Id"
+	HeaderEvent      = "X-Webhook-Event"
+	HeaderTimestamp  = "X-Webhook-Timestamp"
+	HeaderSignature  = "X-Webhook-Signature"
+)
+
+// TestEventName is the event sent by the "send test event" endpoint
+const TestEventName = "webhook.test"
+
+// Payload is the JSON body posted to subscribers
+type Payload struct {
+	EventID    *int64          `json:"event_id,omitempty"`
+	Event      string          `json:"event"`
+	CompanyID  int             `json:"company_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Service manages webhook subscriptions and delivers events to them
+type Service struct {
+	db     *sql.DB
+	client *http.Client
+
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	// AllowPrivateNetworks permits receivers on loopback, private and
+	// link-local addresses, for deployments whose receivers are internal
+	AllowPrivateNetworks bool
+}
+
+// NewService creates a new webhook service
+func NewService(db *sql.DB) *Service {
+	s := &Service{
+		db:           db,
+		PollInterval: 2 * time.Second,
+		BatchSize:    20,
+		MaxAttempts:  8,
+	}
+	s.client = newClient(10*time.Second, func() bool { return s.AllowPrivateNetworks })
+	return s
+}
+
+// Subscribe enqueues webhook deliveries for every event published on the bus
+func (s *Service) Subscribe(bus *events.Bus) {
+	bus.Subscribe(events.AllEvents, "webhooks", func(ctx context.Context, env events.Envelope) error {
+		body, err := json.Marshal(Payload{
+			EventID:    &env.ID,
+			Event:      env.Name,
+			CompanyID:  env.CompanyID,
+			OccurredAt: env.OccurredAt,
+			Data:       env.Payload,
+		})
+		if err != nil {
+			return err
+		}
+		return EnqueueEvent(s.db, env.CompanyID, env.ID, env.Name, body)
+	})
+}
+
+// CreateSubscription validates and stores a new subscription, generating a
+// signing secret when none is supplied
+func (s *Service) CreateSubscription(sub *Subscription) (*Subscription, error) {
+	if err := s.validateSubscription(sub); err != nil {
+		return nil, err
+	}
+	if sub.Secret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			return nil, err
+		}
+		sub.Secret = secret
+	}
+	return CreateSubscription(s.db, sub)
+}
+
+// UpdateSubscription validates and updates a subscription
+func (s *Service) UpdateSubscription(sub *Subscription) (*Subscription, error) {
+	if err := s.validateSubscription(sub); err != nil {
+		return nil, err
+	}
+	return UpdateSubscription(s.db, sub)
+}
+
+// SendTestEvent delivers a test event synchronously and returns the logged delivery
+func (s *Service) SendTestEvent(companyID, subscriptionID int) (*Delivery, error) {
+	sub, err := GetSubscription(s.db, companyID, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := json.Marshal(map[string]string{"message": "This is a test event"})
+	body, err := json.Marshal(Payload{
+		Event:      TestEventName,
+		CompanyID:  companyID,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := CreateTestDelivery(s.db, sub, TestEventName, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// A test is a one-shot: failures go straight to the dead-letter state
+	s.attempt(context.Background(), sub, delivery, 1)
+	return delivery, recordAttempt(s.db, delivery)
+}
+
+// Run delivers due webhooks until ctx is cancelled
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.deliverBatch(ctx); err != nil {
+				log.Printf("Webhook delivery error: %v", err)
+			}
+		}
+	}
+}
+
+// deliverBatch claims and attempts one batch of due deliveries
+func (s *Service) deliverBatch(ctx context.Context) error {
+	deliveries, err := claimDueDeliveries(s.db, s.BatchSize, time.Minute)
+	if err != nil {
+		return err
+	}
+
+	subs := make(map[int]*Subscription)
+	for i := range deliveries {
+		d := &deliveries[i]
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		sub, ok := subs[d.SubscriptionID]
+		if !ok {
+			sub, err = GetSubscription(s.db, d.CompanyID, d.SubscriptionID)
+			if err != nil {
+				return err
+			}
+			subs[d.SubscriptionID] = sub
+		}
+
+		s.attempt(ctx, sub, d, s.MaxAttempts)
+		if err := recordAttempt(s.db, d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attempt posts a delivery once and updates its status, attempts and next
+// attempt time in place. Any 2xx response counts as success.
+func (s *Service) attempt(ctx context.Context, sub *Subscription, d *Delivery, maxAttempts int) {
+	d.Attempts++
+	d.LastStatusCode = nil
+
+	statusCode, err := s.post(ctx, sub, d)
+	if statusCode != 0 {
+		d.LastStatusCode = &statusCode
+	}
+	if err == nil {
+		now := time.Now()
+		d.Status = "succeeded"
+		d.DeliveredAt = &now
+		d.LastError = ""
+		return
+	}
+
+	d.LastError = err.Error()
+	if d.Attempts >= maxAttempts {
+		d.Status = "dead"
+		return
+	}
+	d.NextAttemptAt = time.Now().Add(retryDelay(d.Attempts))
+}
+
+// post sends the signed payload to the subscription URL
+func (s *Service) post(ctx context.Context, sub *Subscription, d *Delivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Modular-ERP-Webhooks/1.0")
+	req.Header.Set(HeaderDeliveryID, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(HeaderEvent, d.EventName)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(sub.Secret, timestamp, d.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign computes the hex HMAC-SHA256 signature of a timestamped body.
+// Receivers should recompute it and reject stale timestamps to stop replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay returns the exponential backoff before the next attempt:
+// 30s, 1m, 2m, 4m, ... capped at six hours
+func retryDelay(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < 6*time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > 6*time.Hour {
+		delay = 6 * time.Hour
+	}
+	return delay
+}
+
+// validateSubscription checks the URL and event types of a subscription.
+// The URL host must not resolve to an internal address; the delivery dialer
+// checks again, since DNS can change after the subscription is saved.
+func (s *Service) validateSubscription(sub *Subscription) error {
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if !s.AllowPrivateNetworks {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := checkHost(ctx, u.Hostname()); err != nil {
+			return err
+		}
+	}
+	if len(sub.EventTypes) == 0 {
+		return errors.New("at least one event type is required")
+	}
+	return nil
+}
+
+// generateSecret returns a random hex signing secret
+func generateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,243 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000.{\"a\":1}"))
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	if got := Sign("secret", "1700000000", []byte(`{"a":1}`)); got != want {
+		t.Fatalf("Sign() = %s, want %s", got, want)
+	}
+	if Sign("other", "1700000000", []byte(`{"a":1}`)) == want {
+		t.Fatal("signature does not depend on the secret")
+	}
+	if Sign("secret", "1700000001", []byte(`{"a":1}`)) == want {
+		t.Fatal("signature does not depend on the timestamp")
+	}
+}
+
+func TestAttemptSendsSignedPayload(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := NewService(nil)
+	s.AllowPrivateNetworks = true
+	sub := &Subscription{URL: server.URL, Secret: "topsecret"}
+	d := &Delivery{ID: 42, EventName: "shift.started", Payload: []byte(`{"event":"shift.started"}`), Status: "pending"}
+
+	s.attempt(context.Background(), sub, d, 3)
+
+	if d.Status != "succeeded" || d.DeliveredAt == nil || d.Attempts != 1 {
+		t.Fatalf("delivery = %+v, want succeeded after one attempt", d)
+	}
+	if d.LastStatusCode == nil || *d.LastStatusCode != http.StatusNoContent {
+		t.Fatalf("last status code = %v, want 204", d.LastStatusCode)
+	}
+	if got.Method != http.MethodPost || got.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("request = %s %s, want a JSON POST", got.Method, got.Header.Get("Content-Type"))
+	}
+	if got.Header.Get(HeaderDeliveryID) != "42" || got.Header.Get(HeaderEvent) != "shift.started" {
+		t.Fatalf("headers = %v", got.Header)
+	}
+	if string(body) != string(d.Payload) {
+		t.Fatalf("body = %s, want %s", body, d.Payload)
+	}
+
+	want := "sha256=" + Sign("topsecret", got.Header.Get(HeaderTimestamp), body)
+	if sig := got.Header.Get(HeaderSignature); sig != want {
+		t.Fatalf("signature = %s, want %s", sig, want)
+	}
+}
+
+func TestAttemptRetriesUntilDead(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	s := NewService(nil)
+	s.AllowPrivateNetworks = true
+	sub := &Subscription{URL: server.URL, Secret: "s"}
+	d := &Delivery{ID: 1, EventName: "shift.completed", Payload: []byte(`{}`), Status: "pending"}
+
+	for i := 1; i <= 2; i++ {
+		before := time.Now()
+		s.attempt(context.Background(), sub, d, 3)
+		if d.Status != "pending" || d.Attempts != i {
+			t.Fatalf("attempt %d: status %q attempts %d, want pending", i, d.Status, d.Attempts)
+		}
+		if d.LastStatusCode == nil || *d.LastStatusCode != http.StatusInternalServerError {
+			t.Fatalf("attempt %d: last status code = %v, want 500", i, d.LastStatusCode)
+		}
+		if !strings.Contains(d.LastError, "500") {
+			t.Fatalf("attempt %d: last error = %q", i, d.LastError)
+		}
+		if delay := d.NextAttemptAt.Sub(before); delay < retryDelay(i) || delay > retryDelay(i)+time.Minute {
+			t.Fatalf("attempt %d: next attempt in %v, want about %v", i, delay, retryDelay(i))
+		}
+	}
+
+	s.attempt(context.Background(), sub, d, 3)
+	if d.Status != "dead" || d.Attempts != 3 {
+		t.Fatalf("status %q attempts %d, want dead after 3 attempts", d.Status, d.Attempts)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("receiver called %d times, want 3", n)
+	}
+}
+
+func TestAttemptRecoversAfterFailure(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	s := NewService(nil)
+	s.AllowPrivateNetworks = true
+	sub := &Subscription{URL: server.URL, Secret: "s"}
+	d := &Delivery{ID: 1, EventName: "shift.completed", Payload: []byte(`{}`), Status: "pending"}
+
+	s.attempt(context.Background(), sub, d, 8)
+	s.attempt(context.Background(), sub, d, 8)
+
+	if d.Status != "succeeded" || d.Attempts != 2 || d.LastError != "" {
+		t.Fatalf("delivery = %+v, want succeeded on the second attempt", d)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{8, 64 * time.Minute},
+		{20, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSubscriptionBlocksInternalAddresses(t *testing.T) {
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{"http://127.0.0.1/hook", true},
+		{"http://127.10.0.1:8080/hook", true},
+		{"http://localhost/hook", true},
+		{"http://[::1]/hook", true},
+		{"http://0.0.0.0/hook", true},
+		{"http://[::]/hook", true},
+		{"http://169.254.169.254/latest/meta-data/", true},
+		{"http://[fe80::1]/hook", true},
+		{"http://10.1.2.3/hook", true},
+		{"http://172.16.0.1/hook", true},
+		{"https://192.168.1.10/hook", true},
+		{"http://[fd00::1]/hook", true},
+		{"http://100.64.0.1/hook", true},
+		{"http://[::ffff:127.0.0.1]/hook", true},
+		{"https://93.184.216.34/hook", false},
+		{"https://[2606:2800:220:1::1]/hook", false},
+	}
+
+	s := NewService(nil)
+	for _, tt := range tests {
+		err := s.validateSubscription(&Subscription{URL: tt.url, EventTypes: []string{"*"}})
+		if tt.blocked && err != errBlockedAddress {
+			t.Errorf("%s: err = %v, want blocked", tt.url, err)
+		}
+		if !tt.blocked && err != nil {
+			t.Errorf("%s: err = %v, want allowed", tt.url, err)
+		}
+	}
+
+	s.AllowPrivateNetworks = true
+	if err := s.validateSubscription(&Subscription{URL: "http://127.0.0.1/hook", EventTypes: []string{"*"}}); err != nil {
+		t.Errorf("with private networks allowed: err = %v", err)
+	}
+}
+
+func TestDeliveryDialerBlocksInternalAddresses(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	// Subscriptions saved before the host resolved internally still reach the
+	// dialer, which must refuse the connection
+	s := NewService(nil)
+	sub := &Subscription{URL: server.URL, Secret: "s"}
+	d := &Delivery{ID: 1, EventName: "shift.started", Payload: []byte(`{}`), Status: "pending"}
+
+	s.attempt(context.Background(), sub, d, 1)
+
+	if d.Status != "dead" || !strings.Contains(d.LastError, errBlockedAddress.Error()) {
+		t.Fatalf("delivery = %+v, want refused by the dialer", d)
+	}
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Fatalf("receiver called %d times, want 0", n)
+	}
+}
+
+func TestDeliveryDialerBlocksRedirectToInternalAddress(t *testing.T) {
+	var internalCalls int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&internalCalls, 1)
+	}))
+	defer internal.Close()
+
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusTemporaryRedirect)
+	}))
+	defer redirector.Close()
+
+	// Let the first hop through, as if the redirector were public, and block the rest
+	var dials int32
+	s := NewService(nil)
+	s.client = newClient(10*time.Second, func() bool { return atomic.AddInt32(&dials, 1) == 1 })
+	sub := &Subscription{URL: redirector.URL, Secret: "s"}
+	d := &Delivery{ID: 1, EventName: "shift.started", Payload: []byte(`{}`), Status: "pending"}
+
+	s.attempt(context.Background(), sub, d, 1)
+
+	if !strings.Contains(d.LastError, errBlockedAddress.Error()) {
+		t.Fatalf("last error = %q, want the redirect refused", d.LastError)
+	}
+	if n := atomic.LoadInt32(&internalCalls); n != 0 {
+		t.Fatalf("internal receiver called %d times, want 0", n)
+	}
+}
@@ -0,0 +1,294 @@
+package attendance
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"modular-erp/internal/core/models"
+)
+
+// JobAccrueLeave is the job type that posts leave accruals, annual grants and
+// carry-over expiries to the leave ledger
+const JobAccrueLeave = "attendance.accrue_leave"
+
+// accrueLeaveSchedule runs the accrual job every hour; each entry is keyed by
+// its period or year, so later runs never post it twice, and corrections to
+// hours worked or carry-over get numbered references of their own
+const accrueLeaveSchedule = "15 * * * *"
+
+// maxAccrualPeriods is how many past pay periods one run catches up on
+const maxAccrualPeriods = 12
+
+// AccrueLeave posts the ledger entries due by now for every active leave
+// type that keeps a balance and returns how many were posted. Pay period
+// accruals are posted once a period has ended, annual grants on January
+// 1st, prorated for employees who joined during the year, and hours over a
+// carry-over cap expire at the start of each year. Hours worked accruals and
+// expiries are brought up to date when shifts or earlier entries change.
+func AccrueLeave(ctx context.Context, db *sql.DB, now time.Time) (int, error) {
+	rows, err := db.Query(`
+		SELECT ` + leaveTypeColumns + `
+		FROM leave_types
+		WHERE is_active = true AND accrual_method <> 'none'
+		ORDER BY company_id, id
+	`)
+	if err != nil {
+		return 0, err
+	}
+	var types []LeaveType
+	for rows.Next() {
+		t, err := scanLeaveType(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		types = append(types, *t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	posted := 0
+	var policy *Policy
+	var loc *time.Location
+	for i := range types {
+		if ctx.Err() != nil {
+			return posted, ctx.Err()
+		}
+		t := &types[i]
+		if policy == nil || policy.CompanyID != t.CompanyID {
+			if policy, err = GetPolicy(db, t.CompanyID); err != nil {
+				return posted, fmt.Errorf("company %d: %w", t.CompanyID, err)
+			}
+			loc = models.GetCompanyLocation(db, t.CompanyID)
+		}
+
+		n, err := accrueLeaveType(db, t, policy, localDay(now, loc), loc)
+		posted += n
+		if err != nil {
+			return posted, fmt.Errorf("company %d, leave type %d: %w", t.CompanyID, t.ID, err)
+		}
+	}
+
+	return posted, nil
+}
+
+// accrueLeaveType posts the entries of one leave type due by today
+func accrueLeaveType(db *sql.DB, t *LeaveType, policy *Policy, today time.Time, loc *time.Location) (int, error) {
+	created := localDay(t.CreatedAt, loc)
+	yearStart := time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	posted := 0
+
+	switch t.AccrualMethod {
+	case AccrualPerPayPeriod, AccrualPerHourWorked:
+		start, _ := policy.PayPeriodOf(today)
+		for i := 0; i < maxAccrualPeriods; i++ {
+			var end time.Time
+			start, end = policy.PayPeriodOf(start.AddDate(0, 0, -1))
+			if end.Before(created) {
+				break
+			}
+
+			var n int
+			var err error
+			if t.AccrualMethod == AccrualPerPayPeriod {
+				n, err = accruePayPeriod(db, t, start, end, loc)
+			} else {
+				n, err = accrueHoursWorked(db, t, start, end, loc)
+			}
+			posted += n
+			if err != nil {
+				return posted, err
+			}
+		}
+	case AccrualAnnualGrant:
+		n, err := grantAnnualLeave(db, t, yearStart)
+		posted += n
+		if err != nil {
+			return posted, err
+		}
+	}
+
+	if t.CarryOverCap != nil && created.Before(yearStart) {
+		n, err := expireCarryOver(db, t, yearStart)
+		posted += n
+		if err != nil {
+			return posted, err
+		}
+	}
+
+	return posted, nil
+}
+
+// accruePayPeriod gives every active employee who had joined by the end of
+// a pay period the leave type's rate of hours for it
+func accruePayPeriod(db *sql.DB, t *LeaveType, start, end time.Time, loc *time.Location) (int, error) {
+	joinedBy := time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, loc)
+
+	result, err := db.Exec(`
+		INSERT INTO leave_ledger (company_id, user_id, leave_type_id, entry_date, kind, hours, reference, note, created_at)
+		SELECT $1, u.id, $2, $3, 'accrual', $4, $5, $6, CURRENT_TIMESTAMP
+		FROM users u
+		WHERE u.company_id = $1 AND u.is_active = true AND u.created_at < $7
+		ON CONFLICT (user_id, leave_type_id, reference) DO NOTHING
+	`, t.CompanyID, t.ID, end.Format("2006-01-02"), t.AccrualRate, "accrual:"+start.Format("2006-01-02"),
+		fmt.Sprintf("Pay period %s to %s", start.Format("2006-01-02"), end.Format("2006-01-02")), joinedBy.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// accrueHoursWorked gives each employee the leave type's rate of hours for
+// every hour they worked in a pay period, as counted by the report. Shifts
+// can still be completed or corrected after the period ends, so every run
+// counts the period again and posts the difference from what was accrued so
+// far; each correction is a further entry with a numbered reference.
+func accrueHoursWorked(db *sql.DB, t *LeaveType, start, end time.Time, loc *time.Location) (int, error) {
+	groups, err := GetShiftReportGroups(db, periodFilter(t.CompanyID, nil, start, end, loc), GroupByEmployee, "", false, loc.String())
+	if err != nil {
+		return 0, err
+	}
+
+	reference := "accrual:" + start.Format("2006-01-02")
+	rows, err := db.Query(`
+		SELECT user_id, SUM(hours), COUNT(*)
+		FROM leave_ledger
+		WHERE leave_type_id = $1 AND kind = 'accrual' AND (reference = $2 OR reference LIKE $2 || ':%')
+		GROUP BY user_id
+	`, t.ID, reference)
+	if err != nil {
+		return 0, err
+	}
+	type accrued struct {
+		hours   float64
+		entries int
+	}
+	accruedSoFar := map[int]accrued{}
+	for rows.Next() {
+		var userID int
+		var a accrued
+		if err := rows.Scan(&userID, &a.hours, &a.entries); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		accruedSoFar[userID] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	worked := map[int]float64{}
+	userIDs := []int{}
+	for _, g := range groups {
+		userID, err := strconv.Atoi(g.Key)
+		if err != nil {
+			continue
+		}
+		worked[userID] = g.TotalHours
+		userIDs = append(userIDs, userID)
+	}
+	// Employees whose shifts in the period were all removed give back what they accrued
+	for userID := range accruedSoFar {
+		if _, ok := worked[userID]; !ok {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	posted := 0
+	for _, userID := range userIDs {
+		so := accruedSoFar[userID]
+		hours := math.Round((worked[userID]*t.AccrualRate-so.hours)*100) / 100
+		if hours == 0 || (so.entries == 0 && hours < 0) {
+			continue
+		}
+
+		result, err := db.Exec(`
+			INSERT INTO leave_ledger (company_id, user_id, leave_type_id, entry_date, kind, hours, reference, note, created_at)
+			VALUES ($1, $2, $3, $4, 'accrual', $5, $6, $7, CURRENT_TIMESTAMP)
+			ON CONFLICT (user_id, leave_type_id, reference) DO NOTHING
+		`, t.CompanyID, userID, t.ID, end.Format("2006-01-02"), hours, entryReference(reference, so.entries),
+			fmt.Sprintf("%.2f hours worked from %s to %s", worked[userID], start.Format("2006-01-02"), end.Format("2006-01-02")))
+		if err != nil {
+			return posted, err
+		}
+		n, _ := result.RowsAffected()
+		posted += int(n)
+	}
+
+	return posted, nil
+}
+
+// entryReference is the reference of ledger entry n posted under reference;
+// entries after the first are numbered so each stays unique
+func entryReference(reference string, n int) string {
+	if n == 0 {
+		return reference
+	}
+	return reference + ":" + strconv.Itoa(n)
+}
+
+// grantAnnualLeave gives every active employee the leave type's yearly
+// hours, prorated by the days left in the year when they joined
+func grantAnnualLeave(db *sql.DB, t *LeaveType, yearStart time.Time) (int, error) {
+	yearEnd := yearStart.AddDate(1, 0, -1)
+
+	result, err := db.Exec(`
+		INSERT INTO leave_ledger (company_id, user_id, leave_type_id, entry_date, kind, hours, reference, note, created_at)
+		SELECT $1, u.id, $2, g.entry_date, 'grant',
+		       ROUND($5 * ($4::date - g.entry_date + 1)::numeric / ($4::date - $3::date + 1), 2),
+		       $6, $7, CURRENT_TIMESTAMP
+		FROM users u
+		CROSS JOIN LATERAL (SELECT GREATEST(u.created_at::date, $3::date) AS entry_date) g
+		WHERE u.company_id = $1 AND u.is_active = true
+		ON CONFLICT (user_id, leave_type_id, reference) DO NOTHING
+	`, t.CompanyID, t.ID, yearStart.Format("2006-01-02"), yearEnd.Format("2006-01-02"), t.AccrualRate,
+		fmt.Sprintf("grant:%d", yearStart.Year()), fmt.Sprintf("Annual grant for %d", yearStart.Year()))
+	if err != nil {
+		return 0, err
+	}
+
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// expireCarryOver removes the hours each employee held over the leave
+// type's carry-over cap when the year started. Entries dated before then can
+// still be posted later, such as accruals for the last pay period of the
+// year, so every run works the expiry out again and posts the difference
+// from what has expired so far.
+func expireCarryOver(db *sql.DB, t *LeaveType, yearStart time.Time) (int, error) {
+	reference := fmt.Sprintf("carry_over:%d", yearStart.Year())
+
+	result, err := db.Exec(`
+		INSERT INTO leave_ledger (company_id, user_id, leave_type_id, entry_date, kind, hours, reference, note, created_at)
+		SELECT $1, b.user_id, $2, $3, 'carry_over', -GREATEST(b.balance - $4, 0) - b.expired,
+		       CASE WHEN b.entries = 0 THEN $5::text ELSE $5::text || ':' || b.entries END, $6, CURRENT_TIMESTAMP
+		FROM (
+			SELECT l.user_id,
+			       COALESCE(SUM(l.hours) FILTER (WHERE l.entry_date < $3), 0) AS balance,
+			       COALESCE(SUM(l.hours) FILTER (WHERE l.kind = 'carry_over' AND (l.reference = $5 OR l.reference LIKE $5 || ':%')), 0) AS expired,
+			       COUNT(*) FILTER (WHERE l.kind = 'carry_over' AND (l.reference = $5 OR l.reference LIKE $5 || ':%')) AS entries
+			FROM leave_ledger l
+			WHERE l.leave_type_id = $2
+			GROUP BY l.user_id
+		) b
+		WHERE -GREATEST(b.balance - $4, 0) <> b.expired
+		ON CONFLICT (user_id, leave_type_id, reference) DO NOTHING
+	`, t.CompanyID, t.ID, yearStart.Format("2006-01-02"), *t.CarryOverCap, reference,
+		fmt.Sprintf("Hours over the %.2f-hour carry-over cap expired", *t.CarryOverCap))
+	if err != nil {
+		return 0, err
+	}
+
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
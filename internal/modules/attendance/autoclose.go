@@ -0,0 +1,188 @@
+package attendance
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"modular-erp/internal/core/events"
+	"modular-erp/internal/core/jobs"
+	"modular-erp/internal/core/models"
+	"modular-erp/internal/core/notifications"
+)
+
+// JobAutoCloseShifts is the job type that closes forgotten in-progress shifts
+const JobAutoCloseShifts = "attendance.auto_close_shifts"
+
+// autoCloseSchedule runs the auto-close job every five minutes
+const autoCloseSchedule = "*/5 * * * *"
+
+// NotificationShiftAutoClosed is the notification kind sent for auto-closed shifts
+const NotificationShiftAutoClosed = "shift.auto_closed"
+
+// registerJobs registers the attendance background jobs and their schedules
+func registerJobs(runner *jobs.Runner, db *sql.DB) {
+	runner.Register(JobAutoCloseShifts, func(ctx context.Context, job *jobs.Job) error {
+		closed, err := AutoCloseShifts(ctx, db, time.Now())
+		if closed > 0 {
+			log.Printf("Auto-closed %d forgotten shifts", closed)
+		}
+		return err
+	})
+
+	if err := runner.Schedule(JobAutoCloseShifts, autoCloseSchedule, JobAutoCloseShifts, nil); err != nil {
+		log.Printf("Failed to schedule %s: %v", JobAutoCloseShifts, err)
+	}
+
+	runner.Register(JobAccrueLeave, func(ctx context.Context, job *jobs.Job) error {
+		posted, err := AccrueLeave(ctx, db, time.Now())
+		if posted > 0 {
+			log.Printf("Posted %d leave ledger entries", posted)
+		}
+		return err
+	})
+
+	if err := runner.Schedule(JobAccrueLeave, accrueLeaveSchedule, JobAccrueLeave, nil); err != nil {
+		log.Printf("Failed to schedule %s: %v", JobAccrueLeave, err)
+	}
+}
+
+// AutoCloseShifts closes every in-progress shift that has passed its
+// company's auto-close time and returns how many were closed
+func AutoCloseShifts(ctx context.Context, db *sql.DB, now time.Time) (int, error) {
+	policies, err := getAutoClosePolicies(db)
+	if err != nil {
+		return 0, err
+	}
+
+	closed := 0
+	for i := range policies {
+		if ctx.Err() != nil {
+			return closed, ctx.Err()
+		}
+		n, err := autoCloseCompanyShifts(db, &policies[i], now)
+		closed += n
+		if err != nil {
+			return closed, fmt.Errorf("company %d: %w", policies[i].CompanyID, err)
+		}
+	}
+
+	return closed, nil
+}
+
+// autoCloseCompanyShifts closes the overdue in-progress shifts of one company
+func autoCloseCompanyShifts(db *sql.DB, policy *Policy, now time.Time) (int, error) {
+	loc := models.GetCompanyLocation(db, policy.CompanyID)
+
+	rows, err := db.Query(`
+		SELECT id, clock_in FROM shifts
+		WHERE company_id = $1 AND status = 'in_progress'
+	`, policy.CompanyID)
+	if err != nil {
+		return 0, err
+	}
+
+	due := make(map[int]time.Time)
+	for rows.Next() {
+		var id int
+		var clockIn time.Time
+		if err := rows.Scan(&id, &clockIn); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if closeAt := policy.CloseTime(clockIn, loc); !closeAt.After(now) {
+			due[id] = closeAt
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	closed := 0
+	for id, closeAt := range due {
+		ok, err := autoCloseShift(db, id, closeAt, loc)
+		if err != nil {
+			return closed, err
+		}
+		if ok {
+			closed++
+		}
+	}
+
+	return closed, nil
+}
+
+// autoCloseShift closes a single shift at closeAt, publishes shift.completed
+// and notifies the employee and their manager, all in one transaction.
+// It reports false if the shift was closed by someone else in the meantime.
+func autoCloseShift(db *sql.DB, shiftID int, closeAt time.Time, loc *time.Location) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow(`SELECT status FROM shifts WHERE id = $1 FOR UPDATE`, shiftID).Scan(&status)
+	if err == sql.ErrNoRows || (err == nil && status != "in_progress") {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err = closeBreaks(tx, shiftID, closeAt.UTC()); err != nil {
+		return false, err
+	}
+
+	shift := &Shift{}
+	err = scanShift(tx.QueryRow(`
+		UPDATE shifts s
+		SET clock_out = $1, status = 'completed', auto_closed = true, updated_at = CURRENT_TIMESTAMP
+		WHERE s.id = $2
+		RETURNING `+shiftColumns,
+		closeAt.UTC(), shiftID,
+	), shift)
+
+	if err != nil {
+		return false, err
+	}
+
+	if err = events.Publish(tx, shift.CompanyID, shiftCompletedEvent(shift)); err != nil {
+		return false, err
+	}
+
+	data := map[string]interface{}{
+		"shift_id":  shift.ID,
+		"user_id":   shift.UserID,
+		"clock_in":  shift.ClockIn,
+		"clock_out": shift.ClockOut,
+	}
+	clockIn := shift.ClockIn.In(loc).Format("2006-01-02 15:04")
+	clockOut := shift.ClockOut.In(loc).Format("2006-01-02 15:04")
+
+	err = notifications.Notify(tx, shift.CompanyID, shift.UserID, NotificationShiftAutoClosed,
+		"Your shift was closed automatically",
+		fmt.Sprintf("You did not clock out of the shift started %s; it was closed at %s and is pending review.", clockIn, clockOut),
+		data)
+	if err != nil {
+		return false, err
+	}
+
+	err = notifications.NotifyManager(tx, shift.CompanyID, shift.UserID, NotificationShiftAutoClosed,
+		"A shift was closed automatically",
+		fmt.Sprintf("A shift started %s was not clocked out and was closed at %s. Please review it.", clockIn, clockOut),
+		data)
+	if err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
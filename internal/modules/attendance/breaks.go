@@ -0,0 +1,228 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Break types. Unpaid break time is subtracted from worked hours.
+const (
+	BreakPaid   = "paid"
+	BreakUnpaid = "unpaid"
+)
+
+// Break is a pause within a shift
+type Break struct {
+	ID        int        `json:"id"`
+	ShiftID   int        `json:"shift_id"`
+	CompanyID int        `json:"company_id"`
+	UserID    int        `json:"user_id"`
+	Type      string     `json:"type"`
+	StartTime time.Time  `json:"start_time"`
+	EndTime   *time.Time `json:"end_time,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+const breakColumns = `id, shift_id, company_id, user_id, break_type, start_time, end_time, created_at, updated_at`
+
+func scanBreak(row scanner) (*Break, error) {
+	b := &Break{}
+	err := row.Scan(
+		&b.ID, &b.ShiftID, &b.CompanyID, &b.UserID, &b.Type,
+		&b.StartTime, &b.EndTime, &b.CreatedAt, &b.UpdatedAt,
+	)
+	return b, err
+}
+
+// unpaidBreakSeconds is the total length of the finished unpaid breaks of shift "s"
+const unpaidBreakSeconds = `COALESCE((
+	SELECT SUM(EXTRACT(EPOCH FROM (b.end_time - b.start_time)))
+	FROM shift_breaks b
+	WHERE b.shift_id = s.id AND b.break_type = 'unpaid' AND b.end_time IS NOT NULL
+), 0)`
+
+// workedSeconds is the length of shift "s" minus its unpaid breaks; NULL while the shift is open
+const workedSeconds = `(EXTRACT(EPOCH FROM (s.clock_out - s.clock_in)) - ` + unpaidBreakSeconds + `)`
+
+// StartBreak starts a break in the user's active shift
+func StartBreak(db *sql.DB, userID int, breakType string) (*Break, error) {
+	if breakType != BreakPaid && breakType != BreakUnpaid {
+		return nil, errors.New("break type must be paid or unpaid")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	shiftID, companyID, clockIn, err := lockActiveShift(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	breaks, err := GetShiftBreaks(tx, shiftID)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range breaks {
+		if b.EndTime == nil {
+			return nil, errors.New("a break is already in progress")
+		}
+	}
+
+	start := time.Now().UTC()
+	breaks = append(breaks, Break{Type: breakType, StartTime: start})
+	if err := ValidateBreaks(clockIn, nil, breaks); err != nil {
+		return nil, err
+	}
+
+	b, err := scanBreak(tx.QueryRow(`
+		INSERT INTO shift_breaks (shift_id, company_id, user_id, break_type, start_time, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+breakColumns,
+		shiftID, companyID, userID, breakType, start,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// EndBreak ends the open break in the user's active shift
+func EndBreak(db *sql.DB, userID int) (*Break, error) {
+	b, err := scanBreak(db.QueryRow(`
+		UPDATE shift_breaks
+		SET end_time = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE end_time IS NULL AND shift_id IN (
+			SELECT id FROM shifts WHERE user_id = $2 AND status = 'in_progress'
+		)
+		RETURNING `+breakColumns,
+		time.Now().UTC(), userID,
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, errors.New("no break in progress")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// GetOpenBreak retrieves the break in progress in a shift, if any
+func GetOpenBreak(db *sql.DB, shiftID int) (*Break, error) {
+	b, err := scanBreak(db.QueryRow(`
+		SELECT `+breakColumns+`
+		FROM shift_breaks
+		WHERE shift_id = $1 AND end_time IS NULL
+	`, shiftID))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// GetShiftBreaks retrieves the breaks of a shift in chronological order
+func GetShiftBreaks(db querier, shiftID int) ([]Break, error) {
+	rows, err := db.Query(`
+		SELECT `+breakColumns+`
+		FROM shift_breaks
+		WHERE shift_id = $1
+		ORDER BY start_time
+	`, shiftID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	breaks := []Break{}
+	for rows.Next() {
+		b, err := scanBreak(rows)
+		if err != nil {
+			return nil, err
+		}
+		breaks = append(breaks, *b)
+	}
+
+	return breaks, rows.Err()
+}
+
+// lockActiveShift locks the user's in-progress shift for the rest of the transaction
+func lockActiveShift(tx *sql.Tx, userID int) (shiftID, companyID int, clockIn time.Time, err error) {
+	err = tx.QueryRow(`
+		SELECT id, company_id, clock_in FROM shifts
+		WHERE user_id = $1 AND status = 'in_progress'
+		FOR UPDATE
+	`, userID).Scan(&shiftID, &companyID, &clockIn)
+
+	if err == sql.ErrNoRows {
+		return 0, 0, time.Time{}, errors.New("no active shift found")
+	}
+	return shiftID, companyID, clockIn, err
+}
+
+// closeBreaks ends a shift's breaks at the given time: open breaks and breaks
+// running past it are cut off, and breaks starting after it are removed, so
+// every break stays inside the shift
+func closeBreaks(tx *sql.Tx, shiftID int, at time.Time) error {
+	_, err := tx.Exec(`DELETE FROM shift_breaks WHERE shift_id = $1 AND start_time > $2`, shiftID, at)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE shift_breaks
+		SET end_time = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE shift_id = $1 AND (end_time IS NULL OR end_time > $2)
+	`, shiftID, at)
+	return err
+}
+
+// ValidateBreaks checks that breaks lie within the shift and do not overlap.
+// An open break (nil EndTime) is only allowed while the shift is open.
+func ValidateBreaks(clockIn time.Time, clockOut *time.Time, breaks []Break) error {
+	sorted := append([]Break(nil), breaks...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].StartTime.Before(sorted[j].StartTime) })
+
+	for i, b := range sorted {
+		if b.StartTime.Before(clockIn) {
+			return errors.New("break starts before the shift")
+		}
+		if b.EndTime == nil {
+			if clockOut != nil {
+				return errors.New("break must end before the shift ends")
+			}
+			if i != len(sorted)-1 {
+				return errors.New("breaks must not overlap")
+			}
+			continue
+		}
+		if b.EndTime.Before(b.StartTime) {
+			return errors.New("break ends before it starts")
+		}
+		if clockOut != nil && b.EndTime.After(*clockOut) {
+			return errors.New("break must end before the shift ends")
+		}
+		if i+1 < len(sorted) && sorted[i+1].StartTime.Before(*b.EndTime) {
+			return errors.New("breaks must not overlap")
+		}
+	}
+
+	return nil
+}
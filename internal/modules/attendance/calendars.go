@@ -0,0 +1,343 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// icalImportYears is how many years after the current one recurring events
+// without an end are repeated into on import
+const icalImportYears = 5
+
+// ErrHolidayCalendarNotFound is returned when a holiday calendar does not exist
+var ErrHolidayCalendarNotFound = errors.New("holiday calendar not found")
+
+// HolidayCalendar is a set of public holidays, usually of a country or
+// region. The holidays of calendars assigned to the company count as its
+// own wherever attendance looks up holidays.
+type HolidayCalendar struct {
+	ID           int       `json:"id"`
+	CompanyID    int       `json:"company_id"`
+	Name         string    `json:"name"`
+	Country      string    `json:"country"` // ISO 3166-1 alpha-2, e.g. DE
+	Region       string    `json:"region"`  // e.g. DE-BY
+	Description  string    `json:"description"`
+	Assigned     bool      `json:"assigned"`
+	HolidayCount int       `json:"holiday_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// HolidayImportResult summarises an iCalendar import
+type HolidayImportResult struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Removed int `json:"removed"`
+	// Kept counts imported dates that already had a manual holiday, which wins
+	Kept int `json:"kept"`
+	// Skipped counts events that were cancelled or could not be read
+	Skipped int `json:"skipped"`
+}
+
+// holidayCalendarColumns lists the columns scanned by scanHolidayCalendar;
+// calendars must be aliased as "c"
+const holidayCalendarColumns = `c.id, c.company_id, c.name, c.country, c.region, COALESCE(c.description, ''), c.assigned,
+	(SELECT COUNT(*) FROM holiday_calendar_entries e WHERE e.calendar_id = c.id), c.created_at, c.updated_at`
+
+func scanHolidayCalendar(row scanner) (*HolidayCalendar, error) {
+	c := &HolidayCalendar{}
+	err := row.Scan(
+		&c.ID, &c.CompanyID, &c.Name, &c.Country, &c.Region, &c.Description, &c.Assigned,
+		&c.HolidayCount, &c.CreatedAt, &c.UpdatedAt,
+	)
+	return c, err
+}
+
+// calendarHolidayColumns lists the columns of a calendar entry "e" of
+// calendar "c" scanned by scanHoliday
+const calendarHolidayColumns = `e.id, c.company_id, to_char(e.holiday_date, 'YYYY-MM-DD'), e.name, e.calendar_id, c.name,
+	e.source, e.created_at`
+
+// validate checks the calendar fields and that its name is unique in the company
+func (c *HolidayCalendar) validate(q querier) error {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Country = strings.ToUpper(strings.TrimSpace(c.Country))
+	c.Region = strings.ToUpper(strings.TrimSpace(c.Region))
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(c.Name) > 100 {
+		return errors.New("name is too long")
+	}
+	if c.Country != "" && (len(c.Country) != 2 || strings.Trim(c.Country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "") {
+		return errors.New("country must be a two-letter ISO 3166 code")
+	}
+	if len(c.Region) > 50 {
+		return errors.New("region is too long")
+	}
+
+	var taken bool
+	err := q.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM holiday_calendars WHERE company_id = $1 AND lower(name) = lower($2) AND id <> $3)
+	`, c.CompanyID, c.Name, c.ID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errors.New("a holiday calendar with this name already exists")
+	}
+	return nil
+}
+
+// GetHolidayCalendars retrieves the company's holiday calendars
+func GetHolidayCalendars(db *sql.DB, companyID int) ([]HolidayCalendar, error) {
+	rows, err := db.Query(`
+		SELECT `+holidayCalendarColumns+`
+		FROM holiday_calendars c
+		WHERE c.company_id = $1
+		ORDER BY c.name
+	`, companyID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calendars := []HolidayCalendar{}
+	for rows.Next() {
+		c, err := scanHolidayCalendar(rows)
+		if err != nil {
+			return nil, err
+		}
+		calendars = append(calendars, *c)
+	}
+
+	return calendars, rows.Err()
+}
+
+// GetHolidayCalendar retrieves a company holiday calendar
+func GetHolidayCalendar(q querier, companyID, id int) (*HolidayCalendar, error) {
+	c, err := scanHolidayCalendar(q.QueryRow(`
+		SELECT `+holidayCalendarColumns+` FROM holiday_calendars c WHERE c.id = $1 AND c.company_id = $2
+	`, id, companyID))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrHolidayCalendarNotFound
+	}
+	return c, err
+}
+
+// CreateHolidayCalendar creates a holiday calendar
+func CreateHolidayCalendar(db *sql.DB, c *HolidayCalendar) (*HolidayCalendar, error) {
+	if err := c.validate(db); err != nil {
+		return nil, err
+	}
+
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO holiday_calendars (company_id, name, country, region, description, assigned, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id
+	`, c.CompanyID, c.Name, c.Country, c.Region, c.Description, c.Assigned).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetHolidayCalendar(db, c.CompanyID, id)
+}
+
+// UpdateHolidayCalendar updates a holiday calendar; assigning or unassigning
+// it adds or removes its holidays from the company's
+func UpdateHolidayCalendar(db *sql.DB, c *HolidayCalendar) (*HolidayCalendar, error) {
+	if err := c.validate(db); err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec(`
+		UPDATE holiday_calendars
+		SET name = $1, country = $2, region = $3, description = $4, assigned = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6 AND company_id = $7
+	`, c.Name, c.Country, c.Region, c.Description, c.Assigned, c.ID, c.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrHolidayCalendarNotFound
+	}
+
+	return GetHolidayCalendar(db, c.CompanyID, c.ID)
+}
+
+// DeleteHolidayCalendar deletes a holiday calendar with its holidays
+func DeleteHolidayCalendar(db *sql.DB, companyID, id int) error {
+	result, err := db.Exec(`DELETE FROM holiday_calendars WHERE id = $1 AND company_id = $2`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrHolidayCalendarNotFound
+	}
+	return nil
+}
+
+// GetCalendarHolidays retrieves a calendar's holidays in the year, or all
+// when year is 0
+func GetCalendarHolidays(db *sql.DB, companyID, calendarID, year int) ([]Holiday, error) {
+	if _, err := GetHolidayCalendar(db, companyID, calendarID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT `+calendarHolidayColumns+`
+		FROM holiday_calendar_entries e
+		JOIN holiday_calendars c ON c.id = e.calendar_id
+		WHERE e.calendar_id = $1 AND ($2 = 0 OR EXTRACT(YEAR FROM e.holiday_date) = $2)
+		ORDER BY e.holiday_date
+	`, calendarID, year)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holidays := []Holiday{}
+	for rows.Next() {
+		h, err := scanHoliday(rows)
+		if err != nil {
+			return nil, err
+		}
+		holidays = append(holidays, *h)
+	}
+
+	return holidays, rows.Err()
+}
+
+// CreateCalendarHoliday adds a holiday to a calendar by hand. A calendar has
+// at most one holiday per date; a manual holiday is kept by later imports.
+func CreateCalendarHoliday(db *sql.DB, companyID, calendarID int, holiday *Holiday) (*Holiday, error) {
+	holiday.Name = strings.TrimSpace(holiday.Name)
+	if holiday.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	if len(holiday.Name) > 255 {
+		return nil, errors.New("name is too long")
+	}
+	if _, err := time.Parse("2006-01-02", holiday.Date); err != nil {
+		return nil, errors.New("date must be in YYYY-MM-DD format")
+	}
+	if _, err := GetHolidayCalendar(db, companyID, calendarID); err != nil {
+		return nil, err
+	}
+
+	created, err := scanHoliday(db.QueryRow(`
+		WITH e AS (
+			INSERT INTO holiday_calendar_entries (calendar_id, holiday_date, name, source, created_at)
+			VALUES ($1, $2, $3, 'manual', CURRENT_TIMESTAMP)
+			ON CONFLICT (calendar_id, holiday_date) DO NOTHING
+			RETURNING *
+		)
+		SELECT `+calendarHolidayColumns+` FROM e JOIN holiday_calendars c ON c.id = e.calendar_id
+	`, calendarID, holiday.Date, holiday.Name))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("the calendar already has a holiday on this date")
+	}
+	return created, err
+}
+
+// DeleteCalendarHoliday removes a holiday from a calendar
+func DeleteCalendarHoliday(db *sql.DB, companyID, calendarID, id int) error {
+	result, err := db.Exec(`
+		DELETE FROM holiday_calendar_entries e
+		USING holiday_calendars c
+		WHERE e.id = $1 AND e.calendar_id = $2 AND c.id = e.calendar_id AND c.company_id = $3
+	`, id, calendarID, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrHolidayNotFound
+	}
+	return nil
+}
+
+// ImportHolidayCalendar adds the holidays of an iCalendar file to a
+// calendar. Dates already imported are renamed to the file's; manual
+// holidays are kept. With replace, holidays imported before and missing
+// from the file are removed. Recurring events without an end are repeated
+// for icalImportYears years after the current one.
+func ImportHolidayCalendar(db *sql.DB, companyID, calendarID int, r io.Reader, replace bool, loc *time.Location, now time.Time) (*HolidayImportResult, error) {
+	horizon := time.Date(localDay(now, loc).Year()+icalImportYears, time.December, 31, 0, 0, 0, 0, time.UTC)
+	holidays, skipped, err := parseICalendar(r, loc, horizon)
+	if err != nil {
+		return nil, err
+	}
+	if len(holidays) == 0 {
+		return nil, errors.New("the calendar has no holidays to import")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+		SELECT id FROM holiday_calendars WHERE id = $1 AND company_id = $2 FOR UPDATE
+	`, calendarID, companyID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, ErrHolidayCalendarNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &HolidayImportResult{Skipped: skipped}
+	dates := make([]string, 0, len(holidays))
+	for _, h := range holidays {
+		dates = append(dates, h.Date)
+
+		var inserted bool
+		err := tx.QueryRow(`
+			INSERT INTO holiday_calendar_entries (calendar_id, holiday_date, name, source, uid, created_at)
+			VALUES ($1, $2, $3, 'ics', NULLIF($4, ''), CURRENT_TIMESTAMP)
+			ON CONFLICT (calendar_id, holiday_date) DO UPDATE
+			SET name = EXCLUDED.name, uid = EXCLUDED.uid
+			WHERE holiday_calendar_entries.source = 'ics'
+			RETURNING xmax = 0
+		`, calendarID, h.Date, h.Name, h.UID).Scan(&inserted)
+		switch {
+		case err == sql.ErrNoRows:
+			result.Kept++
+		case err != nil:
+			return nil, err
+		case inserted:
+			result.Added++
+		default:
+			result.Updated++
+		}
+	}
+
+	if replace {
+		removed, err := tx.Exec(`
+			DELETE FROM holiday_calendar_entries
+			WHERE calendar_id = $1 AND source = 'ics' AND holiday_date <> ALL($2::date[])
+		`, calendarID, pq.Array(dates))
+		if err != nil {
+			return nil, err
+		}
+		n, _ := removed.RowsAffected()
+		result.Removed = int(n)
+	}
+
+	if _, err := tx.Exec(`UPDATE holiday_calendars SET updated_at = CURRENT_TIMESTAMP WHERE id = $1`, calendarID); err != nil {
+		return nil, err
+	}
+
+	return result, tx.Commit()
+}
@@ -0,0 +1,442 @@
+package attendance
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"modular-erp/internal/core/events"
+)
+
+// ReasonCodes are the accepted reasons for a manager correction
+var ReasonCodes = []string{
+	"missed_punch",    // employee forgot to clock in or out
+	"wrong_time",      // punch recorded at the wrong time
+	"device_error",    // clock or app malfunction
+	"duplicate",       // shift recorded twice
+	"not_worked",      // shift was never worked
+	"policy_override", // approved exception to the attendance policy
+	"other",           // anything else; a comment is required
+}
+
+// ReasonLateDeviceScan is recorded on revisions made automatically when a
+// badge reader scan arrives after the shift it belongs to was recorded
+const ReasonLateDeviceScan = "late_device_scan"
+
+// Revision actions
+const (
+	RevisionCreate = "create"
+	RevisionUpdate = "update"
+	RevisionCancel = "cancel"
+)
+
+// Revision records one manager change to a shift. Before is empty for
+// shifts added by a manager; the first revision's Before is the original punch.
+type Revision struct {
+	ID         int             `json:"id"`
+	ShiftID    int             `json:"shift_id"`
+	CompanyID  int             `json:"company_id"`
+	Action     string          `json:"action"`
+	ReasonCode string          `json:"reason_code"`
+	Comment    string          `json:"comment,omitempty"`
+	ChangedBy  *int            `json:"changed_by,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// Correction holds the times and reason for a manager correction
+type Correction struct {
+	ClockIn    time.Time  `json:"clock_in"`
+	ClockOut   *time.Time `json:"clock_out"`
+	Notes      *string    `json:"notes,omitempty"`
+	ReasonCode string     `json:"reason_code"`
+	Comment    string     `json:"comment"`
+}
+
+// shiftSnapshot is the state of a shift stored in a revision
+type shiftSnapshot struct {
+	ClockIn  time.Time  `json:"clock_in"`
+	ClockOut *time.Time `json:"clock_out"`
+	Status   string     `json:"status"`
+	Notes    string     `json:"notes,omitempty"`
+}
+
+// validateReason checks the reason code and requires a comment for "other"
+func validateReason(reasonCode, comment string) error {
+	for _, code := range ReasonCodes {
+		if code == reasonCode {
+			if code == "other" && comment == "" {
+				return errors.New("a comment is required for reason code other")
+			}
+			return nil
+		}
+	}
+	return errors.New("invalid reason code")
+}
+
+// validateTimes checks a corrected clock-in/out pair
+func validateTimes(clockIn time.Time, clockOut *time.Time) error {
+	now := time.Now()
+	if clockIn.IsZero() {
+		return errors.New("clock_in is required")
+	}
+	if clockIn.After(now) {
+		return errors.New("clock_in cannot be in the future")
+	}
+	if clockOut != nil {
+		if !clockOut.After(clockIn) {
+			return errors.New("clock_out must be after clock_in")
+		}
+		if clockOut.After(now) {
+			return errors.New("clock_out cannot be in the future")
+		}
+	}
+	return nil
+}
+
+// checkOverlap rejects times that overlap another of the user's shifts.
+// Open shifts are treated as running indefinitely; cancelled shifts are ignored.
+func checkOverlap(q querier, userID, excludeShiftID int, clockIn time.Time, clockOut *time.Time) error {
+	var overlapping bool
+	err := q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM shifts
+			WHERE user_id = $1 AND id <> $2 AND status <> 'cancelled'
+			  AND clock_in < COALESCE($4, 'infinity'::timestamp)
+			  AND COALESCE(clock_out, 'infinity'::timestamp) > $3
+		)
+	`, userID, excludeShiftID, clockIn, clockOut).Scan(&overlapping)
+	if err != nil {
+		return err
+	}
+	if overlapping {
+		return errors.New("shift overlaps another shift of this employee")
+	}
+	return nil
+}
+
+// recordRevision stores a revision of a shift; before is nil for created
+// shifts and changedBy is 0 for automatic changes
+func recordRevision(tx *sql.Tx, before, after *Shift, action, reasonCode, comment string, changedBy int) error {
+	var beforeData interface{} // NULL for created shifts
+	if before != nil {
+		data, err := json.Marshal(snapshot(before))
+		if err != nil {
+			return err
+		}
+		beforeData = data
+	}
+
+	afterData, err := json.Marshal(snapshot(after))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO shift_revisions (shift_id, company_id, action, reason_code, comment, changed_by,
+		                             before_data, after_data, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, 0), $7, $8, CURRENT_TIMESTAMP)
+	`, after.ID, after.CompanyID, action, reasonCode, comment, changedBy, beforeData, afterData)
+	return err
+}
+
+func snapshot(shift *Shift) shiftSnapshot {
+	return shiftSnapshot{
+		ClockIn:  shift.ClockIn,
+		ClockOut: shift.ClockOut,
+		Status:   shift.Status,
+		Notes:    shift.Notes,
+	}
+}
+
+// AddShift records a shift the employee never punched, e.g. a forgotten day
+func AddShift(db *sql.DB, companyID, userID, changedBy int, c Correction) (*Shift, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	shift, err := addShift(tx, companyID, userID, changedBy, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return shift, nil
+}
+
+// addShift inserts a completed shift with its revision and event within tx
+func addShift(tx *sql.Tx, companyID, userID, changedBy int, c Correction) (*Shift, error) {
+	c.ClockIn, c.ClockOut = c.ClockIn.UTC(), utcTime(c.ClockOut)
+	if err := validateReason(c.ReasonCode, c.Comment); err != nil {
+		return nil, err
+	}
+	if c.ClockOut == nil {
+		return nil, errors.New("clock_out is required")
+	}
+	if err := validateTimes(c.ClockIn, c.ClockOut); err != nil {
+		return nil, err
+	}
+	if userID == changedBy {
+		return nil, errors.New("you cannot add shifts for yourself")
+	}
+
+	// Serialize changes to the employee's shifts so overlap checks hold
+	if _, err := tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return nil, err
+	}
+	if err := checkOverlap(tx, userID, 0, c.ClockIn, c.ClockOut); err != nil {
+		return nil, err
+	}
+	if err := checkPeriodOpen(tx, userID, c.ClockIn); err != nil {
+		return nil, err
+	}
+
+	notes := ""
+	if c.Notes != nil {
+		notes = *c.Notes
+	}
+
+	shift := &Shift{}
+	err := scanShift(tx.QueryRow(`
+		INSERT INTO shifts AS s (user_id, company_id, clock_in, clock_out, status, notes, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, 'completed', NULLIF($5, ''), CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+shiftColumns,
+		userID, companyID, c.ClockIn, c.ClockOut, notes,
+	), shift)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = recordRevision(tx, nil, shift, RevisionCreate, c.ReasonCode, c.Comment, changedBy); err != nil {
+		return nil, err
+	}
+	if err = events.Publish(tx, companyID, shiftCompletedEvent(shift)); err != nil {
+		return nil, err
+	}
+
+	return shift, nil
+}
+
+// CorrectShift changes a shift's clock-in/out times. Breaks must still fit
+// inside the corrected shift. Correcting a shift that needs review also reviews it.
+func CorrectShift(db *sql.DB, companyID, shiftID, changedBy int, userIDs []int, c Correction) (*Shift, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	shift, err := correctShift(tx, companyID, shiftID, changedBy, userIDs, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return shift, nil
+}
+
+// correctShift updates a shift with its revision and event within tx
+func correctShift(tx *sql.Tx, companyID, shiftID, changedBy int, userIDs []int, c Correction) (*Shift, error) {
+	c.ClockIn, c.ClockOut = c.ClockIn.UTC(), utcTime(c.ClockOut)
+	if err := validateReason(c.ReasonCode, c.Comment); err != nil {
+		return nil, err
+	}
+	if err := validateTimes(c.ClockIn, c.ClockOut); err != nil {
+		return nil, err
+	}
+
+	before, err := findShift(tx, companyID, shiftID, userIDs, true)
+	if err != nil {
+		return nil, err
+	}
+	if before.UserID == changedBy {
+		return nil, errors.New("you cannot correct your own shift")
+	}
+	if before.Status == "cancelled" {
+		return nil, errors.New("cancelled shifts cannot be corrected")
+	}
+	if before.Status == "completed" && c.ClockOut == nil {
+		return nil, errors.New("clock_out is required for a completed shift")
+	}
+
+	if _, err = tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE`, before.UserID); err != nil {
+		return nil, err
+	}
+	if err = checkOverlap(tx, before.UserID, shiftID, c.ClockIn, c.ClockOut); err != nil {
+		return nil, err
+	}
+	if err = checkPeriodOpen(tx, before.UserID, before.ClockIn, c.ClockIn); err != nil {
+		return nil, err
+	}
+
+	// Closing an open shift also ends a break still running
+	if before.Status == "in_progress" && c.ClockOut != nil {
+		if err = closeBreaks(tx, shiftID, *c.ClockOut); err != nil {
+			return nil, err
+		}
+	}
+
+	breaks, err := GetShiftBreaks(tx, shiftID)
+	if err != nil {
+		return nil, err
+	}
+	if err = ValidateBreaks(c.ClockIn, c.ClockOut, breaks); err != nil {
+		return nil, err
+	}
+
+	notes := before.Notes
+	if c.Notes != nil {
+		notes = *c.Notes
+	}
+	status := before.Status
+	if c.ClockOut != nil {
+		status = "completed"
+	}
+
+	after := &Shift{}
+	err = scanShift(tx.QueryRow(`
+		UPDATE shifts s
+		SET clock_in = $1, clock_out = $2, status = $3, notes = NULLIF($4, ''),
+		    reviewed_at = CASE WHEN `+pendingReview+` THEN CURRENT_TIMESTAMP ELSE s.reviewed_at END,
+		    reviewed_by = CASE WHEN `+pendingReview+` THEN $5 ELSE s.reviewed_by END,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE s.id = $6
+		RETURNING `+shiftColumns,
+		c.ClockIn, c.ClockOut, status, notes, changedBy, shiftID,
+	), after)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = recordRevision(tx, before, after, RevisionUpdate, c.ReasonCode, c.Comment, changedBy); err != nil {
+		return nil, err
+	}
+
+	event := events.Event(shiftCorrectedEvent(before, after, c.ReasonCode, changedBy))
+	if before.Status == "in_progress" && after.Status == "completed" {
+		event = shiftCompletedEvent(after)
+	}
+	if err = events.Publish(tx, companyID, event); err != nil {
+		return nil, err
+	}
+
+	return after, nil
+}
+
+// CancelShiftByManager cancels a bogus or duplicate shift, keeping its times for the record
+func CancelShiftByManager(db *sql.DB, companyID, shiftID, changedBy int, userIDs []int, reasonCode, comment string) (*Shift, error) {
+	if err := validateReason(reasonCode, comment); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	before, err := findShift(tx, companyID, shiftID, userIDs, true)
+	if err != nil {
+		return nil, err
+	}
+	if before.UserID == changedBy {
+		return nil, errors.New("you cannot cancel your own shift")
+	}
+	if before.Status == "cancelled" {
+		return nil, errors.New("shift is already cancelled")
+	}
+	if err = checkPeriodOpen(tx, before.UserID, before.ClockIn); err != nil {
+		return nil, err
+	}
+
+	if before.Status == "in_progress" {
+		if err = closeBreaks(tx, shiftID, time.Now().UTC()); err != nil {
+			return nil, err
+		}
+	}
+
+	after := &Shift{}
+	err = scanShift(tx.QueryRow(`
+		UPDATE shifts s
+		SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+		WHERE s.id = $1
+		RETURNING `+shiftColumns,
+		shiftID,
+	), after)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = recordRevision(tx, before, after, RevisionCancel, reasonCode, comment, changedBy); err != nil {
+		return nil, err
+	}
+
+	reason := reasonCode
+	if comment != "" {
+		reason += ": " + comment
+	}
+	err = events.Publish(tx, companyID, ShiftCancelled{
+		ShiftID:   after.ID,
+		UserID:    after.UserID,
+		CompanyID: after.CompanyID,
+		Reason:    reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return after, nil
+}
+
+// GetShiftRevisions retrieves the revision history of a shift, oldest first
+func GetShiftRevisions(db *sql.DB, companyID, shiftID int, userIDs []int) ([]Revision, error) {
+	if _, err := findShift(db, companyID, shiftID, userIDs, false); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, shift_id, company_id, action, reason_code, COALESCE(comment, ''), changed_by,
+		       before_data, after_data, created_at
+		FROM shift_revisions
+		WHERE shift_id = $1 AND company_id = $2
+		ORDER BY created_at, id
+	`, shiftID, companyID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		var rev Revision
+		var before []byte
+		err := rows.Scan(
+			&rev.ID, &rev.ShiftID, &rev.CompanyID, &rev.Action, &rev.ReasonCode, &rev.Comment,
+			&rev.ChangedBy, &before, &rev.After, &rev.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if before != nil {
+			rev.Before = before
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
@@ -0,0 +1,547 @@
+package attendance
+
+import (
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"modular-erp/internal/core/events"
+	"modular-erp/internal/core/models"
+)
+
+// Outcomes of an ingested device event
+const (
+	DeviceEventApplied      = "applied"       // turned into a clock-in or clock-out
+	DeviceEventIgnored      = "ignored"       // stored but did not change any shift
+	DeviceEventUnknownBadge = "unknown_badge" // stored, no active employee has the badge
+	DeviceEventDuplicate    = "duplicate"     // already ingested, not stored again
+	DeviceEventRejected     = "rejected"      // invalid, not stored
+)
+
+const (
+	// maxDeviceEventBatch limits the events accepted in one request
+	maxDeviceEventBatch = 500
+	// deviceScanDebounce ignores repeated scans of a badge in quick succession
+	deviceScanDebounce = time.Minute
+	// deviceClockSkew is how far in the future an event timestamp may be
+	deviceClockSkew = 5 * time.Minute
+)
+
+// ErrDeviceNotFound is returned when a time clock device does not exist
+var ErrDeviceNotFound = errors.New("device not found")
+
+// ClockDevice is a hardware time clock, such as an RFID or NFC badge reader,
+// that posts badge events with an API secret or a client certificate
+type ClockDevice struct {
+	ID               int        `json:"id"`
+	CompanyID        int        `json:"company_id"`
+	Name             string     `json:"name"`
+	SiteID           *int       `json:"site_id,omitempty"`            // where the device is installed
+	ClientIdentityID *int       `json:"client_identity_id,omitempty"` // certificate the device may use instead of the secret
+	Secret           string     `json:"secret,omitempty"`             // only returned on creation
+	IsActive         bool       `json:"is_active"`
+	LastSeenAt       *time.Time `json:"last_seen_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// DeviceEvent is a badge scan reported by a device. EventID is the device's
+// own identifier and makes retries idempotent.
+type DeviceEvent struct {
+	EventID   string    `json:"event_id"`
+	BadgeID   string    `json:"badge_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DeviceEventResult reports what became of an ingested event
+type DeviceEventResult struct {
+	EventID string `json:"event_id"`
+	Status  string `json:"status"`
+	ShiftID *int   `json:"shift_id,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+const deviceColumns = `id, company_id, name, site_id, client_identity_id, is_active, last_seen_at, created_at, updated_at`
+
+func scanDevice(row scanner) (*ClockDevice, error) {
+	d := &ClockDevice{}
+	err := row.Scan(&d.ID, &d.CompanyID, &d.Name, &d.SiteID, &d.ClientIdentityID, &d.IsActive, &d.LastSeenAt,
+		&d.CreatedAt, &d.UpdatedAt)
+	return d, err
+}
+
+// CreateDevice registers a time clock and returns it with its API secret,
+// which is only stored hashed
+func CreateDevice(db *sql.DB, device *ClockDevice) (*ClockDevice, error) {
+	if strings.TrimSpace(device.Name) == "" {
+		return nil, errors.New("name is required")
+	}
+	if device.SiteID != nil {
+		var exists bool
+		err := db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM work_sites WHERE id = $1 AND company_id = $2)
+		`, *device.SiteID, device.CompanyID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrSiteNotFound
+		}
+	}
+	if device.ClientIdentityID != nil {
+		var exists bool
+		err := db.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM client_identities
+				WHERE id = $1 AND company_id = $2 AND kind = 'device' AND is_active = true
+			)
+		`, *device.ClientIdentityID, device.CompanyID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, errors.New("client identity not found; register the certificate with kind device first")
+		}
+	}
+
+	secret, err := generateDeviceToken()
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := scanDevice(db.QueryRow(`
+		INSERT INTO clock_devices (company_id, name, site_id, client_identity_id, secret_hash, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+deviceColumns,
+		device.CompanyID, device.Name, device.SiteID, device.ClientIdentityID, hashDeviceToken(secret),
+	))
+	if isUniqueViolation(err, "clock_devices_client_identity_id_key") {
+		return nil, errors.New("client identity is already used by another device")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created.Secret = secret
+	return created, nil
+}
+
+// GetDevices retrieves a company's time clocks
+func GetDevices(db *sql.DB, companyID int) ([]ClockDevice, error) {
+	rows, err := db.Query(`
+		SELECT `+deviceColumns+`
+		FROM clock_devices
+		WHERE company_id = $1
+		ORDER BY name
+	`, companyID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []ClockDevice{}
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, *d)
+	}
+
+	return devices, rows.Err()
+}
+
+// RevokeDevice deactivates a time clock so its secret is no longer accepted
+func RevokeDevice(db *sql.DB, companyID, id int) error {
+	result, err := db.Exec(`
+		UPDATE clock_devices
+		SET is_active = false, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND company_id = $2
+	`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+// AuthenticateDevice retrieves the active time clock with an API secret and
+// records that it was seen
+func AuthenticateDevice(db *sql.DB, secret string) (*ClockDevice, error) {
+	d, err := scanDevice(db.QueryRow(`
+		UPDATE clock_devices
+		SET last_seen_at = CURRENT_TIMESTAMP
+		WHERE secret_hash = $1 AND is_active = true
+		RETURNING `+deviceColumns,
+		hashDeviceToken(secret),
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// AuthenticateDeviceCertificate retrieves the active time clock mapped to a
+// verified client certificate registered as a device, and records that it
+// was seen
+func AuthenticateDeviceCertificate(db *sql.DB, cert *x509.Certificate) (*ClockDevice, error) {
+	d, err := scanDevice(db.QueryRow(`
+		UPDATE clock_devices
+		SET last_seen_at = CURRENT_TIMESTAMP
+		WHERE is_active = true AND client_identity_id = (
+			SELECT id FROM client_identities
+			WHERE fingerprint = $1 AND kind = 'device' AND is_active = true
+		)
+		RETURNING `+deviceColumns,
+		models.CertificateFingerprint(cert),
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// IngestDeviceEvents stores a batch of badge events and turns them into
+// shifts. Events are applied in timestamp order, each in its own
+// transaction, so a retried batch only applies what was not stored before.
+// Results are returned in the order of the batch.
+func IngestDeviceEvents(db *sql.DB, key []byte, device *ClockDevice, batch []DeviceEvent) ([]DeviceEventResult, error) {
+	if len(batch) == 0 {
+		return nil, errors.New("events are required")
+	}
+	if len(batch) > maxDeviceEventBatch {
+		return nil, fmt.Errorf("at most %d events can be sent at once", maxDeviceEventBatch)
+	}
+
+	// A device installed at a site punches with the site's position
+	var loc *Location
+	if device.SiteID != nil {
+		sites, err := GetSites(db, device.CompanyID, true)
+		if err != nil {
+			return nil, err
+		}
+		if site := findSite(sites, *device.SiteID); site != nil {
+			loc = &Location{Latitude: site.Latitude, Longitude: site.Longitude, SiteID: &site.ID}
+		}
+	}
+
+	order := make([]int, len(batch))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return batch[order[i]].Timestamp.Before(batch[order[j]].Timestamp)
+	})
+
+	results := make([]DeviceEventResult, len(batch))
+	for _, i := range order {
+		result, err := ingestDeviceEvent(db, key, device, loc, batch[i])
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+
+	return results, nil
+}
+
+// ingestDeviceEvent stores and applies a single event
+func ingestDeviceEvent(db *sql.DB, key []byte, device *ClockDevice, loc *Location, e DeviceEvent) (DeviceEventResult, error) {
+	result := DeviceEventResult{EventID: e.EventID, Status: DeviceEventRejected}
+	switch {
+	case e.EventID == "" || len(e.EventID) > 255:
+		result.Message = "event_id is required and must be at most 255 characters"
+		return result, nil
+	case e.BadgeID == "":
+		result.Message = "badge_id is required"
+		return result, nil
+	case e.Timestamp.IsZero():
+		result.Message = "timestamp is required"
+		return result, nil
+	case e.Timestamp.After(time.Now().Add(deviceClockSkew)):
+		result.Message = "timestamp is in the future"
+		return result, nil
+	}
+	at := e.Timestamp.UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	var userID *int
+	err = tx.QueryRow(`
+		SELECT u.id
+		FROM kiosk_credentials c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.company_id = $1 AND c.kind = $2 AND c.credential_hash = $3
+		  AND u.company_id = $1 AND u.is_active = true
+	`, device.CompanyID, CredentialBadge, hashCredential(key, device.CompanyID, CredentialBadge, e.BadgeID)).Scan(&userID)
+	if err != nil && err != sql.ErrNoRows {
+		return result, err
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO device_events (company_id, device_id, event_id, user_id, occurred_at, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, 'received', CURRENT_TIMESTAMP)
+		ON CONFLICT (device_id, event_id) DO NOTHING
+		RETURNING id
+	`, device.CompanyID, device.ID, e.EventID, userID, at).Scan(&id)
+	if err == sql.ErrNoRows {
+		result.Status = DeviceEventDuplicate
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if userID == nil {
+		result.Status, result.Message = DeviceEventUnknownBadge, "no active employee has this badge"
+	} else {
+		// Serialize events of the same employee
+		if _, err = tx.Exec(`SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, *userID); err != nil {
+			return result, err
+		}
+		result.Status, result.ShiftID, result.Message, err = applyDeviceEvent(tx, device, loc, *userID, at)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE device_events SET status = $1, shift_id = $2, message = NULLIF($3, '') WHERE id = $4
+	`, result.Status, result.ShiftID, result.Message, id)
+	if err != nil {
+		return result, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// applyDeviceEvent turns a badge scan into a clock-in or clock-out. Readers
+// do not say which, so a scan toggles: it closes the shift open at that
+// time, or otherwise opens one. Scans that arrive late are fitted in:
+//   - a scan inside an auto-closed shift replaces the automatic clock-out,
+//     once; the shift still awaits review
+//   - a scan before a shift that a later scan opened makes that later scan
+//     the clock-out, since the clock-in had not arrived yet
+//   - other scans inside or before existing shifts are ignored and left to
+//     a manager to correct
+//
+// Changes to recorded times are kept as revisions, like manager corrections.
+func applyDeviceEvent(tx *sql.Tx, device *ClockDevice, loc *Location, userID int, at time.Time) (string, *int, string, error) {
+	if err := checkPeriodOpen(tx, userID, at); err == ErrPeriodLocked {
+		return DeviceEventIgnored, nil, "pay period is locked by an approved timesheet", nil
+	} else if err != nil {
+		return "", nil, "", err
+	}
+
+	// The latest shift started at or before the scan
+	prev := &Shift{}
+	err := scanShift(tx.QueryRow(`
+		SELECT `+shiftColumns+`
+		FROM shifts s
+		WHERE s.user_id = $1 AND s.status <> 'cancelled' AND s.clock_in <= $2
+		ORDER BY s.clock_in DESC
+		LIMIT 1
+	`, userID, at), prev)
+	if err == sql.ErrNoRows {
+		prev = nil
+	} else if err != nil {
+		return "", nil, "", err
+	}
+
+	if prev != nil {
+		switch {
+		case prev.Status == "in_progress":
+			if at.Sub(prev.ClockIn) < deviceScanDebounce {
+				return DeviceEventIgnored, &prev.ID, "repeated scan", nil
+			}
+			shift, err := closeDeviceShift(tx, prev, device, loc, at)
+			if err != nil {
+				return "", nil, "", err
+			}
+			return DeviceEventApplied, &shift.ID, "clock-out", nil
+
+		case !at.After(*prev.ClockOut):
+			if prev.AutoClosed && prev.ReviewedAt == nil && prev.ClockOutDeviceID == nil &&
+				at.Sub(prev.ClockIn) >= deviceScanDebounce {
+				shift, err := closeDeviceShift(tx, prev, device, loc, at)
+				if err != nil {
+					return "", nil, "", err
+				}
+				return DeviceEventApplied, &shift.ID, "clock-out, replacing the auto-close", nil
+			}
+			return DeviceEventIgnored, &prev.ID, "inside an existing shift", nil
+
+		case at.Sub(*prev.ClockOut) < deviceScanDebounce:
+			return DeviceEventIgnored, &prev.ID, "repeated scan", nil
+		}
+	}
+
+	// The earliest shift started after the scan
+	next := &Shift{}
+	var nextHasBreaks bool
+	err = scanShift(tx.QueryRow(`
+		SELECT `+shiftColumns+`, EXISTS (SELECT 1 FROM shift_breaks b WHERE b.shift_id = s.id)
+		FROM shifts s
+		WHERE s.user_id = $1 AND s.status <> 'cancelled' AND s.clock_in > $2
+		ORDER BY s.clock_in
+		LIMIT 1
+	`, userID, at), next, &nextHasBreaks)
+	if err == sql.ErrNoRows {
+		shift, err := openDeviceShift(tx, device, loc, userID, at)
+		if err != nil {
+			return "", nil, "", err
+		}
+		return DeviceEventApplied, &shift.ID, "clock-in", nil
+	}
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	if next.Status == "in_progress" && next.ClockInDeviceID != nil && !nextHasBreaks {
+		if next.ClockIn.Sub(at) < deviceScanDebounce {
+			return DeviceEventIgnored, &next.ID, "repeated scan", nil
+		}
+		shift, err := reopenDeviceShift(tx, next, device, loc, at)
+		if err != nil {
+			return "", nil, "", err
+		}
+		return DeviceEventApplied, &shift.ID, "late clock-in; the later scan became the clock-out", nil
+	}
+
+	return DeviceEventIgnored, nil, "arrived after a later shift was recorded; correct the shift manually", nil
+}
+
+// openDeviceShift starts a shift at the scan time
+func openDeviceShift(tx *sql.Tx, device *ClockDevice, loc *Location, userID int, at time.Time) (*Shift, error) {
+	args := append([]interface{}{userID, device.CompanyID, at, device.ID}, Punch{Location: loc}.locationArgs()...)
+	shift := &Shift{}
+	err := scanShift(tx.QueryRow(`
+		INSERT INTO shifts AS s (user_id, company_id, clock_in, status, clock_in_device_id,
+		                         clock_in_latitude, clock_in_longitude, clock_in_accuracy, clock_in_site_id,
+		                         created_at, updated_at)
+		VALUES ($1, $2, $3, 'in_progress', $4, $5, $6, $7, $8, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+shiftColumns,
+		args...,
+	), shift)
+	if err != nil {
+		return nil, err
+	}
+
+	err = events.Publish(tx, shift.CompanyID, ShiftStarted{
+		ShiftID:   shift.ID,
+		UserID:    shift.UserID,
+		CompanyID: shift.CompanyID,
+		ClockIn:   shift.ClockIn,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return shift, nil
+}
+
+// closeDeviceShift clocks a shift out at the scan time. Replacing the
+// clock-out of an auto-closed shift is recorded as a revision and leaves the
+// shift awaiting review.
+func closeDeviceShift(tx *sql.Tx, shift *Shift, device *ClockDevice, loc *Location, at time.Time) (*Shift, error) {
+	if err := closeBreaks(tx, shift.ID, at); err != nil {
+		return nil, err
+	}
+
+	args := append([]interface{}{at, device.ID, shift.ID}, Punch{Location: loc}.locationArgs()...)
+	closed := &Shift{}
+	err := scanShift(tx.QueryRow(`
+		UPDATE shifts s
+		SET clock_out = $1, status = 'completed', clock_out_device_id = $2,
+		    clock_out_ip = NULL, clock_out_kiosk_id = NULL,
+		    clock_out_latitude = $4, clock_out_longitude = $5, clock_out_accuracy = $6, clock_out_site_id = $7,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE s.id = $3
+		RETURNING `+shiftColumns,
+		args...,
+	), closed)
+	if err != nil {
+		return nil, err
+	}
+
+	if shift.Status == "in_progress" {
+		if err = events.Publish(tx, closed.CompanyID, shiftCompletedEvent(closed)); err != nil {
+			return nil, err
+		}
+		return closed, nil
+	}
+
+	err = recordRevision(tx, shift, closed, RevisionUpdate, ReasonLateDeviceScan,
+		"a late badge scan replaced the automatic clock-out", 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = events.Publish(tx, closed.CompanyID, shiftCorrectedEvent(shift, closed, ReasonLateDeviceScan, 0)); err != nil {
+		return nil, err
+	}
+
+	return closed, nil
+}
+
+// reopenDeviceShift moves the clock-in of a device-opened shift to the
+// scan time and turns its former clock-in into the clock-out, recording the
+// change as a revision
+func reopenDeviceShift(tx *sql.Tx, shift *Shift, device *ClockDevice, loc *Location, at time.Time) (*Shift, error) {
+	args := append([]interface{}{at, device.ID, shift.ID}, Punch{Location: loc}.locationArgs()...)
+	completed := &Shift{}
+	err := scanShift(tx.QueryRow(`
+		UPDATE shifts s
+		SET clock_out = s.clock_in, clock_out_device_id = s.clock_in_device_id,
+		    clock_out_latitude = s.clock_in_latitude, clock_out_longitude = s.clock_in_longitude,
+		    clock_out_accuracy = s.clock_in_accuracy, clock_out_site_id = s.clock_in_site_id,
+		    clock_in = $1, clock_in_device_id = $2, status = 'completed',
+		    clock_in_latitude = $4, clock_in_longitude = $5, clock_in_accuracy = $6, clock_in_site_id = $7,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE s.id = $3
+		RETURNING `+shiftColumns,
+		args...,
+	), completed)
+	if err != nil {
+		return nil, err
+	}
+
+	err = recordRevision(tx, shift, completed, RevisionUpdate, ReasonLateDeviceScan,
+		"a late badge scan became the clock-in and the former clock-in the clock-out", 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = events.Publish(tx, completed.CompanyID, shiftCorrectedEvent(shift, completed, ReasonLateDeviceScan, 0)); err != nil {
+		return nil, err
+	}
+	if err = events.Publish(tx, completed.CompanyID, shiftCompletedEvent(completed)); err != nil {
+		return nil, err
+	}
+
+	return completed, nil
+}
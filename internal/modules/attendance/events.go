@@ -0,0 +1,162 @@
+package attendance
+
+import "time"
+
+// Event names emitted by the attendance module
+const (
+	EventShiftStarted   = "shift.started"
+	EventShiftCompleted = "shift.completed"
+	EventShiftCancelled = "shift.cancelled"
+	EventShiftCorrected = "shift.corrected"
+
+	EventTimesheetApproved = "timesheet.approved"
+	EventTimesheetReopened = "timesheet.reopened"
+
+	EventLeaveApproved  = "leave.approved"
+	EventLeaveCancelled = "leave.cancelled"
+)
+
+// ShiftStarted is emitted when an employee clocks in
+type ShiftStarted struct {
+	ShiftID   int       `json:"shift_id"`
+	UserID    int       `json:"user_id"`
+	CompanyID int       `json:"company_id"`
+	ClockIn   time.Time `json:"clock_in"`
+}
+
+// EventName implements events.Event
+func (ShiftStarted) EventName() string { return EventShiftStarted }
+
+// ShiftCompleted is emitted when a shift is closed with a clock-out time
+type ShiftCompleted struct {
+	ShiftID   int       `json:"shift_id"`
+	UserID    int       `json:"user_id"`
+	CompanyID int       `json:"company_id"`
+	ClockIn   time.Time `json:"clock_in"`
+	ClockOut  time.Time `json:"clock_out"`
+	Hours     float64   `json:"hours"` // worked hours, excluding unpaid breaks
+	// UnpaidBreakHours is the unpaid break time within the shift
+	UnpaidBreakHours float64 `json:"unpaid_break_hours"`
+	// AutoClosed is set when the shift was closed by the auto-close policy
+	AutoClosed bool `json:"auto_closed"`
+}
+
+// EventName implements events.Event
+func (ShiftCompleted) EventName() string { return EventShiftCompleted }
+
+// ShiftCancelled is emitted when a shift is cancelled
+type ShiftCancelled struct {
+	ShiftID   int    `json:"shift_id"`
+	UserID    int    `json:"user_id"`
+	CompanyID int    `json:"company_id"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// EventName implements events.Event
+func (ShiftCancelled) EventName() string { return EventShiftCancelled }
+
+// ShiftCorrected is emitted when a manager changes the times of a shift
+type ShiftCorrected struct {
+	ShiftID          int        `json:"shift_id"`
+	UserID           int        `json:"user_id"`
+	CompanyID        int        `json:"company_id"`
+	PreviousClockIn  time.Time  `json:"previous_clock_in"`
+	PreviousClockOut *time.Time `json:"previous_clock_out,omitempty"`
+	ClockIn          time.Time  `json:"clock_in"`
+	ClockOut         *time.Time `json:"clock_out,omitempty"`
+	ReasonCode       string     `json:"reason_code"`
+	CorrectedBy      int        `json:"corrected_by,omitempty"` // 0 for automatic corrections
+}
+
+// EventName implements events.Event
+func (ShiftCorrected) EventName() string { return EventShiftCorrected }
+
+// TimesheetApproved is emitted when a manager approves a timesheet, locking
+// its pay period; Hours is what should go to payroll
+type TimesheetApproved struct {
+	TimesheetID int     `json:"timesheet_id"`
+	UserID      int     `json:"user_id"`
+	CompanyID   int     `json:"company_id"`
+	PeriodStart string  `json:"period_start"`
+	PeriodEnd   string  `json:"period_end"`
+	Hours       float64 `json:"hours"` // worked hours, excluding unpaid breaks
+	ApprovedBy  int     `json:"approved_by"`
+}
+
+// EventName implements events.Event
+func (TimesheetApproved) EventName() string { return EventTimesheetApproved }
+
+// TimesheetReopened is emitted when an admin reopens an approved timesheet;
+// hours sent to payroll for the period may change before it is approved again
+type TimesheetReopened struct {
+	TimesheetID int    `json:"timesheet_id"`
+	UserID      int    `json:"user_id"`
+	CompanyID   int    `json:"company_id"`
+	PeriodStart string `json:"period_start"`
+	PeriodEnd   string `json:"period_end"`
+	ReopenedBy  int    `json:"reopened_by"`
+	Comment     string `json:"comment"`
+}
+
+// EventName implements events.Event
+func (TimesheetReopened) EventName() string { return EventTimesheetReopened }
+
+// LeaveApproved is emitted when a manager approves a leave request
+type LeaveApproved struct {
+	LeaveRequestID int     `json:"leave_request_id"`
+	UserID         int     `json:"user_id"`
+	CompanyID      int     `json:"company_id"`
+	LeaveType      string  `json:"leave_type"`
+	Paid           bool    `json:"paid"`
+	StartDate      string  `json:"start_date"`
+	EndDate        string  `json:"end_date"`
+	Hours          float64 `json:"hours"`
+	ApprovedBy     int     `json:"approved_by"`
+}
+
+// EventName implements events.Event
+func (LeaveApproved) EventName() string { return EventLeaveApproved }
+
+// LeaveCancelled is emitted when approved leave is cancelled and its hours
+// are returned to the balance
+type LeaveCancelled struct {
+	LeaveRequestID int     `json:"leave_request_id"`
+	UserID         int     `json:"user_id"`
+	CompanyID      int     `json:"company_id"`
+	StartDate      string  `json:"start_date"`
+	EndDate        string  `json:"end_date"`
+	Hours          float64 `json:"hours"`
+	CancelledBy    int     `json:"cancelled_by"`
+}
+
+// EventName implements events.Event
+func (LeaveCancelled) EventName() string { return EventLeaveCancelled }
+
+// shiftCorrectedEvent builds the correction event for a changed shift
+func shiftCorrectedEvent(before, after *Shift, reasonCode string, correctedBy int) ShiftCorrected {
+	return ShiftCorrected{
+		ShiftID:          after.ID,
+		UserID:           after.UserID,
+		CompanyID:        after.CompanyID,
+		PreviousClockIn:  before.ClockIn,
+		PreviousClockOut: before.ClockOut,
+		ClockIn:          after.ClockIn,
+		ClockOut:         after.ClockOut,
+		ReasonCode:       reasonCode,
+		CorrectedBy:      correctedBy,
+	}
+}
+
+// shiftCompletedEvent builds the completion event for a closed shift
+func shiftCompletedEvent(shift *Shift) ShiftCompleted {
+	return ShiftCompleted{
+		ShiftID:          shift.ID,
+		UserID:           shift.UserID,
+		CompanyID:        shift.CompanyID,
+		ClockIn:          shift.ClockIn,
+		ClockOut:         *shift.ClockOut,
+		Hours:            shift.ClockOut.Sub(shift.ClockIn).Hours() - shift.UnpaidBreakHours,
+		UnpaidBreakHours: shift.UnpaidBreakHours,
+		AutoClosed:       shift.AutoClosed,
+	}
+}
@@ -0,0 +1,777 @@
+package attendance
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats; JSON responses are used when no format is requested
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+	FormatPDF  = "pdf"
+)
+
+// exportContentTypes maps each export format to its media type
+var exportContentTypes = map[string]string{
+	FormatCSV:  "text/csv; charset=utf-8",
+	FormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	FormatPDF:  "application/pdf",
+}
+
+// exportFormat picks the export format from the format query parameter or,
+// failing that, the Accept header. It returns "" for JSON.
+func exportFormat(r *http.Request) (string, error) {
+	if format := strings.ToLower(r.URL.Query().Get("format")); format != "" {
+		if format == "json" {
+			return "", nil
+		}
+		if _, ok := exportContentTypes[format]; !ok {
+			return "", errors.New("format must be json, csv, xlsx or pdf")
+		}
+		return format, nil
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		for format, contentType := range exportContentTypes {
+			if ct, _, _ := mime.ParseMediaType(contentType); ct == mediaType {
+				return format, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// exportColumn describes one column of an exported table
+type exportColumn struct {
+	Title   string
+	Width   float64 // relative width in the PDF layout
+	Numeric bool    // right-aligned in PDFs and stored as a number in XLSX
+}
+
+// exportDoc describes an exported table
+type exportDoc struct {
+	Title     string // e.g. "Timesheet"; also the XLSX sheet name
+	Company   string
+	Period    string // e.g. "2024-01-01 to 2024-01-31"
+	Generated time.Time
+	Columns   []exportColumn
+}
+
+// tableWriter streams the rows of an export; rows are written as they come
+// so large exports are never held in memory
+type tableWriter interface {
+	WriteRow(cells []string) error
+	// WriteTotals writes a closing summary row
+	WriteTotals(cells []string) error
+	Close() error
+}
+
+// newTableWriter starts an export in the given format, writing the heading right away
+func newTableWriter(w io.Writer, format string, doc exportDoc) (tableWriter, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, doc)
+	case FormatXLSX:
+		return newXLSXWriter(w, doc)
+	case FormatPDF:
+		return newPDFWriter(w, doc)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// setExportHeaders sets the content type and download file name of an export
+func setExportHeaders(w http.ResponseWriter, format, name string) {
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": name + "." + format,
+	}))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// csvWriter writes exports as CSV with a UTF-8 byte order mark, so
+// spreadsheet applications detect the encoding
+type csvWriter struct {
+	w    *csv.Writer
+	cols []exportColumn
+}
+
+func newCSVWriter(w io.Writer, doc exportDoc) (*csvWriter, error) {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return nil, err
+	}
+
+	c := &csvWriter{w: csv.NewWriter(w), cols: doc.Columns}
+	titles := make([]string, len(doc.Columns))
+	for i, col := range doc.Columns {
+		titles[i] = col.Title
+	}
+	return c, c.w.Write(titles)
+}
+
+func (c *csvWriter) WriteRow(cells []string) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		// Keep spreadsheets from evaluating text cells as formulas
+		if i < len(c.cols) && !c.cols[i].Numeric && cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			cell = "'" + cell
+		}
+		record[i] = cell
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) WriteTotals(cells []string) error {
+	return c.WriteRow(cells)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// xlsxWriter writes exports as a single-sheet Office Open XML workbook. The
+// fixed parts are written first so the sheet can be streamed as the last
+// entry of the archive.
+type xlsxWriter struct {
+	zip   *zip.Writer
+	sheet io.Writer
+	cols  []exportColumn
+	row   int
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+// xlsxStyles defines the cell formats used by the sheet: 0 is plain, 1 is
+// bold, 2 is a number with two decimals and 3 is a bold number
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="4">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+<xf numFmtId="2" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="2" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1" applyNumberFormat="1"/>
+</cellXfs>
+</styleSheet>`
+
+func newXLSXWriter(w io.Writer, doc exportDoc) (*xlsxWriter, error) {
+	x := &xlsxWriter{zip: zip.NewWriter(w), cols: doc.Columns}
+
+	var workbook bytes.Buffer
+	workbook.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="`)
+	xml.EscapeText(&workbook, []byte(xlsxSheetName(doc.Title)))
+	workbook.WriteString(`" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`)
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, part := range parts {
+		f, err := x.zip.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := x.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	x.sheet = sheet
+
+	var head bytes.Buffer
+	head.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>
+<cols>`)
+	for i, col := range doc.Columns {
+		fmt.Fprintf(&head, `<col min="%d" max="%d" width="%.1f" customWidth="1"/>`, i+1, i+1, col.Width*1.2+4)
+	}
+	head.WriteString("</cols>\n<sheetData>\n")
+	if _, err := x.sheet.Write(head.Bytes()); err != nil {
+		return nil, err
+	}
+
+	titles := make([]string, len(doc.Columns))
+	for i, col := range doc.Columns {
+		titles[i] = col.Title
+	}
+	return x, x.writeRow(titles, true, false)
+}
+
+func (x *xlsxWriter) WriteRow(cells []string) error {
+	return x.writeRow(cells, false, true)
+}
+
+func (x *xlsxWriter) WriteTotals(cells []string) error {
+	return x.writeRow(cells, true, true)
+}
+
+func (x *xlsxWriter) writeRow(cells []string, bold, numbers bool) error {
+	x.row++
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<row r="%d">`, x.row)
+	for i, cell := range cells {
+		if cell == "" {
+			continue
+		}
+		ref := xlsxColumn(i) + strconv.Itoa(x.row)
+
+		if numbers && i < len(x.cols) && x.cols[i].Numeric {
+			if _, err := strconv.ParseFloat(cell, 64); err == nil {
+				style := 2
+				if bold {
+					style = 3
+				}
+				fmt.Fprintf(&buf, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, cell)
+				continue
+			}
+		}
+
+		style := 0
+		if bold {
+			style = 1
+		}
+		fmt.Fprintf(&buf, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">`, ref, style)
+		xml.EscapeText(&buf, []byte(cell))
+		buf.WriteString(`</t></is></c>`)
+	}
+	buf.WriteString("</row>\n")
+
+	_, err := x.sheet.Write(buf.Bytes())
+	return err
+}
+
+func (x *xlsxWriter) Close() error {
+	if _, err := io.WriteString(x.sheet, "</sheetData>\n</worksheet>"); err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
+
+// xlsxColumn converts a zero-based column index to its letters: 0 is A, 26 is AA
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxSheetName strips the characters sheet names may not contain and
+// shortens the name to the 31 characters allowed
+func xlsxSheetName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return -1
+		}
+		return r
+	}, title)
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	if name == "" {
+		name = "Sheet1"
+	}
+	return name
+}
+
+// PDF page layout: A4 landscape, in points
+const (
+	pdfPageWidth   = 842.0
+	pdfPageHeight  = 595.0
+	pdfMargin      = 36.0
+	pdfBandHeight  = 44.0
+	pdfRowHeight   = 16.0
+	pdfFontSize    = 9.0
+	pdfCellPadding = 4.0
+)
+
+// pdfBrandColor is the RGB fill of the page header band and table heading
+const pdfBrandColor = "0.16 0.29 0.48"
+
+// pdfWriter writes exports as a PDF timesheet: every page carries the
+// company header band, the column headings and a page number. Each page is
+// written out as soon as it is full, and object offsets are tracked so the
+// cross-reference table can be written at the end.
+type pdfWriter struct {
+	w       *countingWriter
+	doc     exportDoc
+	widths  []float64
+	offsets []int64 // by object number; 0 is unused
+	pages   []int   // page object numbers
+	content bytes.Buffer
+	y       float64 // baseline of the next row
+	rows    int
+}
+
+// Fixed PDF object numbers; the page tree is written last, once every page is known
+const (
+	pdfCatalogObj  = 1
+	pdfPagesObj    = 2
+	pdfFontObj     = 3
+	pdfBoldFontObj = 4
+)
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newPDFWriter(w io.Writer, doc exportDoc) (*pdfWriter, error) {
+	p := &pdfWriter{
+		w:       &countingWriter{w: w},
+		doc:     doc,
+		offsets: make([]int64, pdfBoldFontObj+1),
+	}
+
+	total := 0.0
+	for _, col := range doc.Columns {
+		total += col.Width
+	}
+	for _, col := range doc.Columns {
+		p.widths = append(p.widths, col.Width/total*(pdfPageWidth-2*pdfMargin))
+	}
+
+	if _, err := io.WriteString(p.w, "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"); err != nil {
+		return nil, err
+	}
+	objects := map[int]string{
+		pdfCatalogObj:  fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pdfPagesObj),
+		pdfFontObj:     "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		pdfBoldFontObj: "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+	for _, num := range []int{pdfCatalogObj, pdfFontObj, pdfBoldFontObj} {
+		if err := p.writeObject(num, objects[num]); err != nil {
+			return nil, err
+		}
+	}
+
+	p.startPage()
+	return p, nil
+}
+
+// newObject reserves the next object number
+func (p *pdfWriter) newObject() int {
+	p.offsets = append(p.offsets, 0)
+	return len(p.offsets) - 1
+}
+
+func (p *pdfWriter) writeObject(num int, body string) error {
+	p.offsets[num] = p.w.n
+	_, err := fmt.Fprintf(p.w, "%d 0 obj\n%s\nendobj\n", num, body)
+	return err
+}
+
+// startPage draws the header band and the column headings of a new page
+func (p *pdfWriter) startPage() {
+	p.content.Reset()
+	top := pdfPageHeight - pdfMargin
+
+	// Header band with the company name and the document title
+	fmt.Fprintf(&p.content, "%s rg %.2f %.2f %.2f %.2f re f\n",
+		pdfBrandColor, pdfMargin, top-pdfBandHeight, pdfPageWidth-2*pdfMargin, pdfBandHeight)
+	p.text(pdfMargin+12, top-pdfBandHeight/2-5, 16, true, "1 1 1", p.doc.Company)
+	title := p.doc.Title
+	p.text(pdfPageWidth-pdfMargin-12-pdfTextWidth(title, 14), top-pdfBandHeight/2-5, 14, true, "1 1 1", title)
+
+	// Period and generation time below the band
+	info := "Generated " + p.doc.Generated.Format("2006-01-02 15:04 MST")
+	if p.doc.Period != "" {
+		info = "Period: " + p.doc.Period + "    " + info
+	}
+	p.text(pdfMargin, top-pdfBandHeight-16, pdfFontSize, false, "0.3 0.3 0.3", info)
+
+	// Column headings
+	p.y = top - pdfBandHeight - 40
+	fmt.Fprintf(&p.content, "0.88 0.91 0.95 rg %.2f %.2f %.2f %.2f re f\n",
+		pdfMargin, p.y-5, pdfPageWidth-2*pdfMargin, pdfRowHeight)
+	titles := make([]string, len(p.doc.Columns))
+	for i, col := range p.doc.Columns {
+		titles[i] = col.Title
+	}
+	p.cells(titles, true)
+	p.y -= pdfRowHeight
+}
+
+// finishPage draws the footer and writes the page out
+func (p *pdfWriter) finishPage() error {
+	fmt.Fprintf(&p.content, "0.7 0.7 0.7 RG 0.5 w %.2f %.2f m %.2f %.2f l S\n",
+		pdfMargin, pdfMargin+12, pdfPageWidth-pdfMargin, pdfMargin+12)
+	p.text(pdfMargin, pdfMargin, 8, false, "0.4 0.4 0.4", p.doc.Company+" - "+p.doc.Title)
+	number := fmt.Sprintf("Page %d", len(p.pages)+1)
+	p.text(pdfPageWidth-pdfMargin-pdfTextWidth(number, 8), pdfMargin, 8, false, "0.4 0.4 0.4", number)
+
+	contentObj := p.newObject()
+	err := p.writeObject(contentObj, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", p.content.Len(), p.content.String()))
+	if err != nil {
+		return err
+	}
+
+	pageObj := p.newObject()
+	p.pages = append(p.pages, pageObj)
+	return p.writeObject(pageObj, fmt.Sprintf(
+		"<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.0f %.0f] /Contents %d 0 R /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> >> >>",
+		pdfPagesObj, pdfPageWidth, pdfPageHeight, contentObj, pdfFontObj, pdfBoldFontObj,
+	))
+}
+
+// ensureRoom starts a new page when the next row would run into the footer
+func (p *pdfWriter) ensureRoom() error {
+	if p.y-pdfRowHeight >= pdfMargin+16 {
+		return nil
+	}
+	if err := p.finishPage(); err != nil {
+		return err
+	}
+	p.startPage()
+	return nil
+}
+
+func (p *pdfWriter) WriteRow(cells []string) error {
+	if err := p.ensureRoom(); err != nil {
+		return err
+	}
+	if p.rows%2 == 1 {
+		fmt.Fprintf(&p.content, "0.96 0.96 0.96 rg %.2f %.2f %.2f %.2f re f\n",
+			pdfMargin, p.y-5, pdfPageWidth-2*pdfMargin, pdfRowHeight)
+	}
+	p.cells(cells, false)
+	p.y -= pdfRowHeight
+	p.rows++
+	return nil
+}
+
+func (p *pdfWriter) WriteTotals(cells []string) error {
+	if err := p.ensureRoom(); err != nil {
+		return err
+	}
+	fmt.Fprintf(&p.content, "0 0 0 RG 0.8 w %.2f %.2f m %.2f %.2f l S\n",
+		pdfMargin, p.y+pdfRowHeight-5, pdfPageWidth-pdfMargin, p.y+pdfRowHeight-5)
+	p.cells(cells, true)
+	p.y -= pdfRowHeight
+	return nil
+}
+
+func (p *pdfWriter) Close() error {
+	if err := p.finishPage(); err != nil {
+		return err
+	}
+
+	kids := make([]string, len(p.pages))
+	for i, page := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", page)
+	}
+	err := p.writeObject(pdfPagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	if err != nil {
+		return err
+	}
+
+	infoObj := p.newObject()
+	info := fmt.Sprintf("<< /Title %s /Author %s /CreationDate (D:%s) >>",
+		pdfString(p.doc.Title), pdfString(p.doc.Company), p.doc.Generated.UTC().Format("20060102150405Z"))
+	if err := p.writeObject(infoObj, info); err != nil {
+		return err
+	}
+
+	xref := p.w.n
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(p.offsets))
+	for _, offset := range p.offsets[1:] {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(p.offsets), pdfCatalogObj, infoObj, xref)
+	_, err = p.w.Write(buf.Bytes())
+	return err
+}
+
+// cells draws one table row at the current baseline, cutting text that
+// does not fit its column
+func (p *pdfWriter) cells(cells []string, bold bool) {
+	x := pdfMargin
+	for i, width := range p.widths {
+		if i < len(cells) && cells[i] != "" {
+			text := pdfFit(cells[i], pdfFontSize, width-2*pdfCellPadding)
+			tx := x + pdfCellPadding
+			if p.doc.Columns[i].Numeric {
+				tx = x + width - pdfCellPadding - pdfTextWidth(text, pdfFontSize)
+			}
+			p.text(tx, p.y, pdfFontSize, bold, "0 0 0", text)
+		}
+		x += width
+	}
+}
+
+// text draws a line of text in the given RGB color
+func (p *pdfWriter) text(x, y, size float64, bold bool, color, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT %s rg /%s %.1f Tf %.2f %.2f Td %s Tj ET\n", color, font, size, x, y, pdfString(s))
+}
+
+// pdfString encodes text as a PDF string literal in WinAnsiEncoding;
+// characters outside Latin-1 are replaced by "?"
+func pdfString(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(byte(r))
+		case r >= 32 && r < 127, r >= 160 && r <= 255:
+			buf.WriteByte(byte(r))
+		case r == '\t' || r == '\n' || r == '\r':
+			buf.WriteByte(' ')
+		default:
+			buf.WriteByte('?')
+		}
+	}
+	buf.WriteByte(')')
+	return buf.String()
+}
+
+// helveticaWidths are the Helvetica glyph widths of the printable ASCII
+// characters, in thousandths of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 to ?
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ to O
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P to _
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` to o
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p to ~
+}
+
+// pdfTextWidth estimates the width of text in points. Bold text is about
+// 5% wider, which the cell padding absorbs.
+func pdfTextWidth(s string, size float64) float64 {
+	units := 0
+	for _, r := range s {
+		if r >= 32 && r < 127 {
+			units += helveticaWidths[r-32]
+		} else {
+			units += 556
+		}
+	}
+	return float64(units) * size / 1000 * 1.05
+}
+
+// pdfFit shortens text with an ellipsis until it fits the width
+func pdfFit(s string, size, width float64) string {
+	if pdfTextWidth(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && pdfTextWidth(string(runes)+"...", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// exportPeriod formats the date range of a shift filter as days in loc; the end date is inclusive
+func exportPeriod(filter ShiftFilter, loc *time.Location) string {
+	return filter.StartDate.In(loc).Format("2006-01-02") + " to " + filter.EndDate.Add(-time.Nanosecond).In(loc).Format("2006-01-02")
+}
+
+// exportHours formats hours for export cells
+func exportHours(hours float64) string {
+	return strconv.FormatFloat(hours, 'f', 2, 64)
+}
+
+// timesheetExportColumns are the columns of a shift export
+var timesheetExportColumns = []exportColumn{
+	{Title: "Employee", Width: 16},
+	{Title: "Username", Width: 11},
+	{Title: "Date", Width: 9},
+	{Title: "Clock in", Width: 6},
+	{Title: "Clock out", Width: 8},
+	{Title: "Unpaid breaks (h)", Width: 10, Numeric: true},
+	{Title: "Hours", Width: 6, Numeric: true},
+	{Title: "Status", Width: 9},
+	{Title: "Notes", Width: 25},
+}
+
+// timesheetRow formats a shift in the company time zone. It also returns
+// the worked hours and unpaid break hours of the shift for the totals;
+// shifts still in progress have no hours yet.
+func timesheetRow(shift *ShiftWithUserInfo, loc *time.Location) ([]string, float64, float64) {
+	clockIn := shift.ClockIn.In(loc)
+
+	clockOut, hours, hoursCell := "", 0.0, ""
+	if shift.ClockOut != nil {
+		out := shift.ClockOut.In(loc)
+		clockOut = out.Format("15:04")
+		inDay := time.Date(clockIn.Year(), clockIn.Month(), clockIn.Day(), 0, 0, 0, 0, time.UTC)
+		outDay := time.Date(out.Year(), out.Month(), out.Day(), 0, 0, 0, 0, time.UTC)
+		if days := int(outDay.Sub(inDay).Hours() / 24); days > 0 {
+			clockOut += fmt.Sprintf(" (+%d)", days)
+		}
+
+		hours = shift.ClockOut.Sub(shift.ClockIn).Hours() - shift.UnpaidBreakHours
+		if hours < 0 {
+			hours = 0
+		}
+		hoursCell = exportHours(hours)
+	}
+
+	status := shift.Status
+	switch {
+	case (shift.AutoClosed || len(shift.Flags) > 0) && shift.ReviewedAt == nil:
+		status = "needs review"
+	case shift.Status == "in_progress":
+		status = "in progress"
+	}
+
+	return []string{
+		shift.FullName,
+		shift.Username,
+		clockIn.Format("2006-01-02"),
+		clockIn.Format("15:04"),
+		clockOut,
+		exportHours(shift.UnpaidBreakHours),
+		hoursCell,
+		status,
+		shift.Notes,
+	}, hours, shift.UnpaidBreakHours
+}
+
+// reportGroupTitles names the first column of a report export by grouping
+var reportGroupTitles = map[string]string{
+	"":                "Scope",
+	GroupByEmployee:   "Employee",
+	GroupByDepartment: "Department",
+	GroupByDay:        "Day",
+	GroupByWeek:       "Week",
+	GroupByMonth:      "Month",
+}
+
+// reportColumns are the columns of a report export
+func reportColumns(groupBy string) []exportColumn {
+	return []exportColumn{
+		{Title: reportGroupTitles[groupBy], Width: 20},
+		{Title: "Shifts", Width: 7, Numeric: true},
+		{Title: "Completed", Width: 8, Numeric: true},
+		{Title: "Active", Width: 7, Numeric: true},
+		{Title: "Hours", Width: 8, Numeric: true},
+		{Title: "Average hours", Width: 9, Numeric: true},
+		{Title: "Unpaid breaks (h)", Width: 10, Numeric: true},
+		{Title: "Pending review", Width: 9, Numeric: true},
+	}
+}
+
+// reportRow formats the statistics of a report or report group
+func reportRow(label string, report ShiftReport) []string {
+	return []string{
+		label,
+		strconv.Itoa(report.TotalShifts),
+		strconv.Itoa(report.CompletedShifts),
+		strconv.Itoa(report.ActiveShifts),
+		exportHours(report.TotalHours),
+		exportHours(report.AverageHours),
+		exportHours(report.UnpaidBreakHours),
+		strconv.Itoa(report.PendingReview),
+	}
+}
+
+// leaveReportRow formats the approved leave of one type under the report's
+// hours column
+func leaveReportRow(t LeaveTypeHours) []string {
+	label := "Leave: " + t.LeaveType
+	if !t.Paid {
+		label += " (unpaid)"
+	}
+	row := make([]string, len(reportColumns("")))
+	row[0], row[4] = label, exportHours(t.Hours)
+	return row
+}
+
+// leaveTotalsRow formats the total approved leave of a report
+func leaveTotalsRow(leave *LeaveReport) []string {
+	row := make([]string, len(reportColumns("")))
+	row[0], row[4] = "Total leave", exportHours(leave.TotalHours)
+	return row
+}
+
+// projectReportTitles names the first column of a project report export by grouping
+var projectReportTitles = map[string]string{
+	ProjectGroupByProject:  "Project",
+	ProjectGroupByClient:   "Client",
+	ProjectGroupByEmployee: "Employee",
+}
+
+// projectReportColumns are the columns of a project report export: the
+// group, then each line of its breakdown
+func projectReportColumns(groupBy string) []exportColumn {
+	breakdown := "Employee"
+	if groupBy == ProjectGroupByEmployee {
+		breakdown = "Project"
+	}
+	return []exportColumn{
+		{Title: projectReportTitles[groupBy], Width: 20},
+		{Title: breakdown, Width: 20},
+		{Title: "Hours", Width: 8, Numeric: true},
+		{Title: "Billable hours", Width: 9, Numeric: true},
+		{Title: "Shifts", Width: 7, Numeric: true},
+	}
+}
+
+// projectReportRow formats a line, or the subtotal of a group, of a project report
+func projectReportRow(group, line string, hours, billable float64, shifts int) []string {
+	return []string{group, line, exportHours(hours), exportHours(billable), strconv.Itoa(shifts)}
+}
@@ -1,11 +1,18 @@
 package attendance
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gorilla/mux"
 	"modular-erp/internal/core/middleware"
 	"modular-erp/pkg/utils"
 )
@@ -20,14 +27,33 @@ func NewHandler(service *Service) *Handler {
 	return &Handler{service: service}
 }
 
-// ClockInRequest represents a clock-in request
+// ClockInRequest represents a clock-in request; user info comes from the JWT
 type ClockInRequest struct {
-	// No additional fields needed, user info comes from JWT
+	Location  *Location `json:"location"` // optional unless the geofence requires it
+	QRToken   string    `json:"qr_token"` // scanned site QR code, replaces the location
+	ProjectID *int      `json:"project_id"`
 }
 
 // ClockOutRequest represents a clock-out request
 type ClockOutRequest struct {
-	Notes string `json:"notes"`
+	Notes    string    `json:"notes"`
+	Location *Location `json:"location"`
+	QRToken  string    `json:"qr_token"`
+}
+
+// punch describes the clock-in made by this request
+func (req ClockInRequest) punch(r *http.Request) Punch {
+	return Punch{
+		Location:  req.Location,
+		QRToken:   req.QRToken,
+		SourceIP:  middleware.GetClientIP(r),
+		ProjectID: req.ProjectID,
+	}
+}
+
+// punch describes the clock-out made by this request
+func (req ClockOutRequest) punch(r *http.Request) Punch {
+	return Punch{Location: req.Location, QRToken: req.QRToken, SourceIP: middleware.GetClientIP(r)}
 }
 
 // ClockIn handles employee clock-in
@@ -38,7 +64,14 @@ func (h *Handler) ClockIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	shift, err := h.service.ClockIn(claims.UserID, claims.CompanyID)
+	// The body is optional for clients that send no location
+	var req ClockInRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	shift, err := h.service.ClockIn(claims.UserID, claims.CompanyID, req.punch(r))
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
@@ -64,7 +97,7 @@ func (h *Handler) ClockOut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	shift, err := h.service.ClockOut(claims.UserID, req.Notes)
+	shift, err := h.service.ClockOut(claims.UserID, claims.CompanyID, req.Notes, req.punch(r))
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
@@ -76,6 +109,57 @@ func (h *Handler) ClockOut(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// StartBreakRequest represents a request to start a break
+type StartBreakRequest struct {
+	Type string `json:"type"` // paid or unpaid, defaults to unpaid
+}
+
+// StartBreak starts a break in the authenticated user's active shift
+func (h *Handler) StartBreak(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req StartBreakRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	b, err := h.service.StartBreak(claims.UserID, req.Type)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": "Break started",
+		"break":   b,
+	})
+}
+
+// EndBreak ends the authenticated user's current break
+func (h *Handler) EndBreak(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	b, err := h.service.EndBreak(claims.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Break ended",
+		"break":   b,
+	})
+}
+
 // GetMyShifts retrieves shifts for the authenticated user
 func (h *Handler) GetMyShifts(w http.ResponseWriter, r *http.Request) {
 	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
@@ -121,12 +205,20 @@ func (h *Handler) GetActiveShift(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	currentBreak, err := h.service.GetCurrentBreak(shift.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve active shift")
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, map[string]interface{}{
 		"shift": shift,
+		"break": currentBreak,
 	})
 }
 
-// GetAllShifts retrieves all shifts for the company (manager/admin only)
+// GetAllShifts retrieves shifts for the company (manager/admin only).
+// Managers only see shifts of their reporting subtree.
 func (h *Handler) GetAllShifts(w http.ResponseWriter, r *http.Request) {
 	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
 	if !ok {
@@ -138,23 +230,24 @@ func (h *Handler) GetAllShifts(w http.ResponseWriter, r *http.Request) {
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
 
-	// Parse date range (default to last 30 days)
-	endDate := time.Now()
-	startDate := endDate.AddDate(0, 0, -30)
+	format, err := exportFormat(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	if startStr := r.URL.Query().Get("start_date"); startStr != "" {
-		if parsed, err := time.Parse("2006-01-02", startStr); err == nil {
-			startDate = parsed
-		}
+	filter, err := h.parseShiftFilter(r, claims)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to resolve reporting lines")
+		return
 	}
 
-	if endStr := r.URL.Query().Get("end_date"); endStr != "" {
-		if parsed, err := time.Parse("2006-01-02", endStr); err == nil {
-			endDate = parsed.Add(24 * time.Hour) // Include the entire end date
-		}
+	if format != "" {
+		h.exportShifts(w, claims.CompanyID, filter, format)
+		return
 	}
 
-	shifts, err := h.service.GetAllShifts(claims.CompanyID, startDate, endDate, limit, offset)
+	shifts, err := h.service.GetAllShifts(filter, limit, offset)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve shifts")
 		return
@@ -163,12 +256,13 @@ func (h *Handler) GetAllShifts(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, map[string]interface{}{
 		"shifts":     shifts,
 		"count":      len(shifts),
-		"start_date": startDate.Format("2006-01-02"),
-		"end_date":   endDate.Format("2006-01-02"),
+		"start_date": filter.StartDate.Format("2006-01-02"),
+		"end_date":   filter.EndDate.Format("2006-01-02"),
 	})
 }
 
-// GetReport generates attendance statistics (manager/admin only)
+// GetReport generates attendance statistics (manager/admin only).
+// Managers only see statistics for their reporting subtree.
 func (h *Handler) GetReport(w http.ResponseWriter, r *http.Request) {
 	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
 	if !ok {
@@ -176,35 +270,3454 @@ func (h *Handler) GetReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse date range (default to last 30 days)
-	endDate := time.Now()
-	startDate := endDate.AddDate(0, 0, -30)
+	format, err := exportFormat(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	if startStr := r.URL.Query().Get("start_date"); startStr != "" {
-		if parsed, err := time.Parse("2006-01-02", startStr); err == nil {
-			startDate = parsed
+	filter, err := h.parseShiftFilter(r, claims)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to resolve reporting lines")
+		return
+	}
+
+	report, err := h.service.GetReport(filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate report")
+		return
+	}
+
+	response := map[string]interface{}{
+		"report":     report,
+		"start_date": filter.StartDate.Format("2006-01-02"),
+		"end_date":   filter.EndDate.Format("2006-01-02"),
+	}
+
+	leave, err := h.service.GetLeaveReport(filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate report")
+		return
+	}
+	response["leave"] = leave
+
+	groupBy := r.URL.Query().Get("group_by")
+	var groups []ShiftReportGroup
+	if groupBy != "" {
+		desc := false
+		switch r.URL.Query().Get("order") {
+		case "", "asc":
+		case "desc":
+			desc = true
+		default:
+			respondWithError(w, http.StatusBadRequest, "order must be asc or desc")
+			return
 		}
+
+		groups, err = h.service.GetReportGroups(filter, groupBy, r.URL.Query().Get("sort"), desc)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response["group_by"] = groupBy
+		response["groups"] = groups
+		response["count"] = len(groups)
 	}
 
-	if endStr := r.URL.Query().Get("end_date"); endStr != "" {
-		if parsed, err := time.Parse("2006-01-02", endStr); err == nil {
-			endDate = parsed.Add(24 * time.Hour)
+	if format != "" {
+		h.exportReport(w, claims.CompanyID, filter, format, groupBy, report, groups, leave)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// exportShifts streams the filtered shifts as a timesheet. Rows are written
+// as they are read, so once the export has started an error can only cut
+// it short.
+func (h *Handler) exportShifts(w http.ResponseWriter, companyID int, filter ShiftFilter, format string) {
+	loc := h.service.CompanyLocation(companyID)
+	doc := exportDoc{
+		Title:     "Timesheet",
+		Company:   h.service.CompanyName(companyID),
+		Period:    exportPeriod(filter, loc),
+		Generated: time.Now().In(loc),
+		Columns:   timesheetExportColumns,
+	}
+
+	var out tableWriter
+	started := false
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+		setExportHeaders(w, format, "timesheet-"+filter.StartDate.Format("2006-01-02"))
+		w.WriteHeader(http.StatusOK)
+		var err error
+		out, err = newTableWriter(w, format, doc)
+		return err
+	}
+
+	var totalHours, totalBreaks float64
+	err := h.service.StreamShifts(filter, func(shift *ShiftWithUserInfo) error {
+		if err := start(); err != nil {
+			return err
+		}
+		row, hours, breaks := timesheetRow(shift, loc)
+		totalHours += hours
+		totalBreaks += breaks
+		return out.WriteRow(row)
+	})
+	if err == nil {
+		if err = start(); err == nil {
+			totals := make([]string, len(timesheetExportColumns))
+			totals[0], totals[5], totals[6] = "Total", exportHours(totalBreaks), exportHours(totalHours)
+			if err = out.WriteTotals(totals); err == nil {
+				err = out.Close()
+			}
 		}
 	}
 
-	report, err := h.service.GetReport(claims.CompanyID, startDate, endDate)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to generate report")
+		if !started {
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve shifts")
+			return
+		}
+		log.Printf("Shift export for company %d cut short: %v", companyID, err)
+	}
+}
+
+// exportReport writes the report statistics, and the groups if any, as a
+// table, followed by the approved leave taken in the period
+func (h *Handler) exportReport(w http.ResponseWriter, companyID int, filter ShiftFilter, format, groupBy string, report *ShiftReport, groups []ShiftReportGroup, leave *LeaveReport) {
+	loc := h.service.CompanyLocation(companyID)
+	title := "Attendance report"
+	if groupBy != "" {
+		title += " by " + groupBy
+	}
+	doc := exportDoc{
+		Title:     title,
+		Company:   h.service.CompanyName(companyID),
+		Period:    exportPeriod(filter, loc),
+		Generated: time.Now().In(loc),
+		Columns:   reportColumns(groupBy),
+	}
+
+	setExportHeaders(w, format, "attendance-report-"+filter.StartDate.Format("2006-01-02"))
+	w.WriteHeader(http.StatusOK)
+
+	out, err := newTableWriter(w, format, doc)
+	for i := 0; err == nil && i < len(groups); i++ {
+		err = out.WriteRow(reportRow(groups[i].Label, groups[i].ShiftReport))
+	}
+	if err == nil {
+		err = out.WriteTotals(reportRow("Total", *report))
+	}
+	for i := 0; err == nil && i < len(leave.ByType); i++ {
+		err = out.WriteRow(leaveReportRow(leave.ByType[i]))
+	}
+	if err == nil && len(leave.ByType) > 0 {
+		err = out.WriteTotals(leaveTotalsRow(leave))
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		log.Printf("Report export for company %d cut short: %v", companyID, err)
+	}
+}
+
+// GetPolicy retrieves the company's attendance policy (manager/admin only)
+func (h *Handler) GetPolicy(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	policy, err := h.service.GetPolicy(claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve policy")
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"report":     report,
-		"start_date": startDate.Format("2006-01-02"),
-		"end_date":   endDate.Format("2006-01-02"),
+		"policy": policy,
+	})
+}
+
+// UpdatePolicy updates the company's attendance policy (admin only)
+func (h *Handler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Start from the current policy so omitted fields keep their values
+	policy, err := h.service.GetPolicy(claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve policy")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(policy); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	policy.CompanyID = claims.CompanyID
+
+	updated, err := h.service.UpdatePolicy(policy)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Policy updated",
+		"policy":  updated,
+	})
+}
+
+// ReviewShift marks an auto-closed shift as reviewed (manager/admin only)
+func (h *Handler) ReviewShift(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	shiftID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid shift ID")
+		return
+	}
+
+	shift, err := h.service.ReviewShift(claims.CompanyID, shiftID, claims.UserID, claims.Role)
+	if err == ErrShiftNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Shift reviewed",
+		"shift":   shift,
+	})
+}
+
+// AddShiftRequest represents a request to add a missing shift
+type AddShiftRequest struct {
+	UserID int `json:"user_id"`
+	Correction
+}
+
+// AddShift records a shift an employee never punched (manager/admin only)
+func (h *Handler) AddShift(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req AddShiftRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	shift, err := h.service.AddShift(claims.CompanyID, req.UserID, claims.UserID, claims.Role, req.Correction)
+	if err == ErrShiftNotFound {
+		respondWithError(w, http.StatusForbidden, "User is not in your reporting line")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": "Shift added",
+		"shift":   shift,
+	})
+}
+
+// CorrectShift changes the clock-in/out times of a shift (manager/admin only)
+func (h *Handler) CorrectShift(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	shiftID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid shift ID")
+		return
+	}
+
+	var req Correction
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	shift, err := h.service.CorrectShift(claims.CompanyID, shiftID, claims.UserID, claims.Role, req)
+	if err == ErrShiftNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Shift corrected",
+		"shift":   shift,
+	})
+}
+
+// ManagerCancelShiftRequest represents a request to cancel an employee's shift
+type ManagerCancelShiftRequest struct {
+	ReasonCode string `json:"reason_code"`
+	Comment    string `json:"comment"`
+}
+
+// ManagerCancelShift cancels an employee's shift (manager/admin only)
+func (h *Handler) ManagerCancelShift(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	shiftID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid shift ID")
+		return
+	}
+
+	var req ManagerCancelShiftRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	shift, err := h.service.CancelShift(claims.CompanyID, shiftID, claims.UserID, claims.Role, req.ReasonCode, req.Comment)
+	if err == ErrShiftNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Shift cancelled",
+		"shift":   shift,
 	})
 }
 
+// GetShiftRevisions retrieves the correction history of a shift (manager/admin only)
+func (h *Handler) GetShiftRevisions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	shiftID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid shift ID")
+		return
+	}
+
+	revisions, err := h.service.GetRevisions(claims.CompanyID, shiftID, claims.UserID, claims.Role)
+	if err == ErrShiftNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve revisions")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"revisions": revisions,
+		"count":     len(revisions),
+	})
+}
+
+// PunchRequestRequest represents an employee's missed-punch or correction request
+type PunchRequestRequest struct {
+	ShiftID    *int       `json:"shift_id"` // omit to request a missing shift
+	ClockIn    time.Time  `json:"clock_in"`
+	ClockOut   *time.Time `json:"clock_out"`
+	ReasonCode string     `json:"reason_code"`
+	Comment    string     `json:"comment"`
+}
+
+// SubmitPunchRequest submits a request for the authenticated user's own shift
+func (h *Handler) SubmitPunchRequest(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req PunchRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	created, err := h.service.SubmitPunchRequest(&PunchRequest{
+		CompanyID:  claims.CompanyID,
+		UserID:     claims.UserID,
+		ShiftID:    req.ShiftID,
+		ClockIn:    req.ClockIn,
+		ClockOut:   req.ClockOut,
+		ReasonCode: req.ReasonCode,
+		Comment:    req.Comment,
+	})
+	if err == ErrShiftNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message":       "Request submitted",
+		"punch_request": created,
+	})
+}
+
+// GetMyPunchRequests retrieves the authenticated user's requests
+func (h *Handler) GetMyPunchRequests(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+
+	requests, err := h.service.GetMyPunchRequests(claims.CompanyID, claims.UserID, r.URL.Query().Get("status"), limit, offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve requests")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"punch_requests": requests,
+		"count":          len(requests),
+	})
+}
+
+// WithdrawPunchRequest withdraws one of the authenticated user's pending requests
+func (h *Handler) WithdrawPunchRequest(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request ID")
+		return
+	}
+
+	withdrawn, err := h.service.WithdrawPunchRequest(claims.CompanyID, claims.UserID, id)
+	if err == ErrPunchRequestNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":       "Request withdrawn",
+		"punch_request": withdrawn,
+	})
+}
+
+// GetPunchRequestQueue lists requests to review, pending ones by default (manager/admin only)
+func (h *Handler) GetPunchRequestQueue(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "pending"
+	} else if status == "all" {
+		status = ""
+	}
+
+	requests, err := h.service.GetPunchRequestQueue(claims.CompanyID, claims.UserID, claims.Role, status, limit, offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve requests")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"punch_requests": requests,
+		"count":          len(requests),
+	})
+}
+
+// ReviewPunchRequestRequest represents an approval or rejection
+type ReviewPunchRequestRequest struct {
+	Comment string `json:"comment"`
+}
+
+// ApprovePunchRequest approves a request and applies it to the shift (manager/admin only)
+func (h *Handler) ApprovePunchRequest(w http.ResponseWriter, r *http.Request) {
+	h.reviewPunchRequest(w, r, true)
+}
+
+// RejectPunchRequest rejects a request (manager/admin only)
+func (h *Handler) RejectPunchRequest(w http.ResponseWriter, r *http.Request) {
+	h.reviewPunchRequest(w, r, false)
+}
+
+func (h *Handler) reviewPunchRequest(w http.ResponseWriter, r *http.Request, approve bool) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request ID")
+		return
+	}
+
+	var req ReviewPunchRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	reviewed, shift, err := h.service.ReviewPunchRequest(claims.CompanyID, id, claims.UserID, claims.Role, approve, req.Comment)
+	if err == ErrPunchRequestNotFound || err == ErrShiftNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	message := "Request rejected"
+	if approve {
+		message = "Request approved"
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":       message,
+		"punch_request": reviewed,
+		"shift":         shift,
+	})
+}
+
+// GetSites lists the company's work sites (manager/admin only)
+func (h *Handler) GetSites(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	sites, err := h.service.GetSites(claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve work sites")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"sites": sites,
+		"count": len(sites),
+	})
+}
+
+// CreateSite creates a work site (admin only)
+func (h *Handler) CreateSite(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var site WorkSite
+	if err := json.NewDecoder(r.Body).Decode(&site); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	site.CompanyID = claims.CompanyID
+
+	created, err := h.service.CreateSite(&site)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": "Work site created",
+		"site":    created,
+	})
+}
+
+// UpdateSite updates a work site (admin only)
+func (h *Handler) UpdateSite(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	// Start from the current site so omitted fields keep their values
+	site, err := h.service.GetSite(claims.CompanyID, id)
+	if err == ErrSiteNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve work site")
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(site); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	site.ID = id
+	site.CompanyID = claims.CompanyID
+
+	updated, err := h.service.UpdateSite(site)
+	if err == ErrSiteNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Work site updated",
+		"site":    updated,
+	})
+}
+
+// DeleteSite deletes a work site (admin only)
+func (h *Handler) DeleteSite(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid site ID")
+		return
+	}
+
+	err = h.service.DeleteSite(claims.CompanyID, id)
+	if err == ErrSiteNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete work site")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Work site deleted",
+	})
+}
+
+// kioskKey is the context key for the kiosk authenticated by kioskAuth
+const kioskKey middleware.ContextKey = "kiosk"
+
+// kioskAuth authenticates kiosks by the device token in the Authorization header
+func (h *Handler) kioskAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			respondWithError(w, http.StatusUnauthorized, "Kiosk token required")
+			return
+		}
+
+		kiosk, err := h.service.AuthenticateKiosk(token)
+		if err == ErrKioskNotFound {
+			respondWithError(w, http.StatusUnauthorized, "Invalid kiosk token")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to authenticate kiosk")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), kioskKey, kiosk)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// KioskPunchRequest identifies the employee punching on a kiosk by PIN or badge
+type KioskPunchRequest struct {
+	PIN     string `json:"pin"`
+	BadgeID string `json:"badge_id"`
+	Notes   string `json:"notes"` // clock-out only
+}
+
+// KioskClockIn clocks in the employee identified at a kiosk
+func (h *Handler) KioskClockIn(w http.ResponseWriter, r *http.Request) {
+	h.kioskPunch(w, r, false)
+}
+
+// KioskClockOut clocks out the employee identified at a kiosk
+func (h *Handler) KioskClockOut(w http.ResponseWriter, r *http.Request) {
+	h.kioskPunch(w, r, true)
+}
+
+func (h *Handler) kioskPunch(w http.ResponseWriter, r *http.Request, clockOut bool) {
+	kiosk, ok := r.Context().Value(kioskKey).(*Kiosk)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req KioskPunchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	kind, value := CredentialPIN, req.PIN
+	if req.BadgeID != "" {
+		kind, value = CredentialBadge, req.BadgeID
+	}
+	if value == "" || (req.PIN != "" && req.BadgeID != "") {
+		respondWithError(w, http.StatusBadRequest, "Either pin or badge_id is required")
+		return
+	}
+
+	userID, fullName, err := h.service.IdentifyAtKiosk(kiosk, kind, value)
+	switch err {
+	case nil:
+	case ErrKioskLocked:
+		respondWithError(w, http.StatusTooManyRequests, err.Error())
+		return
+	case ErrUnknownCredential:
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Failed to identify employee")
+		return
+	}
+
+	punch, err := h.service.KioskPunch(kiosk, middleware.GetClientIP(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to record punch")
+		return
+	}
+
+	var shift *Shift
+	message, status := "Clocked in successfully", http.StatusCreated
+	if clockOut {
+		shift, err = h.service.ClockOut(userID, kiosk.CompanyID, req.Notes, punch)
+		message, status = "Clocked out successfully", http.StatusOK
+	} else {
+		shift, err = h.service.ClockIn(userID, kiosk.CompanyID, punch)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, status, map[string]interface{}{
+		"message":   message,
+		"full_name": fullName,
+		"shift":     shift,
+	})
+}
+
+// GetKioskQRCode returns the current QR token for the kiosk to display
+func (h *Handler) GetKioskQRCode(w http.ResponseWriter, r *http.Request) {
+	kiosk, ok := r.Context().Value(kioskKey).(*Kiosk)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	token, expiresAt, err := h.service.KioskQRToken(kiosk)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"token":           token,
+		"site_id":         kiosk.SiteID,
+		"expires_at":      expiresAt,
+		"refresh_seconds": int(QRTokenPeriod / time.Second),
+	})
+}
+
+// deviceKey is the context key for the time clock authenticated by deviceAuth
+const deviceKey middleware.ContextKey = "clockDevice"
+
+// deviceAuth authenticates time clocks by a verified client certificate
+// mapped to the device, or else by the API secret in the Authorization header
+func (h *Handler) deviceAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// VerifiedChains is only populated once the TLS stack checked the
+		// certificate against the configured client CA
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			device, err := h.service.AuthenticateDeviceCertificate(r.TLS.VerifiedChains[0][0])
+			if err == nil {
+				ctx := context.WithValue(r.Context(), deviceKey, device)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			if err != ErrDeviceNotFound {
+				respondWithError(w, http.StatusInternalServerError, "Failed to authenticate device")
+				return
+			}
+		}
+
+		secret := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if secret == "" || secret == r.Header.Get("Authorization") {
+			respondWithError(w, http.StatusUnauthorized, "Device secret or client certificate required")
+			return
+		}
+
+		device, err := h.service.AuthenticateDevice(secret)
+		if err == ErrDeviceNotFound {
+			respondWithError(w, http.StatusUnauthorized, "Invalid device secret")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to authenticate device")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), deviceKey, device)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// IngestDeviceEventsRequest is a batch of badge events from a time clock
+type IngestDeviceEventsRequest struct {
+	Events []DeviceEvent `json:"events"`
+}
+
+// IngestDeviceEvents accepts a batch of badge events from a time clock
+func (h *Handler) IngestDeviceEvents(w http.ResponseWriter, r *http.Request) {
+	device, ok := r.Context().Value(deviceKey).(*ClockDevice)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req IngestDeviceEventsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	results, err := h.service.IngestDeviceEvents(device, req.Events)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+		"summary": counts,
+		"count":   len(results),
+	})
+}
+
+// GetDevices lists the company's time clocks (admin only)
+func (h *Handler) GetDevices(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	devices, err := h.service.GetDevices(claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve devices")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"devices": devices,
+		"count":   len(devices),
+	})
+}
+
+// CreateDevice registers a time clock; the API secret is only returned here (admin only)
+func (h *Handler) CreateDevice(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var device ClockDevice
+	if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	device.CompanyID = claims.CompanyID
+
+	created, err := h.service.CreateDevice(&device)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": "Device created; store the secret now, it will not be shown again",
+		"device":  created,
+	})
+}
+
+// RevokeDevice deactivates a time clock (admin only)
+func (h *Handler) RevokeDevice(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid device ID")
+		return
+	}
+
+	err = h.service.RevokeDevice(claims.CompanyID, id)
+	if err == ErrDeviceNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke device")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Device revoked",
+	})
+}
+
+// GetKiosks lists the company's kiosks (admin only)
+func (h *Handler) GetKiosks(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	kiosks, err := h.service.GetKiosks(claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve kiosks")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"kiosks": kiosks,
+		"count":  len(kiosks),
+	})
+}
+
+// CreateKiosk registers a kiosk; the device token is only returned here (admin only)
+func (h *Handler) CreateKiosk(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var kiosk Kiosk
+	if err := json.NewDecoder(r.Body).Decode(&kiosk); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	kiosk.CompanyID = claims.CompanyID
+
+	created, err := h.service.CreateKiosk(&kiosk)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": "Kiosk created; store the token now, it will not be shown again",
+		"kiosk":   created,
+	})
+}
+
+// RevokeKiosk deactivates a kiosk (admin only)
+func (h *Handler) RevokeKiosk(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid kiosk ID")
+		return
+	}
+
+	err = h.service.RevokeKiosk(claims.CompanyID, id)
+	if err == ErrKioskNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to revoke kiosk")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Kiosk revoked",
+	})
+}
+
+// SetKioskCredentials assigns an employee's kiosk PIN and/or badge (admin only)
+func (h *Handler) SetKioskCredentials(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req KioskCredentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.service.SetKioskCredentials(claims.CompanyID, userID, req); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Kiosk credentials updated",
+	})
+}
+
+// DeleteKioskCredentials removes an employee's kiosk PIN and badge (admin only)
+func (h *Handler) DeleteKioskCredentials(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.service.DeleteKioskCredentials(claims.CompanyID, userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to remove kiosk credentials")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Kiosk credentials removed",
+	})
+}
+
+// GetRoster lists the scheduled shifts of a week, drafts included (manager/admin only)
+func (h *Handler) GetRoster(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	from, to, err := h.scheduleRange(r, claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	roster, err := h.service.GetRoster(claims.CompanyID, claims.UserID, claims.Role, from, to)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve roster")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"from":   from,
+		"to":     to,
+		"shifts": roster,
+		"count":  len(roster),
+	})
+}
+
+// GetMySchedule lists the caller's published shifts of a week
+func (h *Handler) GetMySchedule(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	from, to, err := h.scheduleRange(r, claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	schedule, err := h.service.GetMySchedule(claims.CompanyID, claims.UserID, from, to)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve schedule")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"from":   from,
+		"to":     to,
+		"shifts": schedule,
+		"count":  len(schedule),
+	})
+}
+
+// CreateScheduledShift adds a draft shift to the roster (manager/admin only)
+func (h *Handler) CreateScheduledShift(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var ss ScheduledShift
+	if err := json.NewDecoder(r.Body).Decode(&ss); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	ss.CompanyID = claims.CompanyID
+
+	created, err := h.service.CreateScheduledShift(&ss, claims.UserID, claims.Role)
+	if err == ErrScheduledShiftNotFound {
+		respondWithError(w, http.StatusForbidden, "User is not in your reporting line")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": "Shift scheduled",
+		"shift":   created,
+	})
+}
+
+// UpdateScheduledShift changes a scheduled shift (manager/admin only)
+func (h *Handler) UpdateScheduledShift(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid scheduled shift ID")
+		return
+	}
+
+	var ss ScheduledShift
+	if err := json.NewDecoder(r.Body).Decode(&ss); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	ss.ID = id
+	ss.CompanyID = claims.CompanyID
+
+	updated, err := h.service.UpdateScheduledShift(&ss, claims.UserID, claims.Role)
+	if err == ErrScheduledShiftNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Scheduled shift updated",
+		"shift":   updated,
+	})
+}
+
+// DeleteScheduledShift removes a draft or cancels a published shift (manager/admin only)
+func (h *Handler) DeleteScheduledShift(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid scheduled shift ID")
+		return
+	}
+
+	err = h.service.DeleteScheduledShift(claims.CompanyID, id, claims.UserID, claims.Role)
+	if err == ErrScheduledShiftNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Scheduled shift removed",
+	})
+}
+
+// PublishSchedule publishes the draft shifts of a week (manager/admin only)
+func (h *Handler) PublishSchedule(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	from, to, err := h.scheduleRange(r, claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	published, err := h.service.PublishSchedule(claims.CompanyID, claims.UserID, claims.Role, from, to)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to publish schedule")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":   "Schedule published",
+		"from":      from,
+		"to":        to,
+		"published": published,
+	})
+}
+
+// GetScheduleReport compares the published schedule with the shifts worked (manager/admin only)
+func (h *Handler) GetScheduleReport(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	from, to, err := h.scheduleRange(r, claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	comparisons, summary, err := h.service.CompareSchedule(claims.CompanyID, claims.UserID, claims.Role, from, to)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate schedule report")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"from":    from,
+		"to":      to,
+		"summary": summary,
+		"shifts":  comparisons,
+		"count":   len(comparisons),
+	})
+}
+
+// GetShiftTemplates lists the company's shift templates (manager/admin only)
+func (h *Handler) GetShiftTemplates(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	templates, err := h.service.GetShiftTemplates(claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve shift templates")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"templates": templates,
+		"count":     len(templates),
+	})
+}
+
+// CreateShiftTemplate creates a shift template (admin only)
+func (h *Handler) CreateShiftTemplate(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var t ShiftTemplate
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	t.CompanyID = claims.CompanyID
+
+	created, err := h.service.CreateShiftTemplate(&t)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message":  "Shift template created",
+		"template": created,
+	})
+}
+
+// UpdateShiftTemplate updates a shift template (admin only)
+func (h *Handler) UpdateShiftTemplate(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid template ID")
+		return
+	}
+
+	var t ShiftTemplate
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	t.ID = id
+	t.CompanyID = claims.CompanyID
+
+	updated, err := h.service.UpdateShiftTemplate(&t)
+	if err == ErrShiftTemplateNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":  "Shift template updated",
+		"template": updated,
+	})
+}
+
+// DeleteShiftTemplate deletes a shift template (admin only)
+func (h *Handler) DeleteShiftTemplate(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid template ID")
+		return
+	}
+
+	err = h.service.DeleteShiftTemplate(claims.CompanyID, id)
+	if err == ErrShiftTemplateNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Shift template deleted",
+	})
+}
+
+// GetRotations lists the company's rotations (manager/admin only)
+func (h *Handler) GetRotations(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	rotations, err := h.service.GetRotations(claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve rotations")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"rotations": rotations,
+		"count":     len(rotations),
+	})
+}
+
+// CreateRotation creates a rotation (admin only)
+func (h *Handler) CreateRotation(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var rotation Rotation
+	if err := json.NewDecoder(r.Body).Decode(&rotation); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	rotation.CompanyID = claims.CompanyID
+
+	created, err := h.service.CreateRotation(&rotation)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message":  "Rotation created",
+		"rotation": created,
+	})
+}
+
+// UpdateRotation updates a rotation (admin only)
+func (h *Handler) UpdateRotation(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid rotation ID")
+		return
+	}
+
+	var rotation Rotation
+	if err := json.NewDecoder(r.Body).Decode(&rotation); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	rotation.ID = id
+	rotation.CompanyID = claims.CompanyID
+
+	updated, err := h.service.UpdateRotation(&rotation)
+	if err == ErrRotationNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":  "Rotation updated",
+		"rotation": updated,
+	})
+}
+
+// DeleteRotation deletes a rotation (admin only)
+func (h *Handler) DeleteRotation(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid rotation ID")
+		return
+	}
+
+	err = h.service.DeleteRotation(claims.CompanyID, id)
+	if err == ErrRotationNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Rotation deleted",
+	})
+}
+
+// GetHolidays lists the company's holidays, optionally of one year (manager/admin only)
+func (h *Handler) GetHolidays(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	year := 0
+	if yearStr := r.URL.Query().Get("year"); yearStr != "" {
+		var err error
+		if year, err = strconv.Atoi(yearStr); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid year")
+			return
+		}
+	}
+
+	holidays, err := h.service.GetHolidays(claims.CompanyID, year)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve holidays")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"holidays": holidays,
+		"count":    len(holidays),
+	})
+}
+
+// CreateHoliday adds a company holiday (admin only)
+func (h *Handler) CreateHoliday(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var holiday Holiday
+	if err := json.NewDecoder(r.Body).Decode(&holiday); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	holiday.CompanyID = claims.CompanyID
+
+	created, err := h.service.CreateHoliday(&holiday)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": "Holiday created",
+		"holiday": created,
+	})
+}
+
+// DeleteHoliday removes a company holiday (admin only)
+func (h *Handler) DeleteHoliday(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid holiday ID")
+		return
+	}
+
+	err = h.service.DeleteHoliday(claims.CompanyID, id)
+	if err == ErrHolidayNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Holiday deleted",
+	})
+}
+
+// CheckHoliday reports whether a date, today by default, is a holiday of
+// the company, from its own holidays or its assigned calendars
+func (h *Handler) CheckHoliday(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	date, holiday, err := h.service.IsHoliday(claims.CompanyID, r.URL.Query().Get("date"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"date":       date,
+		"is_holiday": holiday != nil,
+		"holiday":    holiday,
+	})
+}
+
+// GetHolidayCalendars lists the company's holiday calendars (manager/admin only)
+func (h *Handler) GetHolidayCalendars(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	calendars, err := h.service.GetHolidayCalendars(claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve holiday calendars")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"calendars": calendars,
+		"count":     len(calendars),
+	})
+}
+
+// CreateHolidayCalendar creates a holiday calendar (admin only)
+func (h *Handler) CreateHolidayCalendar(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var calendar HolidayCalendar
+	if err := json.NewDecoder(r.Body).Decode(&calendar); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	calendar.ID = 0
+	calendar.CompanyID = claims.CompanyID
+
+	created, err := h.service.CreateHolidayCalendar(&calendar)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message":  "Holiday calendar created",
+		"calendar": created,
+	})
+}
+
+// UpdateHolidayCalendar updates a holiday calendar, or assigns it to the
+// company; omitted fields keep their current values (admin only)
+func (h *Handler) UpdateHolidayCalendar(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid holiday calendar ID")
+		return
+	}
+
+	calendar, err := h.service.GetHolidayCalendar(claims.CompanyID, id)
+	if err == ErrHolidayCalendarNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve holiday calendar")
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(calendar); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	calendar.ID = id
+	calendar.CompanyID = claims.CompanyID
+
+	updated, err := h.service.UpdateHolidayCalendar(calendar)
+	if err == ErrHolidayCalendarNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":  "Holiday calendar updated",
+		"calendar": updated,
+	})
+}
+
+// DeleteHolidayCalendar deletes a holiday calendar with its holidays (admin only)
+func (h *Handler) DeleteHolidayCalendar(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid holiday calendar ID")
+		return
+	}
+
+	err = h.service.DeleteHolidayCalendar(claims.CompanyID, id)
+	if err == ErrHolidayCalendarNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Holiday calendar deleted",
+	})
+}
+
+// GetCalendarHolidays lists a calendar's holidays, optionally of one year
+// (manager/admin only)
+func (h *Handler) GetCalendarHolidays(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid holiday calendar ID")
+		return
+	}
+
+	year := 0
+	if yearStr := r.URL.Query().Get("year"); yearStr != "" {
+		if year, err = strconv.Atoi(yearStr); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid year")
+			return
+		}
+	}
+
+	holidays, err := h.service.GetCalendarHolidays(claims.CompanyID, id, year)
+	if err == ErrHolidayCalendarNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve holidays")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"holidays": holidays,
+		"count":    len(holidays),
+	})
+}
+
+// CreateCalendarHoliday adds a holiday to a calendar by hand (admin only)
+func (h *Handler) CreateCalendarHoliday(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid holiday calendar ID")
+		return
+	}
+
+	var holiday Holiday
+	if err := json.NewDecoder(r.Body).Decode(&holiday); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	created, err := h.service.CreateCalendarHoliday(claims.CompanyID, id, &holiday)
+	if err == ErrHolidayCalendarNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": "Holiday created",
+		"holiday": created,
+	})
+}
+
+// DeleteCalendarHoliday removes a holiday from a calendar (admin only)
+func (h *Handler) DeleteCalendarHoliday(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	calendarID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid holiday calendar ID")
+		return
+	}
+	id, err := strconv.Atoi(vars["holiday_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid holiday ID")
+		return
+	}
+
+	err = h.service.DeleteCalendarHoliday(claims.CompanyID, calendarID, id)
+	if err == ErrHolidayNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Holiday deleted",
+	})
+}
+
+// maxICalendarSize caps the size of an uploaded iCalendar file
+const maxICalendarSize = 1 << 20
+
+// ImportHolidayCalendar imports the holidays of an iCalendar (.ics) file,
+// sent as the request body, into a calendar; replace=true also removes
+// holidays imported before that are not in the file (admin only)
+func (h *Handler) ImportHolidayCalendar(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid holiday calendar ID")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxICalendarSize))
+	if err != nil {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "The calendar file is too large")
+		return
+	}
+
+	replace := r.URL.Query().Get("replace") == "true"
+	result, err := h.service.ImportHolidayCalendar(claims.CompanyID, id, bytes.NewReader(body), replace)
+	if err == ErrHolidayCalendarNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Holidays imported",
+		"result":  result,
+	})
+}
+
+// GenerateSchedule lays out draft shifts from a template or rotation, or
+// previews them (manager/admin only)
+func (h *Handler) GenerateSchedule(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req GenerateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	result, err := h.service.GenerateSchedule(claims.CompanyID, claims.UserID, claims.Role, &req)
+	if err == ErrScheduledShiftNotFound {
+		respondWithError(w, http.StatusForbidden, "User is not in your reporting line")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	status, message := http.StatusCreated, "Schedule generated"
+	if result.Preview {
+		status, message = http.StatusOK, "Schedule preview"
+	}
+
+	respondWithJSON(w, status, map[string]interface{}{
+		"message": message,
+		"preview": result.Preview,
+		"shifts":  result.Shifts,
+		"skipped": result.Skipped,
+		"count":   len(result.Shifts),
+	})
+}
+
+// GetQualifications lists the roles each employee is qualified for (manager/admin only)
+func (h *Handler) GetQualifications(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	qualifications, err := h.service.GetQualifications(claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve qualifications")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"qualifications": qualifications,
+		"count":          len(qualifications),
+	})
+}
+
+// SetQualificationsRequest lists the roles an employee is qualified for
+type SetQualificationsRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// SetQualifications replaces an employee's qualifications (admin only)
+func (h *Handler) SetQualifications(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req SetQualificationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	roles, err := h.service.SetQualifications(claims.CompanyID, userID, req.Roles)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Qualifications updated",
+		"user_id": userID,
+		"roles":   roles,
+	})
+}
+
+// GetShiftMarketplace lists colleagues' open shift offers and whether the caller may claim them
+func (h *Handler) GetShiftMarketplace(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	offers, err := h.service.GetShiftMarketplace(claims.CompanyID, claims.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve shift offers")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"offers": offers,
+		"count":  len(offers),
+	})
+}
+
+// GetMyShiftOffers lists the offers the caller made or claimed
+func (h *Handler) GetMyShiftOffers(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	offers, err := h.service.GetMyShiftOffers(claims.CompanyID, claims.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve shift offers")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"offers": offers,
+		"count":  len(offers),
+	})
+}
+
+// OfferShiftRequest represents an offer of one of the caller's scheduled shifts
+type OfferShiftRequest struct {
+	ScheduledShiftID int    `json:"scheduled_shift_id"`
+	Kind             string `json:"kind"` // giveaway (default) or swap
+	Comment          string `json:"comment"`
+}
+
+// OfferShift puts one of the caller's published shifts on offer
+func (h *Handler) OfferShift(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req OfferShiftRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	offer, err := h.service.OfferShift(&ShiftOffer{
+		CompanyID:        claims.CompanyID,
+		ScheduledShiftID: req.ScheduledShiftID,
+		OfferedBy:        claims.UserID,
+		Kind:             req.Kind,
+		Comment:          req.Comment,
+	})
+	if err == ErrScheduledShiftNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": "Shift offered",
+		"offer":   offer,
+	})
+}
+
+// ClaimShiftOfferRequest names the caller's shift given in return for a swap
+type ClaimShiftOfferRequest struct {
+	SwapShiftID *int `json:"swap_shift_id"`
+}
+
+// ClaimShiftOffer takes a colleague's open shift offer
+func (h *Handler) ClaimShiftOffer(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid offer ID")
+		return
+	}
+
+	var req ClaimShiftOfferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	offer, err := h.service.ClaimShiftOffer(claims.CompanyID, id, claims.UserID, req.SwapShiftID)
+	if err == ErrShiftOfferNotFound || err == ErrScheduledShiftNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	message := "Shift claimed"
+	if offer.Status == "pending_approval" {
+		message = "Shift claimed, waiting for manager approval"
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": message,
+		"offer":   offer,
+	})
+}
+
+// CancelShiftOffer withdraws one of the caller's offers
+func (h *Handler) CancelShiftOffer(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid offer ID")
+		return
+	}
+
+	offer, err := h.service.CancelShiftOffer(claims.CompanyID, claims.UserID, id)
+	if err == ErrShiftOfferNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Offer cancelled",
+		"offer":   offer,
+	})
+}
+
+// GetPendingShiftOffers lists claimed offers waiting for approval (manager/admin only)
+func (h *Handler) GetPendingShiftOffers(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	offers, err := h.service.GetPendingShiftOffers(claims.CompanyID, claims.UserID, claims.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve shift offers")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"offers": offers,
+		"count":  len(offers),
+	})
+}
+
+// ReviewShiftOfferRequest represents an approval or rejection of a shift offer
+type ReviewShiftOfferRequest struct {
+	Comment string `json:"comment"`
+}
+
+// ApproveShiftOffer approves a claimed offer and reassigns the shifts (manager/admin only)
+func (h *Handler) ApproveShiftOffer(w http.ResponseWriter, r *http.Request) {
+	h.reviewShiftOffer(w, r, true)
+}
+
+// RejectShiftOffer rejects a claimed offer (manager/admin only)
+func (h *Handler) RejectShiftOffer(w http.ResponseWriter, r *http.Request) {
+	h.reviewShiftOffer(w, r, false)
+}
+
+func (h *Handler) reviewShiftOffer(w http.ResponseWriter, r *http.Request, approve bool) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid offer ID")
+		return
+	}
+
+	var req ReviewShiftOfferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	offer, err := h.service.ReviewShiftOffer(claims.CompanyID, id, claims.UserID, claims.Role, approve, req.Comment)
+	if err == ErrShiftOfferNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	message := "Offer rejected"
+	if approve {
+		message = "Offer approved"
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": message,
+		"offer":   offer,
+	})
+}
+
+// GetOvertimeRules retrieves the company's overtime rules (manager/admin only)
+func (h *Handler) GetOvertimeRules(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	rules, err := h.service.GetOvertimeRules(claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve overtime rules")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"rules": rules,
+	})
+}
+
+// UpdateOvertimeRules updates the company's overtime rules (admin only)
+func (h *Handler) UpdateOvertimeRules(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Start from the current rules so omitted fields keep their values
+	rules, err := h.service.GetOvertimeRules(claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve overtime rules")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(rules); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	rules.CompanyID = claims.CompanyID
+
+	saved, err := h.service.SaveOvertimeRules(rules)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Overtime rules updated",
+		"rules":   saved,
+	})
+}
+
+// GetOvertimeReport breaks down the hours worked per employee into regular,
+// overtime, double-time and holiday hours (manager/admin only)
+func (h *Handler) GetOvertimeReport(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	from, to, err := h.scheduleRange(r, claims.CompanyID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+
+	employees, err := h.service.GetOvertimeReport(claims.CompanyID, claims.UserID, claims.Role, userID, from, to)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate overtime report")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"from":      from,
+		"to":        to,
+		"employees": employees,
+		"count":     len(employees),
+	})
+}
+
+// validTimesheetDate reports whether the optional date parameter picking a
+// pay period is valid
+func validTimesheetDate(date string) bool {
+	if date == "" {
+		return true
+	}
+	_, err := time.Parse("2006-01-02", date)
+	return err == nil
+}
+
+// GetMyTimesheet retrieves the authenticated user's timesheet for the pay
+// period containing the "date" parameter, by default the current one
+func (h *Handler) GetMyTimesheet(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	date := r.URL.Query().Get("date")
+	if !validTimesheetDate(date) {
+		respondWithError(w, http.StatusBadRequest, "date must be in YYYY-MM-DD format")
+		return
+	}
+
+	timesheet, err := h.service.GetMyTimesheet(claims.CompanyID, claims.UserID, date)
+	if err == ErrTimesheetNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve timesheet")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"timesheet": timesheet,
+	})
+}
+
+// SubmitTimesheetRequest represents an employee's timesheet submission
+type SubmitTimesheetRequest struct {
+	Date    string `json:"date"` // any day of the pay period; defaults to today
+	Comment string `json:"comment"`
+}
+
+// SubmitTimesheet submits the authenticated user's timesheet for approval
+func (h *Handler) SubmitTimesheet(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req SubmitTimesheetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !validTimesheetDate(req.Date) {
+		respondWithError(w, http.StatusBadRequest, "date must be in YYYY-MM-DD format")
+		return
+	}
+
+	timesheet, err := h.service.SubmitTimesheet(claims.CompanyID, claims.UserID, req.Date, req.Comment)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":   "Timesheet submitted",
+		"timesheet": timesheet,
+	})
+}
+
+// GetTimesheets lists the timesheets of the manager's reporting line for the
+// pay period containing the "date" parameter (manager/admin only)
+func (h *Handler) GetTimesheets(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	date := r.URL.Query().Get("date")
+	if !validTimesheetDate(date) {
+		respondWithError(w, http.StatusBadRequest, "date must be in YYYY-MM-DD format")
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", TimesheetStatusOpen, TimesheetStatusSubmitted, TimesheetStatusApproved, TimesheetStatusRejected:
+	default:
+		respondWithError(w, http.StatusBadRequest, "status must be open, submitted, approved or rejected")
+		return
+	}
+
+	timesheets, err := h.service.GetTimesheets(claims.CompanyID, claims.UserID, claims.Role, date, status)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve timesheets")
+		return
+	}
+
+	response := map[string]interface{}{
+		"timesheets": timesheets,
+		"count":      len(timesheets),
+	}
+	if len(timesheets) > 0 {
+		response["period_start"] = timesheets[0].PeriodStart
+		response["period_end"] = timesheets[0].PeriodEnd
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// GetTimesheet retrieves a timesheet with its shifts (manager/admin only)
+func (h *Handler) GetTimesheet(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid timesheet ID")
+		return
+	}
+
+	timesheet, err := h.service.GetTimesheet(claims.CompanyID, id, claims.UserID, claims.Role)
+	if err == ErrTimesheetNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve timesheet")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"timesheet": timesheet,
+	})
+}
+
+// ReviewTimesheetRequest represents a timesheet approval, rejection or reopening
+type ReviewTimesheetRequest struct {
+	Comment string `json:"comment"`
+}
+
+// ApproveTimesheet approves a submitted timesheet, locking its pay period (manager/admin only)
+func (h *Handler) ApproveTimesheet(w http.ResponseWriter, r *http.Request) {
+	h.reviewTimesheet(w, r, true)
+}
+
+// RejectTimesheet sends a submitted timesheet back to the employee (manager/admin only)
+func (h *Handler) RejectTimesheet(w http.ResponseWriter, r *http.Request) {
+	h.reviewTimesheet(w, r, false)
+}
+
+func (h *Handler) reviewTimesheet(w http.ResponseWriter, r *http.Request, approve bool) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid timesheet ID")
+		return
+	}
+
+	var req ReviewTimesheetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	timesheet, err := h.service.ReviewTimesheet(claims.CompanyID, id, claims.UserID, claims.Role, approve, req.Comment)
+	if err == ErrTimesheetNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	message := "Timesheet rejected"
+	if approve {
+		message = "Timesheet approved"
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":   message,
+		"timesheet": timesheet,
+	})
+}
+
+// ReopenTimesheet unlocks a submitted or approved timesheet so its shifts can
+// be changed again (admin only)
+func (h *Handler) ReopenTimesheet(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid timesheet ID")
+		return
+	}
+
+	var req ReviewTimesheetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	timesheet, err := h.service.ReopenTimesheet(claims.CompanyID, id, claims.UserID, req.Comment)
+	if err == ErrTimesheetNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":   "Timesheet reopened",
+		"timesheet": timesheet,
+	})
+}
+
+// GetLeaveTypes lists the company's leave types; admins may pass all=true
+// to include inactive ones
+func (h *Handler) GetLeaveTypes(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	all := r.URL.Query().Get("all") == "true" && claims.Role == "admin"
+	types, err := h.service.GetLeaveTypes(claims.CompanyID, all)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve leave types")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"leave_types": types,
+		"count":       len(types),
+	})
+}
+
+// CreateLeaveType creates a leave type with its accrual policy (admin only)
+func (h *Handler) CreateLeaveType(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	t := LeaveType{Paid: true, AccrualMethod: AccrualNone}
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	t.ID = 0
+	t.CompanyID = claims.CompanyID
+
+	created, err := h.service.CreateLeaveType(&t)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message":    "Leave type created",
+		"leave_type": created,
+	})
+}
+
+// UpdateLeaveType updates a leave type; omitted fields keep their current
+// values (admin only)
+func (h *Handler) UpdateLeaveType(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid leave type ID")
+		return
+	}
+
+	t, err := h.service.GetLeaveType(claims.CompanyID, id)
+	if err == ErrLeaveTypeNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve leave type")
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	t.ID = id
+	t.CompanyID = claims.CompanyID
+
+	updated, err := h.service.UpdateLeaveType(t)
+	if err == ErrLeaveTypeNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":    "Leave type updated",
+		"leave_type": updated,
+	})
+}
+
+// DeleteLeaveType deactivates a leave type (admin only)
+func (h *Handler) DeleteLeaveType(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid leave type ID")
+		return
+	}
+
+	err = h.service.DeactivateLeaveType(claims.CompanyID, id)
+	if err == ErrLeaveTypeNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to deactivate leave type")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Leave type deactivated",
+	})
+}
+
+// SubmitLeaveRequestRequest represents an employee's leave request
+type SubmitLeaveRequestRequest struct {
+	LeaveTypeID int     `json:"leave_type_id"`
+	StartDate   string  `json:"start_date"`
+	EndDate     string  `json:"end_date"`
+	Hours       float64 `json:"hours"` // omit to take full days
+	Comment     string  `json:"comment"`
+}
+
+// SubmitLeaveRequest submits a leave request for the authenticated user
+func (h *Handler) SubmitLeaveRequest(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req SubmitLeaveRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.EndDate == "" {
+		req.EndDate = req.StartDate
+	}
+
+	created, err := h.service.RequestLeave(&LeaveRequest{
+		CompanyID:   claims.CompanyID,
+		UserID:      claims.UserID,
+		LeaveTypeID: req.LeaveTypeID,
+		StartDate:   req.StartDate,
+		EndDate:     req.EndDate,
+		Hours:       req.Hours,
+		Comment:     req.Comment,
+	})
+	if err == ErrLeaveTypeNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message":       "Leave requested",
+		"leave_request": created,
+	})
+}
+
+// GetMyLeaveRequests retrieves the authenticated user's leave requests
+func (h *Handler) GetMyLeaveRequests(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+
+	requests, err := h.service.GetMyLeaveRequests(claims.CompanyID, claims.UserID, r.URL.Query().Get("status"), limit, offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve leave requests")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"leave_requests": requests,
+		"count":          len(requests),
+	})
+}
+
+// CancelLeaveRequest cancels one of the authenticated user's leave requests,
+// or for managers and admins one of their reporting line's
+func (h *Handler) CancelLeaveRequest(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid leave request ID")
+		return
+	}
+
+	cancelled, err := h.service.CancelLeaveRequest(claims.CompanyID, id, claims.UserID, claims.Role)
+	if err == ErrLeaveRequestNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":       "Leave request cancelled",
+		"leave_request": cancelled,
+	})
+}
+
+// GetLeaveRequestQueue lists the leave requests of the manager's reporting
+// line, pending ones by default (manager/admin only)
+func (h *Handler) GetLeaveRequestQueue(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = LeaveStatusPending
+	} else if status == "all" {
+		status = ""
+	}
+
+	requests, err := h.service.GetLeaveRequestQueue(claims.CompanyID, claims.UserID, claims.Role, status, limit, offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve leave requests")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"leave_requests": requests,
+		"count":          len(requests),
+	})
+}
+
+// ReviewLeaveRequestRequest represents a leave approval or rejection
+type ReviewLeaveRequestRequest struct {
+	Comment string `json:"comment"`
+}
+
+// ApproveLeaveRequest approves a leave request, taking it from the balance (manager/admin only)
+func (h *Handler) ApproveLeaveRequest(w http.ResponseWriter, r *http.Request) {
+	h.reviewLeaveRequest(w, r, true)
+}
+
+// RejectLeaveRequest rejects a leave request (manager/admin only)
+func (h *Handler) RejectLeaveRequest(w http.ResponseWriter, r *http.Request) {
+	h.reviewLeaveRequest(w, r, false)
+}
+
+func (h *Handler) reviewLeaveRequest(w http.ResponseWriter, r *http.Request, approve bool) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid leave request ID")
+		return
+	}
+
+	var req ReviewLeaveRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	reviewed, err := h.service.ReviewLeaveRequest(claims.CompanyID, id, claims.UserID, claims.Role, approve, req.Comment)
+	if err == ErrLeaveRequestNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	message := "Leave request rejected"
+	if approve {
+		message = "Leave request approved"
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":       message,
+		"leave_request": reviewed,
+	})
+}
+
+// GetMyLeaveBalances retrieves the authenticated user's leave balances
+func (h *Handler) GetMyLeaveBalances(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	balances, err := h.service.GetMyLeaveBalances(claims.CompanyID, claims.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve leave balances")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"balances": balances,
+		"count":    len(balances),
+	})
+}
+
+// GetLeaveBalances retrieves the leave balances of the manager's reporting
+// line, or of the employee in the "user_id" parameter (manager/admin only)
+func (h *Handler) GetLeaveBalances(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID := 0
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+		userID = id
+	}
+
+	balances, err := h.service.GetLeaveBalances(claims.CompanyID, claims.UserID, claims.Role, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve leave balances")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"balances": balances,
+		"count":    len(balances),
+	})
+}
+
+// GetMyLeaveLedger retrieves the authenticated user's leave ledger
+func (h *Handler) GetMyLeaveLedger(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	h.leaveLedger(w, r, claims, claims.UserID)
+}
+
+// GetLeaveLedger retrieves the leave ledger of the employee in the "user_id"
+// parameter (manager/admin only)
+func (h *Handler) GetLeaveLedger(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	h.leaveLedger(w, r, claims, userID)
+}
+
+// leaveLedger writes a user's ledger, filtered by the optional leave_type_id
+// parameter and paged by limit and offset
+func (h *Handler) leaveLedger(w http.ResponseWriter, r *http.Request, claims *utils.Claims, userID int) {
+	leaveTypeID := 0
+	if v := r.URL.Query().Get("leave_type_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid leave type ID")
+			return
+		}
+		leaveTypeID = id
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+
+	entries, err := h.service.GetLeaveLedger(claims.CompanyID, claims.UserID, claims.Role, userID, leaveTypeID, limit, offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve leave ledger")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
+
+// AdjustLeaveBalanceRequest represents a manual leave balance correction
+type AdjustLeaveBalanceRequest struct {
+	UserID      int     `json:"user_id"`
+	LeaveTypeID int     `json:"leave_type_id"`
+	Hours       float64 `json:"hours"` // negative to deduct
+	Note        string  `json:"note"`
+}
+
+// AdjustLeaveBalance records a manual correction to an employee's leave
+// balance (admin only)
+func (h *Handler) AdjustLeaveBalance(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req AdjustLeaveBalanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	adminID := claims.UserID
+	entry, err := h.service.AdjustLeaveBalance(&LeaveLedgerEntry{
+		CompanyID:   claims.CompanyID,
+		UserID:      req.UserID,
+		LeaveTypeID: req.LeaveTypeID,
+		Hours:       req.Hours,
+		Note:        req.Note,
+		CreatedBy:   &adminID,
+	})
+	if err == ErrLeaveTypeNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": "Leave balance adjusted",
+		"entry":   entry,
+	})
+}
+
+// GetProjects lists the company's projects; admins may pass all=true to
+// include inactive ones
+func (h *Handler) GetProjects(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	all := r.URL.Query().Get("all") == "true" && claims.Role == "admin"
+	projects, err := h.service.GetProjects(claims.CompanyID, all)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve projects")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"projects": projects,
+		"count":    len(projects),
+	})
+}
+
+// CreateProject creates a project or cost code (admin only)
+func (h *Handler) CreateProject(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	p := Project{Billable: true}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	p.ID = 0
+	p.CompanyID = claims.CompanyID
+
+	created, err := h.service.CreateProject(&p)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": "Project created",
+		"project": created,
+	})
+}
+
+// UpdateProject updates a project; omitted fields keep their current values (admin only)
+func (h *Handler) UpdateProject(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	p, err := h.service.GetProject(claims.CompanyID, id)
+	if err == ErrProjectNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve project")
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(p); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	p.ID = id
+	p.CompanyID = claims.CompanyID
+
+	updated, err := h.service.UpdateProject(p)
+	if err == ErrProjectNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Project updated",
+		"project": updated,
+	})
+}
+
+// DeleteProject deactivates a project (admin only)
+func (h *Handler) DeleteProject(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid project ID")
+		return
+	}
+
+	err = h.service.DeactivateProject(claims.CompanyID, id)
+	if err == ErrProjectNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Project deactivated",
+	})
+}
+
+// SwitchProjectRequest represents a change of project during the active shift
+type SwitchProjectRequest struct {
+	ProjectID *int `json:"project_id"` // null stops allocating the shift's time
+}
+
+// SwitchProject ends the project segment running in the authenticated
+// user's active shift and starts one on another project
+func (h *Handler) SwitchProject(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req SwitchProjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	allocations, err := h.service.SwitchProject(claims.UserID, req.ProjectID)
+	if err == ErrProjectNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":     "Project switched",
+		"allocations": allocations,
+	})
+}
+
+// GetShiftAllocations shows how a shift's time is split across projects.
+// Employees see their own shifts, managers those of their reporting subtree.
+func (h *Handler) GetShiftAllocations(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	shiftID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid shift ID")
+		return
+	}
+
+	allocations, err := h.service.GetShiftAllocations(claims.CompanyID, shiftID, claims.UserID, claims.Role)
+	if err == ErrShiftNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve allocations")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"allocations": allocations,
+	})
+}
+
+// AllocateShiftRequest splits a completed shift's hours across projects
+type AllocateShiftRequest struct {
+	Allocations []AllocationInput `json:"allocations"`
+}
+
+// AllocateShift replaces the project allocations of a completed shift.
+// Employees allocate their own shifts, managers those of their reporting subtree.
+func (h *Handler) AllocateShift(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	shiftID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid shift ID")
+		return
+	}
+
+	var req AllocateShiftRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	allocations, err := h.service.AllocateShift(claims.CompanyID, shiftID, claims.UserID, claims.Role, req.Allocations)
+	if err == ErrShiftNotFound || err == ErrProjectNotFound {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":     "Shift allocated",
+		"allocations": allocations,
+	})
+}
+
+// GetProjectReport totals shift hours per project, client or employee for
+// invoicing, as JSON or an export (manager/admin only). Managers only see
+// the hours of their reporting subtree.
+func (h *Handler) GetProjectReport(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.UserClaimsKey).(*utils.Claims)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	format, err := exportFormat(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter, err := h.parseShiftFilter(r, claims)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to resolve reporting lines")
+		return
+	}
+
+	report, err := h.service.GetProjectReport(filter, r.URL.Query().Get("group_by"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if format != "" {
+		h.exportProjectReport(w, claims.CompanyID, filter, format, report)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"report":     report,
+		"count":      len(report.Groups),
+		"start_date": filter.StartDate.Format("2006-01-02"),
+		"end_date":   filter.EndDate.Format("2006-01-02"),
+	})
+}
+
+// exportProjectReport writes the lines of each project report group, each
+// followed by its subtotal
+func (h *Handler) exportProjectReport(w http.ResponseWriter, companyID int, filter ShiftFilter, format string, report *ProjectReport) {
+	loc := h.service.CompanyLocation(companyID)
+	doc := exportDoc{
+		Title:     "Project hours by " + report.GroupBy,
+		Company:   h.service.CompanyName(companyID),
+		Period:    exportPeriod(filter, loc),
+		Generated: time.Now().In(loc),
+		Columns:   projectReportColumns(report.GroupBy),
+	}
+
+	setExportHeaders(w, format, "project-report-"+filter.StartDate.Format("2006-01-02"))
+	w.WriteHeader(http.StatusOK)
+
+	out, err := newTableWriter(w, format, doc)
+	for _, g := range report.Groups {
+		for i := 0; err == nil && i < len(g.Breakdown); i++ {
+			l := g.Breakdown[i]
+			err = out.WriteRow(projectReportRow(g.Label, l.Label, l.Hours, l.BillableHours, l.Shifts))
+		}
+		if err == nil {
+			err = out.WriteRow(projectReportRow(g.Label, "Subtotal", g.Hours, g.BillableHours, g.Shifts))
+		}
+	}
+	if err == nil {
+		totals := projectReportRow("Total", "", report.TotalHours, report.BillableHours, report.Shifts)
+		if err = out.WriteTotals(totals); err == nil {
+			err = out.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("Project report export for company %d cut short: %v", companyID, err)
+	}
+}
+
+// scheduleRange reads the period of a schedule or report request in the
+// company time zone: the week containing the "week" date, an explicit
+// start_date and end_date, or by default the current week. Weeks start on
+// Monday.
+func (h *Handler) scheduleRange(r *http.Request, companyID int) (time.Time, time.Time, error) {
+	loc := h.service.CompanyLocation(companyID)
+	query := r.URL.Query()
+
+	if query.Get("start_date") != "" || query.Get("end_date") != "" {
+		from, err := time.ParseInLocation("2006-01-02", query.Get("start_date"), loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("start_date must be a date in YYYY-MM-DD format")
+		}
+		to, err := time.ParseInLocation("2006-01-02", query.Get("end_date"), loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("end_date must be a date in YYYY-MM-DD format")
+		}
+		to = to.AddDate(0, 0, 1) // include the entire end date
+		if !to.After(from) || to.Sub(from) > 93*24*time.Hour {
+			return time.Time{}, time.Time{}, errors.New("date range must be between 1 and 92 days")
+		}
+		return from, to, nil
+	}
+
+	day := time.Now().In(loc)
+	if week := query.Get("week"); week != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", week, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("week must be a date in YYYY-MM-DD format")
+		}
+		day = parsed
+	}
+
+	from := weekStart(day)
+	return from, from.AddDate(0, 0, 7), nil
+}
+
+// parseShiftFilter builds a shift filter from the date range,
+// department_id and needs_review query parameters, scoped to what the caller may see
+func (h *Handler) parseShiftFilter(r *http.Request, claims *utils.Claims) (ShiftFilter, error) {
+	// Parse date range (default to last 30 days); dates are days in the company's time zone
+	loc := h.service.CompanyLocation(claims.CompanyID)
+	endDate := time.Now().In(loc)
+	startDate := endDate.AddDate(0, 0, -30)
+
+	if startStr := r.URL.Query().Get("start_date"); startStr != "" {
+		if parsed, err := time.ParseInLocation("2006-01-02", startStr, loc); err == nil {
+			startDate = parsed
+		}
+	}
+
+	if endStr := r.URL.Query().Get("end_date"); endStr != "" {
+		if parsed, err := time.ParseInLocation("2006-01-02", endStr, loc); err == nil {
+			endDate = parsed.AddDate(0, 0, 1) // Include the entire end date
+		}
+	}
+
+	departmentID, _ := strconv.Atoi(r.URL.Query().Get("department_id"))
+
+	filter := ShiftFilter{
+		CompanyID:    claims.CompanyID,
+		StartDate:    startDate,
+		EndDate:      endDate,
+		DepartmentID: departmentID,
+		NeedsReview:  r.URL.Query().Get("needs_review") == "true",
+	}
+
+	if err := h.service.ScopeFilter(&filter, claims.UserID, claims.Role); err != nil {
+		return filter, err
+	}
+
+	// Optionally narrow to a comma-separated list of users within the scope
+	if userIDs := r.URL.Query().Get("user_ids"); userIDs != "" {
+		ids := []int{}
+		for _, part := range strings.Split(userIDs, ",") {
+			if id, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		filter.RestrictUsers(ids)
+	}
+
+	return filter, nil
+}
+
 // Helper functions
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, map[string]string{"error": message})
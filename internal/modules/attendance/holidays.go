@@ -0,0 +1,149 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrHolidayNotFound is returned when a holiday does not exist
+var ErrHolidayNotFound = errors.New("holiday not found")
+
+// Holiday is a day the company is closed, skipped when generating rosters.
+// Holidays are the company's own, or come from an assigned holiday calendar,
+// in which case CalendarID is set.
+type Holiday struct {
+	ID         int       `json:"id"`
+	CompanyID  int       `json:"company_id"`
+	Date       string    `json:"date"` // YYYY-MM-DD
+	Name       string    `json:"name"`
+	CalendarID *int      `json:"calendar_id,omitempty"`
+	Calendar   string    `json:"calendar,omitempty"`
+	Source     string    `json:"source,omitempty"` // manual or ics, for calendar holidays
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const holidayColumns = `id, company_id, to_char(holiday_date, 'YYYY-MM-DD'), name, NULL::int, '', '', created_at`
+
+func scanHoliday(row scanner) (*Holiday, error) {
+	h := &Holiday{}
+	err := row.Scan(&h.ID, &h.CompanyID, &h.Date, &h.Name, &h.CalendarID, &h.Calendar, &h.Source, &h.CreatedAt)
+	return h, err
+}
+
+// effectiveHolidaysQuery selects the holidays of company $1 between $2 and
+// $3: its own and those of its assigned calendars. On a date with more than
+// one, the company's own holiday wins, then the oldest calendar's.
+const effectiveHolidaysQuery = `
+	SELECT DISTINCT ON (h.holiday_date)
+	       h.id, h.company_id, to_char(h.holiday_date, 'YYYY-MM-DD'), h.name, h.calendar_id, h.calendar, h.source, h.created_at
+	FROM (
+		SELECT ch.id, ch.company_id, ch.holiday_date, ch.name, NULL::int AS calendar_id, '' AS calendar, '' AS source,
+		       ch.created_at, 0 AS rank
+		FROM company_holidays ch
+		WHERE ch.company_id = $1
+		UNION ALL
+		SELECT e.id, c.company_id, e.holiday_date, e.name, c.id, c.name, e.source, e.created_at, c.id
+		FROM holiday_calendar_entries e
+		JOIN holiday_calendars c ON c.id = e.calendar_id
+		WHERE c.company_id = $1 AND c.assigned = true
+	) h
+	WHERE h.holiday_date BETWEEN $2::date AND $3::date
+	ORDER BY h.holiday_date, h.rank
+`
+
+// effectiveHolidays returns the company's holidays between two dates,
+// inclusive, including those of its assigned calendars
+func effectiveHolidays(q querier, companyID int, from, to string) ([]Holiday, error) {
+	rows, err := q.Query(effectiveHolidaysQuery, companyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holidays := []Holiday{}
+	for rows.Next() {
+		h, err := scanHoliday(rows)
+		if err != nil {
+			return nil, err
+		}
+		holidays = append(holidays, *h)
+	}
+
+	return holidays, rows.Err()
+}
+
+// CreateHoliday adds a holiday; a company has at most one per date
+func CreateHoliday(db *sql.DB, holiday *Holiday) (*Holiday, error) {
+	if strings.TrimSpace(holiday.Name) == "" {
+		return nil, errors.New("name is required")
+	}
+	if _, err := time.Parse("2006-01-02", holiday.Date); err != nil {
+		return nil, errors.New("date must be in YYYY-MM-DD format")
+	}
+
+	created, err := scanHoliday(db.QueryRow(`
+		INSERT INTO company_holidays (company_id, holiday_date, name, created_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (company_id, holiday_date) DO NOTHING
+		RETURNING `+holidayColumns,
+		holiday.CompanyID, holiday.Date, holiday.Name,
+	))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("a holiday already exists on this date")
+	}
+	return created, err
+}
+
+// GetHolidays retrieves a company's holidays in the year, or all when year
+// is 0, including those of its assigned calendars
+func GetHolidays(db *sql.DB, companyID, year int) ([]Holiday, error) {
+	from, to := "-infinity", "infinity"
+	if year != 0 {
+		from, to = fmt.Sprintf("%04d-01-01", year), fmt.Sprintf("%04d-12-31", year)
+	}
+	return effectiveHolidays(db, companyID, from, to)
+}
+
+// IsHoliday returns the company's holiday on a date, from its own holidays
+// or its assigned calendars, or nil when the date is not a holiday
+func IsHoliday(q querier, companyID int, date string) (*Holiday, error) {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return nil, errors.New("date must be in YYYY-MM-DD format")
+	}
+
+	holidays, err := effectiveHolidays(q, companyID, date, date)
+	if err != nil || len(holidays) == 0 {
+		return nil, err
+	}
+	return &holidays[0], nil
+}
+
+// DeleteHoliday removes a holiday
+func DeleteHoliday(db *sql.DB, companyID, id int) error {
+	result, err := db.Exec(`DELETE FROM company_holidays WHERE id = $1 AND company_id = $2`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrHolidayNotFound
+	}
+	return nil
+}
+
+// holidayDates returns the company's holidays between two dates, inclusive,
+// keyed by YYYY-MM-DD, including those of its assigned calendars
+func holidayDates(q querier, companyID int, from, to string) (map[string]string, error) {
+	holidays, err := effectiveHolidays(q, companyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	dates := make(map[string]string, len(holidays))
+	for _, h := range holidays {
+		dates[h.Date] = h.Name
+	}
+	return dates, nil
+}
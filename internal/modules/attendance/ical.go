@@ -0,0 +1,344 @@
+package attendance
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxICalEventDays caps how many days one imported event may cover
+const maxICalEventDays = 31
+
+// maxICalHolidays caps how many holidays one import may add
+const maxICalHolidays = 5000
+
+// icalHoliday is a holiday read from an iCalendar file
+type icalHoliday struct {
+	Date string // YYYY-MM-DD
+	Name string
+	UID  string
+}
+
+// icalProperty is one content line of an iCalendar file
+type icalProperty struct {
+	Name  string
+	Value string
+}
+
+// parseICalendar reads the holidays of an iCalendar (.ics) file. Every day
+// an event covers is a holiday named by its summary. Yearly recurring events
+// are repeated up to their end, or up to horizon when they have none; events
+// that are cancelled or repeat in other ways are skipped and counted. Times
+// in UTC are moved to loc before taking their date.
+func parseICalendar(r io.Reader, loc *time.Location, horizon time.Time) ([]icalHoliday, int, error) {
+	lines, err := unfoldICalLines(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var holidays []icalHoliday
+	seen := map[string]bool{}
+	skipped := 0
+	inCalendar := false
+	var event []icalProperty
+	inEvent := false
+
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		prop, ok := parseICalLine(line)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case prop.Name == "BEGIN" && strings.EqualFold(prop.Value, "VCALENDAR"):
+			inCalendar = true
+		case prop.Name == "BEGIN" && strings.EqualFold(prop.Value, "VEVENT"):
+			inEvent = true
+			event = event[:0]
+		case prop.Name == "END" && strings.EqualFold(prop.Value, "VEVENT"):
+			if !inEvent {
+				continue
+			}
+			inEvent = false
+
+			days, err := icalEventDays(event, loc, horizon)
+			if err != nil || len(days) == 0 {
+				skipped++
+				continue
+			}
+			name, uid := icalEventName(event), icalPropertyValue(event, "UID")
+			if len(uid) > 255 {
+				uid = uid[:255]
+			}
+			for _, day := range days {
+				if seen[day] {
+					continue
+				}
+				seen[day] = true
+				holidays = append(holidays, icalHoliday{Date: day, Name: name, UID: uid})
+				if len(holidays) > maxICalHolidays {
+					return nil, 0, errors.New("the calendar has too many holidays to import at once")
+				}
+			}
+		case inEvent:
+			event = append(event, prop)
+		}
+	}
+
+	if !inCalendar {
+		return nil, 0, errors.New("not an iCalendar file")
+	}
+	return holidays, skipped, nil
+}
+
+// unfoldICalLines splits an iCalendar file into content lines, joining the
+// lines folded onto the next with a leading space or tab
+func unfoldICalLines(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) == 0 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseICalLine splits a content line into its name and value; parameters,
+// such as TZID, are not needed for dates and are dropped
+func parseICalLine(line string) (icalProperty, bool) {
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return icalProperty{}, false
+	}
+
+	name, _, _ := strings.Cut(line[:colon], ";")
+	return icalProperty{Name: strings.ToUpper(strings.TrimSpace(name)), Value: line[colon+1:]}, true
+}
+
+// icalPropertyValue returns the value of an event's first property of a name
+func icalPropertyValue(event []icalProperty, name string) string {
+	if p := icalPropertyOf(event, name); p != nil {
+		return p.Value
+	}
+	return ""
+}
+
+func icalPropertyOf(event []icalProperty, name string) *icalProperty {
+	for i := range event {
+		if event[i].Name == name {
+			return &event[i]
+		}
+	}
+	return nil
+}
+
+// icalEventName returns an event's summary, unescaped
+func icalEventName(event []icalProperty) string {
+	name := icalPropertyValue(event, "SUMMARY")
+	name = strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(name)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Holiday"
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
+
+// icalEventDays returns the dates, as YYYY-MM-DD, that an event covers
+func icalEventDays(event []icalProperty, loc *time.Location, horizon time.Time) ([]string, error) {
+	if strings.EqualFold(icalPropertyValue(event, "STATUS"), "CANCELLED") {
+		return nil, nil
+	}
+
+	dtstart := icalPropertyOf(event, "DTSTART")
+	if dtstart == nil {
+		return nil, errors.New("event has no start")
+	}
+	start, allDay, err := parseICalDate(dtstart.Value, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	// An all-day event ends the day before DTEND; a timed one on the date it ends
+	length := 1
+	if dtend := icalPropertyOf(event, "DTEND"); dtend != nil {
+		end, _, err := parseICalDate(dtend.Value, loc)
+		if err != nil {
+			return nil, err
+		}
+		length = int(end.Sub(start).Hours()/24 + 0.5)
+		if !allDay {
+			length++
+		}
+	} else if d := icalPropertyValue(event, "DURATION"); d != "" {
+		if length, err = parseICalDays(d); err != nil {
+			return nil, err
+		}
+	}
+	if length < 1 {
+		length = 1
+	}
+	if length > maxICalEventDays {
+		return nil, errors.New("event is too long")
+	}
+
+	starts := []time.Time{start}
+	if rule := icalPropertyValue(event, "RRULE"); rule != "" {
+		if starts, err = yearlyOccurrences(rule, start, loc, horizon); err != nil {
+			return nil, err
+		}
+	}
+
+	excluded := map[string]bool{}
+	for _, p := range event {
+		if p.Name != "EXDATE" {
+			continue
+		}
+		for _, v := range strings.Split(p.Value, ",") {
+			if d, _, err := parseICalDate(v, loc); err == nil {
+				excluded[d.Format("2006-01-02")] = true
+			}
+		}
+	}
+
+	var days []string
+	for _, s := range starts {
+		if excluded[s.Format("2006-01-02")] {
+			continue
+		}
+		for i := 0; i < length; i++ {
+			days = append(days, s.AddDate(0, 0, i).Format("2006-01-02"))
+		}
+	}
+	return days, nil
+}
+
+// parseICalDate parses a DATE or DATE-TIME value to its date and reports
+// whether it was a date alone
+func parseICalDate(value string, loc *time.Location) (time.Time, bool, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 8 {
+		d, err := time.Parse("20060102", value)
+		return d, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		value = t.In(loc).Format("20060102")
+	} else if len(value) >= 8 {
+		value = value[:8]
+	}
+	d, err := time.Parse("20060102", value)
+	return d, false, err
+}
+
+// parseICalDays reads a DURATION made of whole days or weeks, such as P1D or P2W
+func parseICalDays(value string) (int, error) {
+	value = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "+")
+	if !strings.HasPrefix(value, "P") || len(value) < 3 {
+		return 0, errors.New("invalid duration")
+	}
+
+	n, err := strconv.Atoi(value[1 : len(value)-1])
+	if err != nil {
+		return 0, errors.New("only durations in days or weeks are supported")
+	}
+	switch value[len(value)-1] {
+	case 'D':
+		return n, nil
+	case 'W':
+		return n * 7, nil
+	}
+	return 0, errors.New("only durations in days or weeks are supported")
+}
+
+// yearlyOccurrences returns the starts of an event repeating every year, or
+// every INTERVAL years, on the same day, up to UNTIL, COUNT or horizon.
+// Years without the day, such as February 29th, are left out.
+func yearlyOccurrences(rule string, start time.Time, loc *time.Location, horizon time.Time) ([]time.Time, error) {
+	interval, count := 1, 0
+	until := horizon
+	for _, part := range strings.Split(rule, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(k) {
+		case "FREQ":
+			if !strings.EqualFold(v, "YEARLY") {
+				return nil, errors.New("only yearly recurrence is supported")
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, errors.New("invalid interval")
+			}
+			interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, errors.New("invalid count")
+			}
+			count = n
+		case "UNTIL":
+			d, _, err := parseICalDate(v, loc)
+			if err != nil {
+				return nil, errors.New("invalid until")
+			}
+			if d.Before(until) {
+				until = d
+			}
+		case "BYMONTH":
+			if v != strconv.Itoa(int(start.Month())) {
+				return nil, errors.New("only yearly recurrence on the start date is supported")
+			}
+		case "BYMONTHDAY":
+			if v != strconv.Itoa(start.Day()) {
+				return nil, errors.New("only yearly recurrence on the start date is supported")
+			}
+		case "WKST", "":
+		default:
+			return nil, errors.New("only yearly recurrence on the start date is supported")
+		}
+	}
+
+	var starts []time.Time
+	for year, n := start.Year(), 0; count == 0 || n < count; year += interval {
+		d := time.Date(year, start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		if d.After(until) {
+			break
+		}
+		if d.Month() == start.Month() {
+			starts = append(starts, d)
+			n++
+		}
+	}
+	return starts, nil
+}
@@ -0,0 +1,313 @@
+package attendance
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Credential kinds employees can identify with at a kiosk
+const (
+	CredentialPIN   = "pin"
+	CredentialBadge = "badge"
+)
+
+// A kiosk is locked for kioskLockout once kioskMaxFailedAttempts unknown
+// PINs or badges are entered within kioskFailureWindow. Successful punches do
+// not clear the count, so valid PINs cannot be mixed in to keep guessing.
+const (
+	kioskMaxFailedAttempts = 5
+	kioskFailureWindow     = 15 * time.Minute
+	kioskLockout           = 5 * time.Minute
+)
+
+var (
+	// ErrKioskNotFound is returned when a kiosk does not exist
+	ErrKioskNotFound = errors.New("kiosk not found")
+	// ErrKioskLocked is returned while a kiosk is locked after too many failed attempts
+	ErrKioskLocked = errors.New("too many failed attempts, try again later")
+	// ErrUnknownCredential is returned when no active employee has the PIN or badge
+	ErrUnknownCredential = errors.New("unknown PIN or badge")
+)
+
+var pinPattern = regexp.MustCompile(`^[0-9]{4,8}$`)
+
+// Kiosk is a shared device employees punch on with a PIN or badge
+type Kiosk struct {
+	ID         int        `json:"id"`
+	CompanyID  int        `json:"company_id"`
+	Name       string     `json:"name"`
+	SiteID     *int       `json:"site_id,omitempty"` // where the kiosk is installed
+	Token      string     `json:"token,omitempty"`   // only returned on creation
+	IsActive   bool       `json:"is_active"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// KioskCredentials are the PIN and badge ID to assign to an employee; empty
+// fields are left unchanged
+type KioskCredentials struct {
+	PIN     string `json:"pin"`
+	BadgeID string `json:"badge_id"`
+}
+
+const kioskColumns = `id, company_id, name, site_id, is_active, last_seen_at, created_at, updated_at`
+
+func scanKiosk(row scanner) (*Kiosk, error) {
+	k := &Kiosk{}
+	err := row.Scan(&k.ID, &k.CompanyID, &k.Name, &k.SiteID, &k.IsActive, &k.LastSeenAt, &k.CreatedAt, &k.UpdatedAt)
+	return k, err
+}
+
+// CreateKiosk registers a kiosk and returns it with its device token, which
+// is only stored hashed
+func CreateKiosk(db *sql.DB, kiosk *Kiosk) (*Kiosk, error) {
+	if strings.TrimSpace(kiosk.Name) == "" {
+		return nil, errors.New("name is required")
+	}
+	if kiosk.SiteID != nil {
+		var exists bool
+		err := db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM work_sites WHERE id = $1 AND company_id = $2)
+		`, *kiosk.SiteID, kiosk.CompanyID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrSiteNotFound
+		}
+	}
+
+	token, err := generateDeviceToken()
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := scanKiosk(db.QueryRow(`
+		INSERT INTO kiosks (company_id, name, site_id, token_hash, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+kioskColumns,
+		kiosk.CompanyID, kiosk.Name, kiosk.SiteID, hashDeviceToken(token),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	created.Token = token
+	return created, nil
+}
+
+// GetKiosks retrieves a company's kiosks
+func GetKiosks(db *sql.DB, companyID int) ([]Kiosk, error) {
+	rows, err := db.Query(`
+		SELECT `+kioskColumns+`
+		FROM kiosks
+		WHERE company_id = $1
+		ORDER BY name
+	`, companyID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	kiosks := []Kiosk{}
+	for rows.Next() {
+		k, err := scanKiosk(rows)
+		if err != nil {
+			return nil, err
+		}
+		kiosks = append(kiosks, *k)
+	}
+
+	return kiosks, rows.Err()
+}
+
+// RevokeKiosk deactivates a kiosk so its token is no longer accepted
+func RevokeKiosk(db *sql.DB, companyID, id int) error {
+	result, err := db.Exec(`
+		UPDATE kiosks
+		SET is_active = false, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND company_id = $2
+	`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrKioskNotFound
+	}
+	return nil
+}
+
+// AuthenticateKiosk retrieves the active kiosk with a device token and
+// records that it was seen
+func AuthenticateKiosk(db *sql.DB, token string) (*Kiosk, error) {
+	k, err := scanKiosk(db.QueryRow(`
+		UPDATE kiosks
+		SET last_seen_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND is_active = true
+		RETURNING `+kioskColumns,
+		hashDeviceToken(token),
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrKioskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// SetKioskCredentials assigns a PIN and/or badge ID to an employee of the
+// company. Credentials are stored as keyed hashes and must be unique within
+// the company.
+func SetKioskCredentials(db *sql.DB, key []byte, companyID, userID int, c KioskCredentials) error {
+	if c.PIN == "" && c.BadgeID == "" {
+		return errors.New("pin or badge_id is required")
+	}
+	if c.PIN != "" && !pinPattern.MatchString(c.PIN) {
+		return errors.New("pin must be 4 to 8 digits")
+	}
+	if len(c.BadgeID) > 64 {
+		return errors.New("badge_id is too long")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND company_id = $2)
+	`, userID, companyID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("user not found")
+	}
+
+	credentials := map[string]string{CredentialPIN: c.PIN, CredentialBadge: c.BadgeID}
+	for _, kind := range []string{CredentialPIN, CredentialBadge} {
+		value := credentials[kind]
+		if value == "" {
+			continue
+		}
+		hash := hashCredential(key, companyID, kind, value)
+
+		var taken bool
+		err = tx.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM kiosk_credentials
+				WHERE company_id = $1 AND kind = $2 AND credential_hash = $3 AND user_id <> $4
+			)
+		`, companyID, kind, hash, userID).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return fmt.Errorf("this %s is already assigned to another employee", kind)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO kiosk_credentials (company_id, user_id, kind, credential_hash, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			ON CONFLICT (user_id, kind) DO UPDATE
+			SET credential_hash = EXCLUDED.credential_hash, updated_at = CURRENT_TIMESTAMP
+		`, companyID, userID, kind, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteKioskCredentials removes an employee's PIN and badge
+func DeleteKioskCredentials(db *sql.DB, companyID, userID int) error {
+	_, err := db.Exec(`DELETE FROM kiosk_credentials WHERE company_id = $1 AND user_id = $2`, companyID, userID)
+	return err
+}
+
+// IdentifyAtKiosk finds the active employee with a PIN or badge. Unknown
+// credentials count towards the kiosk's lockout until the failure window
+// that began with the first of them has passed.
+func IdentifyAtKiosk(db *sql.DB, key []byte, kiosk *Kiosk, kind, value string) (userID int, fullName string, err error) {
+	var locked bool
+	err = db.QueryRow(`
+		SELECT COALESCE(locked_until > CURRENT_TIMESTAMP, false) FROM kiosks WHERE id = $1
+	`, kiosk.ID).Scan(&locked)
+	if err != nil {
+		return 0, "", err
+	}
+	if locked {
+		return 0, "", ErrKioskLocked
+	}
+
+	err = db.QueryRow(`
+		SELECT u.id, u.full_name
+		FROM kiosk_credentials c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.company_id = $1 AND c.kind = $2 AND c.credential_hash = $3
+		  AND u.company_id = $1 AND u.is_active = true
+	`, kiosk.CompanyID, kind, hashCredential(key, kiosk.CompanyID, kind, value)).Scan(&userID, &fullName)
+
+	if err == sql.ErrNoRows {
+		// Count within the current window, starting a new one once it has
+		// passed, and lock the kiosk on every failure from the limit on
+		const inWindow = `COALESCE(failures_since > CURRENT_TIMESTAMP - $4 * INTERVAL '1 second', false)`
+		_, err = db.Exec(`
+			UPDATE kiosks
+			SET failed_attempts = CASE WHEN `+inWindow+` THEN failed_attempts + 1 ELSE 1 END,
+			    failures_since = CASE WHEN `+inWindow+` THEN failures_since ELSE CURRENT_TIMESTAMP END,
+			    locked_until = CASE WHEN (CASE WHEN `+inWindow+` THEN failed_attempts + 1 ELSE 1 END) >= $2
+			                        THEN CURRENT_TIMESTAMP + $3 * INTERVAL '1 second'
+			                        ELSE locked_until END
+			WHERE id = $1
+		`, kiosk.ID, kioskMaxFailedAttempts, int(kioskLockout.Seconds()), int(kioskFailureWindow.Seconds()))
+		if err != nil {
+			return 0, "", err
+		}
+		return 0, "", ErrUnknownCredential
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	return userID, fullName, nil
+}
+
+// hashCredential returns the keyed hash of a PIN or badge ID. The credential
+// key keeps short PINs from being brute-forced from a copy of the database.
+func hashCredential(key []byte, companyID int, kind, value string) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%d:%s:%s", companyID, kind, value)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashDeviceToken returns the SHA-256 digest stored for a kiosk or time clock token
+func hashDeviceToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateDeviceToken returns a random hex token for a kiosk or time clock
+func generateDeviceToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
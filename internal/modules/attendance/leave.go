@@ -0,0 +1,1049 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"modular-erp/internal/core/events"
+	"modular-erp/internal/core/notifications"
+)
+
+// Accrual methods of a leave type
+const (
+	AccrualNone          = "none"            // no balance is kept, e.g. unpaid leave
+	AccrualManual        = "manual"          // the balance only changes through adjustments
+	AccrualPerPayPeriod  = "per_pay_period"  // accrual_rate hours at the end of every pay period
+	AccrualPerHourWorked = "per_hour_worked" // accrual_rate hours per hour worked in a pay period
+	AccrualAnnualGrant   = "annual_grant"    // accrual_rate hours on January 1st
+)
+
+// Leave request statuses
+const (
+	LeaveStatusPending   = "pending"
+	LeaveStatusApproved  = "approved"
+	LeaveStatusRejected  = "rejected"
+	LeaveStatusCancelled = "cancelled"
+)
+
+// Kinds of leave ledger entries
+const (
+	LedgerAccrual    = "accrual"
+	LedgerGrant      = "grant"
+	LedgerCarryOver  = "carry_over" // hours over the carry-over cap, expired at the start of a year
+	LedgerUsage      = "usage"
+	LedgerReversal   = "reversal" // usage given back when approved leave is cancelled
+	LedgerAdjustment = "adjustment"
+)
+
+// Notification kinds sent for leave requests
+const (
+	NotificationLeaveRequested = "leave.requested"
+	NotificationLeaveReviewed  = "leave.reviewed"
+	NotificationLeaveCancelled = "leave.cancelled"
+)
+
+// maxLeaveDays is the longest span a single leave request may cover
+const maxLeaveDays = 92
+
+var (
+	// ErrLeaveTypeNotFound is returned when a leave type does not exist
+	ErrLeaveTypeNotFound = errors.New("leave type not found")
+	// ErrLeaveRequestNotFound is returned when a leave request does not exist or is not visible to the caller
+	ErrLeaveRequestNotFound = errors.New("leave request not found")
+)
+
+// LeaveType is a kind of absence, e.g. vacation, with the policy that
+// builds up its balance. Types with the "none" method keep no balance and
+// can be requested without limit.
+type LeaveType struct {
+	ID            int       `json:"id"`
+	CompanyID     int       `json:"company_id"`
+	Name          string    `json:"name"`
+	Paid          bool      `json:"paid"`
+	AccrualMethod string    `json:"accrual_method"` // none, manual, per_pay_period, per_hour_worked, annual_grant
+	AccrualRate   float64   `json:"accrual_rate"`
+	CarryOverCap  *float64  `json:"carry_over_cap"` // hours kept into a new year; null means no cap
+	AllowNegative bool      `json:"allow_negative"` // requests may take the balance below zero
+	IsActive      bool      `json:"is_active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// LeaveRequest is an employee's request for time off between two dates.
+// Its hours are spread over the working days in the range; approval takes
+// them from the balance.
+type LeaveRequest struct {
+	ID            int        `json:"id"`
+	CompanyID     int        `json:"company_id"`
+	UserID        int        `json:"user_id"`
+	LeaveTypeID   int        `json:"leave_type_id"`
+	LeaveType     string     `json:"leave_type"`
+	Paid          bool       `json:"paid"`
+	StartDate     string     `json:"start_date"` // YYYY-MM-DD
+	EndDate       string     `json:"end_date"`   // YYYY-MM-DD, inclusive
+	Hours         float64    `json:"hours"`
+	Comment       string     `json:"comment,omitempty"`
+	Status        string     `json:"status"` // pending, approved, rejected, cancelled
+	ReviewedBy    *int       `json:"reviewed_by,omitempty"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+	ReviewComment string     `json:"review_comment,omitempty"`
+	CancelledBy   *int       `json:"cancelled_by,omitempty"`
+	CancelledAt   *time.Time `json:"cancelled_at,omitempty"`
+	FullName      string     `json:"full_name,omitempty"`
+	Days          []LeaveDay `json:"days,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// LeaveDay is the share of a leave request falling on one working day
+type LeaveDay struct {
+	Date  string  `json:"date"` // YYYY-MM-DD
+	Hours float64 `json:"hours"`
+}
+
+// LeaveBalance is an employee's balance of one leave type. Available is the
+// balance less the hours of pending requests.
+type LeaveBalance struct {
+	UserID          int     `json:"user_id"`
+	FullName        string  `json:"full_name"`
+	LeaveTypeID     int     `json:"leave_type_id"`
+	LeaveType       string  `json:"leave_type"`
+	AccrualMethod   string  `json:"accrual_method"`
+	Balance         float64 `json:"balance"`
+	Pending         float64 `json:"pending"`
+	Available       float64 `json:"available"`
+	AccruedThisYear float64 `json:"accrued_this_year"`
+	UsedThisYear    float64 `json:"used_this_year"`
+}
+
+// LeaveLedgerEntry is one change to a leave balance; the balance is the sum
+// of the entries
+type LeaveLedgerEntry struct {
+	ID             int       `json:"id"`
+	CompanyID      int       `json:"company_id"`
+	UserID         int       `json:"user_id"`
+	LeaveTypeID    int       `json:"leave_type_id"`
+	LeaveType      string    `json:"leave_type"`
+	EntryDate      string    `json:"entry_date"` // YYYY-MM-DD
+	Kind           string    `json:"kind"`       // accrual, grant, carry_over, usage, reversal, adjustment
+	Hours          float64   `json:"hours"`
+	BalanceAfter   float64   `json:"balance_after"`
+	LeaveRequestID *int      `json:"leave_request_id,omitempty"`
+	Note           string    `json:"note,omitempty"`
+	CreatedBy      *int      `json:"created_by,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// LeaveReport totals the approved leave taken over a period
+type LeaveReport struct {
+	TotalHours  float64          `json:"total_hours"`
+	PaidHours   float64          `json:"paid_hours"`
+	UnpaidHours float64          `json:"unpaid_hours"`
+	Days        int              `json:"days"`
+	ByType      []LeaveTypeHours `json:"by_type"`
+}
+
+// LeaveTypeHours is the leave of one type taken over a period
+type LeaveTypeHours struct {
+	LeaveTypeID int     `json:"leave_type_id"`
+	LeaveType   string  `json:"leave_type"`
+	Paid        bool    `json:"paid"`
+	Hours       float64 `json:"hours"`
+	Days        int     `json:"days"`
+	Employees   int     `json:"employees"`
+}
+
+const leaveTypeColumns = `id, company_id, name, paid, accrual_method, accrual_rate, carry_over_cap,
+	allow_negative, is_active, created_at, updated_at`
+
+func scanLeaveType(row scanner) (*LeaveType, error) {
+	t := &LeaveType{}
+	err := row.Scan(
+		&t.ID, &t.CompanyID, &t.Name, &t.Paid, &t.AccrualMethod, &t.AccrualRate, &t.CarryOverCap,
+		&t.AllowNegative, &t.IsActive, &t.CreatedAt, &t.UpdatedAt,
+	)
+	return t, err
+}
+
+// leaveRequestColumns lists the columns scanned by scanLeaveRequest;
+// requests must be aliased as "lr", joined to users as "u" and to leave
+// types as "lt"
+const leaveRequestColumns = `lr.id, lr.company_id, lr.user_id, lr.leave_type_id, lt.name, lt.paid,
+	to_char(lr.start_date, 'YYYY-MM-DD'), to_char(lr.end_date, 'YYYY-MM-DD'), lr.hours, COALESCE(lr.comment, ''),
+	lr.status, lr.reviewed_by, lr.reviewed_at, COALESCE(lr.review_comment, ''), lr.cancelled_by, lr.cancelled_at,
+	u.full_name, lr.created_at, lr.updated_at`
+
+func scanLeaveRequest(row scanner) (*LeaveRequest, error) {
+	r := &LeaveRequest{}
+	err := row.Scan(
+		&r.ID, &r.CompanyID, &r.UserID, &r.LeaveTypeID, &r.LeaveType, &r.Paid,
+		&r.StartDate, &r.EndDate, &r.Hours, &r.Comment,
+		&r.Status, &r.ReviewedBy, &r.ReviewedAt, &r.ReviewComment, &r.CancelledBy, &r.CancelledAt,
+		&r.FullName, &r.CreatedAt, &r.UpdatedAt,
+	)
+	return r, err
+}
+
+// tracked reports whether the leave type keeps a balance
+func (t *LeaveType) tracked() bool {
+	return t.AccrualMethod != AccrualNone
+}
+
+// validate checks the leave type fields and that its name is unique in the company
+func (t *LeaveType) validate(q querier) error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(t.Name) > 100 {
+		return errors.New("name is too long")
+	}
+
+	switch t.AccrualMethod {
+	case AccrualNone, AccrualManual:
+		t.AccrualRate = 0
+	case AccrualPerPayPeriod, AccrualAnnualGrant:
+		if t.AccrualRate <= 0 || t.AccrualRate > 2000 {
+			return errors.New("accrual_rate must be between 0 and 2000 hours")
+		}
+	case AccrualPerHourWorked:
+		if t.AccrualRate <= 0 || t.AccrualRate > 1 {
+			return errors.New("accrual_rate must be between 0 and 1 hour per hour worked")
+		}
+	default:
+		return errors.New("accrual_method must be none, manual, per_pay_period, per_hour_worked or annual_grant")
+	}
+	if !t.tracked() {
+		t.CarryOverCap = nil
+		t.AllowNegative = false
+	}
+	if t.CarryOverCap != nil && *t.CarryOverCap < 0 {
+		return errors.New("carry_over_cap must not be negative")
+	}
+
+	var taken bool
+	err := q.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM leave_types WHERE company_id = $1 AND lower(name) = lower($2) AND id <> $3)
+	`, t.CompanyID, t.Name, t.ID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errors.New("a leave type with this name already exists")
+	}
+	return nil
+}
+
+// GetLeaveTypes retrieves the company's leave types, active ones only
+// unless all is set. Companies start with vacation, sick and unpaid leave,
+// created the first time their leave types are listed.
+func GetLeaveTypes(db *sql.DB, companyID int, all bool) ([]LeaveType, error) {
+	_, err := db.Exec(`
+		INSERT INTO leave_types (company_id, name, paid, accrual_method, accrual_rate, carry_over_cap,
+		                         created_at, updated_at)
+		SELECT $1, d.name, d.paid, d.method, d.rate, d.cap, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		FROM (VALUES ('Vacation', true, 'annual_grant', 80, 40),
+		             ('Sick', true, 'per_hour_worked', 0.0333, 40),
+		             ('Unpaid', false, 'none', 0, NULL)) AS d (name, paid, method, rate, cap)
+		WHERE NOT EXISTS (SELECT 1 FROM leave_types WHERE company_id = $1)
+		ON CONFLICT (company_id, name) DO NOTHING
+	`, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT `+leaveTypeColumns+`
+		FROM leave_types
+		WHERE company_id = $1 AND ($2 OR is_active = true)
+		ORDER BY name
+	`, companyID, all)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := []LeaveType{}
+	for rows.Next() {
+		t, err := scanLeaveType(rows)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, *t)
+	}
+
+	return types, rows.Err()
+}
+
+// getLeaveType retrieves a company leave type
+func getLeaveType(q querier, companyID, id int) (*LeaveType, error) {
+	t, err := scanLeaveType(q.QueryRow(`
+		SELECT `+leaveTypeColumns+` FROM leave_types WHERE id = $1 AND company_id = $2
+	`, id, companyID))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrLeaveTypeNotFound
+	}
+	return t, err
+}
+
+// CreateLeaveType creates a leave type
+func CreateLeaveType(db *sql.DB, t *LeaveType) (*LeaveType, error) {
+	if err := t.validate(db); err != nil {
+		return nil, err
+	}
+
+	return scanLeaveType(db.QueryRow(`
+		INSERT INTO leave_types (company_id, name, paid, accrual_method, accrual_rate, carry_over_cap,
+		                         allow_negative, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+leaveTypeColumns,
+		t.CompanyID, t.Name, t.Paid, t.AccrualMethod, t.AccrualRate, t.CarryOverCap, t.AllowNegative,
+	))
+}
+
+// UpdateLeaveType updates a leave type. Requests and ledger entries already
+// made are unchanged; a new accrual policy applies from the next accrual run.
+func UpdateLeaveType(db *sql.DB, t *LeaveType) (*LeaveType, error) {
+	if err := t.validate(db); err != nil {
+		return nil, err
+	}
+
+	updated, err := scanLeaveType(db.QueryRow(`
+		UPDATE leave_types
+		SET name = $1, paid = $2, accrual_method = $3, accrual_rate = $4, carry_over_cap = $5,
+		    allow_negative = $6, is_active = $7, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $8 AND company_id = $9
+		RETURNING `+leaveTypeColumns,
+		t.Name, t.Paid, t.AccrualMethod, t.AccrualRate, t.CarryOverCap, t.AllowNegative, t.IsActive,
+		t.ID, t.CompanyID,
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrLeaveTypeNotFound
+	}
+	return updated, err
+}
+
+// DeactivateLeaveType stops a leave type from being requested or accruing.
+// Types are never deleted so past requests and ledger entries keep them.
+func DeactivateLeaveType(db *sql.DB, companyID, id int) error {
+	result, err := db.Exec(`
+		UPDATE leave_types SET is_active = false, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND company_id = $2
+	`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrLeaveTypeNotFound
+	}
+	return nil
+}
+
+// leaveBalance returns an employee's balance of a leave type and the hours
+// of their pending requests for it, other than excludeID
+func leaveBalance(q querier, userID, leaveTypeID, excludeID int) (float64, float64, error) {
+	var balance, pending float64
+	err := q.QueryRow(`
+		SELECT
+			(SELECT COALESCE(SUM(hours), 0) FROM leave_ledger WHERE user_id = $1 AND leave_type_id = $2),
+			(SELECT COALESCE(SUM(hours), 0) FROM leave_requests
+			 WHERE user_id = $1 AND leave_type_id = $2 AND status = 'pending' AND id <> $3)
+	`, userID, leaveTypeID, excludeID).Scan(&balance, &pending)
+	return balance, pending, err
+}
+
+// checkLeaveBalance rejects taking hours of a leave type the employee has
+// not got available, unless the type keeps no balance or allows going negative
+func checkLeaveBalance(q querier, t *LeaveType, userID, excludeID int, hours float64) error {
+	if !t.tracked() || t.AllowNegative {
+		return nil
+	}
+	balance, pending, err := leaveBalance(q, userID, t.ID, excludeID)
+	if err != nil {
+		return err
+	}
+	if available := balance - pending; hours > available+0.005 {
+		return fmt.Errorf("not enough %s balance: %.2f hours available", t.Name, math.Max(available, 0))
+	}
+	return nil
+}
+
+// checkLeavePeriodOpen rejects leave touching a pay period whose timesheet
+// is approved, share-locking the timesheets like checkPeriodOpen
+func checkLeavePeriodOpen(q querier, userID int, start, end string) error {
+	rows, err := q.Query(`
+		SELECT status FROM timesheets
+		WHERE user_id = $1 AND period_start <= $3 AND period_end >= $2
+		FOR SHARE
+	`, userID, start, end)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		if err := rows.Scan(&status); err != nil {
+			return err
+		}
+		if status == TimesheetStatusApproved {
+			return ErrPeriodLocked
+		}
+	}
+	return rows.Err()
+}
+
+// workingDays returns the days from start to end, inclusive, that leave is
+// taken on: Monday to Friday, except company holidays
+func workingDays(q querier, companyID int, start, end time.Time) ([]time.Time, error) {
+	holidays, err := holidayDates(q, companyID, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	var days []time.Time
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		if _, ok := holidays[day.Format("2006-01-02")]; ok {
+			continue
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// splitLeaveHours spreads hours over days in whole hundredths, the last day
+// taking the remainder
+func splitLeaveHours(hours float64, days []time.Time) []LeaveDay {
+	each := math.Floor(hours/float64(len(days))*100) / 100
+	split := make([]LeaveDay, len(days))
+	for i, day := range days {
+		split[i] = LeaveDay{Date: day.Format("2006-01-02"), Hours: each}
+	}
+	split[len(split)-1].Hours = math.Round((hours-each*float64(len(days)-1))*100) / 100
+	return split
+}
+
+// CreateLeaveRequest validates and submits a leave request, notifying the
+// employee's manager. Without hours, every working day in the range counts
+// as a full day of the policy's leave_hours_per_day.
+func CreateLeaveRequest(db *sql.DB, req *LeaveRequest, policy *Policy) (*LeaveRequest, error) {
+	start, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return nil, errors.New("start_date must be in YYYY-MM-DD format")
+	}
+	end, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		return nil, errors.New("end_date must be in YYYY-MM-DD format")
+	}
+	if end.Before(start) {
+		return nil, errors.New("end_date must not be before start_date")
+	}
+	if calendarDays(start, end) >= maxLeaveDays {
+		return nil, fmt.Errorf("a leave request can cover at most %d days", maxLeaveDays)
+	}
+	if req.Hours < 0 {
+		return nil, errors.New("hours must not be negative")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Serialize with the employee's other requests so overlap and balance
+	// checks see them
+	if _, err := tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE`, req.UserID); err != nil {
+		return nil, err
+	}
+
+	leaveType, err := getLeaveType(tx, req.CompanyID, req.LeaveTypeID)
+	if err != nil {
+		return nil, err
+	}
+	if !leaveType.IsActive {
+		return nil, errors.New("leave type is no longer available")
+	}
+
+	days, err := workingDays(tx, req.CompanyID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if len(days) == 0 {
+		return nil, errors.New("the requested dates have no working days")
+	}
+	hours := req.Hours
+	if hours == 0 {
+		hours = float64(len(days)) * policy.LeaveHoursPerDay
+	}
+	if hours > float64(len(days))*24 {
+		return nil, errors.New("hours exceed the working days requested")
+	}
+	hours = math.Round(hours*100) / 100
+
+	var overlap sql.NullString
+	err = tx.QueryRow(`
+		SELECT to_char(MIN(leave_date), 'YYYY-MM-DD') FROM leave_days
+		WHERE user_id = $1 AND leave_date BETWEEN $2 AND $3
+	`, req.UserID, req.StartDate, req.EndDate).Scan(&overlap)
+	if err != nil {
+		return nil, err
+	}
+	if overlap.Valid {
+		return nil, fmt.Errorf("leave has already been requested on %s", overlap.String)
+	}
+
+	if err := checkLeavePeriodOpen(tx, req.UserID, req.StartDate, req.EndDate); err != nil {
+		return nil, err
+	}
+	if err := checkLeaveBalance(tx, leaveType, req.UserID, 0, hours); err != nil {
+		return nil, err
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO leave_requests (company_id, user_id, leave_type_id, start_date, end_date, hours, comment,
+		                            status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), 'pending', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id
+	`, req.CompanyID, req.UserID, leaveType.ID, req.StartDate, req.EndDate, hours, req.Comment).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	split := splitLeaveHours(hours, days)
+	for _, day := range split {
+		_, err = tx.Exec(`
+			INSERT INTO leave_days (leave_request_id, user_id, leave_date, hours) VALUES ($1, $2, $3, $4)
+		`, id, req.UserID, day.Date, day.Hours)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	created, err := getLeaveRequest(tx, req.CompanyID, id, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = notifications.NotifyManager(tx, created.CompanyID, created.UserID, NotificationLeaveRequested,
+		"New leave request",
+		fmt.Sprintf("%s requested %s leave from %s to %s (%.2f hours).",
+			created.FullName, created.LeaveType, created.StartDate, created.EndDate, created.Hours),
+		map[string]interface{}{"leave_request_id": created.ID, "user_id": created.UserID})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	created.Days = split
+	return created, nil
+}
+
+// getLeaveRequest retrieves a company leave request, optionally locking it.
+// userIDs limits whose requests may be seen; nil means any in the company.
+func getLeaveRequest(q querier, companyID, id int, userIDs []int, forUpdate bool) (*LeaveRequest, error) {
+	lock := ""
+	if forUpdate {
+		lock = "FOR UPDATE OF lr"
+	}
+
+	r, err := scanLeaveRequest(q.QueryRow(`
+		SELECT `+leaveRequestColumns+`
+		FROM leave_requests lr
+		JOIN users u ON lr.user_id = u.id
+		JOIN leave_types lt ON lr.leave_type_id = lt.id
+		WHERE lr.id = $1 AND lr.company_id = $2 AND ($3::int[] IS NULL OR lr.user_id = ANY($3))
+		`+lock,
+		id, companyID, pq.Array(userIDs),
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrLeaveRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetLeaveRequests retrieves company leave requests by start date.
+// userIDs limits whose requests are returned; nil means every user in the company.
+func GetLeaveRequests(db *sql.DB, companyID int, userIDs []int, status string, limit, offset int) ([]LeaveRequest, error) {
+	rows, err := db.Query(`
+		SELECT `+leaveRequestColumns+`
+		FROM leave_requests lr
+		JOIN users u ON lr.user_id = u.id
+		JOIN leave_types lt ON lr.leave_type_id = lt.id
+		WHERE lr.company_id = $1 AND ($2::int[] IS NULL OR lr.user_id = ANY($2)) AND ($3 = '' OR lr.status = $3)
+		ORDER BY lr.start_date, lr.id
+		LIMIT $4 OFFSET $5
+	`, companyID, pq.Array(userIDs), status, limit, offset)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []LeaveRequest{}
+	for rows.Next() {
+		r, err := scanLeaveRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *r)
+	}
+
+	return requests, rows.Err()
+}
+
+// ReviewLeaveRequest approves or rejects a pending leave request. Approval
+// checks the balance again and records the usage in the ledger; rejection
+// frees the days for another request. Reviewers cannot review their own
+// requests. The employee is notified either way.
+func ReviewLeaveRequest(db *sql.DB, companyID, id, reviewerID int, userIDs []int, approve bool, comment string) (*LeaveRequest, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	req, err := getLeaveRequest(tx, companyID, id, userIDs, true)
+	if err != nil {
+		return nil, err
+	}
+	if req.Status != LeaveStatusPending {
+		return nil, errors.New("request has already been " + req.Status)
+	}
+	if req.UserID == reviewerID {
+		return nil, errors.New("you cannot review your own request")
+	}
+
+	status := LeaveStatusRejected
+	if approve {
+		status = LeaveStatusApproved
+
+		if _, err := tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE`, req.UserID); err != nil {
+			return nil, err
+		}
+		leaveType, err := getLeaveType(tx, companyID, req.LeaveTypeID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkLeavePeriodOpen(tx, req.UserID, req.StartDate, req.EndDate); err != nil {
+			return nil, err
+		}
+		if err := checkLeaveBalance(tx, leaveType, req.UserID, req.ID, req.Hours); err != nil {
+			return nil, err
+		}
+
+		if leaveType.tracked() {
+			_, err = tx.Exec(`
+				INSERT INTO leave_ledger (company_id, user_id, leave_type_id, entry_date, kind, hours,
+				                          leave_request_id, note, created_by, created_at)
+				VALUES ($1, $2, $3, $4, 'usage', $5, $6, $7, $8, CURRENT_TIMESTAMP)
+			`, companyID, req.UserID, req.LeaveTypeID, req.StartDate, -req.Hours, req.ID,
+				fmt.Sprintf("Leave request #%d", req.ID), reviewerID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = events.Publish(tx, companyID, LeaveApproved{
+			LeaveRequestID: req.ID,
+			UserID:         req.UserID,
+			CompanyID:      companyID,
+			LeaveType:      req.LeaveType,
+			Paid:           req.Paid,
+			StartDate:      req.StartDate,
+			EndDate:        req.EndDate,
+			Hours:          req.Hours,
+			ApprovedBy:     reviewerID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if _, err := tx.Exec(`DELETE FROM leave_days WHERE leave_request_id = $1`, req.ID); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE leave_requests
+		SET status = $1, reviewed_by = $2, reviewed_at = CURRENT_TIMESTAMP, review_comment = NULLIF($3, ''),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4
+	`, status, reviewerID, comment, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	body := fmt.Sprintf("Your %s leave from %s to %s was %s.", req.LeaveType, req.StartDate, req.EndDate, status)
+	if comment != "" {
+		body += " " + comment
+	}
+	err = notifications.Notify(tx, companyID, req.UserID, NotificationLeaveReviewed,
+		"Leave request "+status, body,
+		map[string]interface{}{"leave_request_id": req.ID, "status": status})
+	if err != nil {
+		return nil, err
+	}
+
+	reviewed, err := getLeaveRequest(tx, companyID, req.ID, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return reviewed, nil
+}
+
+// CancelLeaveRequest cancels a pending or approved leave request, giving
+// approved hours back to the balance. Employees can cancel their own
+// requests until the leave starts on today's date; managers can cancel the
+// requests of the users in userIDs at any time (nil means any in the company).
+// The manager is notified when an employee cancels approved leave, and the
+// employee when a manager cancels theirs.
+func CancelLeaveRequest(db *sql.DB, companyID, id, userID int, userIDs []int, today string) (*LeaveRequest, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	req, err := getLeaveRequest(tx, companyID, id, userIDs, true)
+	if err != nil {
+		return nil, err
+	}
+	if req.Status != LeaveStatusPending && req.Status != LeaveStatusApproved {
+		return nil, errors.New("only pending or approved requests can be cancelled")
+	}
+	own := req.UserID == userID
+	if own && req.Status == LeaveStatusApproved && req.StartDate <= today {
+		return nil, errors.New("leave that has started can only be cancelled by a manager")
+	}
+
+	if req.Status == LeaveStatusApproved {
+		if err := checkLeavePeriodOpen(tx, req.UserID, req.StartDate, req.EndDate); err != nil {
+			return nil, err
+		}
+
+		// Give back whatever the request took from the balance, dated like
+		// the usage so yearly totals and carry-over still add up
+		_, err = tx.Exec(`
+			INSERT INTO leave_ledger (company_id, user_id, leave_type_id, entry_date, kind, hours,
+			                          leave_request_id, note, created_by, created_at)
+			SELECT $1, $2, $3, $4, 'reversal', -SUM(hours), $5, $6, $7, CURRENT_TIMESTAMP
+			FROM leave_ledger
+			WHERE leave_request_id = $5
+			HAVING SUM(hours) <> 0
+		`, companyID, req.UserID, req.LeaveTypeID, req.StartDate, req.ID,
+			fmt.Sprintf("Leave request #%d cancelled", req.ID), userID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = events.Publish(tx, companyID, LeaveCancelled{
+			LeaveRequestID: req.ID,
+			UserID:         req.UserID,
+			CompanyID:      companyID,
+			StartDate:      req.StartDate,
+			EndDate:        req.EndDate,
+			Hours:          req.Hours,
+			CancelledBy:    userID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM leave_days WHERE leave_request_id = $1`, req.ID); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`
+		UPDATE leave_requests
+		SET status = 'cancelled', cancelled_by = $1, cancelled_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`, userID, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{"leave_request_id": req.ID, "user_id": req.UserID}
+	switch {
+	case !own:
+		err = notifications.Notify(tx, companyID, req.UserID, NotificationLeaveCancelled,
+			"Leave cancelled",
+			fmt.Sprintf("Your %s leave from %s to %s was cancelled.", req.LeaveType, req.StartDate, req.EndDate),
+			data)
+	case req.Status == LeaveStatusApproved:
+		err = notifications.NotifyManager(tx, companyID, req.UserID, NotificationLeaveCancelled,
+			"Leave cancelled",
+			fmt.Sprintf("%s cancelled their approved %s leave from %s to %s.",
+				req.FullName, req.LeaveType, req.StartDate, req.EndDate),
+			data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cancelled, err := getLeaveRequest(tx, companyID, req.ID, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return cancelled, nil
+}
+
+// GetLeaveBalances retrieves the balances of every leave type that keeps one
+// for the active users in userIDs (nil means every user in the company).
+// Accrued and used hours are counted from yearStart, a YYYY-MM-DD date.
+func GetLeaveBalances(db *sql.DB, companyID int, userIDs []int, yearStart string) ([]LeaveBalance, error) {
+	rows, err := db.Query(`
+		SELECT u.id, u.full_name, lt.id, lt.name, lt.accrual_method,
+		       COALESCE(l.balance, 0), COALESCE(l.accrued, 0), COALESCE(l.used, 0), COALESCE(p.pending, 0)
+		FROM users u
+		JOIN leave_types lt ON lt.company_id = u.company_id
+		LEFT JOIN LATERAL (
+			SELECT SUM(hours) AS balance,
+			       SUM(hours) FILTER (WHERE kind IN ('accrual', 'grant') AND entry_date >= $3) AS accrued,
+			       -SUM(hours) FILTER (WHERE kind IN ('usage', 'reversal') AND entry_date >= $3) AS used
+			FROM leave_ledger
+			WHERE user_id = u.id AND leave_type_id = lt.id
+		) l ON true
+		LEFT JOIN LATERAL (
+			SELECT SUM(hours) AS pending
+			FROM leave_requests
+			WHERE user_id = u.id AND leave_type_id = lt.id AND status = 'pending'
+		) p ON true
+		WHERE u.company_id = $1 AND ($2::int[] IS NULL OR u.id = ANY($2)) AND u.is_active = true
+		  AND lt.is_active = true AND lt.accrual_method <> 'none'
+		ORDER BY u.full_name, u.id, lt.name
+	`, companyID, pq.Array(userIDs), yearStart)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []LeaveBalance{}
+	for rows.Next() {
+		var b LeaveBalance
+		err := rows.Scan(
+			&b.UserID, &b.FullName, &b.LeaveTypeID, &b.LeaveType, &b.AccrualMethod,
+			&b.Balance, &b.AccruedThisYear, &b.UsedThisYear, &b.Pending,
+		)
+		if err != nil {
+			return nil, err
+		}
+		b.Available = math.Round((b.Balance-b.Pending)*100) / 100
+		balances = append(balances, b)
+	}
+
+	return balances, rows.Err()
+}
+
+// GetLeaveLedger retrieves an employee's ledger entries, newest first, with
+// the balance after each. leaveTypeID limits it to one type when not 0.
+func GetLeaveLedger(db *sql.DB, companyID, userID, leaveTypeID, limit, offset int) ([]LeaveLedgerEntry, error) {
+	rows, err := db.Query(`
+		SELECT l.id, l.company_id, l.user_id, l.leave_type_id, lt.name, to_char(l.entry_date, 'YYYY-MM-DD'),
+		       l.kind, l.hours, l.balance_after, l.leave_request_id, COALESCE(l.note, ''), l.created_by, l.created_at
+		FROM (
+			SELECT *, SUM(hours) OVER (PARTITION BY leave_type_id ORDER BY entry_date, id) AS balance_after
+			FROM leave_ledger
+			WHERE company_id = $1 AND user_id = $2
+		) l
+		JOIN leave_types lt ON l.leave_type_id = lt.id
+		WHERE ($3 = 0 OR l.leave_type_id = $3)
+		ORDER BY l.entry_date DESC, l.id DESC
+		LIMIT $4 OFFSET $5
+	`, companyID, userID, leaveTypeID, limit, offset)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LeaveLedgerEntry{}
+	for rows.Next() {
+		var e LeaveLedgerEntry
+		err := rows.Scan(
+			&e.ID, &e.CompanyID, &e.UserID, &e.LeaveTypeID, &e.LeaveType, &e.EntryDate,
+			&e.Kind, &e.Hours, &e.BalanceAfter, &e.LeaveRequestID, &e.Note, &e.CreatedBy, &e.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// AdjustLeaveBalance records a manual correction to an employee's balance,
+// e.g. an opening balance or hours bought back; a note is required
+func AdjustLeaveBalance(db *sql.DB, entry *LeaveLedgerEntry) (*LeaveLedgerEntry, error) {
+	if entry.Hours == 0 || math.Abs(entry.Hours) > 2000 {
+		return nil, errors.New("hours must be non-zero and at most 2000")
+	}
+	if strings.TrimSpace(entry.Note) == "" {
+		return nil, errors.New("a note is required for an adjustment")
+	}
+
+	leaveType, err := getLeaveType(db, entry.CompanyID, entry.LeaveTypeID)
+	if err != nil {
+		return nil, err
+	}
+	if !leaveType.tracked() {
+		return nil, errors.New("leave type does not keep a balance")
+	}
+
+	var exists bool
+	err = db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND company_id = $2)
+	`, entry.UserID, entry.CompanyID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("user not found")
+	}
+
+	err = db.QueryRow(`
+		INSERT INTO leave_ledger (company_id, user_id, leave_type_id, entry_date, kind, hours, note,
+		                          created_by, created_at)
+		VALUES ($1, $2, $3, $4, 'adjustment', $5, $6, $7, CURRENT_TIMESTAMP)
+		RETURNING id, created_at,
+		          (SELECT COALESCE(SUM(hours), 0) FROM leave_ledger WHERE user_id = $2 AND leave_type_id = $3) + $5
+	`, entry.CompanyID, entry.UserID, entry.LeaveTypeID, entry.EntryDate, math.Round(entry.Hours*100)/100,
+		entry.Note, entry.CreatedBy).Scan(&entry.ID, &entry.CreatedAt, &entry.BalanceAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Kind = LedgerAdjustment
+	entry.LeaveType = leaveType.Name
+	return entry, nil
+}
+
+// GetLeaveReport totals the approved leave taken between two dates,
+// inclusive, by the users and department of a shift filter
+func GetLeaveReport(q querier, filter ShiftFilter, from, to string) (*LeaveReport, error) {
+	rows, err := q.Query(`
+		SELECT lt.id, lt.name, lt.paid, SUM(ld.hours), COUNT(*), COUNT(DISTINCT ld.user_id)
+		FROM leave_days ld
+		JOIN leave_requests lr ON ld.leave_request_id = lr.id
+		JOIN leave_types lt ON lr.leave_type_id = lt.id
+		JOIN users u ON ld.user_id = u.id
+		WHERE lr.company_id = $1 AND lr.status = 'approved' AND ld.leave_date BETWEEN $2 AND $3
+		  AND ($4::int[] IS NULL OR ld.user_id = ANY($4)) AND ($5 = 0 OR u.department_id = $5)
+		GROUP BY lt.id, lt.name, lt.paid
+		ORDER BY lt.name
+	`, filter.CompanyID, from, to, pq.Array(filter.UserIDs), filter.DepartmentID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &LeaveReport{ByType: []LeaveTypeHours{}}
+	for rows.Next() {
+		var t LeaveTypeHours
+		if err := rows.Scan(&t.LeaveTypeID, &t.LeaveType, &t.Paid, &t.Hours, &t.Days, &t.Employees); err != nil {
+			return nil, err
+		}
+		report.TotalHours += t.Hours
+		if t.Paid {
+			report.PaidHours += t.Hours
+		} else {
+			report.UnpaidHours += t.Hours
+		}
+		report.Days += t.Days
+		report.ByType = append(report.ByType, t)
+	}
+
+	return report, rows.Err()
+}
+
+// leaveHoursByUser sums the approved leave hours of each user between two
+// dates, inclusive. userIDs limits the users; nil means every user in the company.
+func leaveHoursByUser(q querier, companyID int, userIDs []int, from, to string) (map[int]float64, error) {
+	rows, err := q.Query(`
+		SELECT ld.user_id, SUM(ld.hours)
+		FROM leave_days ld
+		JOIN leave_requests lr ON ld.leave_request_id = lr.id
+		WHERE lr.company_id = $1 AND lr.status = 'approved' AND ld.leave_date BETWEEN $2 AND $3
+		  AND ($4::int[] IS NULL OR ld.user_id = ANY($4))
+		GROUP BY ld.user_id
+	`, companyID, from, to, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hours := map[int]float64{}
+	for rows.Next() {
+		var userID int
+		var h float64
+		if err := rows.Scan(&userID, &h); err != nil {
+			return nil, err
+		}
+		hours[userID] = h
+	}
+
+	return hours, rows.Err()
+}
+
+// approvedLeaveDates returns the days of approved leave of each user between
+// two dates, inclusive, with the leave type's name, keyed by YYYY-MM-DD
+func approvedLeaveDates(q querier, companyID int, userIDs []int, from, to string) (map[int]map[string]string, error) {
+	rows, err := q.Query(`
+		SELECT ld.user_id, to_char(ld.leave_date, 'YYYY-MM-DD'), lt.name
+		FROM leave_days ld
+		JOIN leave_requests lr ON ld.leave_request_id = lr.id
+		JOIN leave_types lt ON lr.leave_type_id = lt.id
+		WHERE lr.company_id = $1 AND lr.status = 'approved' AND ld.user_id = ANY($2)
+		  AND ld.leave_date BETWEEN $3 AND $4
+	`, companyID, pq.Array(userIDs), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dates := map[int]map[string]string{}
+	for rows.Next() {
+		var userID int
+		var date, name string
+		if err := rows.Scan(&userID, &date, &name); err != nil {
+			return nil, err
+		}
+		if dates[userID] == nil {
+			dates[userID] = map[string]string{}
+		}
+		dates[userID][date] = name
+	}
+
+	return dates, rows.Err()
+}
@@ -3,7 +3,12 @@ package attendance
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
+	"modular-erp/internal/core/events"
 )
 
 // Shift represents a work shift
@@ -15,8 +20,107 @@ type Shift struct {
 	ClockOut  *time.Time `json:"clock_out,omitempty"`
 	Status    string     `json:"status"` // in_progress, completed, cancelled
 	Notes     string     `json:"notes,omitempty"`
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
+	// AutoClosed marks shifts closed by the auto-close policy; they are left
+	// out of report hours until a manager reviews them
+	AutoClosed bool       `json:"auto_closed"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	ReviewedBy *int       `json:"reviewed_by,omitempty"`
+	// UnpaidBreakHours is the length of the finished unpaid breaks, which
+	// do not count as worked time
+	UnpaidBreakHours float64 `json:"unpaid_break_hours"`
+	// Flags lists policy violations recorded at punch time; flagged shifts need review
+	Flags            []string  `json:"flags,omitempty"`
+	ClockInLocation  *Location `json:"clock_in_location,omitempty"`
+	ClockOutLocation *Location `json:"clock_out_location,omitempty"`
+	ClockInIP        string    `json:"clock_in_ip,omitempty"`
+	ClockOutIP       string    `json:"clock_out_ip,omitempty"`
+	ClockInKioskID   *int      `json:"clock_in_kiosk_id,omitempty"`
+	ClockOutKioskID  *int      `json:"clock_out_kiosk_id,omitempty"`
+	ClockInDeviceID  *int      `json:"clock_in_device_id,omitempty"`
+	ClockOutDeviceID *int      `json:"clock_out_device_id,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// shiftColumns lists the columns scanned by scanShift; shifts must be aliased as "s"
+const shiftColumns = `s.id, s.user_id, s.company_id, s.clock_in, s.clock_out, s.status, COALESCE(s.notes, ''),
+	s.auto_closed, s.reviewed_at, s.reviewed_by, ` + unpaidBreakSeconds + ` / 3600, s.flags,
+	s.clock_in_latitude, s.clock_in_longitude, s.clock_in_accuracy, s.clock_in_site_id,
+	s.clock_out_latitude, s.clock_out_longitude, s.clock_out_accuracy, s.clock_out_site_id,
+	COALESCE(s.clock_in_ip, ''), COALESCE(s.clock_out_ip, ''), s.clock_in_kiosk_id, s.clock_out_kiosk_id,
+	s.clock_in_device_id, s.clock_out_device_id, s.created_at, s.updated_at`
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// scanShift scans shiftColumns, followed by any extra destinations
+func scanShift(row scanner, shift *Shift, extra ...interface{}) error {
+	var in, out nullLocation
+	dest := []interface{}{
+		&shift.ID, &shift.UserID, &shift.CompanyID, &shift.ClockIn, &shift.ClockOut, &shift.Status, &shift.Notes,
+		&shift.AutoClosed, &shift.ReviewedAt, &shift.ReviewedBy, &shift.UnpaidBreakHours, pq.Array(&shift.Flags),
+		&in.Latitude, &in.Longitude, &in.Accuracy, &in.SiteID,
+		&out.Latitude, &out.Longitude, &out.Accuracy, &out.SiteID,
+		&shift.ClockInIP, &shift.ClockOutIP, &shift.ClockInKioskID, &shift.ClockOutKioskID,
+		&shift.ClockInDeviceID, &shift.ClockOutDeviceID, &shift.CreatedAt, &shift.UpdatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+
+	shift.ClockInLocation = in.location()
+	shift.ClockOutLocation = out.location()
+	return nil
+}
+
+// nullLocation scans the nullable location columns of a punch
+type nullLocation struct {
+	Latitude, Longitude, Accuracy sql.NullFloat64
+	SiteID                        *int
+}
+
+func (n nullLocation) location() *Location {
+	if !n.Latitude.Valid || !n.Longitude.Valid {
+		return nil
+	}
+	return &Location{
+		Latitude:  n.Latitude.Float64,
+		Longitude: n.Longitude.Float64,
+		Accuracy:  n.Accuracy.Float64,
+		SiteID:    n.SiteID,
+	}
+}
+
+// NeedsReview reports whether the shift is auto-closed or flagged and not yet reviewed
+func (s *Shift) NeedsReview() bool {
+	return (s.AutoClosed || len(s.Flags) > 0) && s.ReviewedAt == nil
+}
+
+// Punch describes how a clock-in or clock-out was made
+type Punch struct {
+	Location *Location // nil when the device sent no coordinates
+	SourceIP string    // client address, behind any trusted proxies
+	KioskID  *int      // shared kiosk the punch was made on, if any
+	QRToken  string    // site QR code scanned by the employee, if any
+	Flags    []string  // policy violations to record on the shift
+	// ProjectID is the project a clock-in starts allocating time to, if any
+	ProjectID *int
+}
+
+// locationArgs returns the latitude, longitude, accuracy and site ID column values
+func (p Punch) locationArgs() []interface{} {
+	if p.Location == nil {
+		return []interface{}{nil, nil, nil, nil}
+	}
+	return []interface{}{p.Location.Latitude, p.Location.Longitude, p.Location.Accuracy, p.Location.SiteID}
 }
 
 // ShiftWithUserInfo represents a shift with user information
@@ -32,15 +136,87 @@ type ShiftReport struct {
 	TotalShifts     int     `json:"total_shifts"`
 	CompletedShifts int     `json:"completed_shifts"`
 	ActiveShifts    int     `json:"active_shifts"`
-	TotalHours      float64 `json:"total_hours"`
+	TotalHours      float64 `json:"total_hours"` // worked hours, excluding unpaid breaks
 	AverageHours    float64 `json:"average_hours"`
+	// UnpaidBreakHours is the unpaid break time subtracted from TotalHours
+	UnpaidBreakHours float64 `json:"unpaid_break_hours"`
+	// PendingReview counts auto-closed or flagged shifts not yet reviewed; their hours
+	// are excluded from TotalHours and they are not counted as completed
+	PendingReview int `json:"pending_review"`
+}
+
+// pendingReview matches auto-closed or flagged shifts that have not been reviewed
+const pendingReview = `((s.auto_closed OR cardinality(s.flags) > 0) AND s.reviewed_at IS NULL)`
+
+// ShiftFilter narrows company-wide shift queries
+type ShiftFilter struct {
+	CompanyID    int
+	StartDate    time.Time
+	EndDate      time.Time
+	UserIDs      []int // nil means every user in the company
+	DepartmentID int   // 0 means any department
+	NeedsReview  bool  // only auto-closed or flagged shifts awaiting review
+}
+
+// RestrictUsers narrows the filter to the given users, keeping only those
+// it already allows
+func (f *ShiftFilter) RestrictUsers(ids []int) {
+	restricted := []int{}
+	for _, id := range ids {
+		if (f.UserIDs == nil || containsID(f.UserIDs, id)) && !containsID(restricted, id) {
+			restricted = append(restricted, id)
+		}
+	}
+	f.UserIDs = restricted
+}
+
+// where builds the SQL conditions and arguments for the filter. Shifts must
+// be aliased as "s" and users as "u"; placeholders start at $1.
+func (f ShiftFilter) where() (string, []interface{}) {
+	conditions := []string{"s.company_id = $1", "s.clock_in >= $2", "s.clock_in <= $3"}
+	args := []interface{}{f.CompanyID, f.StartDate.UTC(), f.EndDate.UTC()}
+
+	if f.UserIDs != nil {
+		placeholders := make([]string, len(f.UserIDs))
+		for i, id := range f.UserIDs {
+			args = append(args, id)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		if len(placeholders) == 0 {
+			conditions = append(conditions, "FALSE")
+		} else {
+			conditions = append(conditions, "s.user_id IN ("+strings.Join(placeholders, ", ")+")")
+		}
+	}
+
+	if f.DepartmentID != 0 {
+		args = append(args, f.DepartmentID)
+		conditions = append(conditions, fmt.Sprintf("u.department_id = $%d", len(args)))
+	}
+
+	if f.NeedsReview {
+		conditions = append(conditions, pendingReview)
+	}
+
+	return strings.Join(conditions, " AND "), args
 }
 
 // CreateShift creates a new shift record
-func CreateShift(db *sql.DB, userID, companyID int) (*Shift, error) {
+func CreateShift(db *sql.DB, userID, companyID int, punch Punch) (*Shift, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Serialize clock-ins of the same employee, as device events do
+	if _, err = tx.Exec(`SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return nil, err
+	}
+
 	// Check if user has an active shift
 	var activeShiftID int
-	err := db.QueryRow(`
+	err = tx.QueryRow(`
 		SELECT id FROM shifts
 		WHERE user_id = $1 AND status = 'in_progress'
 		LIMIT 1
@@ -53,57 +229,136 @@ func CreateShift(db *sql.DB, userID, companyID int) (*Shift, error) {
 	shift := &Shift{
 		UserID:    userID,
 		CompanyID: companyID,
-		ClockIn:   time.Now(),
+		ClockIn:   time.Now().UTC(),
 		Status:    "in_progress",
 	}
+	if err = checkPeriodOpen(tx, userID, shift.ClockIn); err != nil {
+		return nil, err
+	}
+	if punch.ProjectID != nil {
+		if _, err = activeProject(tx, companyID, *punch.ProjectID); err != nil {
+			return nil, err
+		}
+	}
 
-	err = db.QueryRow(`
-		INSERT INTO shifts (user_id, company_id, clock_in, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-		RETURNING id, clock_in, created_at, updated_at
-	`, shift.UserID, shift.CompanyID, shift.ClockIn, shift.Status).Scan(
-		&shift.ID, &shift.ClockIn, &shift.CreatedAt, &shift.UpdatedAt,
-	)
+	args := append([]interface{}{shift.UserID, shift.CompanyID, shift.ClockIn, shift.Status, pq.Array(punch.Flags),
+		punch.SourceIP, punch.KioskID}, punch.locationArgs()...)
+	err = scanShift(tx.QueryRow(`
+		INSERT INTO shifts AS s (user_id, company_id, clock_in, status, flags, clock_in_ip, clock_in_kiosk_id,
+		                         clock_in_latitude, clock_in_longitude, clock_in_accuracy, clock_in_site_id,
+		                         created_at, updated_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5, '{}'::text[]), NULLIF($6, ''), $7, $8, $9, $10, $11,
+		        CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+shiftColumns,
+		args...,
+	), shift)
+
+	if isUniqueViolation(err, "idx_shifts_user_in_progress") {
+		return nil, errors.New("user already has an active shift")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if punch.ProjectID != nil {
+		if err = startSegment(tx, shift.ID, *punch.ProjectID, userID, shift.ClockIn); err != nil {
+			return nil, err
+		}
+	}
 
+	err = events.Publish(tx, companyID, ShiftStarted{
+		ShiftID:   shift.ID,
+		UserID:    shift.UserID,
+		CompanyID: shift.CompanyID,
+		ClockIn:   shift.ClockIn,
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return shift, nil
 }
 
+// utcTime returns t in UTC, or nil. Shift times are stored in UTC, as
+// TIMESTAMP columns drop the offset of what is written to them.
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// isUniqueViolation reports whether err broke the named unique index
+func isUniqueViolation(err error, index string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == index
+}
+
 // EndShift ends an active shift
-func EndShift(db *sql.DB, userID int, notes string) (*Shift, error) {
-	clockOut := time.Now()
+func EndShift(db *sql.DB, userID int, notes string, punch Punch) (*Shift, error) {
+	clockOut := time.Now().UTC()
 
-	shift := &Shift{}
-	err := db.QueryRow(`
-		UPDATE shifts
-		SET clock_out = $1, status = 'completed', notes = $2, updated_at = CURRENT_TIMESTAMP
-		WHERE user_id = $3 AND status = 'in_progress'
-		RETURNING id, user_id, company_id, clock_in, clock_out, status, notes, created_at, updated_at
-	`, clockOut, notes, userID).Scan(
-		&shift.ID, &shift.UserID, &shift.CompanyID, &shift.ClockIn, &shift.ClockOut,
-		&shift.Status, &shift.Notes, &shift.CreatedAt, &shift.UpdatedAt,
-	)
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-	if err == sql.ErrNoRows {
-		return nil, errors.New("no active shift found")
+	shiftID, _, clockIn, err := lockActiveShift(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkPeriodOpen(tx, userID, clockIn); err != nil {
+		return nil, err
 	}
+
+	// A break still running ends with the shift
+	if err = closeBreaks(tx, shiftID, clockOut); err != nil {
+		return nil, err
+	}
+
+	args := append([]interface{}{clockOut, notes, shiftID, pq.Array(punch.Flags), punch.SourceIP, punch.KioskID},
+		punch.locationArgs()...)
+	shift := &Shift{}
+	err = scanShift(tx.QueryRow(`
+		UPDATE shifts s
+		SET clock_out = $1, status = 'completed', notes = $2,
+		    flags = ARRAY(SELECT DISTINCT f FROM unnest(s.flags || COALESCE($4, '{}'::text[])) AS f),
+		    clock_out_ip = NULLIF($5, ''), clock_out_kiosk_id = $6,
+		    clock_out_latitude = $7, clock_out_longitude = $8, clock_out_accuracy = $9, clock_out_site_id = $10,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE s.id = $3
+		RETURNING `+shiftColumns,
+		args...,
+	), shift)
+
 	if err != nil {
 		return nil, err
 	}
 
+	if err = events.Publish(tx, shift.CompanyID, shiftCompletedEvent(shift)); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return shift, nil
 }
 
 // GetUserShifts retrieves all shifts for a specific user
 func GetUserShifts(db *sql.DB, userID int, limit, offset int) ([]Shift, error) {
 	rows, err := db.Query(`
-		SELECT id, user_id, company_id, clock_in, clock_out, status, notes, created_at, updated_at
-		FROM shifts
-		WHERE user_id = $1
-		ORDER BY clock_in DESC
+		SELECT `+shiftColumns+`
+		FROM shifts s
+		WHERE s.user_id = $1
+		ORDER BY s.clock_in DESC
 		LIMIT $2 OFFSET $3
 	`, userID, limit, offset)
 
@@ -115,11 +370,7 @@ func GetUserShifts(db *sql.DB, userID int, limit, offset int) ([]Shift, error) {
 	var shifts []Shift
 	for rows.Next() {
 		var shift Shift
-		err := rows.Scan(
-			&shift.ID, &shift.UserID, &shift.CompanyID, &shift.ClockIn, &shift.ClockOut,
-			&shift.Status, &shift.Notes, &shift.CreatedAt, &shift.UpdatedAt,
-		)
-		if err != nil {
+		if err := scanShift(rows, &shift); err != nil {
 			return nil, err
 		}
 		shifts = append(shifts, shift)
@@ -131,15 +382,12 @@ func GetUserShifts(db *sql.DB, userID int, limit, offset int) ([]Shift, error) {
 // GetActiveShift retrieves the active shift for a user
 func GetActiveShift(db *sql.DB, userID int) (*Shift, error) {
 	shift := &Shift{}
-	err := db.QueryRow(`
-		SELECT id, user_id, company_id, clock_in, clock_out, status, notes, created_at, updated_at
-		FROM shifts
-		WHERE user_id = $1 AND status = 'in_progress'
+	err := scanShift(db.QueryRow(`
+		SELECT `+shiftColumns+`
+		FROM shifts s
+		WHERE s.user_id = $1 AND s.status = 'in_progress'
 		LIMIT 1
-	`, userID).Scan(
-		&shift.ID, &shift.UserID, &shift.CompanyID, &shift.ClockIn, &shift.ClockOut,
-		&shift.Status, &shift.Notes, &shift.CreatedAt, &shift.UpdatedAt,
-	)
+	`, userID), shift)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -151,17 +399,85 @@ func GetActiveShift(db *sql.DB, userID int) (*Shift, error) {
 	return shift, nil
 }
 
-// GetCompanyShifts retrieves all shifts for a company with user info
-func GetCompanyShifts(db *sql.DB, companyID int, startDate, endDate time.Time, limit, offset int) ([]ShiftWithUserInfo, error) {
-	rows, err := db.Query(`
-		SELECT s.id, s.user_id, s.company_id, s.clock_in, s.clock_out, s.status, s.notes,
-		       s.created_at, s.updated_at, u.username, u.full_name, u.role
+// ErrShiftNotFound is returned when a shift does not exist or is not visible to the caller
+var ErrShiftNotFound = errors.New("shift not found")
+
+// findShift retrieves a company shift, optionally locking it for the rest of the
+// transaction. userIDs limits whose shifts may be seen; nil means any in the company.
+func findShift(q querier, companyID, shiftID int, userIDs []int, forUpdate bool) (*Shift, error) {
+	lock := ""
+	if forUpdate {
+		lock = "FOR UPDATE"
+	}
+
+	shift := &Shift{}
+	err := scanShift(q.QueryRow(`
+		SELECT `+shiftColumns+`
+		FROM shifts s
+		WHERE s.id = $1 AND s.company_id = $2 AND ($3::int[] IS NULL OR s.user_id = ANY($3))
+		`+lock,
+		shiftID, companyID, pq.Array(userIDs),
+	), shift)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrShiftNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return shift, nil
+}
+
+// ReviewShift marks an auto-closed or flagged shift as reviewed so it counts in reports again.
+// userIDs limits which employees' shifts may be reviewed; nil means any in the company.
+// Reviewers cannot review their own shifts.
+func ReviewShift(db *sql.DB, companyID, shiftID, reviewerID int, userIDs []int) (*Shift, error) {
+	shift, err := findShift(db, companyID, shiftID, userIDs, false)
+	if err != nil {
+		return nil, err
+	}
+	if shift.UserID == reviewerID {
+		return nil, errors.New("you cannot review your own shift")
+	}
+	if !shift.AutoClosed && len(shift.Flags) == 0 {
+		return nil, errors.New("shift does not need review")
+	}
+	if shift.ReviewedAt != nil {
+		return nil, errors.New("shift has already been reviewed")
+	}
+
+	err = scanShift(db.QueryRow(`
+		UPDATE shifts s
+		SET reviewed_at = CURRENT_TIMESTAMP, reviewed_by = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE s.id = $2 AND s.reviewed_at IS NULL
+		RETURNING `+shiftColumns,
+		reviewerID, shiftID,
+	), shift)
+
+	if err == sql.ErrNoRows {
+		return nil, errors.New("shift has already been reviewed")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return shift, nil
+}
+
+// GetCompanyShifts retrieves shifts matching the filter with user info
+func GetCompanyShifts(db *sql.DB, filter ShiftFilter, limit, offset int) ([]ShiftWithUserInfo, error) {
+	where, args := filter.where()
+	args = append(args, limit, offset)
+
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT `+shiftColumns+`, u.username, u.full_name, u.role
 		FROM shifts s
 		JOIN users u ON s.user_id = u.id
-		WHERE s.company_id = $1 AND s.clock_in >= $2 AND s.clock_in <= $3
+		WHERE %s
 		ORDER BY s.clock_in DESC
-		LIMIT $4 OFFSET $5
-	`, companyID, startDate, endDate, limit, offset)
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)-1, len(args)), args...)
 
 	if err != nil {
 		return nil, err
@@ -171,12 +487,7 @@ func GetCompanyShifts(db *sql.DB, companyID int, startDate, endDate time.Time, l
 	var shifts []ShiftWithUserInfo
 	for rows.Next() {
 		var shift ShiftWithUserInfo
-		err := rows.Scan(
-			&shift.ID, &shift.UserID, &shift.CompanyID, &shift.ClockIn, &shift.ClockOut,
-			&shift.Status, &shift.Notes, &shift.CreatedAt, &shift.UpdatedAt,
-			&shift.Username, &shift.FullName, &shift.Role,
-		)
-		if err != nil {
+		if err := scanShift(rows, &shift.Shift, &shift.Username, &shift.FullName, &shift.Role); err != nil {
 			return nil, err
 		}
 		shifts = append(shifts, shift)
@@ -185,23 +496,62 @@ func GetCompanyShifts(db *sql.DB, companyID int, startDate, endDate time.Time, l
 	return shifts, nil
 }
 
+// StreamCompanyShifts calls fn with each shift matching the filter, ordered
+// by employee and clock-in, without loading them all into memory
+func StreamCompanyShifts(db *sql.DB, filter ShiftFilter, fn func(*ShiftWithUserInfo) error) error {
+	where, args := filter.where()
+
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT `+shiftColumns+`, u.username, u.full_name, u.role
+		FROM shifts s
+		JOIN users u ON s.user_id = u.id
+		WHERE %s
+		ORDER BY u.full_name, u.id, s.clock_in
+	`, where), args...)
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shift ShiftWithUserInfo
+		if err := scanShift(rows, &shift.Shift, &shift.Username, &shift.FullName, &shift.Role); err != nil {
+			return err
+		}
+		if err := fn(&shift); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 // GetShiftReport generates a report of shift statistics
-func GetShiftReport(db *sql.DB, companyID int, startDate, endDate time.Time) (*ShiftReport, error) {
+func GetShiftReport(db querier, filter ShiftFilter) (*ShiftReport, error) {
 	report := &ShiftReport{}
+	where, args := filter.where()
 
-	err := db.QueryRow(`
+	err := db.QueryRow(fmt.Sprintf(`
 		SELECT
 			COUNT(*) as total_shifts,
-			COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed_shifts,
-			COUNT(CASE WHEN status = 'in_progress' THEN 1 END) as active_shifts,
-			COALESCE(SUM(EXTRACT(EPOCH FROM (clock_out - clock_in)) / 3600), 0) as total_hours
-		FROM shifts
-		WHERE company_id = $1 AND clock_in >= $2 AND clock_in <= $3
-	`, companyID, startDate, endDate).Scan(
+			COUNT(CASE WHEN s.status = 'completed' AND NOT `+pendingReview+` THEN 1 END) as completed_shifts,
+			COUNT(CASE WHEN s.status = 'in_progress' THEN 1 END) as active_shifts,
+			COALESCE(SUM(CASE WHEN s.status = 'completed' AND NOT `+pendingReview+`
+				THEN `+workedSeconds+` / 3600 END), 0) as total_hours,
+			COALESCE(SUM(CASE WHEN s.status = 'completed' AND NOT `+pendingReview+`
+				THEN `+unpaidBreakSeconds+` / 3600 END), 0) as unpaid_break_hours,
+			COUNT(CASE WHEN `+pendingReview+` THEN 1 END) as pending_review
+		FROM shifts s
+		JOIN users u ON s.user_id = u.id
+		WHERE %s
+	`, where), args...).Scan(
 		&report.TotalShifts,
 		&report.CompletedShifts,
 		&report.ActiveShifts,
 		&report.TotalHours,
+		&report.UnpaidBreakHours,
+		&report.PendingReview,
 	)
 
 	if err != nil {
@@ -214,3 +564,119 @@ func GetShiftReport(db *sql.DB, companyID int, startDate, endDate time.Time) (*S
 
 	return report, nil
 }
+
+// Groupings of a report breakdown
+const (
+	GroupByEmployee   = "employee"
+	GroupByDay        = "day"
+	GroupByWeek       = "week"
+	GroupByMonth      = "month"
+	GroupByDepartment = "department"
+)
+
+// ShiftReportGroup holds the shift statistics of one group of a report breakdown
+type ShiftReportGroup struct {
+	// Key is the user or department ID, or the day, week (its Monday) or month
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	ShiftReport
+}
+
+// reportGroupSorts maps the sort options of a report breakdown to columns
+var reportGroupSorts = map[string]string{
+	"key":              "key",
+	"label":            "label",
+	"total_shifts":     "total_shifts",
+	"completed_shifts": "completed_shifts",
+	"active_shifts":    "active_shifts",
+	"total_hours":      "total_hours",
+	"average_hours":    "average_hours",
+}
+
+// GetShiftReportGroups breaks the report statistics down by employee,
+// department, or by day, week or month of clock-in in the time zone tz.
+// Groups are ordered by sortBy, by default the label for employees and
+// departments and the period otherwise.
+func GetShiftReportGroups(db *sql.DB, filter ShiftFilter, groupBy, sortBy string, desc bool, tz string) ([]ShiftReportGroup, error) {
+	where, args := filter.where()
+	args = append(args, tz)
+	local := fmt.Sprintf("((s.clock_in AT TIME ZONE 'UTC') AT TIME ZONE $%d)", len(args))
+
+	var key, label string
+	switch groupBy {
+	case GroupByEmployee:
+		key, label = "u.id::text", "u.full_name"
+	case GroupByDepartment:
+		key, label = "COALESCE(u.department_id::text, '')", "COALESCE(d.name, 'No department')"
+	case GroupByDay:
+		key = "to_char(" + local + ", 'YYYY-MM-DD')"
+		label = key
+	case GroupByWeek:
+		key = "to_char(date_trunc('week', " + local + "), 'YYYY-MM-DD')"
+		label = "to_char(date_trunc('week', " + local + "), 'IYYY-\"W\"IW')"
+	case GroupByMonth:
+		key = "to_char(" + local + ", 'YYYY-MM')"
+		label = "to_char(" + local + ", 'FMMonth YYYY')"
+	default:
+		return nil, errors.New("group_by must be employee, department, day, week or month")
+	}
+
+	if sortBy == "" {
+		sortBy = "key"
+		if groupBy == GroupByEmployee || groupBy == GroupByDepartment {
+			sortBy = "label"
+		}
+	}
+	order, ok := reportGroupSorts[sortBy]
+	if !ok {
+		return nil, errors.New("sort must be key, label, total_shifts, completed_shifts, active_shifts, total_hours or average_hours")
+	}
+	if desc {
+		order += " DESC"
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT key, label, total_shifts, completed_shifts, active_shifts, total_hours,
+		       COALESCE(total_hours / NULLIF(completed_shifts, 0), 0) AS average_hours,
+		       unpaid_break_hours, pending_review
+		FROM (
+			SELECT
+				%s AS key,
+				%s AS label,
+				COUNT(*) AS total_shifts,
+				COUNT(CASE WHEN s.status = 'completed' AND NOT `+pendingReview+` THEN 1 END) AS completed_shifts,
+				COUNT(CASE WHEN s.status = 'in_progress' THEN 1 END) AS active_shifts,
+				COALESCE(SUM(CASE WHEN s.status = 'completed' AND NOT `+pendingReview+`
+					THEN `+workedSeconds+` / 3600 END), 0) AS total_hours,
+				COALESCE(SUM(CASE WHEN s.status = 'completed' AND NOT `+pendingReview+`
+					THEN `+unpaidBreakSeconds+` / 3600 END), 0) AS unpaid_break_hours,
+				COUNT(CASE WHEN `+pendingReview+` THEN 1 END) AS pending_review
+			FROM shifts s
+			JOIN users u ON s.user_id = u.id
+			LEFT JOIN departments d ON u.department_id = d.id
+			WHERE %s
+			GROUP BY 1, 2
+		) g
+		ORDER BY %s, key
+	`, key, label, where, order), args...)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []ShiftReportGroup{}
+	for rows.Next() {
+		var g ShiftReportGroup
+		err := rows.Scan(
+			&g.Key, &g.Label, &g.TotalShifts, &g.CompletedShifts, &g.ActiveShifts, &g.TotalHours,
+			&g.AverageHours, &g.UnpaidBreakHours, &g.PendingReview,
+		)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+
+	return groups, rows.Err()
+}
@@ -0,0 +1,32 @@
+package attendance
+
+import (
+	"errors"
+	"net"
+
+	"modular-erp/pkg/utils"
+)
+
+// FlagUntrustedNetwork is recorded when a punch comes from outside the allowed networks
+const FlagUntrustedNetwork = "untrusted_network"
+
+// CheckNetwork applies the policy's network mode to the client address of a
+// punch, returning the flags to record or an error if the punch must be rejected
+func CheckNetwork(policy *Policy, sourceIP string) ([]string, error) {
+	if policy.NetworkMode == EnforceOff {
+		return nil, nil
+	}
+
+	networks, err := utils.ParseNetworks(policy.AllowedNetworks)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(sourceIP); ip != nil && utils.NetworksContain(networks, ip) {
+		return nil, nil
+	}
+
+	if policy.NetworkMode == EnforceBlock {
+		return nil, errors.New("punching is only allowed from company networks")
+	}
+	return []string{FlagUntrustedNetwork}, nil
+}
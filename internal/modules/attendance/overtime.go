@@ -0,0 +1,423 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OvertimeRules is a company's rule set for classifying worked time into
+// pay buckets. A threshold of 0 disables it.
+type OvertimeRules struct {
+	CompanyID int `json:"company_id"`
+	// Hours worked in a day after which time is overtime, then double time
+	DailyOvertimeHours   float64 `json:"daily_overtime_hours"`
+	DailyDoubleTimeHours float64 `json:"daily_double_time_hours"`
+	// Regular hours in a week after which time is overtime
+	WeeklyOvertimeHours float64 `json:"weekly_overtime_hours"`
+	WeekStartDay        int     `json:"week_start_day"` // 1 = Monday to 7 = Sunday
+	// SplitAtMidnight counts the time of a shift crossing midnight towards
+	// each calendar day; otherwise the whole shift counts towards the day it started
+	SplitAtMidnight bool `json:"split_at_midnight"`
+	// Pay multipliers, used for the paid hours of the report
+	OvertimeMultiplier   float64   `json:"overtime_multiplier"`
+	DoubleTimeMultiplier float64   `json:"double_time_multiplier"`
+	HolidayMultiplier    float64   `json:"holiday_multiplier"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
+// defaultOvertimeRules is used for companies that never saved overtime rules
+func defaultOvertimeRules(companyID int) *OvertimeRules {
+	return &OvertimeRules{
+		CompanyID:            companyID,
+		DailyOvertimeHours:   8,
+		DailyDoubleTimeHours: 12,
+		WeeklyOvertimeHours:  40,
+		WeekStartDay:         1,
+		SplitAtMidnight:      true,
+		OvertimeMultiplier:   1.5,
+		DoubleTimeMultiplier: 2,
+		HolidayMultiplier:    2,
+	}
+}
+
+// WorkedShift is a completed shift to classify, with its unpaid breaks
+type WorkedShift struct {
+	ShiftID      int
+	UserID       int
+	ClockIn      time.Time
+	ClockOut     time.Time
+	UnpaidBreaks []Interval
+}
+
+// Interval is a period of time
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// OvertimeBreakdown is the worked time of a shift on one workday, by pay bucket
+type OvertimeBreakdown struct {
+	ShiftID         int     `json:"shift_id"`
+	UserID          int     `json:"user_id"`
+	Date            string  `json:"date"` // workday, YYYY-MM-DD in the company time zone
+	RegularHours    float64 `json:"regular_hours"`
+	OvertimeHours   float64 `json:"overtime_hours"`
+	DoubleTimeHours float64 `json:"double_time_hours"`
+	HolidayHours    float64 `json:"holiday_hours"`
+}
+
+// OvertimeSummary totals an employee's pay buckets over a period
+type OvertimeSummary struct {
+	UserID          int                 `json:"user_id"`
+	FullName        string              `json:"full_name"`
+	RegularHours    float64             `json:"regular_hours"`
+	OvertimeHours   float64             `json:"overtime_hours"`
+	DoubleTimeHours float64             `json:"double_time_hours"`
+	HolidayHours    float64             `json:"holiday_hours"`
+	TotalHours      float64             `json:"total_hours"`
+	PaidHours       float64             `json:"paid_hours"` // weighted by the multipliers
+	Shifts          []OvertimeBreakdown `json:"shifts"`
+}
+
+const overtimeRulesColumns = `company_id, daily_overtime_hours, daily_double_time_hours, weekly_overtime_hours,
+	week_start_day, split_at_midnight, overtime_multiplier, double_time_multiplier, holiday_multiplier, updated_at`
+
+func scanOvertimeRules(row scanner) (*OvertimeRules, error) {
+	r := &OvertimeRules{}
+	err := row.Scan(
+		&r.CompanyID, &r.DailyOvertimeHours, &r.DailyDoubleTimeHours, &r.WeeklyOvertimeHours,
+		&r.WeekStartDay, &r.SplitAtMidnight, &r.OvertimeMultiplier, &r.DoubleTimeMultiplier,
+		&r.HolidayMultiplier, &r.UpdatedAt,
+	)
+	return r, err
+}
+
+// GetOvertimeRules retrieves a company's overtime rules, or the defaults if none are saved
+func GetOvertimeRules(db *sql.DB, companyID int) (*OvertimeRules, error) {
+	r, err := scanOvertimeRules(db.QueryRow(`
+		SELECT `+overtimeRulesColumns+`
+		FROM overtime_rules
+		WHERE company_id = $1
+	`, companyID))
+
+	if err == sql.ErrNoRows {
+		return defaultOvertimeRules(companyID), nil
+	}
+	return r, err
+}
+
+// SaveOvertimeRules validates and stores a company's overtime rules
+func SaveOvertimeRules(db *sql.DB, r *OvertimeRules) (*OvertimeRules, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+
+	return scanOvertimeRules(db.QueryRow(`
+		INSERT INTO overtime_rules (company_id, daily_overtime_hours, daily_double_time_hours, weekly_overtime_hours,
+		                            week_start_day, split_at_midnight, overtime_multiplier, double_time_multiplier,
+		                            holiday_multiplier, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP)
+		ON CONFLICT (company_id) DO UPDATE
+		SET daily_overtime_hours = EXCLUDED.daily_overtime_hours,
+		    daily_double_time_hours = EXCLUDED.daily_double_time_hours,
+		    weekly_overtime_hours = EXCLUDED.weekly_overtime_hours,
+		    week_start_day = EXCLUDED.week_start_day,
+		    split_at_midnight = EXCLUDED.split_at_midnight,
+		    overtime_multiplier = EXCLUDED.overtime_multiplier,
+		    double_time_multiplier = EXCLUDED.double_time_multiplier,
+		    holiday_multiplier = EXCLUDED.holiday_multiplier,
+		    updated_at = CURRENT_TIMESTAMP
+		RETURNING `+overtimeRulesColumns,
+		r.CompanyID, r.DailyOvertimeHours, r.DailyDoubleTimeHours, r.WeeklyOvertimeHours,
+		r.WeekStartDay, r.SplitAtMidnight, r.OvertimeMultiplier, r.DoubleTimeMultiplier, r.HolidayMultiplier,
+	))
+}
+
+// validate checks the rule thresholds and multipliers
+func (r *OvertimeRules) validate() error {
+	if r.DailyOvertimeHours < 0 || r.DailyOvertimeHours > 24 {
+		return errors.New("daily_overtime_hours must be between 0 and 24")
+	}
+	if r.DailyDoubleTimeHours < 0 || r.DailyDoubleTimeHours > 24 {
+		return errors.New("daily_double_time_hours must be between 0 and 24")
+	}
+	if r.DailyOvertimeHours > 0 && r.DailyDoubleTimeHours > 0 && r.DailyDoubleTimeHours <= r.DailyOvertimeHours {
+		return errors.New("daily_double_time_hours must be greater than daily_overtime_hours")
+	}
+	if r.WeeklyOvertimeHours < 0 || r.WeeklyOvertimeHours > 168 {
+		return errors.New("weekly_overtime_hours must be between 0 and 168")
+	}
+	if r.WeekStartDay < 1 || r.WeekStartDay > 7 {
+		return errors.New("week_start_day must be between 1 (Monday) and 7 (Sunday)")
+	}
+	for _, m := range []float64{r.OvertimeMultiplier, r.DoubleTimeMultiplier, r.HolidayMultiplier} {
+		if m < 1 || m > 5 {
+			return errors.New("multipliers must be between 1 and 5")
+		}
+	}
+	return nil
+}
+
+// ClassifyHours splits the worked time of shifts into regular, overtime,
+// double-time and holiday hours, one breakdown per shift and workday.
+//
+// Each employee's shifts are walked in order. Time on a holiday is holiday
+// time and does not count towards the thresholds. Other time is double
+// time past the daily double-time threshold, overtime past the daily
+// overtime threshold or once the week's regular hours reach the weekly
+// threshold, and regular otherwise. Only regular hours count towards the
+// weekly threshold, so no time is paid as overtime twice.
+func ClassifyHours(rules *OvertimeRules, shifts []WorkedShift, holidays map[string]string, loc *time.Location) []OvertimeBreakdown {
+	sorted := make([]WorkedShift, len(shifts))
+	copy(sorted, shifts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].UserID != sorted[j].UserID {
+			return sorted[i].UserID < sorted[j].UserID
+		}
+		return sorted[i].ClockIn.Before(sorted[j].ClockIn)
+	})
+
+	type userDay struct {
+		userID int
+		day    string
+	}
+	daily := map[userDay]time.Duration{}
+	weekly := map[userDay]time.Duration{} // keyed by the week's first day
+
+	breakdowns := []OvertimeBreakdown{}
+	for _, shift := range sorted {
+		for _, piece := range rules.workdayPieces(shift, loc) {
+			b := OvertimeBreakdown{ShiftID: shift.ShiftID, UserID: shift.UserID, Date: piece.day}
+			if _, ok := holidays[piece.day]; ok {
+				b.HolidayHours = piece.worked.Hours()
+				breakdowns = append(breakdowns, b)
+				continue
+			}
+
+			dayKey := userDay{shift.UserID, piece.day}
+			weekKey := userDay{shift.UserID, piece.week}
+			for remaining := piece.worked; remaining > 0; {
+				bucket, limit := rules.bucket(daily[dayKey], weekly[weekKey])
+				chunk := remaining
+				if limit > 0 && limit < chunk {
+					chunk = limit
+				}
+
+				switch bucket {
+				case "double_time":
+					b.DoubleTimeHours += chunk.Hours()
+				case "overtime":
+					b.OvertimeHours += chunk.Hours()
+				default:
+					b.RegularHours += chunk.Hours()
+					weekly[weekKey] += chunk
+				}
+				daily[dayKey] += chunk
+				remaining -= chunk
+			}
+			breakdowns = append(breakdowns, b)
+		}
+	}
+
+	return breakdowns
+}
+
+// bucket returns the pay bucket of the next worked minute given the time
+// already worked that day and the regular time worked that week, and how
+// long until a threshold changes the bucket (0 when none will)
+func (r *OvertimeRules) bucket(day, week time.Duration) (string, time.Duration) {
+	overtime := hoursDuration(r.DailyOvertimeHours)
+	double := hoursDuration(r.DailyDoubleTimeHours)
+	weekly := hoursDuration(r.WeeklyOvertimeHours)
+
+	if double > 0 && day >= double {
+		return "double_time", 0
+	}
+	untilDouble := time.Duration(0)
+	if double > 0 {
+		untilDouble = double - day
+	}
+	if (overtime > 0 && day >= overtime) || (weekly > 0 && week >= weekly) {
+		return "overtime", untilDouble
+	}
+
+	// Below every enabled threshold: regular until the nearest one
+	limit := untilDouble
+	if overtime > 0 && (limit == 0 || overtime-day < limit) {
+		limit = overtime - day
+	}
+	if weekly > 0 && (limit == 0 || weekly-week < limit) {
+		limit = weekly - week
+	}
+	return "regular", limit
+}
+
+// workdayPiece is the worked time of a shift attributed to one workday
+type workdayPiece struct {
+	day    string // YYYY-MM-DD
+	week   string // first day of the week, YYYY-MM-DD
+	worked time.Duration
+}
+
+// workdayPieces splits a shift into its workdays, cutting at each local
+// midnight when the rules split shifts, and subtracts the unpaid breaks
+func (r *OvertimeRules) workdayPieces(shift WorkedShift, loc *time.Location) []workdayPiece {
+	var pieces []workdayPiece
+	start := shift.ClockIn.In(loc)
+	end := shift.ClockOut.In(loc)
+
+	for start.Before(end) {
+		day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+		pieceEnd := end
+		if r.SplitAtMidnight {
+			if midnight := day.AddDate(0, 0, 1); midnight.Before(end) {
+				pieceEnd = midnight
+			}
+		}
+
+		worked := pieceEnd.Sub(start)
+		for _, br := range shift.UnpaidBreaks {
+			worked -= overlap(start, pieceEnd, br.Start, br.End)
+		}
+		if worked > 0 {
+			pieces = append(pieces, workdayPiece{
+				day:    day.Format("2006-01-02"),
+				week:   r.weekStart(day).Format("2006-01-02"),
+				worked: worked,
+			})
+		}
+		start = pieceEnd
+	}
+
+	return pieces
+}
+
+// weekStart returns the first day of the rules' week containing day
+func (r *OvertimeRules) weekStart(day time.Time) time.Time {
+	offset := (int(day.Weekday()) - r.WeekStartDay%7 + 7) % 7 // days since the week started
+	return time.Date(day.Year(), day.Month(), day.Day()-offset, 0, 0, 0, 0, day.Location())
+}
+
+// overlap returns the length of the overlap between [aStart, aEnd) and [bStart, bEnd)
+func overlap(aStart, aEnd, bStart, bEnd time.Time) time.Duration {
+	if bStart.After(aStart) {
+		aStart = bStart
+	}
+	if bEnd.Before(aEnd) {
+		aEnd = bEnd
+	}
+	if !aEnd.After(aStart) {
+		return 0
+	}
+	return aEnd.Sub(aStart)
+}
+
+// GetOvertimeReport classifies the hours of the completed, reviewed shifts
+// worked on days in [from, to) and totals them per employee. Shifts from
+// the start of the first week are included so the weekly threshold is
+// right; only days inside the period are reported. userIDs limits whose
+// shifts are included; nil means every user in the company.
+func GetOvertimeReport(db *sql.DB, companyID int, userIDs []int, from, to time.Time) ([]OvertimeSummary, error) {
+	rules, err := GetOvertimeRules(db, companyID)
+	if err != nil {
+		return nil, err
+	}
+	loc := from.Location()
+	windowStart := rules.weekStart(from)
+
+	rows, err := db.Query(`
+		SELECT s.id, s.user_id, u.full_name, s.clock_in, s.clock_out
+		FROM shifts s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.company_id = $1 AND ($2::int[] IS NULL OR s.user_id = ANY($2))
+		  AND s.status = 'completed' AND NOT `+pendingReview+`
+		  AND s.clock_out > $3 AND s.clock_in < $4
+	`, companyID, pq.Array(userIDs), windowStart.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	var shifts []WorkedShift
+	var shiftIDs []int
+	names := map[int]string{}
+	index := map[int]int{}
+	for rows.Next() {
+		var s WorkedShift
+		var name string
+		if err := rows.Scan(&s.ShiftID, &s.UserID, &name, &s.ClockIn, &s.ClockOut); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names[s.UserID] = name
+		index[s.ShiftID] = len(shifts)
+		shifts = append(shifts, s)
+		shiftIDs = append(shiftIDs, s.ShiftID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`
+		SELECT shift_id, start_time, end_time
+		FROM shift_breaks
+		WHERE shift_id = ANY($1) AND break_type = 'unpaid' AND end_time IS NOT NULL
+	`, pq.Array(shiftIDs))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var shiftID int
+		var br Interval
+		if err := rows.Scan(&shiftID, &br.Start, &br.End); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		s := &shifts[index[shiftID]]
+		s.UnpaidBreaks = append(s.UnpaidBreaks, br)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	holidays, err := holidayDates(db, companyID, windowStart.AddDate(0, 0, -1).Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	firstDay, lastDay := from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02")
+	summaries := []OvertimeSummary{}
+	byUser := map[int]int{}
+	for _, b := range ClassifyHours(rules, shifts, holidays, loc) {
+		if b.Date < firstDay || b.Date > lastDay {
+			continue
+		}
+		i, ok := byUser[b.UserID]
+		if !ok {
+			i = len(summaries)
+			byUser[b.UserID] = i
+			summaries = append(summaries, OvertimeSummary{UserID: b.UserID, FullName: names[b.UserID], Shifts: []OvertimeBreakdown{}})
+		}
+		sum := &summaries[i]
+		sum.RegularHours += b.RegularHours
+		sum.OvertimeHours += b.OvertimeHours
+		sum.DoubleTimeHours += b.DoubleTimeHours
+		sum.HolidayHours += b.HolidayHours
+		sum.Shifts = append(sum.Shifts, b)
+	}
+
+	for i := range summaries {
+		sum := &summaries[i]
+		sum.TotalHours = sum.RegularHours + sum.OvertimeHours + sum.DoubleTimeHours + sum.HolidayHours
+		sum.PaidHours = sum.RegularHours + sum.OvertimeHours*rules.OvertimeMultiplier +
+			sum.DoubleTimeHours*rules.DoubleTimeMultiplier + sum.HolidayHours*rules.HolidayMultiplier
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].FullName < summaries[j].FullName })
+
+	return summaries, nil
+}
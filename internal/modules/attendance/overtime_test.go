@@ -0,0 +1,319 @@
+package attendance
+
+import (
+	"math"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestClassifyHours(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := func(change func(r *OvertimeRules)) *OvertimeRules {
+		r := defaultOvertimeRules(1)
+		if change != nil {
+			change(r)
+		}
+		return r
+	}
+	at := func(loc *time.Location, value string) time.Time {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	utc := func(value string) time.Time { return at(time.UTC, value) }
+	shift := func(id int, from, to string) WorkedShift {
+		return WorkedShift{ShiftID: id, UserID: 7, ClockIn: utc(from), ClockOut: utc(to)}
+	}
+	// fullWeek is eight regular hours on each weekday from Monday, January 8th 2024
+	fullWeek := func() []WorkedShift {
+		var shifts []WorkedShift
+		for day := 8; day <= 12; day++ {
+			date := time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+			shifts = append(shifts, shift(day, date+" 09:00", date+" 17:00"))
+		}
+		return shifts
+	}
+	day := func(shiftID int, date string, regular, overtime, double, holiday float64) OvertimeBreakdown {
+		return OvertimeBreakdown{
+			ShiftID: shiftID, UserID: 7, Date: date,
+			RegularHours: regular, OvertimeHours: overtime, DoubleTimeHours: double, HolidayHours: holiday,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		rules    *OvertimeRules
+		shifts   []WorkedShift
+		holidays map[string]string
+		loc      *time.Location
+		shiftID  int // only compare this shift's breakdowns; 0 compares all
+		want     []OvertimeBreakdown
+	}{
+		{
+			name:   "crossing midnight split at midnight",
+			rules:  rules(nil),
+			shifts: []WorkedShift{shift(1, "2024-01-15 20:00", "2024-01-16 06:00")},
+			want: []OvertimeBreakdown{
+				day(1, "2024-01-15", 4, 0, 0, 0),
+				day(1, "2024-01-16", 6, 0, 0, 0),
+			},
+		},
+		{
+			name:   "crossing midnight counted on the start day",
+			rules:  rules(func(r *OvertimeRules) { r.SplitAtMidnight = false }),
+			shifts: []WorkedShift{shift(1, "2024-01-15 20:00", "2024-01-16 06:00")},
+			want:   []OvertimeBreakdown{day(1, "2024-01-15", 8, 2, 0, 0)},
+		},
+		{
+			name:  "split shift's days share no daily threshold",
+			rules: rules(nil),
+			shifts: []WorkedShift{
+				shift(1, "2024-01-15 12:00", "2024-01-15 18:00"),
+				shift(2, "2024-01-15 20:00", "2024-01-16 06:00"),
+			},
+			want: []OvertimeBreakdown{
+				day(1, "2024-01-15", 6, 0, 0, 0),
+				day(2, "2024-01-15", 2, 2, 0, 0),
+				day(2, "2024-01-16", 6, 0, 0, 0),
+			},
+		},
+		{
+			name:    "Sunday into Monday with weeks starting Monday",
+			rules:   rules(func(r *OvertimeRules) { r.WeekStartDay = 1 }),
+			shifts:  append(fullWeek(), shift(99, "2024-01-14 20:00", "2024-01-15 04:00")),
+			shiftID: 99,
+			want: []OvertimeBreakdown{
+				day(99, "2024-01-14", 0, 4, 0, 0),
+				day(99, "2024-01-15", 4, 0, 0, 0),
+			},
+		},
+		{
+			name:    "Sunday into Monday with weeks starting Sunday",
+			rules:   rules(func(r *OvertimeRules) { r.WeekStartDay = 7 }),
+			shifts:  append(fullWeek(), shift(99, "2024-01-14 20:00", "2024-01-15 04:00")),
+			shiftID: 99,
+			want: []OvertimeBreakdown{
+				day(99, "2024-01-14", 4, 0, 0, 0),
+				day(99, "2024-01-15", 4, 0, 0, 0),
+			},
+		},
+		{
+			name:    "Saturday into Sunday with weeks starting Monday",
+			rules:   rules(func(r *OvertimeRules) { r.WeekStartDay = 1 }),
+			shifts:  append(fullWeek(), shift(99, "2024-01-13 20:00", "2024-01-14 04:00")),
+			shiftID: 99,
+			want: []OvertimeBreakdown{
+				day(99, "2024-01-13", 0, 4, 0, 0),
+				day(99, "2024-01-14", 0, 4, 0, 0),
+			},
+		},
+		{
+			name:    "Saturday into Sunday with weeks starting Sunday",
+			rules:   rules(func(r *OvertimeRules) { r.WeekStartDay = 7 }),
+			shifts:  append(fullWeek(), shift(99, "2024-01-13 20:00", "2024-01-14 04:00")),
+			shiftID: 99,
+			want: []OvertimeBreakdown{
+				day(99, "2024-01-13", 0, 4, 0, 0),
+				day(99, "2024-01-14", 4, 0, 0, 0),
+			},
+		},
+		{
+			name:   "daily thresholds crossed mid-shift",
+			rules:  rules(nil),
+			shifts: []WorkedShift{shift(1, "2024-01-15 07:00", "2024-01-15 21:00")},
+			want:   []OvertimeBreakdown{day(1, "2024-01-15", 8, 4, 2, 0)},
+		},
+		{
+			name:   "daily double time disabled",
+			rules:  rules(func(r *OvertimeRules) { r.DailyDoubleTimeHours = 0 }),
+			shifts: []WorkedShift{shift(1, "2024-01-15 07:00", "2024-01-15 21:00")},
+			want:   []OvertimeBreakdown{day(1, "2024-01-15", 8, 6, 0, 0)},
+		},
+		{
+			name:  "weekly threshold reached while in daily overtime",
+			rules: rules(nil),
+			shifts: []WorkedShift{
+				shift(1, "2024-01-15 08:00", "2024-01-15 18:00"),
+				shift(2, "2024-01-16 08:00", "2024-01-16 18:00"),
+				shift(3, "2024-01-17 08:00", "2024-01-17 18:00"),
+				shift(4, "2024-01-18 08:00", "2024-01-18 18:00"),
+				shift(5, "2024-01-19 06:00", "2024-01-19 20:00"),
+				shift(6, "2024-01-20 08:00", "2024-01-20 14:00"),
+			},
+			want: []OvertimeBreakdown{
+				day(1, "2024-01-15", 8, 2, 0, 0),
+				day(2, "2024-01-16", 8, 2, 0, 0),
+				day(3, "2024-01-17", 8, 2, 0, 0),
+				day(4, "2024-01-18", 8, 2, 0, 0),
+				// The week's 40 regular hours and the day's 8 are reached
+				// together; overtime is not counted twice
+				day(5, "2024-01-19", 8, 4, 2, 0),
+				day(6, "2024-01-20", 0, 6, 0, 0),
+			},
+		},
+		{
+			name:  "weekly threshold reached mid-shift",
+			rules: rules(func(r *OvertimeRules) { r.DailyOvertimeHours, r.DailyDoubleTimeHours = 0, 0 }),
+			shifts: []WorkedShift{
+				shift(1, "2024-01-15 08:00", "2024-01-15 20:00"),
+				shift(2, "2024-01-16 08:00", "2024-01-16 20:00"),
+				shift(3, "2024-01-17 08:00", "2024-01-17 20:00"),
+				shift(4, "2024-01-18 08:00", "2024-01-18 20:00"),
+			},
+			want: []OvertimeBreakdown{
+				day(1, "2024-01-15", 12, 0, 0, 0),
+				day(2, "2024-01-16", 12, 0, 0, 0),
+				day(3, "2024-01-17", 12, 0, 0, 0),
+				day(4, "2024-01-18", 4, 8, 0, 0),
+			},
+		},
+		{
+			name:  "unpaid break straddling midnight split at midnight",
+			rules: rules(nil),
+			shifts: []WorkedShift{{
+				ShiftID: 1, UserID: 7, ClockIn: utc("2024-01-15 20:00"), ClockOut: utc("2024-01-16 06:00"),
+				UnpaidBreaks: []Interval{{Start: utc("2024-01-15 23:30"), End: utc("2024-01-16 00:30")}},
+			}},
+			want: []OvertimeBreakdown{
+				day(1, "2024-01-15", 3.5, 0, 0, 0),
+				day(1, "2024-01-16", 5.5, 0, 0, 0),
+			},
+		},
+		{
+			name:  "unpaid break straddling midnight counted on the start day",
+			rules: rules(func(r *OvertimeRules) { r.SplitAtMidnight = false }),
+			shifts: []WorkedShift{{
+				ShiftID: 1, UserID: 7, ClockIn: utc("2024-01-15 19:00"), ClockOut: utc("2024-01-16 06:00"),
+				UnpaidBreaks: []Interval{{Start: utc("2024-01-15 23:30"), End: utc("2024-01-16 00:30")}},
+			}},
+			want: []OvertimeBreakdown{day(1, "2024-01-15", 8, 2, 0, 0)},
+		},
+		{
+			name:     "holiday time is not overtime",
+			rules:    rules(nil),
+			shifts:   []WorkedShift{shift(1, "2024-01-15 07:00", "2024-01-15 21:00")},
+			holidays: map[string]string{"2024-01-15": "Martin Luther King Jr. Day"},
+			want:     []OvertimeBreakdown{day(1, "2024-01-15", 0, 0, 0, 14)},
+		},
+		{
+			name:     "shift crossing into a holiday",
+			rules:    rules(nil),
+			shifts:   []WorkedShift{shift(1, "2024-01-14 20:00", "2024-01-15 04:00")},
+			holidays: map[string]string{"2024-01-15": "Martin Luther King Jr. Day"},
+			want: []OvertimeBreakdown{
+				day(1, "2024-01-14", 4, 0, 0, 0),
+				day(1, "2024-01-15", 0, 0, 0, 4),
+			},
+		},
+		{
+			name:  "holiday time does not count towards the week",
+			rules: rules(nil),
+			shifts: []WorkedShift{
+				shift(1, "2024-01-15 08:00", "2024-01-15 18:00"),
+				shift(2, "2024-01-16 09:00", "2024-01-16 17:00"),
+				shift(3, "2024-01-17 09:00", "2024-01-17 17:00"),
+				shift(4, "2024-01-18 09:00", "2024-01-18 17:00"),
+				shift(5, "2024-01-19 09:00", "2024-01-19 17:00"),
+				shift(6, "2024-01-20 09:00", "2024-01-20 17:00"),
+			},
+			holidays: map[string]string{"2024-01-15": "Martin Luther King Jr. Day"},
+			shiftID:  6,
+			want:     []OvertimeBreakdown{day(6, "2024-01-20", 8, 0, 0, 0)},
+		},
+		{
+			name:  "spring forward night is an hour shorter",
+			rules: rules(nil),
+			loc:   newYork,
+			shifts: []WorkedShift{{
+				ShiftID: 1, UserID: 7,
+				ClockIn:  at(newYork, "2024-03-09 22:00"),
+				ClockOut: at(newYork, "2024-03-10 10:00"),
+			}},
+			// 22:00 to midnight is 2 hours; midnight to 10:00 is 9, not 10
+			want: []OvertimeBreakdown{
+				day(1, "2024-03-09", 2, 0, 0, 0),
+				day(1, "2024-03-10", 8, 1, 0, 0),
+			},
+		},
+		{
+			name:  "fall back night is an hour longer",
+			rules: rules(nil),
+			loc:   newYork,
+			shifts: []WorkedShift{{
+				ShiftID: 1, UserID: 7,
+				ClockIn:  at(newYork, "2024-11-02 20:00"),
+				ClockOut: at(newYork, "2024-11-03 06:00"),
+			}},
+			// 20:00 to midnight is 4 hours; midnight to 06:00 is 7, not 6
+			want: []OvertimeBreakdown{
+				day(1, "2024-11-02", 4, 0, 0, 0),
+				day(1, "2024-11-03", 7, 0, 0, 0),
+			},
+		},
+		{
+			name:  "days follow the company time zone",
+			rules: rules(nil),
+			loc:   newYork,
+			// 22:00 to 04:00 UTC is 17:00 to 23:00 in New York, a single day
+			shifts: []WorkedShift{shift(1, "2024-01-15 22:00", "2024-01-16 04:00")},
+			want:   []OvertimeBreakdown{day(1, "2024-01-15", 6, 0, 0, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := tt.loc
+			if loc == nil {
+				loc = time.UTC
+			}
+
+			got := ClassifyHours(tt.rules, tt.shifts, tt.holidays, loc)
+			if tt.shiftID != 0 {
+				var filtered []OvertimeBreakdown
+				for _, b := range got {
+					if b.ShiftID == tt.shiftID {
+						filtered = append(filtered, b)
+					}
+				}
+				got = filtered
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d breakdowns, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range tt.want {
+				if !sameBreakdown(got[i], tt.want[i]) {
+					t.Errorf("breakdown %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestClassifyHoursKeepsEmployeesApart(t *testing.T) {
+	shifts := []WorkedShift{
+		{ShiftID: 1, UserID: 1, ClockIn: time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC), ClockOut: time.Date(2024, 1, 15, 14, 0, 0, 0, time.UTC)},
+		{ShiftID: 2, UserID: 2, ClockIn: time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC), ClockOut: time.Date(2024, 1, 15, 15, 0, 0, 0, time.UTC)},
+	}
+
+	for _, b := range ClassifyHours(defaultOvertimeRules(1), shifts, nil, time.UTC) {
+		if b.RegularHours != 6 || b.OvertimeHours != 0 {
+			t.Errorf("shift %d = %+v, want 6 regular hours", b.ShiftID, b)
+		}
+	}
+}
+
+// sameBreakdown compares breakdowns, allowing for floating point rounding
+func sameBreakdown(a, b OvertimeBreakdown) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 1e-9 }
+	return a.ShiftID == b.ShiftID && a.UserID == b.UserID && a.Date == b.Date &&
+		near(a.RegularHours, b.RegularHours) && near(a.OvertimeHours, b.OvertimeHours) &&
+		near(a.DoubleTimeHours, b.DoubleTimeHours) && near(a.HolidayHours, b.HolidayHours)
+}
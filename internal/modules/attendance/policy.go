@@ -0,0 +1,297 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"modular-erp/pkg/utils"
+)
+
+// Auto-close modes
+const (
+	AutoCloseAfterHours = "after_hours" // close N hours after clock-in
+	AutoCloseFixedTime  = "fixed_time"  // close at a fixed local time of day
+)
+
+// Pay period lengths used for timesheets
+const (
+	PayPeriodWeekly      = "weekly"
+	PayPeriodBiweekly    = "biweekly"
+	PayPeriodSemimonthly = "semimonthly" // the 1st to the 15th and the 16th to the end of the month
+	PayPeriodMonthly     = "monthly"
+)
+
+// Enforcement modes for punch restrictions such as the geofence
+const (
+	EnforceOff   = "off"   // not checked
+	EnforceFlag  = "flag"  // punch allowed, shift flagged for review
+	EnforceBlock = "block" // punch rejected
+)
+
+// Policy holds a company's attendance settings
+type Policy struct {
+	CompanyID           int     `json:"company_id"`
+	AutoCloseEnabled    bool    `json:"auto_close_enabled"`
+	AutoCloseMode       string  `json:"auto_close_mode"`
+	AutoCloseAfterHours float64 `json:"auto_close_after_hours"`
+	AutoCloseTime       string  `json:"auto_close_time"` // HH:MM in the company time zone
+	MaxShiftHours       float64 `json:"max_shift_hours"`
+	// GeofenceMode decides what happens to punches outside every work site
+	GeofenceMode string `json:"geofence_mode"`
+	// GeofenceMaxAccuracy is the worst GPS accuracy, in meters, accepted as a location
+	GeofenceMaxAccuracy int `json:"geofence_max_accuracy"`
+	// NetworkMode decides what happens to punches from outside AllowedNetworks
+	NetworkMode     string   `json:"network_mode"`
+	AllowedNetworks []string `json:"allowed_networks"` // IPs or CIDR ranges
+	// LatenessGraceMinutes is how late an employee may clock in, or how early
+	// they may leave, before the schedule report counts it
+	LatenessGraceMinutes int `json:"lateness_grace_minutes"`
+	// SwapApprovalRequired makes claimed shift offers wait for a manager
+	SwapApprovalRequired bool `json:"swap_approval_required"`
+	// MaxWeeklyHours caps the scheduled hours a shift claim may bring an
+	// employee to in a week; 0 means no limit
+	MaxWeeklyHours float64 `json:"max_weekly_hours"`
+	// PayPeriod is the span covered by one timesheet
+	PayPeriod string `json:"pay_period"`
+	// PayPeriodAnchor is the first day of any weekly or biweekly pay period,
+	// in YYYY-MM-DD format; later periods follow on from it
+	PayPeriodAnchor string `json:"pay_period_anchor"`
+	// LeaveHoursPerDay is how many hours a full day of leave takes from a balance
+	LeaveHoursPerDay float64   `json:"leave_hours_per_day"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// defaultPolicy is used for companies that never saved a policy
+func defaultPolicy(companyID int) *Policy {
+	return &Policy{
+		CompanyID:            companyID,
+		AutoCloseEnabled:     false,
+		AutoCloseMode:        AutoCloseAfterHours,
+		AutoCloseAfterHours:  12,
+		AutoCloseTime:        "23:59",
+		MaxShiftHours:        16,
+		GeofenceMode:         EnforceOff,
+		GeofenceMaxAccuracy:  100,
+		NetworkMode:          EnforceOff,
+		AllowedNetworks:      []string{},
+		LatenessGraceMinutes: 5,
+		SwapApprovalRequired: true,
+		MaxWeeklyHours:       0,
+		PayPeriod:            PayPeriodWeekly,
+		PayPeriodAnchor:      "2024-01-01",
+		LeaveHoursPerDay:     8,
+	}
+}
+
+const policyColumns = `company_id, auto_close_enabled, auto_close_mode, auto_close_after_hours,
+	auto_close_time, max_shift_hours, geofence_mode, geofence_max_accuracy, network_mode, allowed_networks,
+	lateness_grace_minutes, swap_approval_required, max_weekly_hours, pay_period, pay_period_anchor, leave_hours_per_day,
+	updated_at`
+
+func scanPolicy(row scanner) (*Policy, error) {
+	p := &Policy{}
+	err := row.Scan(
+		&p.CompanyID, &p.AutoCloseEnabled, &p.AutoCloseMode, &p.AutoCloseAfterHours,
+		&p.AutoCloseTime, &p.MaxShiftHours, &p.GeofenceMode, &p.GeofenceMaxAccuracy,
+		&p.NetworkMode, pq.Array(&p.AllowedNetworks), &p.LatenessGraceMinutes,
+		&p.SwapApprovalRequired, &p.MaxWeeklyHours, &p.PayPeriod, &p.PayPeriodAnchor, &p.LeaveHoursPerDay,
+		&p.UpdatedAt,
+	)
+	return p, err
+}
+
+// GetPolicy retrieves a company's attendance policy, or the defaults if none is saved
+func GetPolicy(db *sql.DB, companyID int) (*Policy, error) {
+	p, err := scanPolicy(db.QueryRow(`
+		SELECT `+policyColumns+`
+		FROM attendance_policies
+		WHERE company_id = $1
+	`, companyID))
+
+	if err == sql.ErrNoRows {
+		return defaultPolicy(companyID), nil
+	}
+	return p, err
+}
+
+// SavePolicy validates and stores a company's attendance policy
+func SavePolicy(db *sql.DB, p *Policy) (*Policy, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	return scanPolicy(db.QueryRow(`
+		INSERT INTO attendance_policies (company_id, auto_close_enabled, auto_close_mode,
+		                                 auto_close_after_hours, auto_close_time, max_shift_hours,
+		                                 geofence_mode, geofence_max_accuracy, network_mode, allowed_networks,
+		                                 lateness_grace_minutes, swap_approval_required, max_weekly_hours,
+		                                 pay_period, pay_period_anchor, leave_hours_per_day, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, CURRENT_TIMESTAMP)
+		ON CONFLICT (company_id) DO UPDATE
+		SET auto_close_enabled = EXCLUDED.auto_close_enabled,
+		    auto_close_mode = EXCLUDED.auto_close_mode,
+		    auto_close_after_hours = EXCLUDED.auto_close_after_hours,
+		    auto_close_time = EXCLUDED.auto_close_time,
+		    max_shift_hours = EXCLUDED.max_shift_hours,
+		    geofence_mode = EXCLUDED.geofence_mode,
+		    geofence_max_accuracy = EXCLUDED.geofence_max_accuracy,
+		    network_mode = EXCLUDED.network_mode,
+		    allowed_networks = EXCLUDED.allowed_networks,
+		    lateness_grace_minutes = EXCLUDED.lateness_grace_minutes,
+		    swap_approval_required = EXCLUDED.swap_approval_required,
+		    max_weekly_hours = EXCLUDED.max_weekly_hours,
+		    pay_period = EXCLUDED.pay_period,
+		    pay_period_anchor = EXCLUDED.pay_period_anchor,
+		    leave_hours_per_day = EXCLUDED.leave_hours_per_day,
+		    updated_at = CURRENT_TIMESTAMP
+		RETURNING `+policyColumns,
+		p.CompanyID, p.AutoCloseEnabled, p.AutoCloseMode, p.AutoCloseAfterHours, p.AutoCloseTime, p.MaxShiftHours,
+		p.GeofenceMode, p.GeofenceMaxAccuracy, p.NetworkMode, pq.Array(p.AllowedNetworks),
+		p.LatenessGraceMinutes, p.SwapApprovalRequired, p.MaxWeeklyHours, p.PayPeriod, p.PayPeriodAnchor,
+		p.LeaveHoursPerDay,
+	))
+}
+
+// getAutoClosePolicies retrieves the policies of every company with auto-close enabled
+func getAutoClosePolicies(db *sql.DB) ([]Policy, error) {
+	rows, err := db.Query(`
+		SELECT ` + policyColumns + `
+		FROM attendance_policies
+		WHERE auto_close_enabled = true
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []Policy
+	for rows.Next() {
+		p, err := scanPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, *p)
+	}
+
+	return policies, rows.Err()
+}
+
+// validate checks the policy fields
+func (p *Policy) validate() error {
+	switch p.AutoCloseMode {
+	case AutoCloseAfterHours, AutoCloseFixedTime:
+	default:
+		return errors.New("auto_close_mode must be after_hours or fixed_time")
+	}
+	if p.MaxShiftHours <= 0 || p.MaxShiftHours > 48 {
+		return errors.New("max_shift_hours must be between 0 and 48")
+	}
+	if p.AutoCloseMode == AutoCloseAfterHours && (p.AutoCloseAfterHours <= 0 || p.AutoCloseAfterHours > p.MaxShiftHours) {
+		return errors.New("auto_close_after_hours must be positive and not exceed max_shift_hours")
+	}
+	if _, err := time.Parse("15:04", p.AutoCloseTime); err != nil {
+		return errors.New("auto_close_time must be in HH:MM format")
+	}
+	if !validEnforceMode(p.GeofenceMode) {
+		return errors.New("geofence_mode must be off, flag or block")
+	}
+	if p.GeofenceMaxAccuracy <= 0 {
+		return errors.New("geofence_max_accuracy must be positive")
+	}
+	if !validEnforceMode(p.NetworkMode) {
+		return errors.New("network_mode must be off, flag or block")
+	}
+	if p.AllowedNetworks == nil {
+		p.AllowedNetworks = []string{}
+	}
+	if _, err := utils.ParseNetworks(p.AllowedNetworks); err != nil {
+		return err
+	}
+	if p.NetworkMode != EnforceOff && len(p.AllowedNetworks) == 0 {
+		return errors.New("allowed_networks is required when network_mode is not off")
+	}
+	if p.LatenessGraceMinutes < 0 || p.LatenessGraceMinutes > 120 {
+		return errors.New("lateness_grace_minutes must be between 0 and 120")
+	}
+	if p.MaxWeeklyHours < 0 || p.MaxWeeklyHours > 168 {
+		return errors.New("max_weekly_hours must be between 0 and 168")
+	}
+	switch p.PayPeriod {
+	case PayPeriodWeekly, PayPeriodBiweekly, PayPeriodSemimonthly, PayPeriodMonthly:
+	default:
+		return errors.New("pay_period must be weekly, biweekly, semimonthly or monthly")
+	}
+	if _, err := time.Parse("2006-01-02", p.PayPeriodAnchor); err != nil {
+		return errors.New("pay_period_anchor must be in YYYY-MM-DD format")
+	}
+	if p.LeaveHoursPerDay <= 0 || p.LeaveHoursPerDay > 24 {
+		return errors.New("leave_hours_per_day must be between 0 and 24")
+	}
+	return nil
+}
+
+// validEnforceMode reports whether mode is a known enforcement mode
+func validEnforceMode(mode string) bool {
+	return mode == EnforceOff || mode == EnforceFlag || mode == EnforceBlock
+}
+
+// CloseTime returns when a shift started at clockIn should be auto-closed.
+// In fixed_time mode this is the first occurrence of the configured local
+// time after clock-in; either way it never exceeds max_shift_hours.
+func (p *Policy) CloseTime(clockIn time.Time, loc *time.Location) time.Time {
+	limit := clockIn.Add(hoursDuration(p.MaxShiftHours))
+
+	closeAt := clockIn.Add(hoursDuration(p.AutoCloseAfterHours))
+	if p.AutoCloseMode == AutoCloseFixedTime {
+		tod, _ := time.Parse("15:04", p.AutoCloseTime)
+		local := clockIn.In(loc)
+		closeAt = time.Date(local.Year(), local.Month(), local.Day(), tod.Hour(), tod.Minute(), 0, 0, loc)
+		if !closeAt.After(clockIn) {
+			closeAt = closeAt.AddDate(0, 0, 1)
+		}
+	}
+
+	if closeAt.After(limit) {
+		return limit
+	}
+	return closeAt
+}
+
+// PayPeriodOf returns the first and last day of the pay period containing
+// day. Days are dates at midnight UTC.
+func (p *Policy) PayPeriodOf(day time.Time) (time.Time, time.Time) {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch p.PayPeriod {
+	case PayPeriodSemimonthly:
+		if day.Day() <= 15 {
+			return day.AddDate(0, 0, 1-day.Day()), time.Date(day.Year(), day.Month(), 15, 0, 0, 0, 0, time.UTC)
+		}
+		return time.Date(day.Year(), day.Month(), 16, 0, 0, 0, 0, time.UTC),
+			time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC)
+	case PayPeriodMonthly:
+		return day.AddDate(0, 0, 1-day.Day()), time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC)
+	}
+
+	length := 7
+	if p.PayPeriod == PayPeriodBiweekly {
+		length = 14
+	}
+	anchor, err := time.Parse("2006-01-02", p.PayPeriodAnchor)
+	if err != nil {
+		anchor = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	offset := int(day.Sub(anchor).Hours()/24) % length
+	if offset < 0 {
+		offset += length
+	}
+	start := day.AddDate(0, 0, -offset)
+	return start, start.AddDate(0, 0, length-1)
+}
+
+// hoursDuration converts fractional hours to a duration
+func hoursDuration(hours float64) time.Duration {
+	return time.Duration(hours * float64(time.Hour))
+}
@@ -0,0 +1,611 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Groupings of the project hours report
+const (
+	ProjectGroupByProject  = "project"
+	ProjectGroupByClient   = "client"
+	ProjectGroupByEmployee = "employee"
+)
+
+// maxShiftAllocations caps how many projects one shift's hours are split across
+const maxShiftAllocations = 20
+
+// ErrProjectNotFound is returned when a project does not exist
+var ErrProjectNotFound = errors.New("project not found")
+
+// Project is a project or cost code that shift time is allocated to
+type Project struct {
+	ID          int       `json:"id"`
+	CompanyID   int       `json:"company_id"`
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	Client      string    `json:"client"`
+	Description string    `json:"description"`
+	Billable    bool      `json:"billable"`
+	IsActive    bool      `json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ShiftAllocation is part of a shift's time spent on a project: either a
+// segment from StartTime to EndTime, open until the shift ends when EndTime
+// is nil, or a fixed number of hours allocated after the shift
+type ShiftAllocation struct {
+	ID          int        `json:"id"`
+	ShiftID     int        `json:"shift_id"`
+	ProjectID   int        `json:"project_id"`
+	ProjectCode string     `json:"project_code"`
+	ProjectName string     `json:"project_name"`
+	Client      string     `json:"client"`
+	StartTime   *time.Time `json:"start_time,omitempty"`
+	EndTime     *time.Time `json:"end_time,omitempty"`
+	// Hours is the allocated time; for segments, their length less the
+	// unpaid breaks within them
+	Hours     float64   `json:"hours"`
+	CreatedBy *int      `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ShiftAllocations is the split of a shift's worked hours across projects
+type ShiftAllocations struct {
+	ShiftID          int               `json:"shift_id"`
+	UserID           int               `json:"user_id"`
+	Status           string            `json:"status"`
+	WorkedHours      float64           `json:"worked_hours"`
+	AllocatedHours   float64           `json:"allocated_hours"`
+	UnallocatedHours float64           `json:"unallocated_hours"`
+	Allocations      []ShiftAllocation `json:"allocations"`
+}
+
+// AllocationInput allocates hours of a completed shift to a project
+type AllocationInput struct {
+	ProjectID int     `json:"project_id"`
+	Hours     float64 `json:"hours"`
+}
+
+// ProjectReport is the hours of completed shifts per project, client or
+// employee. Time not allocated to any project is reported as its own group.
+type ProjectReport struct {
+	GroupBy          string               `json:"group_by"`
+	TotalHours       float64              `json:"total_hours"`
+	AllocatedHours   float64              `json:"allocated_hours"`
+	UnallocatedHours float64              `json:"unallocated_hours"`
+	BillableHours    float64              `json:"billable_hours"`
+	Shifts           int                  `json:"shifts"`
+	Groups           []ProjectReportGroup `json:"groups"`
+}
+
+// ProjectReportGroup holds the hours of one project, client or employee,
+// broken down by employee, or by project for employees
+type ProjectReportGroup struct {
+	// Key is the project or user ID, or the client; "" for unallocated time
+	Key           string              `json:"key"`
+	Label         string              `json:"label"`
+	Hours         float64             `json:"hours"`
+	BillableHours float64             `json:"billable_hours"`
+	Shifts        int                 `json:"shifts"`
+	Breakdown     []ProjectReportLine `json:"breakdown"`
+}
+
+// ProjectReportLine is one line of a project report group's breakdown
+type ProjectReportLine struct {
+	Key           string  `json:"key"`
+	Label         string  `json:"label"`
+	Hours         float64 `json:"hours"`
+	BillableHours float64 `json:"billable_hours"`
+	Shifts        int     `json:"shifts"`
+}
+
+const projectColumns = `id, company_id, code, name, client, COALESCE(description, ''), billable, is_active,
+	created_at, updated_at`
+
+func scanProject(row scanner) (*Project, error) {
+	p := &Project{}
+	err := row.Scan(
+		&p.ID, &p.CompanyID, &p.Code, &p.Name, &p.Client, &p.Description, &p.Billable, &p.IsActive,
+		&p.CreatedAt, &p.UpdatedAt,
+	)
+	return p, err
+}
+
+// allocationColumns lists the columns scanned by scanAllocation; allocations
+// must be aliased as "a", joined to their shift "s" and project "p".
+// shiftEnd is the SQL end of the shift for segments still open.
+func allocationColumns(shiftEnd string) string {
+	return `a.id, a.shift_id, a.project_id, p.code, p.name, p.client, a.start_time, a.end_time,
+	` + allocatedSeconds(shiftEnd) + ` / 3600, a.created_by, a.created_at`
+}
+
+func scanAllocation(row scanner) (*ShiftAllocation, error) {
+	a := &ShiftAllocation{}
+	err := row.Scan(
+		&a.ID, &a.ShiftID, &a.ProjectID, &a.ProjectCode, &a.ProjectName, &a.Client, &a.StartTime, &a.EndTime,
+		&a.Hours, &a.CreatedBy, &a.CreatedAt,
+	)
+	return a, err
+}
+
+// allocatedSeconds is the time of allocation "a" of shift "s" ending at
+// shiftEnd: its fixed hours, or the part of its segment within the shift
+// less the unpaid breaks in that part. Segments are clipped to the shift so
+// corrected clock times carry through.
+func allocatedSeconds(shiftEnd string) string {
+	segStart := `GREATEST(a.start_time, s.clock_in)`
+	segEnd := `LEAST(COALESCE(a.end_time, ` + shiftEnd + `), ` + shiftEnd + `)`
+	return `COALESCE(a.hours * 3600, GREATEST(
+		EXTRACT(EPOCH FROM (` + segEnd + ` - ` + segStart + `)) - COALESCE((
+			SELECT SUM(GREATEST(EXTRACT(EPOCH FROM (LEAST(b.end_time, ` + segEnd + `) - GREATEST(b.start_time, ` + segStart + `))), 0))
+			FROM shift_breaks b
+			WHERE b.shift_id = s.id AND b.break_type = 'unpaid' AND b.end_time IS NOT NULL
+		), 0), 0))`
+}
+
+// validate checks the project fields and that its code is unique in the company
+func (p *Project) validate(q querier) error {
+	p.Code = strings.ToUpper(strings.TrimSpace(p.Code))
+	p.Name = strings.TrimSpace(p.Name)
+	p.Client = strings.TrimSpace(p.Client)
+	if p.Code == "" {
+		return errors.New("code is required")
+	}
+	if len(p.Code) > 50 {
+		return errors.New("code is too long")
+	}
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(p.Name) > 255 || len(p.Client) > 255 {
+		return errors.New("name and client must be at most 255 characters")
+	}
+
+	var taken bool
+	err := q.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM projects WHERE company_id = $1 AND code = $2 AND id <> $3)
+	`, p.CompanyID, p.Code, p.ID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errors.New("a project with this code already exists")
+	}
+	return nil
+}
+
+// GetProjects retrieves the company's projects, including inactive ones when all is set
+func GetProjects(db *sql.DB, companyID int, all bool) ([]Project, error) {
+	rows, err := db.Query(`
+		SELECT `+projectColumns+`
+		FROM projects
+		WHERE company_id = $1 AND ($2 OR is_active = true)
+		ORDER BY code
+	`, companyID, all)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := []Project{}
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, *p)
+	}
+
+	return projects, rows.Err()
+}
+
+// GetProject retrieves a company project
+func GetProject(q querier, companyID, id int) (*Project, error) {
+	p, err := scanProject(q.QueryRow(`
+		SELECT `+projectColumns+` FROM projects WHERE id = $1 AND company_id = $2
+	`, id, companyID))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrProjectNotFound
+	}
+	return p, err
+}
+
+// activeProject retrieves a project time can be allocated to
+func activeProject(q querier, companyID, id int) (*Project, error) {
+	p, err := GetProject(q, companyID, id)
+	if err != nil {
+		return nil, err
+	}
+	if !p.IsActive {
+		return nil, fmt.Errorf("project %s is inactive", p.Code)
+	}
+	return p, nil
+}
+
+// CreateProject creates a project
+func CreateProject(db *sql.DB, p *Project) (*Project, error) {
+	if err := p.validate(db); err != nil {
+		return nil, err
+	}
+
+	return scanProject(db.QueryRow(`
+		INSERT INTO projects (company_id, code, name, client, description, billable, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+projectColumns,
+		p.CompanyID, p.Code, p.Name, p.Client, p.Description, p.Billable,
+	))
+}
+
+// UpdateProject updates a project. Time already allocated stays with it.
+func UpdateProject(db *sql.DB, p *Project) (*Project, error) {
+	if err := p.validate(db); err != nil {
+		return nil, err
+	}
+
+	updated, err := scanProject(db.QueryRow(`
+		UPDATE projects
+		SET code = $1, name = $2, client = $3, description = $4, billable = $5, is_active = $6,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $7 AND company_id = $8
+		RETURNING `+projectColumns,
+		p.Code, p.Name, p.Client, p.Description, p.Billable, p.IsActive, p.ID, p.CompanyID,
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrProjectNotFound
+	}
+	return updated, err
+}
+
+// DeactivateProject stops time being allocated to a project. Projects are
+// never deleted so the time allocated to them stays in reports.
+func DeactivateProject(db *sql.DB, companyID, id int) error {
+	result, err := db.Exec(`
+		UPDATE projects SET is_active = false, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND company_id = $2
+	`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrProjectNotFound
+	}
+	return nil
+}
+
+// startSegment starts a segment of a shift on a project at the given time
+func startSegment(tx *sql.Tx, shiftID, projectID, createdBy int, at time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO shift_allocations (shift_id, project_id, start_time, created_by, created_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+	`, shiftID, projectID, at, createdBy)
+	return err
+}
+
+// SwitchProject ends the project segment running in the user's active shift
+// and starts one on projectID, or stops allocating time when projectID is nil
+func SwitchProject(db *sql.DB, userID int, projectID *int) (*ShiftAllocations, error) {
+	now := time.Now().UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	shiftID, companyID, clockIn, err := lockActiveShift(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkPeriodOpen(tx, userID, clockIn); err != nil {
+		return nil, err
+	}
+
+	var current sql.NullInt64
+	err = tx.QueryRow(`
+		SELECT project_id FROM shift_allocations
+		WHERE shift_id = $1 AND start_time IS NOT NULL AND end_time IS NULL
+	`, shiftID).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if projectID == nil && !current.Valid {
+		return nil, errors.New("no project is in progress")
+	}
+	if projectID != nil && current.Valid && int(current.Int64) == *projectID {
+		return nil, errors.New("already working on this project")
+	}
+
+	if projectID != nil {
+		if _, err := activeProject(tx, companyID, *projectID); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE shift_allocations SET end_time = $2
+		WHERE shift_id = $1 AND start_time IS NOT NULL AND end_time IS NULL
+	`, shiftID, now)
+	if err != nil {
+		return nil, err
+	}
+	if projectID != nil {
+		if err := startSegment(tx, shiftID, *projectID, userID, now); err != nil {
+			return nil, err
+		}
+	}
+
+	allocations, err := getShiftAllocations(tx, companyID, shiftID, nil, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return allocations, nil
+}
+
+// GetShiftAllocations retrieves how a shift's time is split across
+// projects. userIDs limits whose shifts may be seen; nil means any in the company.
+func GetShiftAllocations(db *sql.DB, companyID, shiftID int, userIDs []int) (*ShiftAllocations, error) {
+	return getShiftAllocations(db, companyID, shiftID, userIDs, time.Now().UTC())
+}
+
+// getShiftAllocations retrieves a shift's allocations; a shift in progress
+// counts up to now
+func getShiftAllocations(q querier, companyID, shiftID int, userIDs []int, now time.Time) (*ShiftAllocations, error) {
+	shift, err := findShift(q, companyID, shiftID, userIDs, false)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ShiftAllocations{
+		ShiftID:     shift.ID,
+		UserID:      shift.UserID,
+		Status:      shift.Status,
+		Allocations: []ShiftAllocation{},
+	}
+	err = q.QueryRow(`
+		SELECT (EXTRACT(EPOCH FROM (COALESCE(s.clock_out, $2) - s.clock_in)) - `+unpaidBreakSeconds+`) / 3600
+		FROM shifts s WHERE s.id = $1
+	`, shiftID, now).Scan(&result.WorkedHours)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`
+		SELECT `+allocationColumns("COALESCE(s.clock_out, $2)")+`
+		FROM shift_allocations a
+		JOIN shifts s ON a.shift_id = s.id
+		JOIN projects p ON a.project_id = p.id
+		WHERE a.shift_id = $1
+		ORDER BY a.start_time NULLS LAST, a.id
+	`, shiftID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAllocation(rows)
+		if err != nil {
+			return nil, err
+		}
+		result.Allocations = append(result.Allocations, *a)
+		result.AllocatedHours += a.Hours
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result.UnallocatedHours = math.Max(result.WorkedHours-result.AllocatedHours, 0)
+	return result, nil
+}
+
+// AllocateShift replaces the project allocations of a completed shift,
+// including any segments recorded while it ran, with the given hours per
+// project. The hours may not add up to more than the shift's worked hours;
+// an empty list clears the allocation. userIDs limits whose shifts may be
+// allocated; nil means any in the company.
+func AllocateShift(db *sql.DB, companyID, shiftID, changedBy int, userIDs []int, inputs []AllocationInput) (*ShiftAllocations, error) {
+	if len(inputs) > maxShiftAllocations {
+		return nil, fmt.Errorf("a shift can be allocated to at most %d projects", maxShiftAllocations)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	shift, err := findShift(tx, companyID, shiftID, userIDs, true)
+	if err != nil {
+		return nil, err
+	}
+	if shift.Status != "completed" {
+		return nil, errors.New("only completed shifts can be allocated")
+	}
+	if err = checkPeriodOpen(tx, shift.UserID, shift.ClockIn); err != nil {
+		return nil, err
+	}
+
+	var worked float64
+	err = tx.QueryRow(`SELECT `+workedSeconds+` / 3600 FROM shifts s WHERE s.id = $1`, shiftID).Scan(&worked)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0.0
+	seen := map[int]bool{}
+	for _, in := range inputs {
+		if seen[in.ProjectID] {
+			return nil, errors.New("each project may only be listed once")
+		}
+		seen[in.ProjectID] = true
+		if in.Hours <= 0 {
+			return nil, errors.New("hours must be greater than 0")
+		}
+		if _, err := activeProject(tx, companyID, in.ProjectID); err != nil {
+			return nil, err
+		}
+		total += math.Round(in.Hours*100) / 100
+	}
+	if total > math.Round(worked*100)/100+0.005 {
+		return nil, fmt.Errorf("allocated hours (%.2f) exceed the %.2f hours worked", total, worked)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM shift_allocations WHERE shift_id = $1`, shiftID); err != nil {
+		return nil, err
+	}
+	for _, in := range inputs {
+		_, err := tx.Exec(`
+			INSERT INTO shift_allocations (shift_id, project_id, hours, created_by, created_at)
+			VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		`, shiftID, in.ProjectID, math.Round(in.Hours*100)/100, changedBy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	allocations, err := getShiftAllocations(tx, companyID, shiftID, nil, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return allocations, nil
+}
+
+// GetProjectReport totals the hours of the completed shifts matching the
+// filter per project, client or employee, with a breakdown by employee, or
+// by project for employees. Shifts awaiting review are left out, as in the
+// attendance report; the worked time not allocated to a project is
+// reported as "Unallocated".
+func GetProjectReport(db *sql.DB, filter ShiftFilter, groupBy string) (*ProjectReport, error) {
+	if groupBy == "" {
+		groupBy = ProjectGroupByProject
+	}
+
+	project := [2]string{"COALESCE(t.project_id::text, '')", "COALESCE(p.code || ' - ' || p.name, 'Unallocated')"}
+	employee := [2]string{"t.user_id::text", "t.full_name"}
+	var group, line [2]string
+	switch groupBy {
+	case ProjectGroupByProject:
+		group, line = project, employee
+	case ProjectGroupByClient:
+		client := "CASE WHEN t.project_id IS NULL THEN '' ELSE COALESCE(NULLIF(p.client, ''), 'No client') END"
+		group, line = [2]string{client, "COALESCE(NULLIF(" + client + ", ''), 'Unallocated')"}, employee
+	case ProjectGroupByEmployee:
+		group, line = employee, project
+	default:
+		return nil, errors.New("group_by must be project, client or employee")
+	}
+
+	where, args := filter.where()
+	completed := where + " AND s.status = 'completed' AND NOT " + pendingReview
+
+	rows, err := db.Query(fmt.Sprintf(`
+		WITH allocated AS (
+			SELECT s.id AS shift_id, s.user_id, u.full_name, a.project_id, `+allocatedSeconds("s.clock_out")+` / 3600 AS hours
+			FROM shifts s
+			JOIN users u ON s.user_id = u.id
+			JOIN shift_allocations a ON a.shift_id = s.id
+			WHERE %[1]s
+		), t AS (
+			SELECT shift_id, user_id, full_name, project_id, hours FROM allocated
+			UNION ALL
+			SELECT s.id, s.user_id, u.full_name, NULL,
+			       GREATEST(`+workedSeconds+` / 3600 - COALESCE((SELECT SUM(hours) FROM allocated WHERE shift_id = s.id), 0), 0)
+			FROM shifts s
+			JOIN users u ON s.user_id = u.id
+			WHERE %[1]s
+		)
+		SELECT GROUPING(group_key, line_key), COALESCE(group_key, ''), COALESCE(group_label, ''),
+		       COALESCE(line_key, ''), COALESCE(line_label, ''),
+		       COALESCE(SUM(hours), 0), COALESCE(SUM(CASE WHEN billable THEN hours END), 0),
+		       COALESCE(SUM(CASE WHEN project_id IS NULL THEN hours END), 0), COUNT(DISTINCT shift_id)
+		FROM (
+			SELECT %[2]s AS group_key, %[3]s AS group_label, %[4]s AS line_key, %[5]s AS line_label,
+			       t.shift_id, t.project_id, t.hours, COALESCE(p.billable, false) AS billable
+			FROM t
+			LEFT JOIN projects p ON t.project_id = p.id
+			WHERE t.hours > 0
+		) r
+		GROUP BY GROUPING SETS ((group_key, group_label, line_key, line_label), (group_key, group_label), ())
+	`, completed, group[0], group[1], line[0], line[1]), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &ProjectReport{GroupBy: groupBy, Groups: []ProjectReportGroup{}}
+	groups := map[string]*ProjectReportGroup{}
+	lines := map[string][]ProjectReportLine{}
+	for rows.Next() {
+		var level, shifts int
+		var groupKey, groupLabel, lineKey, lineLabel string
+		var hours, billable, unallocated float64
+		err := rows.Scan(&level, &groupKey, &groupLabel, &lineKey, &lineLabel, &hours, &billable, &unallocated, &shifts)
+		if err != nil {
+			return nil, err
+		}
+
+		switch level {
+		case 0:
+			lines[groupKey] = append(lines[groupKey], ProjectReportLine{
+				Key: lineKey, Label: lineLabel, Hours: hours, BillableHours: billable, Shifts: shifts,
+			})
+		case 1:
+			groups[groupKey] = &ProjectReportGroup{
+				Key: groupKey, Label: groupLabel, Hours: hours, BillableHours: billable, Shifts: shifts,
+			}
+		default:
+			report.TotalHours, report.BillableHours, report.Shifts = hours, billable, shifts
+			report.UnallocatedHours = unallocated
+			report.AllocatedHours = hours - unallocated
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for key, g := range groups {
+		g.Breakdown = lines[key]
+		if g.Breakdown == nil {
+			g.Breakdown = []ProjectReportLine{}
+		}
+		sortProjectReportLines(g.Breakdown)
+		report.Groups = append(report.Groups, *g)
+	}
+	sort.SliceStable(report.Groups, func(i, j int) bool {
+		a, b := report.Groups[i], report.Groups[j]
+		if (a.Key == "") != (b.Key == "") {
+			return b.Key == ""
+		}
+		return a.Label < b.Label
+	})
+
+	return report, nil
+}
+
+// sortProjectReportLines orders breakdown lines by label, with unallocated time last
+func sortProjectReportLines(lines []ProjectReportLine) {
+	sort.SliceStable(lines, func(i, j int) bool {
+		if (lines[i].Key == "") != (lines[j].Key == "") {
+			return lines[j].Key == ""
+		}
+		return lines[i].Label < lines[j].Label
+	})
+}
@@ -0,0 +1,316 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"modular-erp/internal/core/notifications"
+)
+
+// Notification kinds sent for punch requests
+const (
+	NotificationPunchRequestSubmitted = "punch_request.submitted"
+	NotificationPunchRequestReviewed  = "punch_request.reviewed"
+)
+
+// ErrPunchRequestNotFound is returned when a punch request does not exist or is not visible to the caller
+var ErrPunchRequestNotFound = errors.New("punch request not found")
+
+// errPunchRequestPending is returned when a shift already has a pending request
+var errPunchRequestPending = errors.New("a request for this shift is already pending")
+
+// PunchRequest is an employee's request to add a missed shift or fix the
+// times of one of their shifts. ShiftID is nil for a missing shift.
+type PunchRequest struct {
+	ID            int        `json:"id"`
+	CompanyID     int        `json:"company_id"`
+	UserID        int        `json:"user_id"`
+	ShiftID       *int       `json:"shift_id,omitempty"`
+	ClockIn       time.Time  `json:"clock_in"`
+	ClockOut      *time.Time `json:"clock_out,omitempty"`
+	ReasonCode    string     `json:"reason_code"`
+	Comment       string     `json:"comment,omitempty"`
+	Status        string     `json:"status"` // pending, approved, rejected, withdrawn
+	ReviewedBy    *int       `json:"reviewed_by,omitempty"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+	ReviewComment string     `json:"review_comment,omitempty"`
+	FullName      string     `json:"full_name,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// punchRequestColumns lists the columns scanned by scanPunchRequest; requests
+// must be aliased as "p" and joined to users as "u"
+const punchRequestColumns = `p.id, p.company_id, p.user_id, p.shift_id, p.clock_in, p.clock_out, p.reason_code,
+	COALESCE(p.comment, ''), p.status, p.reviewed_by, p.reviewed_at, COALESCE(p.review_comment, ''),
+	u.full_name, p.created_at, p.updated_at`
+
+func scanPunchRequest(row scanner) (*PunchRequest, error) {
+	p := &PunchRequest{}
+	err := row.Scan(
+		&p.ID, &p.CompanyID, &p.UserID, &p.ShiftID, &p.ClockIn, &p.ClockOut, &p.ReasonCode,
+		&p.Comment, &p.Status, &p.ReviewedBy, &p.ReviewedAt, &p.ReviewComment,
+		&p.FullName, &p.CreatedAt, &p.UpdatedAt,
+	)
+	return p, err
+}
+
+// CreatePunchRequest validates and submits a request, notifying the employee's manager
+func CreatePunchRequest(db *sql.DB, req *PunchRequest) (*PunchRequest, error) {
+	req.ClockIn, req.ClockOut = req.ClockIn.UTC(), utcTime(req.ClockOut)
+	if req.ReasonCode == "" {
+		req.ReasonCode = "missed_punch"
+	}
+	if err := validateReason(req.ReasonCode, req.Comment); err != nil {
+		return nil, err
+	}
+	if err := validateTimes(req.ClockIn, req.ClockOut); err != nil {
+		return nil, err
+	}
+
+	if err := checkPeriodOpen(db, req.UserID, req.ClockIn); err != nil {
+		return nil, err
+	}
+
+	excludeShiftID := 0
+	if req.ShiftID == nil {
+		if req.ClockOut == nil {
+			return nil, errors.New("clock_out is required")
+		}
+	} else {
+		shift, err := findShift(db, req.CompanyID, *req.ShiftID, []int{req.UserID}, false)
+		if err != nil {
+			return nil, err
+		}
+		if shift.Status == "cancelled" {
+			return nil, errors.New("cancelled shifts cannot be corrected")
+		}
+		if shift.Status == "completed" && req.ClockOut == nil {
+			return nil, errors.New("clock_out is required for a completed shift")
+		}
+
+		var pending bool
+		err = db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM punch_requests WHERE shift_id = $1 AND status = 'pending')
+		`, shift.ID).Scan(&pending)
+		if err != nil {
+			return nil, err
+		}
+		if pending {
+			return nil, errPunchRequestPending
+		}
+		if err = checkPeriodOpen(db, req.UserID, shift.ClockIn); err != nil {
+			return nil, err
+		}
+		excludeShiftID = shift.ID
+	}
+
+	// Checked again on approval, but reject obvious conflicts right away
+	if err := checkOverlap(db, req.UserID, excludeShiftID, req.ClockIn, req.ClockOut); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO punch_requests (company_id, user_id, shift_id, clock_in, clock_out, reason_code, comment,
+		                            status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), 'pending', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id
+	`, req.CompanyID, req.UserID, req.ShiftID, req.ClockIn, req.ClockOut, req.ReasonCode, req.Comment).Scan(&id)
+	if isUniqueViolation(err, "idx_punch_requests_shift_pending") {
+		return nil, errPunchRequestPending
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := getPunchRequest(tx, req.CompanyID, id, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	title := "New missed-punch request"
+	if created.ShiftID != nil {
+		title = "New shift correction request"
+	}
+	err = notifications.NotifyManager(tx, created.CompanyID, created.UserID, NotificationPunchRequestSubmitted,
+		title, fmt.Sprintf("%s submitted a request that needs your approval.", created.FullName),
+		map[string]interface{}{"punch_request_id": created.ID, "user_id": created.UserID})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// getPunchRequest retrieves a company punch request, optionally locking it.
+// userIDs limits whose requests may be seen; nil means any in the company.
+func getPunchRequest(q querier, companyID, id int, userIDs []int, forUpdate bool) (*PunchRequest, error) {
+	lock := ""
+	if forUpdate {
+		lock = "FOR UPDATE OF p"
+	}
+
+	p, err := scanPunchRequest(q.QueryRow(`
+		SELECT `+punchRequestColumns+`
+		FROM punch_requests p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.id = $1 AND p.company_id = $2 AND ($3::int[] IS NULL OR p.user_id = ANY($3))
+		`+lock,
+		id, companyID, pq.Array(userIDs),
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrPunchRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// GetPunchRequests retrieves company punch requests, oldest first.
+// userIDs limits whose requests are returned; nil means every user in the company.
+func GetPunchRequests(db *sql.DB, companyID int, userIDs []int, status string, limit, offset int) ([]PunchRequest, error) {
+	rows, err := db.Query(`
+		SELECT `+punchRequestColumns+`
+		FROM punch_requests p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.company_id = $1 AND ($2::int[] IS NULL OR p.user_id = ANY($2)) AND ($3 = '' OR p.status = $3)
+		ORDER BY p.created_at, p.id
+		LIMIT $4 OFFSET $5
+	`, companyID, pq.Array(userIDs), status, limit, offset)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []PunchRequest{}
+	for rows.Next() {
+		p, err := scanPunchRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *p)
+	}
+
+	return requests, rows.Err()
+}
+
+// WithdrawPunchRequest lets an employee withdraw their own pending request
+func WithdrawPunchRequest(db *sql.DB, companyID, userID, id int) (*PunchRequest, error) {
+	result, err := db.Exec(`
+		UPDATE punch_requests
+		SET status = 'withdrawn', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND company_id = $2 AND user_id = $3 AND status = 'pending'
+	`, id, companyID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := getPunchRequest(db, companyID, id, []int{userID}, false); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("only pending requests can be withdrawn")
+	}
+
+	return getPunchRequest(db, companyID, id, nil, false)
+}
+
+// ReviewPunchRequest approves or rejects a pending request. Approving adds or
+// corrects the shift, with a revision, in the same transaction. The employee
+// is notified either way. Reviewers cannot review their own requests.
+func ReviewPunchRequest(db *sql.DB, companyID, id, reviewerID int, userIDs []int, approve bool, comment string) (*PunchRequest, *Shift, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	req, err := getPunchRequest(tx, companyID, id, userIDs, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.Status != "pending" {
+		return nil, nil, errors.New("request has already been " + req.Status)
+	}
+	if req.UserID == reviewerID {
+		return nil, nil, errors.New("you cannot review your own request")
+	}
+
+	var shift *Shift
+	status := "rejected"
+	if approve {
+		status = "approved"
+		correction := Correction{
+			ClockIn:    req.ClockIn,
+			ClockOut:   req.ClockOut,
+			ReasonCode: req.ReasonCode,
+			Comment:    fmt.Sprintf("Punch request #%d", req.ID),
+		}
+		if req.Comment != "" {
+			correction.Comment += ": " + req.Comment
+		}
+
+		if req.ShiftID == nil {
+			shift, err = addShift(tx, companyID, req.UserID, reviewerID, correction)
+		} else {
+			shift, err = correctShift(tx, companyID, *req.ShiftID, reviewerID, nil, correction)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var shiftID *int
+	if shift != nil {
+		shiftID = &shift.ID
+	}
+
+	_, err = tx.Exec(`
+		UPDATE punch_requests
+		SET status = $1, shift_id = COALESCE($2, shift_id), reviewed_by = $3, reviewed_at = CURRENT_TIMESTAMP,
+		    review_comment = NULLIF($4, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5
+	`, status, shiftID, reviewerID, comment, req.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body := "Your request was " + status + "."
+	if comment != "" {
+		body += " " + comment
+	}
+	err = notifications.Notify(tx, companyID, req.UserID, NotificationPunchRequestReviewed,
+		"Punch request "+status, body,
+		map[string]interface{}{"punch_request_id": req.ID, "status": status, "shift_id": shiftID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reviewed, err := getPunchRequest(tx, companyID, req.ID, nil, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return reviewed, shift, nil
+}
@@ -0,0 +1,63 @@
+package attendance
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QRTokenPeriod is how often a site's QR code changes. A scanned token is
+// accepted until the end of the following period to allow for scan delays.
+const QRTokenPeriod = 30 * time.Second
+
+// ErrInvalidQRToken is returned for QR tokens that are forged, expired or for another company
+var ErrInvalidQRToken = errors.New("invalid or expired QR code")
+
+// SignQRToken returns the QR token of a site for the period containing at,
+// and when the period ends. Tokens have the form "<site>.<period>.<signature>".
+func SignQRToken(key []byte, companyID, siteID int, at time.Time) (string, time.Time) {
+	period := at.Unix() / int64(QRTokenPeriod/time.Second)
+	expiresAt := time.Unix((period+1)*int64(QRTokenPeriod/time.Second), 0)
+	return fmt.Sprintf("%d.%d.%s", siteID, period, qrSignature(key, companyID, siteID, period)), expiresAt
+}
+
+// VerifyQRToken checks a scanned token's signature and freshness and returns its site
+func VerifyQRToken(key []byte, companyID int, token string, now time.Time) (int, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, ErrInvalidQRToken
+	}
+	siteID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, ErrInvalidQRToken
+	}
+	period, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, ErrInvalidQRToken
+	}
+
+	expected := qrSignature(key, companyID, siteID, period)
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return 0, ErrInvalidQRToken
+	}
+
+	current := now.Unix() / int64(QRTokenPeriod/time.Second)
+	if period != current && period != current-1 {
+		return 0, ErrInvalidQRToken
+	}
+
+	return siteID, nil
+}
+
+// qrSignature is a truncated HMAC, short enough to keep the QR code small.
+// The key is used for nothing but QR tokens.
+func qrSignature(key []byte, companyID, siteID int, period int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "qr:%d:%d:%d", companyID, siteID, period)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
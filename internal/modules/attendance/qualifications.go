@@ -0,0 +1,111 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Qualifications are the scheduled shift roles an employee may work, e.g.
+// "forklift" or "cashier". They are checked when employees take over each
+// other's shifts.
+type Qualifications struct {
+	UserID   int      `json:"user_id"`
+	FullName string   `json:"full_name"`
+	Roles    []string `json:"roles"`
+}
+
+// GetQualifications retrieves the qualifications of every active employee of the company
+func GetQualifications(db *sql.DB, companyID int) ([]Qualifications, error) {
+	rows, err := db.Query(`
+		SELECT u.id, u.full_name, COALESCE(array_agg(q.role ORDER BY q.role) FILTER (WHERE q.role IS NOT NULL), '{}')
+		FROM users u
+		LEFT JOIN user_qualifications q ON q.user_id = u.id
+		WHERE u.company_id = $1 AND u.is_active = true
+		GROUP BY u.id, u.full_name
+		ORDER BY u.full_name
+	`, companyID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	qualifications := []Qualifications{}
+	for rows.Next() {
+		var q Qualifications
+		if err := rows.Scan(&q.UserID, &q.FullName, pq.Array(&q.Roles)); err != nil {
+			return nil, err
+		}
+		qualifications = append(qualifications, q)
+	}
+
+	return qualifications, rows.Err()
+}
+
+// SetQualifications replaces an employee's qualifications
+func SetQualifications(db *sql.DB, companyID, userID int, roles []string) ([]string, error) {
+	cleaned := []string{}
+	for _, role := range roles {
+		role = strings.TrimSpace(role)
+		if role == "" {
+			continue
+		}
+		if len(role) > 100 {
+			return nil, errors.New("role is too long")
+		}
+		if !containsString(cleaned, role) {
+			cleaned = append(cleaned, role)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND company_id = $2)
+	`, userID, companyID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("user not found")
+	}
+
+	if _, err = tx.Exec(`DELETE FROM user_qualifications WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO user_qualifications (company_id, user_id, role, created_at)
+		SELECT $1, $2, unnest($3::text[]), CURRENT_TIMESTAMP
+	`, companyID, userID, pq.Array(cleaned))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return cleaned, nil
+}
+
+// isQualified reports whether an employee may work a scheduled shift role;
+// shifts without a role need no qualification
+func isQualified(q querier, userID int, role string) (bool, error) {
+	if role == "" {
+		return true, nil
+	}
+
+	var qualified bool
+	err := q.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM user_qualifications WHERE user_id = $1 AND role = $2)
+	`, userID, role).Scan(&qualified)
+	return qualified, err
+}
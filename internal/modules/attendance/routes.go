@@ -4,14 +4,18 @@ import (
 	"database/sql"
 
 	"github.com/gorilla/mux"
+	"modular-erp/internal/core/config"
+	"modular-erp/internal/core/jobs"
 	"modular-erp/internal/core/middleware"
 )
 
-// RegisterRoutes registers all attendance module routes
-func RegisterRoutes(router *mux.Router, db *sql.DB, jwtSecret string) {
-	service := NewService(db)
+// RegisterRoutes registers all attendance module routes and background jobs
+func RegisterRoutes(router *mux.Router, db *sql.DB, jwtSecret string, cfg config.AttendanceConfig, runner *jobs.Runner) {
+	service := NewService(db, jwtSecret, cfg.KioskCredentialKey)
 	handler := NewHandler(service)
 
+	registerJobs(runner, db)
+
 	// Create a subrouter for attendance with authentication
 	attendanceRouter := router.PathPrefix("/api/attendance").Subrouter()
 	attendanceRouter.Use(middleware.AuthMiddleware(jwtSecret))
@@ -21,10 +25,126 @@ func RegisterRoutes(router *mux.Router, db *sql.DB, jwtSecret string) {
 	attendanceRouter.HandleFunc("/clock-out", handler.ClockOut).Methods("POST", "OPTIONS")
 	attendanceRouter.HandleFunc("/my-shifts", handler.GetMyShifts).Methods("GET", "OPTIONS")
 	attendanceRouter.HandleFunc("/active-shift", handler.GetActiveShift).Methods("GET", "OPTIONS")
+	attendanceRouter.HandleFunc("/break/start", handler.StartBreak).Methods("POST", "OPTIONS")
+	attendanceRouter.HandleFunc("/break/end", handler.EndBreak).Methods("POST", "OPTIONS")
+	attendanceRouter.HandleFunc("/punch-requests", handler.SubmitPunchRequest).Methods("POST", "OPTIONS")
+	attendanceRouter.HandleFunc("/punch-requests/mine", handler.GetMyPunchRequests).Methods("GET", "OPTIONS")
+	attendanceRouter.HandleFunc("/punch-requests/{id:[0-9]+}/withdraw", handler.WithdrawPunchRequest).Methods("POST", "OPTIONS")
+	attendanceRouter.HandleFunc("/schedule/mine", handler.GetMySchedule).Methods("GET", "OPTIONS")
+	attendanceRouter.HandleFunc("/shift-offers", handler.GetShiftMarketplace).Methods("GET", "OPTIONS")
+	attendanceRouter.HandleFunc("/shift-offers", handler.OfferShift).Methods("POST", "OPTIONS")
+	attendanceRouter.HandleFunc("/shift-offers/mine", handler.GetMyShiftOffers).Methods("GET", "OPTIONS")
+	attendanceRouter.HandleFunc("/shift-offers/{id:[0-9]+}/claim", handler.ClaimShiftOffer).Methods("POST", "OPTIONS")
+	attendanceRouter.HandleFunc("/shift-offers/{id:[0-9]+}/cancel", handler.CancelShiftOffer).Methods("POST", "OPTIONS")
+	attendanceRouter.HandleFunc("/timesheets/mine", handler.GetMyTimesheet).Methods("GET", "OPTIONS")
+	attendanceRouter.HandleFunc("/timesheets/mine/submit", handler.SubmitTimesheet).Methods("POST", "OPTIONS")
+	attendanceRouter.HandleFunc("/holidays/check", handler.CheckHoliday).Methods("GET", "OPTIONS")
+	attendanceRouter.HandleFunc("/projects", handler.GetProjects).Methods("GET", "OPTIONS")
+	attendanceRouter.HandleFunc("/project/switch", handler.SwitchProject).Methods("POST", "OPTIONS")
+	attendanceRouter.HandleFunc("/shifts/{id:[0-9]+}/allocations", handler.GetShiftAllocations).Methods("GET", "OPTIONS")
+	attendanceRouter.HandleFunc("/shifts/{id:[0-9]+}/allocations", handler.AllocateShift).Methods("PUT", "OPTIONS")
+	attendanceRouter.HandleFunc("/leave-types", handler.GetLeaveTypes).Methods("GET", "OPTIONS")
+	attendanceRouter.HandleFunc("/leave-requests", handler.SubmitLeaveRequest).Methods("POST", "OPTIONS")
+	attendanceRouter.HandleFunc("/leave-requests/mine", handler.GetMyLeaveRequests).Methods("GET", "OPTIONS")
+	attendanceRouter.HandleFunc("/leave-requests/{id:[0-9]+}/cancel", handler.CancelLeaveRequest).Methods("POST", "OPTIONS")
+	attendanceRouter.HandleFunc("/leave-balances/mine", handler.GetMyLeaveBalances).Methods("GET", "OPTIONS")
+	attendanceRouter.HandleFunc("/leave-ledger/mine", handler.GetMyLeaveLedger).Methods("GET", "OPTIONS")
 
 	// Manager/Admin endpoints - require manager or admin role
 	managerRouter := attendanceRouter.PathPrefix("").Subrouter()
 	managerRouter.Use(middleware.RequireRole("manager", "admin"))
 	managerRouter.HandleFunc("/shifts", handler.GetAllShifts).Methods("GET", "OPTIONS")
 	managerRouter.HandleFunc("/report", handler.GetReport).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/shifts", handler.AddShift).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/shifts/{id:[0-9]+}", handler.CorrectShift).Methods("PUT", "OPTIONS")
+	managerRouter.HandleFunc("/shifts/{id:[0-9]+}/cancel", handler.ManagerCancelShift).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/shifts/{id:[0-9]+}/revisions", handler.GetShiftRevisions).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/shifts/{id:[0-9]+}/review", handler.ReviewShift).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/punch-requests", handler.GetPunchRequestQueue).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/punch-requests/{id:[0-9]+}/approve", handler.ApprovePunchRequest).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/punch-requests/{id:[0-9]+}/reject", handler.RejectPunchRequest).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/policy", handler.GetPolicy).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/sites", handler.GetSites).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/schedule", handler.GetRoster).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/schedule", handler.CreateScheduledShift).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/schedule/{id:[0-9]+}", handler.UpdateScheduledShift).Methods("PUT", "OPTIONS")
+	managerRouter.HandleFunc("/schedule/{id:[0-9]+}", handler.DeleteScheduledShift).Methods("DELETE", "OPTIONS")
+	managerRouter.HandleFunc("/schedule/publish", handler.PublishSchedule).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/schedule/report", handler.GetScheduleReport).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/schedule/generate", handler.GenerateSchedule).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/schedule/templates", handler.GetShiftTemplates).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/schedule/rotations", handler.GetRotations).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/holidays", handler.GetHolidays).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/holiday-calendars", handler.GetHolidayCalendars).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/holiday-calendars/{id:[0-9]+}/holidays", handler.GetCalendarHolidays).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/shift-offers/pending", handler.GetPendingShiftOffers).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/shift-offers/{id:[0-9]+}/approve", handler.ApproveShiftOffer).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/shift-offers/{id:[0-9]+}/reject", handler.RejectShiftOffer).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/qualifications", handler.GetQualifications).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/overtime-rules", handler.GetOvertimeRules).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/report/overtime", handler.GetOvertimeReport).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/report/projects", handler.GetProjectReport).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/timesheets", handler.GetTimesheets).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/timesheets/{id:[0-9]+}", handler.GetTimesheet).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/timesheets/{id:[0-9]+}/approve", handler.ApproveTimesheet).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/timesheets/{id:[0-9]+}/reject", handler.RejectTimesheet).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/leave-requests", handler.GetLeaveRequestQueue).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/leave-requests/{id:[0-9]+}/approve", handler.ApproveLeaveRequest).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/leave-requests/{id:[0-9]+}/reject", handler.RejectLeaveRequest).Methods("POST", "OPTIONS")
+	managerRouter.HandleFunc("/leave-balances", handler.GetLeaveBalances).Methods("GET", "OPTIONS")
+	managerRouter.HandleFunc("/leave-ledger", handler.GetLeaveLedger).Methods("GET", "OPTIONS")
+
+	// Admin endpoints
+	adminRouter := attendanceRouter.PathPrefix("").Subrouter()
+	adminRouter.Use(middleware.RequireRole("admin"))
+	adminRouter.HandleFunc("/policy", handler.UpdatePolicy).Methods("PUT", "OPTIONS")
+	adminRouter.HandleFunc("/sites", handler.CreateSite).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/sites/{id:[0-9]+}", handler.UpdateSite).Methods("PUT", "OPTIONS")
+	adminRouter.HandleFunc("/sites/{id:[0-9]+}", handler.DeleteSite).Methods("DELETE", "OPTIONS")
+	adminRouter.HandleFunc("/kiosks", handler.GetKiosks).Methods("GET", "OPTIONS")
+	adminRouter.HandleFunc("/kiosks", handler.CreateKiosk).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/kiosks/{id:[0-9]+}", handler.RevokeKiosk).Methods("DELETE", "OPTIONS")
+	adminRouter.HandleFunc("/kiosk-credentials/{user_id:[0-9]+}", handler.SetKioskCredentials).Methods("PUT", "OPTIONS")
+	adminRouter.HandleFunc("/kiosk-credentials/{user_id:[0-9]+}", handler.DeleteKioskCredentials).Methods("DELETE", "OPTIONS")
+	adminRouter.HandleFunc("/devices", handler.GetDevices).Methods("GET", "OPTIONS")
+	adminRouter.HandleFunc("/devices", handler.CreateDevice).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/devices/{id:[0-9]+}", handler.RevokeDevice).Methods("DELETE", "OPTIONS")
+	adminRouter.HandleFunc("/schedule/templates", handler.CreateShiftTemplate).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/schedule/templates/{id:[0-9]+}", handler.UpdateShiftTemplate).Methods("PUT", "OPTIONS")
+	adminRouter.HandleFunc("/schedule/templates/{id:[0-9]+}", handler.DeleteShiftTemplate).Methods("DELETE", "OPTIONS")
+	adminRouter.HandleFunc("/schedule/rotations", handler.CreateRotation).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/schedule/rotations/{id:[0-9]+}", handler.UpdateRotation).Methods("PUT", "OPTIONS")
+	adminRouter.HandleFunc("/schedule/rotations/{id:[0-9]+}", handler.DeleteRotation).Methods("DELETE", "OPTIONS")
+	adminRouter.HandleFunc("/holidays", handler.CreateHoliday).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/holidays/{id:[0-9]+}", handler.DeleteHoliday).Methods("DELETE", "OPTIONS")
+	adminRouter.HandleFunc("/holiday-calendars", handler.CreateHolidayCalendar).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/holiday-calendars/{id:[0-9]+}", handler.UpdateHolidayCalendar).Methods("PUT", "OPTIONS")
+	adminRouter.HandleFunc("/holiday-calendars/{id:[0-9]+}", handler.DeleteHolidayCalendar).Methods("DELETE", "OPTIONS")
+	adminRouter.HandleFunc("/holiday-calendars/{id:[0-9]+}/holidays", handler.CreateCalendarHoliday).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/holiday-calendars/{id:[0-9]+}/holidays/{holiday_id:[0-9]+}", handler.DeleteCalendarHoliday).Methods("DELETE", "OPTIONS")
+	adminRouter.HandleFunc("/holiday-calendars/{id:[0-9]+}/import", handler.ImportHolidayCalendar).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/qualifications/{user_id:[0-9]+}", handler.SetQualifications).Methods("PUT", "OPTIONS")
+	adminRouter.HandleFunc("/overtime-rules", handler.UpdateOvertimeRules).Methods("PUT", "OPTIONS")
+	adminRouter.HandleFunc("/timesheets/{id:[0-9]+}/reopen", handler.ReopenTimesheet).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/leave-types", handler.CreateLeaveType).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/leave-types/{id:[0-9]+}", handler.UpdateLeaveType).Methods("PUT", "OPTIONS")
+	adminRouter.HandleFunc("/leave-types/{id:[0-9]+}", handler.DeleteLeaveType).Methods("DELETE", "OPTIONS")
+	adminRouter.HandleFunc("/leave-adjustments", handler.AdjustLeaveBalance).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/projects", handler.CreateProject).Methods("POST", "OPTIONS")
+	adminRouter.HandleFunc("/projects/{id:[0-9]+}", handler.UpdateProject).Methods("PUT", "OPTIONS")
+	adminRouter.HandleFunc("/projects/{id:[0-9]+}", handler.DeleteProject).Methods("DELETE", "OPTIONS")
+
+	// Kiosk endpoints - shared devices authenticate with a device token and
+	// punch for the employee identified by PIN or badge
+	kioskRouter := router.PathPrefix("/api/kiosk").Subrouter()
+	kioskRouter.Use(handler.kioskAuth)
+	kioskRouter.HandleFunc("/clock-in", handler.KioskClockIn).Methods("POST", "OPTIONS")
+	kioskRouter.HandleFunc("/clock-out", handler.KioskClockOut).Methods("POST", "OPTIONS")
+	kioskRouter.HandleFunc("/qr-code", handler.GetKioskQRCode).Methods("GET", "OPTIONS")
+
+	// Time clock endpoints - hardware badge readers authenticate with an API
+	// secret or a client certificate registered for the device
+	deviceRouter := router.PathPrefix("/api/devices").Subrouter()
+	deviceRouter.Use(handler.deviceAuth)
+	deviceRouter.HandleFunc("/events", handler.IngestDeviceEvents).Methods("POST", "OPTIONS")
 }
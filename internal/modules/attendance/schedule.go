@@ -0,0 +1,522 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lib/pq"
+	"modular-erp/internal/core/notifications"
+)
+
+// Notification kinds sent for schedules
+const (
+	NotificationSchedulePublished = "schedule.published"
+	NotificationScheduleChanged   = "schedule.changed"
+)
+
+// Outcomes of a scheduled shift compared with the punches
+const (
+	ScheduleUpcoming   = "upcoming"    // not started yet
+	ScheduleInProgress = "in_progress" // started, employee clocked in
+	ScheduleMissing    = "missing"     // started, no clock-in yet
+	ScheduleWorked     = "worked"      // over, employee worked it
+	ScheduleNoShow     = "no_show"     // over, no punches
+	ScheduleOnLeave    = "on_leave"    // no punches, employee on approved leave that day
+)
+
+// ErrScheduledShiftNotFound is returned when a scheduled shift does not exist or is not visible to the caller
+var ErrScheduledShiftNotFound = errors.New("scheduled shift not found")
+
+// ScheduledShift is a planned shift on the roster. Drafts are only visible
+// to managers until the roster is published.
+type ScheduledShift struct {
+	ID          int        `json:"id"`
+	CompanyID   int        `json:"company_id"`
+	UserID      int        `json:"user_id"`
+	SiteID      *int       `json:"site_id,omitempty"`
+	Role        string     `json:"role,omitempty"` // position worked, e.g. "cashier"
+	StartTime   time.Time  `json:"start_time"`
+	EndTime     time.Time  `json:"end_time"`
+	Notes       string     `json:"notes,omitempty"`
+	Status      string     `json:"status"` // draft, published, cancelled
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	CreatedBy   *int       `json:"created_by,omitempty"`
+	FullName    string     `json:"full_name,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ScheduleComparison is a published scheduled shift with the actual shift worked for it
+type ScheduleComparison struct {
+	ScheduledShift
+	ActualShiftID     *int       `json:"actual_shift_id,omitempty"`
+	ActualClockIn     *time.Time `json:"actual_clock_in,omitempty"`
+	ActualClockOut    *time.Time `json:"actual_clock_out,omitempty"`
+	ActualHours       *float64   `json:"actual_hours,omitempty"` // worked, less unpaid breaks
+	LeaveType         string     `json:"leave_type,omitempty"`   // approved leave on the shift's day
+	Outcome           string     `json:"outcome"`
+	LateMinutes       float64    `json:"late_minutes"`
+	EarlyLeaveMinutes float64    `json:"early_leave_minutes"`
+}
+
+// ScheduleSummary totals a planned vs actual comparison
+type ScheduleSummary struct {
+	ScheduledShifts        int     `json:"scheduled_shifts"`
+	ScheduledHours         float64 `json:"scheduled_hours"`
+	ActualHours            float64 `json:"actual_hours"`
+	NoShows                int     `json:"no_shows"`
+	OnLeave                int     `json:"on_leave"`
+	LateArrivals           int     `json:"late_arrivals"`
+	EarlyLeaves            int     `json:"early_leaves"`
+	TotalLateMinutes       float64 `json:"total_late_minutes"`
+	TotalEarlyLeaveMinutes float64 `json:"total_early_leave_minutes"`
+}
+
+// scheduledShiftColumns lists the columns scanned by scanScheduledShift;
+// scheduled shifts must be aliased as "ss" and joined to users as "u"
+const scheduledShiftColumns = `ss.id, ss.company_id, ss.user_id, ss.site_id, COALESCE(ss.role, ''), ss.start_time,
+	ss.end_time, COALESCE(ss.notes, ''), ss.status, ss.published_at, ss.created_by, u.full_name,
+	ss.created_at, ss.updated_at`
+
+func scanScheduledShift(row scanner, ss *ScheduledShift, extra ...interface{}) error {
+	dest := []interface{}{
+		&ss.ID, &ss.CompanyID, &ss.UserID, &ss.SiteID, &ss.Role, &ss.StartTime,
+		&ss.EndTime, &ss.Notes, &ss.Status, &ss.PublishedAt, &ss.CreatedBy, &ss.FullName,
+		&ss.CreatedAt, &ss.UpdatedAt,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// validate checks the fields of a scheduled shift and that its employee and
+// site belong to the company
+func (ss *ScheduledShift) validate(q querier) error {
+	if !ss.EndTime.After(ss.StartTime) {
+		return errors.New("end_time must be after start_time")
+	}
+	if ss.EndTime.Sub(ss.StartTime) > 24*time.Hour {
+		return errors.New("a scheduled shift cannot be longer than 24 hours")
+	}
+
+	var userOK, siteOK bool
+	err := q.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND company_id = $3),
+		       $2::int IS NULL OR EXISTS (SELECT 1 FROM work_sites WHERE id = $2 AND company_id = $3)
+	`, ss.UserID, ss.SiteID, ss.CompanyID).Scan(&userOK, &siteOK)
+	if err != nil {
+		return err
+	}
+	if !userOK {
+		return errors.New("user not found")
+	}
+	if !siteOK {
+		return ErrSiteNotFound
+	}
+	return nil
+}
+
+// checkScheduleConflict rejects a scheduled shift that overlaps another one
+// of the same employee, which would double-book them
+func checkScheduleConflict(q querier, userID, excludeID int, start, end time.Time) error {
+	var id int
+	var otherStart, otherEnd time.Time
+	err := q.QueryRow(`
+		SELECT id, start_time, end_time FROM scheduled_shifts
+		WHERE user_id = $1 AND id <> $2 AND status <> 'cancelled' AND start_time < $4 AND end_time > $3
+		ORDER BY start_time
+		LIMIT 1
+	`, userID, excludeID, start, end).Scan(&id, &otherStart, &otherEnd)
+
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("employee is already scheduled from %s to %s (scheduled shift #%d)",
+		otherStart.Format(time.RFC3339), otherEnd.Format(time.RFC3339), id)
+}
+
+// getScheduledShift retrieves a company scheduled shift, optionally locking it.
+// userIDs limits whose shifts may be seen; nil means any in the company.
+func getScheduledShift(q querier, companyID, id int, userIDs []int, forUpdate bool) (*ScheduledShift, error) {
+	lock := ""
+	if forUpdate {
+		lock = "FOR UPDATE OF ss"
+	}
+
+	ss := &ScheduledShift{}
+	err := scanScheduledShift(q.QueryRow(`
+		SELECT `+scheduledShiftColumns+`
+		FROM scheduled_shifts ss
+		JOIN users u ON ss.user_id = u.id
+		WHERE ss.id = $1 AND ss.company_id = $2 AND ($3::int[] IS NULL OR ss.user_id = ANY($3))
+		`+lock,
+		id, companyID, pq.Array(userIDs),
+	), ss)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrScheduledShiftNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ss, nil
+}
+
+// CreateScheduledShift adds a draft shift to the roster
+func CreateScheduledShift(db *sql.DB, ss *ScheduledShift) (*ScheduledShift, error) {
+	ss.StartTime, ss.EndTime = ss.StartTime.UTC(), ss.EndTime.UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := ss.validate(tx); err != nil {
+		return nil, err
+	}
+	if err := checkScheduleConflict(tx, ss.UserID, 0, ss.StartTime, ss.EndTime); err != nil {
+		return nil, err
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO scheduled_shifts (company_id, user_id, site_id, role, start_time, end_time, notes, status,
+		                              created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, NULLIF($7, ''), 'draft', $8, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id
+	`, ss.CompanyID, ss.UserID, ss.SiteID, ss.Role, ss.StartTime, ss.EndTime, ss.Notes, ss.CreatedBy).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := getScheduledShift(tx, ss.CompanyID, id, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// UpdateScheduledShift changes a scheduled shift. Employees are notified
+// when a published shift of theirs changes. userIDs limits whose shifts may
+// be changed, including the employee the shift is moved to; nil means any
+// in the company.
+func UpdateScheduledShift(db *sql.DB, ss *ScheduledShift, userIDs []int) (*ScheduledShift, error) {
+	ss.StartTime, ss.EndTime = ss.StartTime.UTC(), ss.EndTime.UTC()
+	if userIDs != nil && !containsID(userIDs, ss.UserID) {
+		return nil, ErrScheduledShiftNotFound
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	before, err := getScheduledShift(tx, ss.CompanyID, ss.ID, userIDs, true)
+	if err != nil {
+		return nil, err
+	}
+	if before.Status == "cancelled" {
+		return nil, errors.New("cancelled shifts cannot be changed")
+	}
+	if err := ss.validate(tx); err != nil {
+		return nil, err
+	}
+	if err := checkScheduleConflict(tx, ss.UserID, ss.ID, ss.StartTime, ss.EndTime); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE scheduled_shifts
+		SET user_id = $1, site_id = $2, role = NULLIF($3, ''), start_time = $4, end_time = $5,
+		    notes = NULLIF($6, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $7
+	`, ss.UserID, ss.SiteID, ss.Role, ss.StartTime, ss.EndTime, ss.Notes, ss.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := getScheduledShift(tx, ss.CompanyID, ss.ID, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if updated.Status == "published" {
+		if before.UserID != updated.UserID {
+			if err := notifyScheduleChange(tx, before, "Your shift was removed"); err != nil {
+				return nil, err
+			}
+			err = notifyScheduleChange(tx, updated, "You have a new shift")
+		} else {
+			err = notifyScheduleChange(tx, updated, "Your shift was changed")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// DeleteScheduledShift removes a draft shift, or cancels a published one and
+// notifies the employee
+func DeleteScheduledShift(db *sql.DB, companyID, id int, userIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ss, err := getScheduledShift(tx, companyID, id, userIDs, true)
+	if err != nil {
+		return err
+	}
+
+	switch ss.Status {
+	case "draft":
+		_, err = tx.Exec(`DELETE FROM scheduled_shifts WHERE id = $1`, ss.ID)
+	case "published":
+		_, err = tx.Exec(`
+			UPDATE scheduled_shifts SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP WHERE id = $1
+		`, ss.ID)
+		if err == nil {
+			err = notifyScheduleChange(tx, ss, "Your shift was cancelled")
+		}
+	default:
+		return errors.New("shift is already cancelled")
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetRoster retrieves the scheduled shifts starting in [from, to), leaving out
+// cancelled ones. userIDs limits whose shifts are returned; nil means every
+// user in the company.
+func GetRoster(db *sql.DB, companyID int, userIDs []int, from, to time.Time, publishedOnly bool) ([]ScheduledShift, error) {
+	rows, err := db.Query(`
+		SELECT `+scheduledShiftColumns+`
+		FROM scheduled_shifts ss
+		JOIN users u ON ss.user_id = u.id
+		WHERE ss.company_id = $1 AND ($2::int[] IS NULL OR ss.user_id = ANY($2))
+		  AND ss.start_time >= $3 AND ss.start_time < $4
+		  AND ss.status <> 'cancelled' AND (NOT $5 OR ss.status = 'published')
+		ORDER BY u.full_name, ss.start_time
+	`, companyID, pq.Array(userIDs), from.UTC(), to.UTC(), publishedOnly)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roster := []ScheduledShift{}
+	for rows.Next() {
+		var ss ScheduledShift
+		if err := scanScheduledShift(rows, &ss); err != nil {
+			return nil, err
+		}
+		roster = append(roster, ss)
+	}
+
+	return roster, rows.Err()
+}
+
+// PublishSchedule publishes the draft shifts starting in [from, to) and
+// notifies each employee with new shifts. userIDs limits whose shifts are
+// published; nil means every user in the company.
+func PublishSchedule(db *sql.DB, companyID int, userIDs []int, from, to time.Time) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		UPDATE scheduled_shifts
+		SET status = 'published', published_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE company_id = $1 AND ($2::int[] IS NULL OR user_id = ANY($2))
+		  AND start_time >= $3 AND start_time < $4 AND status = 'draft'
+		RETURNING user_id
+	`, companyID, pq.Array(userIDs), from.UTC(), to.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	perUser := map[int]int{}
+	var order []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if perUser[userID] == 0 {
+			order = append(order, userID)
+		}
+		perUser[userID]++
+		published++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, userID := range order {
+		err = notifications.Notify(tx, companyID, userID, NotificationSchedulePublished,
+			"Your schedule was published",
+			fmt.Sprintf("You have %d new shift(s) from %s.", perUser[userID], from.Format("Mon Jan 2")),
+			map[string]interface{}{"from": from, "to": to, "shifts": perUser[userID]})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return published, nil
+}
+
+// notifyScheduleChange tells an employee about a change to one of their published shifts
+func notifyScheduleChange(tx *sql.Tx, ss *ScheduledShift, title string) error {
+	return notifications.Notify(tx, ss.CompanyID, ss.UserID, NotificationScheduleChanged, title,
+		fmt.Sprintf("Shift from %s to %s.", ss.StartTime.Format(time.RFC3339), ss.EndTime.Format(time.RFC3339)),
+		map[string]interface{}{"scheduled_shift_id": ss.ID})
+}
+
+// CompareSchedule matches the published shifts starting in [from, to) with
+// the shifts actually worked. Each scheduled shift is matched to the
+// employee's overlapping shift whose clock-in is closest to the planned
+// start. Arriving later or leaving earlier than grace counts as lateness or
+// early leave, and shifts not worked on a day of approved leave count as on
+// leave rather than no-shows. Actual hours leave out unpaid breaks, as in
+// the other reports. userIDs limits whose shifts are compared; nil
+// means every user in the company.
+func CompareSchedule(db *sql.DB, companyID int, userIDs []int, from, to time.Time, grace time.Duration) ([]ScheduleComparison, *ScheduleSummary, error) {
+	rows, err := db.Query(`
+		SELECT `+scheduledShiftColumns+`, a.id, a.clock_in, a.clock_out, a.worked_seconds / 3600.0, COALESCE(lv.name, '')
+		FROM scheduled_shifts ss
+		JOIN users u ON ss.user_id = u.id
+		JOIN companies c ON ss.company_id = c.id
+		LEFT JOIN LATERAL (
+			SELECT s.id, s.clock_in, s.clock_out, `+workedSeconds+` AS worked_seconds
+			FROM shifts s
+			WHERE s.user_id = ss.user_id AND s.status <> 'cancelled'
+			  AND s.clock_in < ss.end_time AND COALESCE(s.clock_out, CURRENT_TIMESTAMP) > ss.start_time
+			ORDER BY ABS(EXTRACT(EPOCH FROM (s.clock_in - ss.start_time)))
+			LIMIT 1
+		) a ON true
+		LEFT JOIN LATERAL (
+			SELECT lt.name
+			FROM leave_days ld
+			JOIN leave_requests lr ON ld.leave_request_id = lr.id
+			JOIN leave_types lt ON lr.leave_type_id = lt.id
+			WHERE ld.user_id = ss.user_id AND lr.status = 'approved'
+			  AND ld.leave_date = ((ss.start_time AT TIME ZONE 'UTC') AT TIME ZONE COALESCE(NULLIF(c.timezone, ''), 'UTC'))::date
+			LIMIT 1
+		) lv ON true
+		WHERE ss.company_id = $1 AND ($2::int[] IS NULL OR ss.user_id = ANY($2))
+		  AND ss.start_time >= $3 AND ss.start_time < $4 AND ss.status = 'published'
+		ORDER BY ss.start_time, u.full_name
+	`, companyID, pq.Array(userIDs), from.UTC(), to.UTC())
+
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	comparisons := []ScheduleComparison{}
+	summary := &ScheduleSummary{}
+	for rows.Next() {
+		var c ScheduleComparison
+		err := scanScheduledShift(rows, &c.ScheduledShift, &c.ActualShiftID, &c.ActualClockIn, &c.ActualClockOut, &c.ActualHours, &c.LeaveType)
+		if err != nil {
+			return nil, nil, err
+		}
+		c.compare(now, grace)
+
+		summary.ScheduledShifts++
+		summary.ScheduledHours += c.EndTime.Sub(c.StartTime).Hours()
+		if c.ActualHours != nil {
+			summary.ActualHours += *c.ActualHours
+		}
+		switch c.Outcome {
+		case ScheduleNoShow:
+			summary.NoShows++
+		case ScheduleOnLeave:
+			summary.OnLeave++
+		}
+		if c.LateMinutes > 0 {
+			summary.LateArrivals++
+			summary.TotalLateMinutes += c.LateMinutes
+		}
+		if c.EarlyLeaveMinutes > 0 {
+			summary.EarlyLeaves++
+			summary.TotalEarlyLeaveMinutes += c.EarlyLeaveMinutes
+		}
+
+		comparisons = append(comparisons, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return comparisons, summary, nil
+}
+
+// compare sets the outcome, lateness and early leave of a scheduled shift
+func (c *ScheduleComparison) compare(now time.Time, grace time.Duration) {
+	switch {
+	case c.ActualClockIn == nil && c.LeaveType != "":
+		c.Outcome = ScheduleOnLeave
+		return
+	case c.ActualClockIn == nil && now.Before(c.StartTime):
+		c.Outcome = ScheduleUpcoming
+		return
+	case c.ActualClockIn == nil && now.Before(c.EndTime):
+		c.Outcome = ScheduleMissing
+		return
+	case c.ActualClockIn == nil:
+		c.Outcome = ScheduleNoShow
+		return
+	case c.ActualClockOut == nil:
+		c.Outcome = ScheduleInProgress
+	default:
+		c.Outcome = ScheduleWorked
+	}
+
+	if late := c.ActualClockIn.Sub(c.StartTime); late > grace {
+		c.LateMinutes = math.Round(late.Minutes())
+	}
+	if c.ActualClockOut != nil {
+		if early := c.EndTime.Sub(*c.ActualClockOut); early > grace {
+			c.EarlyLeaveMinutes = math.Round(early.Minutes())
+		}
+	}
+}
+
+// weekStart returns midnight on the Monday of the week containing t, in t's location
+func weekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7 // days since Monday
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+}
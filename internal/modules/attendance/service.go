@@ -1,28 +1,125 @@
 package attendance
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
 	"database/sql"
+	"errors"
+	"io"
 	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"modular-erp/internal/core/models"
 )
 
 // Service handles business logic for attendance module
 type Service struct {
 	db *sql.DB
+	// qrKey signs QR tokens
+	qrKey []byte
+	// credentialKey keys the hashes of kiosk PINs and badge IDs
+	credentialKey []byte
+}
+
+// NewService creates a new attendance service. The QR and kiosk credential
+// keys are derived from secret, or the credential key from credentialSecret
+// when set, so QR tokens, PIN hashes and JWTs never share a key.
+func NewService(db *sql.DB, secret, credentialSecret string) *Service {
+	if credentialSecret == "" {
+		credentialSecret = secret
+	}
+	return &Service{
+		db:            db,
+		qrKey:         deriveKey(secret, "qr-v1"),
+		credentialKey: deriveKey(credentialSecret, "kiosk-credentials-v1"),
+	}
 }
 
-// NewService creates a new attendance service
-func NewService(db *sql.DB) *Service {
-	return &Service{db: db}
+// deriveKey derives a key for one purpose from a secret with HKDF, so a
+// secret can serve several purposes without their keys being related
+func deriveKey(secret, label string) []byte {
+	key := make([]byte, sha256.Size)
+	io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte(label)), key)
+	return key
 }
 
 // ClockIn creates a new shift for an employee
-func (s *Service) ClockIn(userID, companyID int) (*Shift, error) {
-	return CreateShift(s.db, userID, companyID)
+func (s *Service) ClockIn(userID, companyID int, punch Punch) (*Shift, error) {
+	if err := s.evaluatePunch(companyID, &punch); err != nil {
+		return nil, err
+	}
+	return CreateShift(s.db, userID, companyID, punch)
 }
 
 // ClockOut ends the current shift for an employee
-func (s *Service) ClockOut(userID int, notes string) (*Shift, error) {
-	return EndShift(s.db, userID, notes)
+func (s *Service) ClockOut(userID, companyID int, notes string, punch Punch) (*Shift, error) {
+	if err := s.evaluatePunch(companyID, &punch); err != nil {
+		return nil, err
+	}
+	return EndShift(s.db, userID, notes, punch)
+}
+
+// evaluatePunch applies the company's punch policy, returning an error for
+// punches that must be rejected and adding the flags to record otherwise
+func (s *Service) evaluatePunch(companyID int, punch *Punch) error {
+	policy, err := GetPolicy(s.db, companyID)
+	if err != nil {
+		return err
+	}
+
+	if punch.QRToken != "" || punch.Location != nil || policy.GeofenceMode != EnforceOff {
+		sites, err := GetSites(s.db, companyID, true)
+		if err != nil {
+			return err
+		}
+
+		// A scanned QR code proves presence at its site, so the punch takes
+		// the site's position instead of any device location
+		if punch.QRToken != "" {
+			siteID, err := VerifyQRToken(s.qrKey, companyID, punch.QRToken, time.Now())
+			if err != nil {
+				return err
+			}
+			site := findSite(sites, siteID)
+			if site == nil {
+				return ErrInvalidQRToken
+			}
+			punch.Location = &Location{Latitude: site.Latitude, Longitude: site.Longitude}
+		}
+
+		flags, err := CheckGeofence(policy, sites, punch.Location)
+		if err != nil {
+			return err
+		}
+		punch.Flags = append(punch.Flags, flags...)
+	}
+
+	flags, err := CheckNetwork(policy, punch.SourceIP)
+	if err != nil {
+		return err
+	}
+	punch.Flags = append(punch.Flags, flags...)
+
+	return nil
+}
+
+// StartBreak starts a paid or unpaid break in the employee's active shift
+func (s *Service) StartBreak(userID int, breakType string) (*Break, error) {
+	if breakType == "" {
+		breakType = BreakUnpaid
+	}
+	return StartBreak(s.db, userID, breakType)
+}
+
+// EndBreak ends the employee's current break
+func (s *Service) EndBreak(userID int) (*Break, error) {
+	return EndBreak(s.db, userID)
+}
+
+// GetCurrentBreak retrieves the break in progress in a shift, if any
+func (s *Service) GetCurrentBreak(shiftID int) (*Break, error) {
+	return GetOpenBreak(s.db, shiftID)
 }
 
 // GetMyShifts retrieves shifts for a specific user
@@ -38,15 +135,880 @@ func (s *Service) GetMyActiveShift(userID int) (*Shift, error) {
 	return GetActiveShift(s.db, userID)
 }
 
-// GetAllShifts retrieves all shifts for a company (manager/admin only)
-func (s *Service) GetAllShifts(companyID int, startDate, endDate time.Time, limit, offset int) ([]ShiftWithUserInfo, error) {
+// ScopeFilter restricts a filter to the users the viewer may see.
+// Admins keep the whole company; managers are limited to their reporting subtree.
+func (s *Service) ScopeFilter(filter *ShiftFilter, viewerID int, role string) error {
+	visible, err := models.GetVisibleUserIDs(s.db, viewerID, role)
+	if err != nil {
+		return err
+	}
+	filter.UserIDs = visible
+	return nil
+}
+
+// GetAllShifts retrieves all shifts matching the filter (manager/admin only)
+func (s *Service) GetAllShifts(filter ShiftFilter, limit, offset int) ([]ShiftWithUserInfo, error) {
 	if limit == 0 {
 		limit = 100
 	}
-	return GetCompanyShifts(s.db, companyID, startDate, endDate, limit, offset)
+	return GetCompanyShifts(s.db, filter, limit, offset)
+}
+
+// StreamShifts calls fn with every shift matching the filter, for exports
+func (s *Service) StreamShifts(filter ShiftFilter, fn func(*ShiftWithUserInfo) error) error {
+	return StreamCompanyShifts(s.db, filter, fn)
+}
+
+// CompanyName returns the company's name for export headings
+func (s *Service) CompanyName(companyID int) string {
+	company, err := models.GetCompany(s.db, companyID)
+	if err != nil {
+		return ""
+	}
+	return company.Name
 }
 
 // GetReport generates attendance statistics (manager/admin only)
-func (s *Service) GetReport(companyID int, startDate, endDate time.Time) (*ShiftReport, error) {
-	return GetShiftReport(s.db, companyID, startDate, endDate)
+func (s *Service) GetReport(filter ShiftFilter) (*ShiftReport, error) {
+	return GetShiftReport(s.db, filter)
+}
+
+// GetReportGroups breaks the report down by employee, department, day, week
+// or month, counting days in the company time zone
+func (s *Service) GetReportGroups(filter ShiftFilter, groupBy, sortBy string, desc bool) ([]ShiftReportGroup, error) {
+	tz := s.CompanyLocation(filter.CompanyID).String()
+	return GetShiftReportGroups(s.db, filter, groupBy, sortBy, desc, tz)
+}
+
+// GetPolicy retrieves the company's attendance policy
+func (s *Service) GetPolicy(companyID int) (*Policy, error) {
+	return GetPolicy(s.db, companyID)
+}
+
+// UpdatePolicy validates and saves the company's attendance policy (admin only)
+func (s *Service) UpdatePolicy(policy *Policy) (*Policy, error) {
+	return SavePolicy(s.db, policy)
+}
+
+// ReviewShift marks an auto-closed shift as reviewed. Managers may only
+// review shifts of their reporting subtree.
+func (s *Service) ReviewShift(companyID, shiftID, reviewerID int, role string) (*Shift, error) {
+	visible, err := models.GetVisibleUserIDs(s.db, reviewerID, role)
+	if err != nil {
+		return nil, err
+	}
+	return ReviewShift(s.db, companyID, shiftID, reviewerID, visible)
+}
+
+// AddShift records a missing shift for an employee. Managers may only add
+// shifts for their reporting subtree.
+func (s *Service) AddShift(companyID, userID, managerID int, role string, c Correction) (*Shift, error) {
+	visible, err := models.GetVisibleUserIDs(s.db, managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	if visible != nil && !containsID(visible, userID) {
+		return nil, ErrShiftNotFound
+	}
+
+	users, err := models.GetCompanyUsers(s.db, companyID, []int{userID})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, errors.New("user not found")
+	}
+
+	return AddShift(s.db, companyID, userID, managerID, c)
+}
+
+// CorrectShift changes the times of a shift in the manager's reporting subtree
+func (s *Service) CorrectShift(companyID, shiftID, managerID int, role string, c Correction) (*Shift, error) {
+	visible, err := models.GetVisibleUserIDs(s.db, managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	return CorrectShift(s.db, companyID, shiftID, managerID, visible, c)
+}
+
+// CancelShift cancels a shift in the manager's reporting subtree
+func (s *Service) CancelShift(companyID, shiftID, managerID int, role, reasonCode, comment string) (*Shift, error) {
+	visible, err := models.GetVisibleUserIDs(s.db, managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	return CancelShiftByManager(s.db, companyID, shiftID, managerID, visible, reasonCode, comment)
+}
+
+// GetRevisions retrieves the correction history of a shift in the manager's reporting subtree
+func (s *Service) GetRevisions(companyID, shiftID, managerID int, role string) ([]Revision, error) {
+	visible, err := models.GetVisibleUserIDs(s.db, managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	return GetShiftRevisions(s.db, companyID, shiftID, visible)
+}
+
+// containsID reports whether ids contains id
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SubmitPunchRequest submits an employee's missed-punch or correction request
+func (s *Service) SubmitPunchRequest(req *PunchRequest) (*PunchRequest, error) {
+	return CreatePunchRequest(s.db, req)
+}
+
+// GetMyPunchRequests retrieves an employee's own requests
+func (s *Service) GetMyPunchRequests(companyID, userID int, status string, limit, offset int) ([]PunchRequest, error) {
+	if limit == 0 {
+		limit = 50
+	}
+	return GetPunchRequests(s.db, companyID, []int{userID}, status, limit, offset)
+}
+
+// WithdrawPunchRequest withdraws an employee's own pending request
+func (s *Service) WithdrawPunchRequest(companyID, userID, id int) (*PunchRequest, error) {
+	return WithdrawPunchRequest(s.db, companyID, userID, id)
+}
+
+// GetPunchRequestQueue retrieves requests of the manager's reporting subtree
+func (s *Service) GetPunchRequestQueue(companyID, managerID int, role, status string, limit, offset int) ([]PunchRequest, error) {
+	visible, err := models.GetVisibleUserIDs(s.db, managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	if limit == 0 {
+		limit = 100
+	}
+	return GetPunchRequests(s.db, companyID, visible, status, limit, offset)
+}
+
+// ReviewPunchRequest approves or rejects a request of the manager's reporting subtree
+func (s *Service) ReviewPunchRequest(companyID, id, managerID int, role string, approve bool, comment string) (*PunchRequest, *Shift, error) {
+	visible, err := models.GetVisibleUserIDs(s.db, managerID, role)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ReviewPunchRequest(s.db, companyID, id, managerID, visible, approve, comment)
+}
+
+// GetSites retrieves the company's work sites
+func (s *Service) GetSites(companyID int) ([]WorkSite, error) {
+	return GetSites(s.db, companyID, false)
+}
+
+// GetSite retrieves a work site
+func (s *Service) GetSite(companyID, id int) (*WorkSite, error) {
+	return GetSite(s.db, companyID, id)
+}
+
+// CreateSite creates a work site (admin only)
+func (s *Service) CreateSite(site *WorkSite) (*WorkSite, error) {
+	return CreateSite(s.db, site)
+}
+
+// UpdateSite updates a work site (admin only)
+func (s *Service) UpdateSite(site *WorkSite) (*WorkSite, error) {
+	return UpdateSite(s.db, site)
+}
+
+// DeleteSite deletes a work site (admin only)
+func (s *Service) DeleteSite(companyID, id int) error {
+	return DeleteSite(s.db, companyID, id)
+}
+
+// GetKiosks retrieves the company's kiosks (admin only)
+func (s *Service) GetKiosks(companyID int) ([]Kiosk, error) {
+	return GetKiosks(s.db, companyID)
+}
+
+// CreateKiosk registers a kiosk and returns its device token (admin only)
+func (s *Service) CreateKiosk(kiosk *Kiosk) (*Kiosk, error) {
+	return CreateKiosk(s.db, kiosk)
+}
+
+// RevokeKiosk deactivates a kiosk (admin only)
+func (s *Service) RevokeKiosk(companyID, id int) error {
+	return RevokeKiosk(s.db, companyID, id)
+}
+
+// SetKioskCredentials assigns an employee's kiosk PIN and/or badge (admin only)
+func (s *Service) SetKioskCredentials(companyID, userID int, c KioskCredentials) error {
+	return SetKioskCredentials(s.db, s.credentialKey, companyID, userID, c)
+}
+
+// DeleteKioskCredentials removes an employee's kiosk PIN and badge (admin only)
+func (s *Service) DeleteKioskCredentials(companyID, userID int) error {
+	return DeleteKioskCredentials(s.db, companyID, userID)
+}
+
+// AuthenticateKiosk retrieves the kiosk with a device token
+func (s *Service) AuthenticateKiosk(token string) (*Kiosk, error) {
+	return AuthenticateKiosk(s.db, token)
+}
+
+// IdentifyAtKiosk finds the employee with a PIN or badge, counting failed attempts
+func (s *Service) IdentifyAtKiosk(kiosk *Kiosk, kind, value string) (int, string, error) {
+	return IdentifyAtKiosk(s.db, s.credentialKey, kiosk, kind, value)
+}
+
+// KioskPunch describes a punch made on a kiosk. A kiosk installed at a work
+// site reports the site's position, so the geofence accepts it.
+func (s *Service) KioskPunch(kiosk *Kiosk, sourceIP string) (Punch, error) {
+	punch := Punch{SourceIP: sourceIP, KioskID: &kiosk.ID}
+	if kiosk.SiteID == nil {
+		return punch, nil
+	}
+
+	sites, err := GetSites(s.db, kiosk.CompanyID, true)
+	if err != nil {
+		return punch, err
+	}
+	if site := findSite(sites, *kiosk.SiteID); site != nil {
+		punch.Location = &Location{Latitude: site.Latitude, Longitude: site.Longitude}
+	}
+
+	return punch, nil
+}
+
+// KioskQRToken returns the current QR token of the kiosk's site and when it expires
+func (s *Service) KioskQRToken(kiosk *Kiosk) (string, time.Time, error) {
+	if kiosk.SiteID == nil {
+		return "", time.Time{}, errors.New("kiosk is not assigned to a work site")
+	}
+	token, expiresAt := SignQRToken(s.qrKey, kiosk.CompanyID, *kiosk.SiteID, time.Now())
+	return token, expiresAt, nil
+}
+
+// GetDevices retrieves the company's time clocks (admin only)
+func (s *Service) GetDevices(companyID int) ([]ClockDevice, error) {
+	return GetDevices(s.db, companyID)
+}
+
+// CreateDevice registers a time clock and returns its API secret (admin only)
+func (s *Service) CreateDevice(device *ClockDevice) (*ClockDevice, error) {
+	return CreateDevice(s.db, device)
+}
+
+// RevokeDevice deactivates a time clock (admin only)
+func (s *Service) RevokeDevice(companyID, id int) error {
+	return RevokeDevice(s.db, companyID, id)
+}
+
+// AuthenticateDevice retrieves the time clock with an API secret
+func (s *Service) AuthenticateDevice(secret string) (*ClockDevice, error) {
+	return AuthenticateDevice(s.db, secret)
+}
+
+// AuthenticateDeviceCertificate retrieves the time clock mapped to a verified client certificate
+func (s *Service) AuthenticateDeviceCertificate(cert *x509.Certificate) (*ClockDevice, error) {
+	return AuthenticateDeviceCertificate(s.db, cert)
+}
+
+// IngestDeviceEvents stores a batch of badge events and applies them to shifts
+func (s *Service) IngestDeviceEvents(device *ClockDevice, batch []DeviceEvent) ([]DeviceEventResult, error) {
+	return IngestDeviceEvents(s.db, s.credentialKey, device, batch)
+}
+
+// scheduleScope returns the employees whose schedule the caller may manage;
+// nil means every user in the company
+func (s *Service) scheduleScope(viewerID int, role string) ([]int, error) {
+	return models.GetVisibleUserIDs(s.db, viewerID, role)
+}
+
+// CompanyLocation returns the company's time zone, which rosters are laid out in
+func (s *Service) CompanyLocation(companyID int) *time.Location {
+	return models.GetCompanyLocation(s.db, companyID)
+}
+
+// GetRoster retrieves the scheduled shifts of the manager's reporting subtree
+func (s *Service) GetRoster(companyID, managerID int, role string, from, to time.Time) ([]ScheduledShift, error) {
+	visible, err := s.scheduleScope(managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	return GetRoster(s.db, companyID, visible, from, to, false)
+}
+
+// GetMySchedule retrieves an employee's published shifts
+func (s *Service) GetMySchedule(companyID, userID int, from, to time.Time) ([]ScheduledShift, error) {
+	return GetRoster(s.db, companyID, []int{userID}, from, to, true)
+}
+
+// CreateScheduledShift adds a draft shift for an employee in the manager's reporting subtree
+func (s *Service) CreateScheduledShift(ss *ScheduledShift, managerID int, role string) (*ScheduledShift, error) {
+	visible, err := s.scheduleScope(managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	if visible != nil && !containsID(visible, ss.UserID) {
+		return nil, ErrScheduledShiftNotFound
+	}
+	ss.CreatedBy = &managerID
+	return CreateScheduledShift(s.db, ss)
+}
+
+// UpdateScheduledShift changes a scheduled shift in the manager's reporting subtree
+func (s *Service) UpdateScheduledShift(ss *ScheduledShift, managerID int, role string) (*ScheduledShift, error) {
+	visible, err := s.scheduleScope(managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	return UpdateScheduledShift(s.db, ss, visible)
+}
+
+// DeleteScheduledShift removes or cancels a scheduled shift in the manager's reporting subtree
+func (s *Service) DeleteScheduledShift(companyID, id, managerID int, role string) error {
+	visible, err := s.scheduleScope(managerID, role)
+	if err != nil {
+		return err
+	}
+	return DeleteScheduledShift(s.db, companyID, id, visible)
+}
+
+// PublishSchedule publishes the drafts of the manager's reporting subtree in a date range
+func (s *Service) PublishSchedule(companyID, managerID int, role string, from, to time.Time) (int, error) {
+	visible, err := s.scheduleScope(managerID, role)
+	if err != nil {
+		return 0, err
+	}
+	return PublishSchedule(s.db, companyID, visible, from, to)
+}
+
+// CompareSchedule compares the published schedule of the manager's reporting
+// subtree with the shifts worked, using the policy's lateness grace period
+func (s *Service) CompareSchedule(companyID, managerID int, role string, from, to time.Time) ([]ScheduleComparison, *ScheduleSummary, error) {
+	visible, err := s.scheduleScope(managerID, role)
+	if err != nil {
+		return nil, nil, err
+	}
+	policy, err := GetPolicy(s.db, companyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	grace := time.Duration(policy.LatenessGraceMinutes) * time.Minute
+	return CompareSchedule(s.db, companyID, visible, from, to, grace)
+}
+
+// GetShiftTemplates retrieves the company's shift templates
+func (s *Service) GetShiftTemplates(companyID int) ([]ShiftTemplate, error) {
+	return GetShiftTemplates(s.db, companyID)
+}
+
+// CreateShiftTemplate creates a shift template (admin only)
+func (s *Service) CreateShiftTemplate(t *ShiftTemplate) (*ShiftTemplate, error) {
+	return CreateShiftTemplate(s.db, t)
+}
+
+// UpdateShiftTemplate updates a shift template (admin only)
+func (s *Service) UpdateShiftTemplate(t *ShiftTemplate) (*ShiftTemplate, error) {
+	return UpdateShiftTemplate(s.db, t)
+}
+
+// DeleteShiftTemplate deletes a shift template (admin only)
+func (s *Service) DeleteShiftTemplate(companyID, id int) error {
+	return DeleteShiftTemplate(s.db, companyID, id)
+}
+
+// GetRotations retrieves the company's rotations
+func (s *Service) GetRotations(companyID int) ([]Rotation, error) {
+	return GetRotations(s.db, companyID)
+}
+
+// CreateRotation creates a rotation (admin only)
+func (s *Service) CreateRotation(r *Rotation) (*Rotation, error) {
+	return CreateRotation(s.db, r)
+}
+
+// UpdateRotation updates a rotation (admin only)
+func (s *Service) UpdateRotation(r *Rotation) (*Rotation, error) {
+	return UpdateRotation(s.db, r)
+}
+
+// DeleteRotation deletes a rotation (admin only)
+func (s *Service) DeleteRotation(companyID, id int) error {
+	return DeleteRotation(s.db, companyID, id)
+}
+
+// GetHolidays retrieves the company's holidays, optionally of one year
+func (s *Service) GetHolidays(companyID, year int) ([]Holiday, error) {
+	return GetHolidays(s.db, companyID, year)
+}
+
+// CreateHoliday adds a company holiday (admin only)
+func (s *Service) CreateHoliday(holiday *Holiday) (*Holiday, error) {
+	return CreateHoliday(s.db, holiday)
+}
+
+// DeleteHoliday removes a company holiday (admin only)
+func (s *Service) DeleteHoliday(companyID, id int) error {
+	return DeleteHoliday(s.db, companyID, id)
+}
+
+// IsHoliday returns the company's holiday on a date, or nil; the date
+// defaults to today in the company time zone
+func (s *Service) IsHoliday(companyID int, date string) (string, *Holiday, error) {
+	if date == "" {
+		date = localDay(time.Now(), s.CompanyLocation(companyID)).Format("2006-01-02")
+	}
+	holiday, err := IsHoliday(s.db, companyID, date)
+	return date, holiday, err
+}
+
+// GetHolidayCalendars retrieves the company's holiday calendars
+func (s *Service) GetHolidayCalendars(companyID int) ([]HolidayCalendar, error) {
+	return GetHolidayCalendars(s.db, companyID)
+}
+
+// GetHolidayCalendar retrieves a company holiday calendar
+func (s *Service) GetHolidayCalendar(companyID, id int) (*HolidayCalendar, error) {
+	return GetHolidayCalendar(s.db, companyID, id)
+}
+
+// CreateHolidayCalendar creates a holiday calendar (admin only)
+func (s *Service) CreateHolidayCalendar(c *HolidayCalendar) (*HolidayCalendar, error) {
+	return CreateHolidayCalendar(s.db, c)
+}
+
+// UpdateHolidayCalendar updates or assigns a holiday calendar (admin only)
+func (s *Service) UpdateHolidayCalendar(c *HolidayCalendar) (*HolidayCalendar, error) {
+	return UpdateHolidayCalendar(s.db, c)
+}
+
+// DeleteHolidayCalendar deletes a holiday calendar (admin only)
+func (s *Service) DeleteHolidayCalendar(companyID, id int) error {
+	return DeleteHolidayCalendar(s.db, companyID, id)
+}
+
+// GetCalendarHolidays retrieves a calendar's holidays, optionally of one year
+func (s *Service) GetCalendarHolidays(companyID, calendarID, year int) ([]Holiday, error) {
+	return GetCalendarHolidays(s.db, companyID, calendarID, year)
+}
+
+// CreateCalendarHoliday adds a holiday to a calendar (admin only)
+func (s *Service) CreateCalendarHoliday(companyID, calendarID int, holiday *Holiday) (*Holiday, error) {
+	return CreateCalendarHoliday(s.db, companyID, calendarID, holiday)
+}
+
+// DeleteCalendarHoliday removes a holiday from a calendar (admin only)
+func (s *Service) DeleteCalendarHoliday(companyID, calendarID, id int) error {
+	return DeleteCalendarHoliday(s.db, companyID, calendarID, id)
+}
+
+// ImportHolidayCalendar imports the holidays of an iCalendar file into a
+// calendar (admin only)
+func (s *Service) ImportHolidayCalendar(companyID, calendarID int, r io.Reader, replace bool) (*HolidayImportResult, error) {
+	return ImportHolidayCalendar(s.db, companyID, calendarID, r, replace, s.CompanyLocation(companyID), time.Now())
+}
+
+// GenerateSchedule lays out draft shifts for employees in the manager's
+// reporting subtree from a template or rotation
+func (s *Service) GenerateSchedule(companyID, managerID int, role string, req *GenerateScheduleRequest) (*GenerateScheduleResult, error) {
+	visible, err := s.scheduleScope(managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	if visible != nil {
+		for _, userID := range req.UserIDs {
+			if !containsID(visible, userID) {
+				return nil, ErrScheduledShiftNotFound
+			}
+		}
+	}
+	return GenerateSchedule(s.db, companyID, managerID, req, s.CompanyLocation(companyID))
+}
+
+// GetQualifications retrieves the qualifications of the company's employees
+func (s *Service) GetQualifications(companyID int) ([]Qualifications, error) {
+	return GetQualifications(s.db, companyID)
+}
+
+// SetQualifications replaces an employee's qualifications (admin only)
+func (s *Service) SetQualifications(companyID, userID int, roles []string) ([]string, error) {
+	return SetQualifications(s.db, companyID, userID, roles)
+}
+
+// OfferShift puts one of the employee's shifts on offer
+func (s *Service) OfferShift(offer *ShiftOffer) (*ShiftOffer, error) {
+	return CreateShiftOffer(s.db, offer)
+}
+
+// GetShiftMarketplace retrieves the open offers an employee may browse
+func (s *Service) GetShiftMarketplace(companyID, userID int) ([]ShiftOffer, error) {
+	policy, err := GetPolicy(s.db, companyID)
+	if err != nil {
+		return nil, err
+	}
+	return GetOpenShiftOffers(s.db, companyID, userID, policy, s.CompanyLocation(companyID))
+}
+
+// GetMyShiftOffers retrieves the offers an employee made or claimed
+func (s *Service) GetMyShiftOffers(companyID, userID int) ([]ShiftOffer, error) {
+	return GetMyShiftOffers(s.db, companyID, userID)
+}
+
+// ClaimShiftOffer lets an employee take an open offer
+func (s *Service) ClaimShiftOffer(companyID, id, userID int, swapShiftID *int) (*ShiftOffer, error) {
+	policy, err := GetPolicy(s.db, companyID)
+	if err != nil {
+		return nil, err
+	}
+	return ClaimShiftOffer(s.db, companyID, id, userID, swapShiftID, policy, s.CompanyLocation(companyID))
+}
+
+// CancelShiftOffer withdraws an employee's own offer
+func (s *Service) CancelShiftOffer(companyID, userID, id int) (*ShiftOffer, error) {
+	return CancelShiftOffer(s.db, companyID, userID, id)
+}
+
+// GetPendingShiftOffers retrieves claimed offers of the manager's reporting subtree
+func (s *Service) GetPendingShiftOffers(companyID, managerID int, role string) ([]ShiftOffer, error) {
+	visible, err := s.scheduleScope(managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	return GetPendingShiftOffers(s.db, companyID, visible)
+}
+
+// ReviewShiftOffer approves or rejects a claimed offer of the manager's reporting subtree
+func (s *Service) ReviewShiftOffer(companyID, id, managerID int, role string, approve bool, comment string) (*ShiftOffer, error) {
+	visible, err := s.scheduleScope(managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := GetPolicy(s.db, companyID)
+	if err != nil {
+		return nil, err
+	}
+	return ReviewShiftOffer(s.db, companyID, id, managerID, visible, approve, comment, policy, s.CompanyLocation(companyID))
+}
+
+// GetOvertimeRules retrieves the company's overtime rules
+func (s *Service) GetOvertimeRules(companyID int) (*OvertimeRules, error) {
+	return GetOvertimeRules(s.db, companyID)
+}
+
+// SaveOvertimeRules stores the company's overtime rules (admin only)
+func (s *Service) SaveOvertimeRules(rules *OvertimeRules) (*OvertimeRules, error) {
+	return SaveOvertimeRules(s.db, rules)
+}
+
+// GetOvertimeReport classifies the hours worked by the manager's reporting
+// subtree, or by one employee in it when userID is set
+func (s *Service) GetOvertimeReport(companyID, managerID int, role string, userID int, from, to time.Time) ([]OvertimeSummary, error) {
+	visible, err := s.scheduleScope(managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	if userID != 0 {
+		if visible != nil && !containsID(visible, userID) {
+			return []OvertimeSummary{}, nil
+		}
+		visible = []int{userID}
+	}
+	return GetOvertimeReport(s.db, companyID, visible, from, to)
+}
+
+// timesheetDay resolves the day picking a pay period, defaulting to today
+// in the company time zone
+func (s *Service) timesheetDay(companyID int, date string) (time.Time, *time.Location, error) {
+	loc := s.CompanyLocation(companyID)
+	if date == "" {
+		return localDay(time.Now(), loc), loc, nil
+	}
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return time.Time{}, nil, errors.New("date must be in YYYY-MM-DD format")
+	}
+	return day, loc, nil
+}
+
+// GetMyTimesheet retrieves the employee's timesheet for the pay period containing date
+func (s *Service) GetMyTimesheet(companyID, userID int, date string) (*Timesheet, error) {
+	day, loc, err := s.timesheetDay(companyID, date)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := GetPolicy(s.db, companyID)
+	if err != nil {
+		return nil, err
+	}
+	return GetUserTimesheet(s.db, companyID, userID, policy, day, loc)
+}
+
+// SubmitTimesheet submits the employee's timesheet for the pay period containing date
+func (s *Service) SubmitTimesheet(companyID, userID int, date, comment string) (*Timesheet, error) {
+	day, loc, err := s.timesheetDay(companyID, date)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := GetPolicy(s.db, companyID)
+	if err != nil {
+		return nil, err
+	}
+	return SubmitTimesheet(s.db, companyID, userID, policy, day, loc, comment)
+}
+
+// GetTimesheets lists the timesheets of the manager's reporting subtree for
+// the pay period containing date
+func (s *Service) GetTimesheets(companyID, managerID int, role, date, status string) ([]Timesheet, error) {
+	day, loc, err := s.timesheetDay(companyID, date)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := GetPolicy(s.db, companyID)
+	if err != nil {
+		return nil, err
+	}
+	visible, err := models.GetVisibleUserIDs(s.db, managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	return GetTimesheets(s.db, companyID, visible, policy, day, loc, status)
+}
+
+// GetTimesheet retrieves a timesheet of the manager's reporting subtree
+func (s *Service) GetTimesheet(companyID, id, managerID int, role string) (*Timesheet, error) {
+	visible, err := models.GetVisibleUserIDs(s.db, managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	return GetTimesheet(s.db, companyID, id, visible, s.CompanyLocation(companyID))
+}
+
+// ReviewTimesheet approves or rejects a timesheet of the manager's reporting subtree
+func (s *Service) ReviewTimesheet(companyID, id, managerID int, role string, approve bool, comment string) (*Timesheet, error) {
+	visible, err := models.GetVisibleUserIDs(s.db, managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	return ReviewTimesheet(s.db, companyID, id, managerID, visible, approve, comment, s.CompanyLocation(companyID))
+}
+
+// ReopenTimesheet unlocks a timesheet's pay period (admin only)
+func (s *Service) ReopenTimesheet(companyID, id, adminID int, comment string) (*Timesheet, error) {
+	return ReopenTimesheet(s.db, companyID, id, adminID, comment)
+}
+
+// leaveToday returns today's date in the company time zone
+func (s *Service) leaveToday(companyID int) time.Time {
+	return localDay(time.Now(), s.CompanyLocation(companyID))
+}
+
+// leaveYearStart returns January 1st of the current year in the company
+// time zone, from which balances count the hours accrued and used
+func (s *Service) leaveYearStart(companyID int) string {
+	return time.Date(s.leaveToday(companyID).Year(), time.January, 1, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+}
+
+// GetLeaveTypes retrieves the company's leave types, including inactive ones when all is set
+func (s *Service) GetLeaveTypes(companyID int, all bool) ([]LeaveType, error) {
+	return GetLeaveTypes(s.db, companyID, all)
+}
+
+// GetLeaveType retrieves a company leave type
+func (s *Service) GetLeaveType(companyID, id int) (*LeaveType, error) {
+	return getLeaveType(s.db, companyID, id)
+}
+
+// CreateLeaveType creates a leave type (admin only)
+func (s *Service) CreateLeaveType(t *LeaveType) (*LeaveType, error) {
+	return CreateLeaveType(s.db, t)
+}
+
+// UpdateLeaveType updates a leave type (admin only)
+func (s *Service) UpdateLeaveType(t *LeaveType) (*LeaveType, error) {
+	return UpdateLeaveType(s.db, t)
+}
+
+// DeactivateLeaveType stops a leave type from being requested (admin only)
+func (s *Service) DeactivateLeaveType(companyID, id int) error {
+	return DeactivateLeaveType(s.db, companyID, id)
+}
+
+// RequestLeave submits an employee's leave request
+func (s *Service) RequestLeave(req *LeaveRequest) (*LeaveRequest, error) {
+	policy, err := GetPolicy(s.db, req.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+	return CreateLeaveRequest(s.db, req, policy)
+}
+
+// GetMyLeaveRequests retrieves the employee's own leave requests
+func (s *Service) GetMyLeaveRequests(companyID, userID int, status string, limit, offset int) ([]LeaveRequest, error) {
+	if limit == 0 {
+		limit = 50
+	}
+	return GetLeaveRequests(s.db, companyID, []int{userID}, status, limit, offset)
+}
+
+// GetLeaveRequestQueue retrieves the leave requests of the manager's reporting subtree
+func (s *Service) GetLeaveRequestQueue(companyID, managerID int, role, status string, limit, offset int) ([]LeaveRequest, error) {
+	visible, err := models.GetVisibleUserIDs(s.db, managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	if limit == 0 {
+		limit = 100
+	}
+	return GetLeaveRequests(s.db, companyID, visible, status, limit, offset)
+}
+
+// ReviewLeaveRequest approves or rejects a leave request of the manager's reporting subtree
+func (s *Service) ReviewLeaveRequest(companyID, id, managerID int, role string, approve bool, comment string) (*LeaveRequest, error) {
+	visible, err := models.GetVisibleUserIDs(s.db, managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	return ReviewLeaveRequest(s.db, companyID, id, managerID, visible, approve, comment)
+}
+
+// CancelLeaveRequest cancels the user's own leave request or, for managers
+// and admins, one of their reporting subtree
+func (s *Service) CancelLeaveRequest(companyID, id, userID int, role string) (*LeaveRequest, error) {
+	visible := []int{userID}
+	if role != "employee" {
+		var err error
+		if visible, err = models.GetVisibleUserIDs(s.db, userID, role); err != nil {
+			return nil, err
+		}
+	}
+	today := s.leaveToday(companyID).Format("2006-01-02")
+	return CancelLeaveRequest(s.db, companyID, id, userID, visible, today)
+}
+
+// GetMyLeaveBalances retrieves the employee's own leave balances
+func (s *Service) GetMyLeaveBalances(companyID, userID int) ([]LeaveBalance, error) {
+	return GetLeaveBalances(s.db, companyID, []int{userID}, s.leaveYearStart(companyID))
+}
+
+// GetLeaveBalances retrieves the leave balances of the manager's reporting
+// subtree, or of one employee in it when userID is set
+func (s *Service) GetLeaveBalances(companyID, managerID int, role string, userID int) ([]LeaveBalance, error) {
+	visible, err := models.GetVisibleUserIDs(s.db, managerID, role)
+	if err != nil {
+		return nil, err
+	}
+	if userID != 0 {
+		if visible != nil && !containsID(visible, userID) {
+			return []LeaveBalance{}, nil
+		}
+		visible = []int{userID}
+	}
+	return GetLeaveBalances(s.db, companyID, visible, s.leaveYearStart(companyID))
+}
+
+// GetLeaveLedger retrieves the ledger of an employee in the manager's
+// reporting subtree, or of the caller themselves
+func (s *Service) GetLeaveLedger(companyID, viewerID int, role string, userID, leaveTypeID, limit, offset int) ([]LeaveLedgerEntry, error) {
+	if userID != viewerID {
+		visible, err := models.GetVisibleUserIDs(s.db, viewerID, role)
+		if err != nil {
+			return nil, err
+		}
+		if visible != nil && !containsID(visible, userID) {
+			return []LeaveLedgerEntry{}, nil
+		}
+	}
+	if limit == 0 {
+		limit = 100
+	}
+	return GetLeaveLedger(s.db, companyID, userID, leaveTypeID, limit, offset)
+}
+
+// AdjustLeaveBalance records a manual balance correction dated today (admin only)
+func (s *Service) AdjustLeaveBalance(entry *LeaveLedgerEntry) (*LeaveLedgerEntry, error) {
+	entry.EntryDate = s.leaveToday(entry.CompanyID).Format("2006-01-02")
+	return AdjustLeaveBalance(s.db, entry)
+}
+
+// GetLeaveReport totals the approved leave taken over the report's period,
+// counting days in the company time zone
+func (s *Service) GetLeaveReport(filter ShiftFilter) (*LeaveReport, error) {
+	loc := s.CompanyLocation(filter.CompanyID)
+	from := localDay(filter.StartDate, loc).Format("2006-01-02")
+	// The filter ends at midnight after its last day or just before it
+	to := localDay(filter.EndDate.Add(-time.Nanosecond), loc).Format("2006-01-02")
+	return GetLeaveReport(s.db, filter, from, to)
+}
+
+// GetProjects retrieves the company's projects, including inactive ones when all is set
+func (s *Service) GetProjects(companyID int, all bool) ([]Project, error) {
+	return GetProjects(s.db, companyID, all)
+}
+
+// GetProject retrieves a company project
+func (s *Service) GetProject(companyID, id int) (*Project, error) {
+	return GetProject(s.db, companyID, id)
+}
+
+// CreateProject creates a project (admin only)
+func (s *Service) CreateProject(p *Project) (*Project, error) {
+	return CreateProject(s.db, p)
+}
+
+// UpdateProject updates a project (admin only)
+func (s *Service) UpdateProject(p *Project) (*Project, error) {
+	return UpdateProject(s.db, p)
+}
+
+// DeactivateProject deactivates a project (admin only)
+func (s *Service) DeactivateProject(companyID, id int) error {
+	return DeactivateProject(s.db, companyID, id)
+}
+
+// SwitchProject moves the employee's active shift onto another project, or
+// stops allocating its time when projectID is nil
+func (s *Service) SwitchProject(userID int, projectID *int) (*ShiftAllocations, error) {
+	return SwitchProject(s.db, userID, projectID)
+}
+
+// allocationScope returns whose shift allocations a user may see and
+// change: employees only their own, managers their reporting subtree
+func (s *Service) allocationScope(userID int, role string) ([]int, error) {
+	if role == "employee" {
+		return []int{userID}, nil
+	}
+	return models.GetVisibleUserIDs(s.db, userID, role)
+}
+
+// GetShiftAllocations retrieves how a shift's time is split across projects
+func (s *Service) GetShiftAllocations(companyID, shiftID, userID int, role string) (*ShiftAllocations, error) {
+	visible, err := s.allocationScope(userID, role)
+	if err != nil {
+		return nil, err
+	}
+	return GetShiftAllocations(s.db, companyID, shiftID, visible)
+}
+
+// AllocateShift splits a completed shift's hours across projects
+func (s *Service) AllocateShift(companyID, shiftID, userID int, role string, inputs []AllocationInput) (*ShiftAllocations, error) {
+	visible, err := s.allocationScope(userID, role)
+	if err != nil {
+		return nil, err
+	}
+	return AllocateShift(s.db, companyID, shiftID, userID, visible, inputs)
+}
+
+// GetProjectReport totals the hours of the filtered shifts per project,
+// client or employee (manager/admin only)
+func (s *Service) GetProjectReport(filter ShiftFilter, groupBy string) (*ProjectReport, error) {
+	return GetProjectReport(s.db, filter, groupBy)
 }
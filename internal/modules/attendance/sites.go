@@ -0,0 +1,244 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+)
+
+// Shift flags recorded when a punch breaks a policy in flag mode
+const (
+	FlagMissingLocation = "missing_location"
+	FlagLowAccuracy     = "low_location_accuracy"
+	FlagOutsideGeofence = "outside_geofence"
+)
+
+// ErrSiteNotFound is returned when a work site does not exist
+var ErrSiteNotFound = errors.New("work site not found")
+
+// WorkSite is a place employees may clock in from, a circle around a point
+type WorkSite struct {
+	ID           int       `json:"id"`
+	CompanyID    int       `json:"company_id"`
+	Name         string    `json:"name"`
+	Latitude     float64   `json:"latitude"`
+	Longitude    float64   `json:"longitude"`
+	RadiusMeters int       `json:"radius_meters"`
+	IsActive     bool      `json:"is_active"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Location is the device position sent with a punch
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Accuracy  float64 `json:"accuracy"`          // meters, as reported by the device
+	SiteID    *int    `json:"site_id,omitempty"` // matched work site, if any
+}
+
+// validate checks the reported coordinates and accuracy
+func (loc *Location) validate() error {
+	if loc.Latitude < -90 || loc.Latitude > 90 || loc.Longitude < -180 || loc.Longitude > 180 {
+		return errors.New("invalid coordinates")
+	}
+	if loc.Accuracy < 0 {
+		return errors.New("accuracy cannot be negative")
+	}
+	return nil
+}
+
+const siteColumns = `id, company_id, name, latitude, longitude, radius_meters, is_active, created_at, updated_at`
+
+func scanSite(row scanner) (*WorkSite, error) {
+	site := &WorkSite{}
+	err := row.Scan(
+		&site.ID, &site.CompanyID, &site.Name, &site.Latitude, &site.Longitude,
+		&site.RadiusMeters, &site.IsActive, &site.CreatedAt, &site.UpdatedAt,
+	)
+	return site, err
+}
+
+// validate checks the site fields
+func (site *WorkSite) validate() error {
+	if site.Name == "" {
+		return errors.New("name is required")
+	}
+	if site.Latitude < -90 || site.Latitude > 90 || site.Longitude < -180 || site.Longitude > 180 {
+		return errors.New("invalid coordinates")
+	}
+	if site.RadiusMeters <= 0 {
+		return errors.New("radius_meters must be positive")
+	}
+	return nil
+}
+
+// CreateSite creates a work site
+func CreateSite(db *sql.DB, site *WorkSite) (*WorkSite, error) {
+	if err := site.validate(); err != nil {
+		return nil, err
+	}
+
+	return scanSite(db.QueryRow(`
+		INSERT INTO work_sites (company_id, name, latitude, longitude, radius_meters, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, true, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+siteColumns,
+		site.CompanyID, site.Name, site.Latitude, site.Longitude, site.RadiusMeters,
+	))
+}
+
+// UpdateSite updates a work site
+func UpdateSite(db *sql.DB, site *WorkSite) (*WorkSite, error) {
+	if err := site.validate(); err != nil {
+		return nil, err
+	}
+
+	updated, err := scanSite(db.QueryRow(`
+		UPDATE work_sites
+		SET name = $1, latitude = $2, longitude = $3, radius_meters = $4, is_active = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6 AND company_id = $7
+		RETURNING `+siteColumns,
+		site.Name, site.Latitude, site.Longitude, site.RadiusMeters, site.IsActive, site.ID, site.CompanyID,
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrSiteNotFound
+	}
+	return updated, err
+}
+
+// GetSite retrieves a work site
+func GetSite(db *sql.DB, companyID, id int) (*WorkSite, error) {
+	site, err := scanSite(db.QueryRow(`
+		SELECT `+siteColumns+`
+		FROM work_sites
+		WHERE id = $1 AND company_id = $2
+	`, id, companyID))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrSiteNotFound
+	}
+	return site, err
+}
+
+// DeleteSite deletes a work site; shifts keep their coordinates
+func DeleteSite(db *sql.DB, companyID, id int) error {
+	result, err := db.Exec(`DELETE FROM work_sites WHERE id = $1 AND company_id = $2`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrSiteNotFound
+	}
+	return nil
+}
+
+// GetSites retrieves a company's work sites, optionally only the active ones
+func GetSites(db *sql.DB, companyID int, activeOnly bool) ([]WorkSite, error) {
+	rows, err := db.Query(`
+		SELECT `+siteColumns+`
+		FROM work_sites
+		WHERE company_id = $1 AND (NOT $2 OR is_active = true)
+		ORDER BY name
+	`, companyID, activeOnly)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sites := []WorkSite{}
+	for rows.Next() {
+		site, err := scanSite(rows)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, *site)
+	}
+
+	return sites, rows.Err()
+}
+
+// CheckGeofence matches a punch location against the work sites and applies
+// the policy's geofence mode. It sets loc.SiteID when a site matches and
+// returns the flags to record, or an error if the punch must be rejected.
+func CheckGeofence(policy *Policy, sites []WorkSite, loc *Location) ([]string, error) {
+	if loc != nil {
+		if err := loc.validate(); err != nil {
+			return nil, err
+		}
+		loc.SiteID = nil // only ever set by matching, never by the client
+	}
+
+	// A fix too coarse to place the employee never matches a site
+	if loc != nil && loc.Accuracy <= float64(policy.GeofenceMaxAccuracy) {
+		if site := MatchSite(sites, *loc); site != nil {
+			loc.SiteID = &site.ID
+		}
+	}
+
+	if policy.GeofenceMode == EnforceOff {
+		return nil, nil
+	}
+
+	var flag, message string
+	switch {
+	case loc == nil:
+		flag, message = FlagMissingLocation, "location is required to punch"
+	case loc.Accuracy > float64(policy.GeofenceMaxAccuracy):
+		flag, message = FlagLowAccuracy, "location is not accurate enough"
+	case loc.SiteID == nil:
+		flag, message = FlagOutsideGeofence, "you are not at a work site"
+	default:
+		return nil, nil
+	}
+
+	if policy.GeofenceMode == EnforceBlock {
+		return nil, errors.New(message)
+	}
+	return []string{flag}, nil
+}
+
+// findSite returns the site with the given ID, or nil
+func findSite(sites []WorkSite, id int) *WorkSite {
+	for i := range sites {
+		if sites[i].ID == id {
+			return &sites[i]
+		}
+	}
+	return nil
+}
+
+// MatchSite returns the nearest active site whose radius contains the
+// location. The reported accuracy is not added to the radius, since the
+// client controls it; CheckGeofence rejects fixes that are too coarse.
+func MatchSite(sites []WorkSite, loc Location) *WorkSite {
+	var nearest *WorkSite
+	best := math.Inf(1)
+
+	for i := range sites {
+		site := &sites[i]
+		if !site.IsActive {
+			continue
+		}
+		d := DistanceMeters(site.Latitude, site.Longitude, loc.Latitude, loc.Longitude)
+		if d <= float64(site.RadiusMeters) && d < best {
+			nearest, best = site, d
+		}
+	}
+
+	return nearest
+}
+
+// DistanceMeters returns the great-circle distance between two points
+func DistanceMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadius = 6371000.0
+	rad := math.Pi / 180
+
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,577 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"modular-erp/internal/core/notifications"
+)
+
+// Notification kinds sent for shift offers
+const (
+	NotificationShiftOfferClaimed  = "shift_offer.claimed"
+	NotificationShiftOfferReviewed = "shift_offer.reviewed"
+)
+
+// Kinds of shift offers
+const (
+	OfferGiveaway = "giveaway" // a colleague takes the shift
+	OfferSwap     = "swap"     // a colleague takes the shift and gives one of theirs in return
+)
+
+// ErrShiftOfferNotFound is returned when a shift offer does not exist or is not visible to the caller
+var ErrShiftOfferNotFound = errors.New("shift offer not found")
+
+// ShiftOffer is a published scheduled shift an employee offers to colleagues.
+// Once claimed it is applied right away, or waits for a manager when the
+// policy requires approval.
+type ShiftOffer struct {
+	ID               int        `json:"id"`
+	CompanyID        int        `json:"company_id"`
+	ScheduledShiftID int        `json:"scheduled_shift_id"`
+	OfferedBy        int        `json:"offered_by"`
+	OfferedByName    string     `json:"offered_by_name"`
+	Kind             string     `json:"kind"` // giveaway, swap
+	Comment          string     `json:"comment,omitempty"`
+	Status           string     `json:"status"` // open, pending_approval, approved, rejected, cancelled
+	ClaimedBy        *int       `json:"claimed_by,omitempty"`
+	ClaimedByName    string     `json:"claimed_by_name,omitempty"`
+	ClaimedAt        *time.Time `json:"claimed_at,omitempty"`
+	SwapShiftID      *int       `json:"swap_shift_id,omitempty"` // the claimant's shift given in return
+	ReviewedBy       *int       `json:"reviewed_by,omitempty"`
+	ReviewedAt       *time.Time `json:"reviewed_at,omitempty"`
+	ReviewComment    string     `json:"review_comment,omitempty"`
+	// The offered shift
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Role      string    `json:"role,omitempty"`
+	SiteID    *int      `json:"site_id,omitempty"`
+	// The shift given in return for a swap
+	SwapStartTime *time.Time `json:"swap_start_time,omitempty"`
+	SwapEndTime   *time.Time `json:"swap_end_time,omitempty"`
+	// Set in the marketplace: whether the viewer may claim the offer
+	Eligible         *bool     `json:"eligible,omitempty"`
+	IneligibleReason string    `json:"ineligible_reason,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// shiftOfferColumns lists the columns scanned by scanShiftOffer; they must
+// be selected from shiftOfferTables
+const shiftOfferColumns = `o.id, o.company_id, o.scheduled_shift_id, o.offered_by, u.full_name, o.kind,
+	COALESCE(o.comment, ''), o.status, o.claimed_by, COALESCE(c.full_name, ''), o.claimed_at, o.swap_shift_id,
+	o.reviewed_by, o.reviewed_at, COALESCE(o.review_comment, ''), ss.start_time, ss.end_time,
+	COALESCE(ss.role, ''), ss.site_id, sw.start_time, sw.end_time, o.created_at, o.updated_at`
+
+const shiftOfferTables = `shift_offers o
+	JOIN scheduled_shifts ss ON o.scheduled_shift_id = ss.id
+	JOIN users u ON o.offered_by = u.id
+	LEFT JOIN users c ON o.claimed_by = c.id
+	LEFT JOIN scheduled_shifts sw ON o.swap_shift_id = sw.id`
+
+func scanShiftOffer(row scanner) (*ShiftOffer, error) {
+	o := &ShiftOffer{}
+	err := row.Scan(
+		&o.ID, &o.CompanyID, &o.ScheduledShiftID, &o.OfferedBy, &o.OfferedByName, &o.Kind,
+		&o.Comment, &o.Status, &o.ClaimedBy, &o.ClaimedByName, &o.ClaimedAt, &o.SwapShiftID,
+		&o.ReviewedBy, &o.ReviewedAt, &o.ReviewComment, &o.StartTime, &o.EndTime,
+		&o.Role, &o.SiteID, &o.SwapStartTime, &o.SwapEndTime, &o.CreatedAt, &o.UpdatedAt,
+	)
+	return o, err
+}
+
+// getShiftOffer retrieves a company shift offer, optionally locking it.
+// userIDs limits whose offers may be seen; nil means any in the company.
+func getShiftOffer(q querier, companyID, id int, userIDs []int, forUpdate bool) (*ShiftOffer, error) {
+	lock := ""
+	if forUpdate {
+		lock = "FOR UPDATE OF o"
+	}
+
+	o, err := scanShiftOffer(q.QueryRow(`
+		SELECT `+shiftOfferColumns+`
+		FROM `+shiftOfferTables+`
+		WHERE o.id = $1 AND o.company_id = $2 AND ($3::int[] IS NULL OR o.offered_by = ANY($3))
+		`+lock,
+		id, companyID, pq.Array(userIDs),
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrShiftOfferNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// queryShiftOffers retrieves the shift offers matching a condition on the
+// shiftOfferTables, soonest shift first
+func queryShiftOffers(db *sql.DB, where string, args ...interface{}) ([]ShiftOffer, error) {
+	rows, err := db.Query(`
+		SELECT `+shiftOfferColumns+`
+		FROM `+shiftOfferTables+`
+		WHERE `+where+`
+		ORDER BY ss.start_time, o.id
+		LIMIT 200
+	`, args...)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	offers := []ShiftOffer{}
+	for rows.Next() {
+		o, err := scanShiftOffer(rows)
+		if err != nil {
+			return nil, err
+		}
+		offers = append(offers, *o)
+	}
+
+	return offers, rows.Err()
+}
+
+// CreateShiftOffer puts one of the employee's upcoming published shifts on offer
+func CreateShiftOffer(db *sql.DB, offer *ShiftOffer) (*ShiftOffer, error) {
+	if offer.Kind == "" {
+		offer.Kind = OfferGiveaway
+	}
+	if offer.Kind != OfferGiveaway && offer.Kind != OfferSwap {
+		return nil, errors.New("kind must be giveaway or swap")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ss, err := getScheduledShift(tx, offer.CompanyID, offer.ScheduledShiftID, []int{offer.OfferedBy}, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOfferable(tx, ss); err != nil {
+		return nil, err
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO shift_offers (company_id, scheduled_shift_id, offered_by, kind, comment, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), 'open', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING id
+	`, offer.CompanyID, ss.ID, offer.OfferedBy, offer.Kind, offer.Comment).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := getShiftOffer(tx, offer.CompanyID, id, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// checkOfferable rejects shifts that cannot change hands: drafts, cancelled
+// or started shifts, and shifts already on offer
+func checkOfferable(q querier, ss *ScheduledShift) error {
+	if ss.Status != "published" {
+		return errors.New("only published shifts can be offered or swapped")
+	}
+	if !ss.StartTime.After(time.Now()) {
+		return errors.New("the shift has already started")
+	}
+
+	var offered bool
+	err := q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM shift_offers
+			WHERE (scheduled_shift_id = $1 OR swap_shift_id = $1) AND status IN ('open', 'pending_approval')
+		)
+	`, ss.ID).Scan(&offered)
+	if err != nil {
+		return err
+	}
+	if offered {
+		return errors.New("the shift is already on offer")
+	}
+	return nil
+}
+
+// GetOpenShiftOffers retrieves the company's open offers of upcoming shifts
+// other than the viewer's, marking whether the viewer may claim each one
+func GetOpenShiftOffers(db *sql.DB, companyID, viewerID int, policy *Policy, loc *time.Location) ([]ShiftOffer, error) {
+	offers, err := queryShiftOffers(db, `o.company_id = $1 AND o.status = 'open' AND o.offered_by <> $2
+		AND ss.user_id = o.offered_by AND ss.status = 'published' AND ss.start_time > CURRENT_TIMESTAMP`,
+		companyID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range offers {
+		o := &offers[i]
+		shift := &ScheduledShift{ID: o.ScheduledShiftID, UserID: o.OfferedBy, Role: o.Role, StartTime: o.StartTime, EndTime: o.EndTime}
+
+		// For swaps the schedule and hours are checked once the claimant
+		// picks the shift they give in return
+		if o.Kind == OfferSwap {
+			err = checkQualified(db, viewerID, "you", shift.Role)
+		} else {
+			err = checkTakeShift(db, policy, loc, viewerID, "you", shift, 0)
+		}
+
+		eligible := err == nil
+		o.Eligible = &eligible
+		if err != nil {
+			o.IneligibleReason = err.Error()
+		}
+	}
+
+	return offers, nil
+}
+
+// GetMyShiftOffers retrieves the offers an employee made or claimed
+func GetMyShiftOffers(db *sql.DB, companyID, userID int) ([]ShiftOffer, error) {
+	return queryShiftOffers(db, `o.company_id = $1 AND (o.offered_by = $2 OR o.claimed_by = $2)
+		AND ss.end_time > CURRENT_TIMESTAMP - INTERVAL '30 days'`, companyID, userID)
+}
+
+// GetPendingShiftOffers retrieves the claimed offers waiting for approval.
+// userIDs limits whose offers are returned; nil means every user in the company.
+func GetPendingShiftOffers(db *sql.DB, companyID int, userIDs []int) ([]ShiftOffer, error) {
+	return queryShiftOffers(db, `o.company_id = $1 AND o.status = 'pending_approval'
+		AND ($2::int[] IS NULL OR o.offered_by = ANY($2))`, companyID, pq.Array(userIDs))
+}
+
+// ClaimShiftOffer lets an employee take an open offer, giving one of their
+// own shifts in return for a swap. The swap rules are checked for both
+// employees. Without required approval the shifts change hands right away;
+// otherwise the offerer's manager is asked to approve.
+func ClaimShiftOffer(db *sql.DB, companyID, id, claimantID int, swapShiftID *int, policy *Policy, loc *time.Location) (*ShiftOffer, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	offer, err := getShiftOffer(tx, companyID, id, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if offer.Status != "open" {
+		return nil, errors.New("the offer is no longer open")
+	}
+	if offer.OfferedBy == claimantID {
+		return nil, errors.New("you cannot claim your own offer")
+	}
+	if offer.Kind == OfferSwap && swapShiftID == nil {
+		return nil, errors.New("swap_shift_id is required to claim a swap")
+	}
+	if offer.Kind == OfferGiveaway && swapShiftID != nil {
+		return nil, errors.New("swap_shift_id is only allowed for swaps")
+	}
+
+	shift, swap, err := lockOfferShifts(tx, offer, claimantID, swapShiftID)
+	if err != nil {
+		return nil, err
+	}
+	if swap != nil {
+		if err := checkOfferable(tx, swap); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkSwapRules(tx, policy, loc, offer, shift, swap, claimantID); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE shift_offers
+		SET status = 'pending_approval', claimed_by = $1, claimed_at = CURRENT_TIMESTAMP, swap_shift_id = $2,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, claimantID, swapShiftID, offer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := getShiftOffer(tx, companyID, offer.ID, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.SwapApprovalRequired {
+		data := map[string]interface{}{"shift_offer_id": claimed.ID, "scheduled_shift_id": claimed.ScheduledShiftID}
+		err = notifications.Notify(tx, companyID, claimed.OfferedBy, NotificationShiftOfferClaimed,
+			"Your shift offer was claimed",
+			fmt.Sprintf("%s claimed your shift on %s. It is waiting for manager approval.",
+				claimed.ClaimedByName, claimed.StartTime.In(loc).Format("Mon Jan 2 15:04")), data)
+		if err == nil {
+			err = notifications.NotifyManager(tx, companyID, claimed.OfferedBy, NotificationShiftOfferClaimed,
+				"Shift "+claimed.Kind+" needs your approval",
+				fmt.Sprintf("%s wants to take %s's shift on %s.", claimed.ClaimedByName, claimed.OfferedByName,
+					claimed.StartTime.In(loc).Format("Mon Jan 2 15:04")), data)
+		}
+	} else {
+		claimed, err = applyShiftOffer(tx, claimed, shift, swap, nil, "", loc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+// ReviewShiftOffer approves or rejects a claimed offer. The swap rules are
+// checked again on approval since the schedule may have changed. An offer
+// whose claimant was deleted can only be rejected. userIDs limits whose
+// offers may be reviewed; nil means any in the company.
+func ReviewShiftOffer(db *sql.DB, companyID, id, reviewerID int, userIDs []int, approve bool, comment string, policy *Policy, loc *time.Location) (*ShiftOffer, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	offer, err := getShiftOffer(tx, companyID, id, userIDs, true)
+	if err != nil {
+		return nil, err
+	}
+	if offer.Status != "pending_approval" {
+		return nil, errors.New("the offer is not waiting for approval")
+	}
+	if offer.OfferedBy == reviewerID || (offer.ClaimedBy != nil && *offer.ClaimedBy == reviewerID) {
+		return nil, errors.New("you cannot review your own shift offer")
+	}
+
+	var reviewed *ShiftOffer
+	if approve {
+		if offer.ClaimedBy == nil {
+			return nil, errors.New("the claimant no longer exists; reject the offer instead")
+		}
+		shift, swap, err := lockOfferShifts(tx, offer, *offer.ClaimedBy, offer.SwapShiftID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSwapRules(tx, policy, loc, offer, shift, swap, *offer.ClaimedBy); err != nil {
+			return nil, err
+		}
+		reviewed, err = applyShiftOffer(tx, offer, shift, swap, &reviewerID, comment, loc)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, err = tx.Exec(`
+			UPDATE shift_offers
+			SET status = 'rejected', reviewed_by = $1, reviewed_at = CURRENT_TIMESTAMP,
+			    review_comment = NULLIF($2, ''), updated_at = CURRENT_TIMESTAMP
+			WHERE id = $3
+		`, reviewerID, comment, offer.ID)
+		if err != nil {
+			return nil, err
+		}
+		if reviewed, err = getShiftOffer(tx, companyID, offer.ID, nil, false); err != nil {
+			return nil, err
+		}
+		if err = notifyShiftOfferReviewed(tx, reviewed, loc); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return reviewed, nil
+}
+
+// CancelShiftOffer lets the offerer withdraw an offer that has not been approved yet
+func CancelShiftOffer(db *sql.DB, companyID, userID, id int) (*ShiftOffer, error) {
+	result, err := db.Exec(`
+		UPDATE shift_offers
+		SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND company_id = $2 AND offered_by = $3 AND status IN ('open', 'pending_approval')
+	`, id, companyID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := getShiftOffer(db, companyID, id, []int{userID}, false); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("only open or pending offers can be cancelled")
+	}
+
+	return getShiftOffer(db, companyID, id, nil, false)
+}
+
+// lockOfferShifts locks the offered shift and the claimant's swap shift, if
+// any, and checks that both still belong to their employees and have not started
+func lockOfferShifts(tx *sql.Tx, offer *ShiftOffer, claimantID int, swapShiftID *int) (*ScheduledShift, *ScheduledShift, error) {
+	shift, err := getScheduledShift(tx, offer.CompanyID, offer.ScheduledShiftID, []int{offer.OfferedBy}, true)
+	if err == ErrScheduledShiftNotFound {
+		return nil, nil, errors.New("the offered shift was reassigned or removed")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if shift.Status != "published" || !shift.StartTime.After(time.Now()) {
+		return nil, nil, errors.New("the offered shift was cancelled or has started")
+	}
+
+	if swapShiftID == nil {
+		return shift, nil, nil
+	}
+	swap, err := getScheduledShift(tx, offer.CompanyID, *swapShiftID, []int{claimantID}, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if swap.Status != "published" || !swap.StartTime.After(time.Now()) {
+		return nil, nil, errors.New("the shift given in return was cancelled or has started")
+	}
+	return shift, swap, nil
+}
+
+// checkSwapRules checks that the claimant can take the offered shift and,
+// for a swap, that the offerer can take the claimant's shift
+func checkSwapRules(q querier, policy *Policy, loc *time.Location, offer *ShiftOffer, shift, swap *ScheduledShift, claimantID int) error {
+	swapID := 0
+	if swap != nil {
+		swapID = swap.ID
+	}
+	if err := checkTakeShift(q, policy, loc, claimantID, "you", shift, swapID); err != nil {
+		return err
+	}
+	if swap != nil {
+		return checkTakeShift(q, policy, loc, offer.OfferedBy, offer.OfferedByName, swap, shift.ID)
+	}
+	return nil
+}
+
+// checkTakeShift checks that an employee is qualified for a shift, is free
+// at the time and stays within the weekly hours limit. giveUpID is a shift
+// of theirs they hand over at the same time, or 0.
+func checkTakeShift(q querier, policy *Policy, loc *time.Location, userID int, who string, ss *ScheduledShift, giveUpID int) error {
+	if err := checkQualified(q, userID, who, ss.Role); err != nil {
+		return err
+	}
+	if err := checkScheduleConflict(q, userID, giveUpID, ss.StartTime, ss.EndTime); err != nil {
+		return err
+	}
+	if policy.MaxWeeklyHours <= 0 {
+		return nil
+	}
+
+	from := weekStart(ss.StartTime.In(loc))
+	var hours float64
+	err := q.QueryRow(`
+		SELECT COALESCE(SUM(EXTRACT(EPOCH FROM (end_time - start_time)) / 3600), 0)
+		FROM scheduled_shifts
+		WHERE user_id = $1 AND id <> $2 AND status <> 'cancelled' AND start_time >= $3 AND start_time < $4
+	`, userID, giveUpID, from.UTC(), from.AddDate(0, 0, 7).UTC()).Scan(&hours)
+	if err != nil {
+		return err
+	}
+	if hours+ss.EndTime.Sub(ss.StartTime).Hours() > policy.MaxWeeklyHours {
+		return fmt.Errorf("the shift would take %s over the %g-hour weekly limit", who, policy.MaxWeeklyHours)
+	}
+	return nil
+}
+
+// checkQualified rejects employees without the qualification a shift role needs
+func checkQualified(q querier, userID int, who, role string) error {
+	qualified, err := isQualified(q, userID, role)
+	if err != nil {
+		return err
+	}
+	if !qualified {
+		return fmt.Errorf("%s not qualified for the %q role", isOrAre(who), role)
+	}
+	return nil
+}
+
+// isOrAre returns the subject of a rule message with the matching verb
+func isOrAre(who string) string {
+	if who == "you" {
+		return "you are"
+	}
+	return who + " is"
+}
+
+// applyShiftOffer hands the offered shift to the claimant, and the swap
+// shift to the offerer, then marks the offer approved and notifies both
+func applyShiftOffer(tx *sql.Tx, offer *ShiftOffer, shift, swap *ScheduledShift, reviewerID *int, comment string, loc *time.Location) (*ShiftOffer, error) {
+	_, err := tx.Exec(`
+		UPDATE scheduled_shifts SET user_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2
+	`, *offer.ClaimedBy, shift.ID)
+	if err != nil {
+		return nil, err
+	}
+	if swap != nil {
+		_, err = tx.Exec(`
+			UPDATE scheduled_shifts SET user_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2
+		`, offer.OfferedBy, swap.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE shift_offers
+		SET status = 'approved', reviewed_by = $1, reviewed_at = CASE WHEN $1::int IS NULL THEN NULL ELSE CURRENT_TIMESTAMP END,
+		    review_comment = NULLIF($2, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, reviewerID, comment, offer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := getShiftOffer(tx, offer.CompanyID, offer.ID, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := notifyShiftOfferReviewed(tx, applied, loc); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// notifyShiftOfferReviewed tells both employees whether their shift changed hands
+func notifyShiftOfferReviewed(tx *sql.Tx, offer *ShiftOffer, loc *time.Location) error {
+	when := offer.StartTime.In(loc).Format("Mon Jan 2 15:04")
+	title := "Shift " + offer.Kind + " " + offer.Status
+	body := fmt.Sprintf("The %s of the shift on %s was %s.", offer.Kind, when, offer.Status)
+	if offer.Status == "approved" {
+		body = fmt.Sprintf("%s now works the shift on %s.", offer.ClaimedByName, when)
+	}
+	if offer.ReviewComment != "" {
+		body += " " + offer.ReviewComment
+	}
+
+	recipients := []int{offer.OfferedBy}
+	if offer.ClaimedBy != nil {
+		recipients = append(recipients, *offer.ClaimedBy)
+	}
+
+	data := map[string]interface{}{"shift_offer_id": offer.ID, "status": offer.Status}
+	for _, userID := range recipients {
+		err := notifications.Notify(tx, offer.CompanyID, userID, NotificationShiftOfferReviewed, title, body, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,616 @@
+package attendance
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Limits on rotations and on a single roster generation
+const (
+	maxRotationDays     = 84 // 12-week cycles
+	maxGenerateDays     = 92
+	maxGenerateUsers    = 500
+	maxGeneratedShifts  = 5000
+	defaultGenerateDays = 5 // Monday to Friday when a template has no weekdays
+)
+
+var (
+	// ErrShiftTemplateNotFound is returned when a shift template does not exist
+	ErrShiftTemplateNotFound = errors.New("shift template not found")
+	// ErrRotationNotFound is returned when a rotation does not exist
+	ErrRotationNotFound = errors.New("rotation not found")
+)
+
+// ShiftTemplate is a reusable shift, e.g. "Early 06:00-14:00". A shift whose
+// end is not after its start ends on the next day.
+type ShiftTemplate struct {
+	ID        int       `json:"id"`
+	CompanyID int       `json:"company_id"`
+	Name      string    `json:"name"`
+	StartTime string    `json:"start_time"` // HH:MM in the company time zone
+	EndTime   string    `json:"end_time"`   // HH:MM in the company time zone
+	SiteID    *int      `json:"site_id,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Rotation is a repeating cycle of shift templates, one entry per day. A
+// null entry is a day off, so 4-on/4-off is four template IDs followed by
+// four nulls.
+type Rotation struct {
+	ID        int       `json:"id"`
+	CompanyID int       `json:"company_id"`
+	Name      string    `json:"name"`
+	Days      []*int    `json:"days"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GenerateScheduleRequest describes draft shifts to lay out for a set of
+// employees, from a template on fixed weekdays or from a rotation
+type GenerateScheduleRequest struct {
+	UserIDs      []int    `json:"user_ids"`
+	TemplateID   *int     `json:"template_id"`
+	Weekdays     []int    `json:"weekdays"` // with template_id, 1 = Monday to 7 = Sunday
+	RotationID   *int     `json:"rotation_id"`
+	StartDate    string   `json:"start_date"`
+	EndDate      string   `json:"end_date"`
+	AnchorDate   string   `json:"anchor_date"` // first day of the rotation cycle, defaults to start_date
+	OffsetDays   int      `json:"offset_days"` // moves the cycle forward, e.g. for a second crew
+	SkipHolidays *bool    `json:"skip_holidays"`
+	SkipDates    []string `json:"skip_dates"`
+	Preview      bool     `json:"preview"`
+}
+
+// SkippedShift is a generated shift that was left out of the roster
+type SkippedShift struct {
+	UserID int    `json:"user_id"`
+	Date   string `json:"date"`
+	Reason string `json:"reason"`
+}
+
+// GenerateScheduleResult lists the shifts generated, or that would be in preview mode
+type GenerateScheduleResult struct {
+	Shifts  []ScheduledShift `json:"shifts"`
+	Skipped []SkippedShift   `json:"skipped"`
+	Preview bool             `json:"preview"`
+}
+
+const shiftTemplateColumns = `id, company_id, name, to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI'),
+	site_id, COALESCE(role, ''), created_at, updated_at`
+
+func scanShiftTemplate(row scanner) (*ShiftTemplate, error) {
+	t := &ShiftTemplate{}
+	err := row.Scan(&t.ID, &t.CompanyID, &t.Name, &t.StartTime, &t.EndTime, &t.SiteID, &t.Role, &t.CreatedAt, &t.UpdatedAt)
+	return t, err
+}
+
+// validate checks the template fields and that its site belongs to the company
+func (t *ShiftTemplate) validate(q querier) error {
+	if strings.TrimSpace(t.Name) == "" {
+		return errors.New("name is required")
+	}
+	start, err := time.Parse("15:04", t.StartTime)
+	if err != nil {
+		return errors.New("start_time must be in HH:MM format")
+	}
+	end, err := time.Parse("15:04", t.EndTime)
+	if err != nil {
+		return errors.New("end_time must be in HH:MM format")
+	}
+	if start.Equal(end) {
+		return errors.New("end_time must differ from start_time")
+	}
+	if len(t.Role) > 100 {
+		return errors.New("role is too long")
+	}
+
+	if t.SiteID != nil {
+		var exists bool
+		err := q.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM work_sites WHERE id = $1 AND company_id = $2)
+		`, *t.SiteID, t.CompanyID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrSiteNotFound
+		}
+	}
+	return nil
+}
+
+// times returns the start and end of the template's shift on a date
+func (t *ShiftTemplate) times(day time.Time) (time.Time, time.Time) {
+	start, _ := time.Parse("15:04", t.StartTime)
+	end, _ := time.Parse("15:04", t.EndTime)
+
+	y, m, d := day.Date()
+	from := time.Date(y, m, d, start.Hour(), start.Minute(), 0, 0, day.Location())
+	to := time.Date(y, m, d, end.Hour(), end.Minute(), 0, 0, day.Location())
+	if !to.After(from) {
+		to = time.Date(y, m, d+1, end.Hour(), end.Minute(), 0, 0, day.Location())
+	}
+	return from, to
+}
+
+// CreateShiftTemplate creates a shift template
+func CreateShiftTemplate(db *sql.DB, t *ShiftTemplate) (*ShiftTemplate, error) {
+	if err := t.validate(db); err != nil {
+		return nil, err
+	}
+
+	return scanShiftTemplate(db.QueryRow(`
+		INSERT INTO shift_templates (company_id, name, start_time, end_time, site_id, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+shiftTemplateColumns,
+		t.CompanyID, t.Name, t.StartTime, t.EndTime, t.SiteID, t.Role,
+	))
+}
+
+// UpdateShiftTemplate updates a shift template; shifts already scheduled from it are unchanged
+func UpdateShiftTemplate(db *sql.DB, t *ShiftTemplate) (*ShiftTemplate, error) {
+	if err := t.validate(db); err != nil {
+		return nil, err
+	}
+
+	updated, err := scanShiftTemplate(db.QueryRow(`
+		UPDATE shift_templates
+		SET name = $1, start_time = $2, end_time = $3, site_id = $4, role = NULLIF($5, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6 AND company_id = $7
+		RETURNING `+shiftTemplateColumns,
+		t.Name, t.StartTime, t.EndTime, t.SiteID, t.Role, t.ID, t.CompanyID,
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrShiftTemplateNotFound
+	}
+	return updated, err
+}
+
+// DeleteShiftTemplate deletes a shift template that no rotation uses
+func DeleteShiftTemplate(db *sql.DB, companyID, id int) error {
+	var used bool
+	err := db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM shift_rotations WHERE company_id = $1 AND days @> to_jsonb($2::int))
+	`, companyID, id).Scan(&used)
+	if err != nil {
+		return err
+	}
+	if used {
+		return errors.New("shift template is used by a rotation")
+	}
+
+	result, err := db.Exec(`DELETE FROM shift_templates WHERE id = $1 AND company_id = $2`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrShiftTemplateNotFound
+	}
+	return nil
+}
+
+// GetShiftTemplates retrieves a company's shift templates
+func GetShiftTemplates(db *sql.DB, companyID int) ([]ShiftTemplate, error) {
+	rows, err := db.Query(`
+		SELECT `+shiftTemplateColumns+`
+		FROM shift_templates
+		WHERE company_id = $1
+		ORDER BY start_time, name
+	`, companyID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []ShiftTemplate{}
+	for rows.Next() {
+		t, err := scanShiftTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *t)
+	}
+
+	return templates, rows.Err()
+}
+
+const rotationColumns = `id, company_id, name, days, created_at, updated_at`
+
+func scanRotation(row scanner) (*Rotation, error) {
+	r := &Rotation{}
+	var days []byte
+	if err := row.Scan(&r.ID, &r.CompanyID, &r.Name, &days, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return r, err
+	}
+	return r, json.Unmarshal(days, &r.Days)
+}
+
+// validate checks the rotation's cycle and that its templates belong to the company
+func (r *Rotation) validate(q querier) error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	if len(r.Days) == 0 || len(r.Days) > maxRotationDays {
+		return fmt.Errorf("days must have between 1 and %d entries", maxRotationDays)
+	}
+
+	var templateIDs []int
+	for _, id := range r.Days {
+		if id != nil && !containsID(templateIDs, *id) {
+			templateIDs = append(templateIDs, *id)
+		}
+	}
+	if len(templateIDs) == 0 {
+		return errors.New("a rotation needs at least one working day")
+	}
+
+	var found int
+	err := q.QueryRow(`
+		SELECT COUNT(*) FROM shift_templates WHERE company_id = $1 AND id = ANY($2)
+	`, r.CompanyID, pq.Array(templateIDs)).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != len(templateIDs) {
+		return ErrShiftTemplateNotFound
+	}
+	return nil
+}
+
+// CreateRotation creates a rotation
+func CreateRotation(db *sql.DB, r *Rotation) (*Rotation, error) {
+	if err := r.validate(db); err != nil {
+		return nil, err
+	}
+	days, err := json.Marshal(r.Days)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRotation(db.QueryRow(`
+		INSERT INTO shift_rotations (company_id, name, days, created_at, updated_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+rotationColumns,
+		r.CompanyID, r.Name, days,
+	))
+}
+
+// UpdateRotation updates a rotation
+func UpdateRotation(db *sql.DB, r *Rotation) (*Rotation, error) {
+	if err := r.validate(db); err != nil {
+		return nil, err
+	}
+	days, err := json.Marshal(r.Days)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := scanRotation(db.QueryRow(`
+		UPDATE shift_rotations
+		SET name = $1, days = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND company_id = $4
+		RETURNING `+rotationColumns,
+		r.Name, days, r.ID, r.CompanyID,
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrRotationNotFound
+	}
+	return updated, err
+}
+
+// DeleteRotation deletes a rotation
+func DeleteRotation(db *sql.DB, companyID, id int) error {
+	result, err := db.Exec(`DELETE FROM shift_rotations WHERE id = $1 AND company_id = $2`, id, companyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrRotationNotFound
+	}
+	return nil
+}
+
+// GetRotations retrieves a company's rotations
+func GetRotations(db *sql.DB, companyID int) ([]Rotation, error) {
+	rows, err := db.Query(`
+		SELECT `+rotationColumns+`
+		FROM shift_rotations
+		WHERE company_id = $1
+		ORDER BY name
+	`, companyID)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rotations := []Rotation{}
+	for rows.Next() {
+		r, err := scanRotation(rows)
+		if err != nil {
+			return nil, err
+		}
+		rotations = append(rotations, *r)
+	}
+
+	return rotations, rows.Err()
+}
+
+// GenerateSchedule lays out draft shifts for each employee and day of the
+// request, in the company time zone. Days on a holiday or in skip_dates,
+// an employee's approved leave, and shifts that would double-book an
+// employee, are skipped and reported.
+// In preview mode nothing is saved.
+func GenerateSchedule(db *sql.DB, companyID, createdBy int, req *GenerateScheduleRequest, loc *time.Location) (*GenerateScheduleResult, error) {
+	from, err := time.ParseInLocation("2006-01-02", req.StartDate, loc)
+	if err != nil {
+		return nil, errors.New("start_date must be a date in YYYY-MM-DD format")
+	}
+	to, err := time.ParseInLocation("2006-01-02", req.EndDate, loc)
+	if err != nil {
+		return nil, errors.New("end_date must be a date in YYYY-MM-DD format")
+	}
+	days := calendarDays(from, to) + 1
+	if days < 1 || days > maxGenerateDays {
+		return nil, fmt.Errorf("date range must be between 1 and %d days", maxGenerateDays)
+	}
+	anchor := from
+	if req.AnchorDate != "" {
+		if anchor, err = time.ParseInLocation("2006-01-02", req.AnchorDate, loc); err != nil {
+			return nil, errors.New("anchor_date must be a date in YYYY-MM-DD format")
+		}
+	}
+
+	var userIDs []int
+	for _, id := range req.UserIDs {
+		if !containsID(userIDs, id) {
+			userIDs = append(userIDs, id)
+		}
+	}
+	if len(userIDs) == 0 || len(userIDs) > maxGenerateUsers {
+		return nil, fmt.Errorf("user_ids must list between 1 and %d employees", maxGenerateUsers)
+	}
+	if (req.TemplateID == nil) == (req.RotationID == nil) {
+		return nil, errors.New("exactly one of template_id and rotation_id is required")
+	}
+
+	weekdays := req.Weekdays
+	if req.TemplateID != nil && len(weekdays) == 0 {
+		for d := 1; d <= defaultGenerateDays; d++ {
+			weekdays = append(weekdays, d)
+		}
+	}
+	for _, d := range weekdays {
+		if d < 1 || d > 7 {
+			return nil, errors.New("weekdays must be between 1 (Monday) and 7 (Sunday)")
+		}
+	}
+
+	skipDates := map[string]string{}
+	for _, d := range req.SkipDates {
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return nil, errors.New("skip_dates must be dates in YYYY-MM-DD format")
+		}
+		skipDates[d] = "skipped date"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	names := map[int]string{}
+	rows, err := tx.Query(`
+		SELECT id, full_name FROM users WHERE company_id = $1 AND id = ANY($2) AND is_active = true
+	`, companyID, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names[id] = name
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range userIDs {
+		if _, ok := names[id]; !ok {
+			return nil, fmt.Errorf("user %d not found", id)
+		}
+	}
+
+	templates := map[int]*ShiftTemplate{}
+	rows, err = tx.Query(`SELECT `+shiftTemplateColumns+` FROM shift_templates WHERE company_id = $1`, companyID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		t, err := scanShiftTemplate(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		templates[t.ID] = t
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var cycle []*int
+	if req.RotationID != nil {
+		rotation, err := scanRotation(tx.QueryRow(`
+			SELECT `+rotationColumns+` FROM shift_rotations WHERE id = $1 AND company_id = $2
+		`, *req.RotationID, companyID))
+		if err == sql.ErrNoRows {
+			return nil, ErrRotationNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		cycle = rotation.Days
+	} else if templates[*req.TemplateID] == nil {
+		return nil, ErrShiftTemplateNotFound
+	}
+
+	if req.SkipHolidays == nil || *req.SkipHolidays {
+		holidays, err := holidayDates(tx, companyID, req.StartDate, req.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		for date, name := range holidays {
+			skipDates[date] = "holiday: " + name
+		}
+	}
+
+	onLeave, err := approvedLeaveDates(tx, companyID, userIDs, req.StartDate, req.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Existing shifts that generated ones could overlap, including night
+	// shifts running into the range from either side
+	type interval struct {
+		id         int
+		start, end time.Time
+	}
+	booked := map[int][]interval{}
+	rows, err = tx.Query(`
+		SELECT id, user_id, start_time, end_time FROM scheduled_shifts
+		WHERE company_id = $1 AND user_id = ANY($2) AND status <> 'cancelled'
+		  AND start_time < $4 AND end_time > $3
+	`, companyID, pq.Array(userIDs), from.AddDate(0, 0, -1).UTC(), to.AddDate(0, 0, 2).UTC())
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var userID int
+		var b interval
+		if err := rows.Scan(&b.id, &userID, &b.start, &b.end); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		booked[userID] = append(booked[userID], b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &GenerateScheduleResult{Shifts: []ScheduledShift{}, Skipped: []SkippedShift{}, Preview: req.Preview}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		var template *ShiftTemplate
+		if cycle != nil {
+			i := (calendarDays(anchor, day) + req.OffsetDays) % len(cycle)
+			if i < 0 {
+				i += len(cycle)
+			}
+			if cycle[i] != nil {
+				template = templates[*cycle[i]]
+			}
+		} else if containsID(weekdays, (int(day.Weekday())+6)%7+1) {
+			template = templates[*req.TemplateID]
+		}
+		if template == nil {
+			continue // day off
+		}
+
+		date := day.Format("2006-01-02")
+		start, end := template.times(day)
+		for _, userID := range userIDs {
+			if reason, ok := skipDates[date]; ok {
+				result.Skipped = append(result.Skipped, SkippedShift{UserID: userID, Date: date, Reason: reason})
+				continue
+			}
+			if leaveType, ok := onLeave[userID][date]; ok {
+				result.Skipped = append(result.Skipped, SkippedShift{UserID: userID, Date: date, Reason: "on leave: " + leaveType})
+				continue
+			}
+
+			conflict := ""
+			for _, b := range booked[userID] {
+				if b.start.Before(end) && b.end.After(start) {
+					if b.id == 0 {
+						conflict = "overlaps another generated shift"
+					} else {
+						conflict = fmt.Sprintf("overlaps scheduled shift #%d", b.id)
+					}
+					break
+				}
+			}
+			if conflict != "" {
+				result.Skipped = append(result.Skipped, SkippedShift{UserID: userID, Date: date, Reason: conflict})
+				continue
+			}
+
+			if len(result.Shifts) == maxGeneratedShifts {
+				return nil, fmt.Errorf("more than %d shifts would be generated; use a shorter range or fewer employees", maxGeneratedShifts)
+			}
+			booked[userID] = append(booked[userID], interval{start: start, end: end})
+			result.Shifts = append(result.Shifts, ScheduledShift{
+				CompanyID: companyID,
+				UserID:    userID,
+				SiteID:    template.SiteID,
+				Role:      template.Role,
+				StartTime: start.UTC(),
+				EndTime:   end.UTC(),
+				Notes:     template.Name,
+				Status:    "draft",
+				CreatedBy: &createdBy,
+				FullName:  names[userID],
+			})
+		}
+	}
+
+	if req.Preview {
+		return result, nil
+	}
+
+	for i := range result.Shifts {
+		ss := &result.Shifts[i]
+		err = tx.QueryRow(`
+			INSERT INTO scheduled_shifts (company_id, user_id, site_id, role, start_time, end_time, notes, status,
+			                              created_by, created_at, updated_at)
+			VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, NULLIF($7, ''), 'draft', $8, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			RETURNING id, created_at, updated_at
+		`, ss.CompanyID, ss.UserID, ss.SiteID, ss.Role, ss.StartTime, ss.EndTime, ss.Notes, ss.CreatedBy,
+		).Scan(&ss.ID, &ss.CreatedAt, &ss.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// calendarDays returns the number of calendar days from one date to
+// another, ignoring daylight saving changes in between
+func calendarDays(from, to time.Time) int {
+	fy, fm, fd := from.Date()
+	ty, tm, td := to.Date()
+	a := time.Date(fy, fm, fd, 0, 0, 0, 0, time.UTC)
+	b := time.Date(ty, tm, td, 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
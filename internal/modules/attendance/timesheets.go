@@ -0,0 +1,541 @@
+package attendance
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"modular-erp/internal/core/events"
+	"modular-erp/internal/core/notifications"
+)
+
+// Timesheet statuses
+const (
+	TimesheetStatusOpen      = "open"
+	TimesheetStatusSubmitted = "submitted"
+	TimesheetStatusApproved  = "approved"
+	TimesheetStatusRejected  = "rejected"
+)
+
+// Notification kinds sent for timesheets
+const (
+	NotificationTimesheetSubmitted = "timesheet.submitted"
+	NotificationTimesheetReviewed  = "timesheet.reviewed"
+	NotificationTimesheetReopened  = "timesheet.reopened"
+)
+
+// ErrTimesheetNotFound is returned when a timesheet does not exist or is not visible to the caller
+var ErrTimesheetNotFound = errors.New("timesheet not found")
+
+// ErrPeriodLocked is returned when a change touches a pay period whose timesheet is approved
+var ErrPeriodLocked = errors.New("pay period is locked by an approved timesheet; an admin must reopen it first")
+
+// Timesheet is an employee's hours over one pay period. It is submitted by
+// the employee and approved or rejected by a manager; approval locks the
+// period's shifts until an admin reopens it. Totals are computed from the
+// current shifts, while SubmittedHours and ApprovedHours record the total
+// hours at submission and approval.
+type Timesheet struct {
+	ID             int         `json:"id,omitempty"` // 0 until the timesheet is first submitted
+	CompanyID      int         `json:"company_id"`
+	UserID         int         `json:"user_id"`
+	FullName       string      `json:"full_name"`
+	PeriodStart    string      `json:"period_start"` // YYYY-MM-DD
+	PeriodEnd      string      `json:"period_end"`   // YYYY-MM-DD, inclusive
+	Status         string      `json:"status"`       // open, submitted, approved, rejected
+	Totals         ShiftReport `json:"totals"`
+	LeaveHours     float64     `json:"leave_hours"` // approved leave taken in the period
+	SubmittedHours *float64    `json:"submitted_hours,omitempty"`
+	ApprovedHours  *float64    `json:"approved_hours,omitempty"`
+	Comment        string      `json:"comment,omitempty"` // the employee's note on submission
+	SubmittedAt    *time.Time  `json:"submitted_at,omitempty"`
+	ReviewedBy     *int        `json:"reviewed_by,omitempty"`
+	ReviewedAt     *time.Time  `json:"reviewed_at,omitempty"`
+	ReviewComment  string      `json:"review_comment,omitempty"`
+	ReopenedBy     *int        `json:"reopened_by,omitempty"`
+	ReopenedAt     *time.Time  `json:"reopened_at,omitempty"`
+	ReopenComment  string      `json:"reopen_comment,omitempty"`
+	Shifts         []Shift     `json:"shifts,omitempty"`
+	UpdatedAt      *time.Time  `json:"updated_at,omitempty"`
+}
+
+// timesheetColumns lists the columns scanned by scanTimesheet; timesheets
+// must be aliased as "t" and joined to users as "u"
+const timesheetColumns = `t.id, t.company_id, t.user_id, u.full_name, to_char(t.period_start, 'YYYY-MM-DD'),
+	to_char(t.period_end, 'YYYY-MM-DD'), t.status, t.submitted_hours, t.approved_hours, COALESCE(t.comment, ''),
+	t.submitted_at, t.reviewed_by, t.reviewed_at, COALESCE(t.review_comment, ''),
+	t.reopened_by, t.reopened_at, COALESCE(t.reopen_comment, ''), t.updated_at`
+
+func scanTimesheet(row scanner) (*Timesheet, error) {
+	t := &Timesheet{}
+	var updatedAt time.Time
+	err := row.Scan(
+		&t.ID, &t.CompanyID, &t.UserID, &t.FullName, &t.PeriodStart,
+		&t.PeriodEnd, &t.Status, &t.SubmittedHours, &t.ApprovedHours, &t.Comment,
+		&t.SubmittedAt, &t.ReviewedBy, &t.ReviewedAt, &t.ReviewComment,
+		&t.ReopenedBy, &t.ReopenedAt, &t.ReopenComment, &updatedAt,
+	)
+	t.UpdatedAt = &updatedAt
+	return t, err
+}
+
+// periodFilter is the shift filter covering the days of a pay period in loc
+func periodFilter(companyID int, userIDs []int, start, end time.Time, loc *time.Location) ShiftFilter {
+	return ShiftFilter{
+		CompanyID: companyID,
+		StartDate: time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc),
+		EndDate:   time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, loc).Add(-time.Microsecond),
+		UserIDs:   userIDs,
+	}
+}
+
+// localDay returns the date of t in loc, at midnight UTC
+func localDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// checkPeriodOpen rejects changes to a shift starting at any of the given
+// times when its pay period has an approved timesheet. The timesheets are
+// share-locked so a concurrent approval waits for the change to commit.
+func checkPeriodOpen(q querier, userID int, times ...time.Time) error {
+	for _, at := range times {
+		rows, err := q.Query(`
+			SELECT t.status
+			FROM timesheets t
+			JOIN companies c ON t.company_id = c.id
+			WHERE t.user_id = $1
+			  AND ($2::timestamptz AT TIME ZONE COALESCE(NULLIF(c.timezone, ''), 'UTC'))::date
+			      BETWEEN t.period_start AND t.period_end
+			FOR SHARE OF t
+		`, userID, at)
+		if err != nil {
+			return err
+		}
+
+		locked := false
+		for rows.Next() {
+			var status string
+			if err := rows.Scan(&status); err != nil {
+				rows.Close()
+				return err
+			}
+			locked = locked || status == TimesheetStatusApproved
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if locked {
+			return ErrPeriodLocked
+		}
+	}
+	return nil
+}
+
+// GetUserTimesheet retrieves an employee's timesheet for the pay period
+// containing day, with its shifts
+func GetUserTimesheet(db *sql.DB, companyID, userID int, policy *Policy, day time.Time, loc *time.Location) (*Timesheet, error) {
+	start, end := policy.PayPeriodOf(day)
+
+	t, err := scanTimesheet(db.QueryRow(`
+		SELECT `+timesheetColumns+`
+		FROM timesheets t
+		JOIN users u ON t.user_id = u.id
+		WHERE t.user_id = $1 AND t.company_id = $2 AND t.period_start = $3
+	`, userID, companyID, start.Format("2006-01-02")))
+
+	if err == sql.ErrNoRows {
+		t = &Timesheet{
+			CompanyID:   companyID,
+			UserID:      userID,
+			PeriodStart: start.Format("2006-01-02"),
+			PeriodEnd:   end.Format("2006-01-02"),
+			Status:      TimesheetStatusOpen,
+		}
+		err = db.QueryRow(`SELECT full_name FROM users WHERE id = $1 AND company_id = $2`, userID, companyID).Scan(&t.FullName)
+		if err == sql.ErrNoRows {
+			return nil, ErrTimesheetNotFound
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return t, fillTimesheet(db, t, loc)
+}
+
+// GetTimesheet retrieves a submitted timesheet with its shifts. userIDs
+// limits whose timesheets may be seen; nil means any in the company.
+func GetTimesheet(db *sql.DB, companyID, id int, userIDs []int, loc *time.Location) (*Timesheet, error) {
+	t, err := getTimesheet(db, companyID, id, userIDs, false)
+	if err != nil {
+		return nil, err
+	}
+	return t, fillTimesheet(db, t, loc)
+}
+
+// fillTimesheet computes a timesheet's totals and leave and loads its shifts
+func fillTimesheet(db *sql.DB, t *Timesheet, loc *time.Location) error {
+	start, _ := time.Parse("2006-01-02", t.PeriodStart)
+	end, _ := time.Parse("2006-01-02", t.PeriodEnd)
+	filter := periodFilter(t.CompanyID, []int{t.UserID}, start, end, loc)
+
+	totals, err := GetShiftReport(db, filter)
+	if err != nil {
+		return err
+	}
+	t.Totals = *totals
+
+	leave, err := leaveHoursByUser(db, t.CompanyID, []int{t.UserID}, t.PeriodStart, t.PeriodEnd)
+	if err != nil {
+		return err
+	}
+	t.LeaveHours = leave[t.UserID]
+
+	t.Shifts = []Shift{}
+	return StreamCompanyShifts(db, filter, func(shift *ShiftWithUserInfo) error {
+		t.Shifts = append(t.Shifts, shift.Shift)
+		return nil
+	})
+}
+
+// getTimesheet retrieves a company timesheet, optionally locking it.
+// userIDs limits whose timesheets may be seen; nil means any in the company.
+func getTimesheet(q querier, companyID, id int, userIDs []int, forUpdate bool) (*Timesheet, error) {
+	lock := ""
+	if forUpdate {
+		lock = "FOR UPDATE OF t"
+	}
+
+	t, err := scanTimesheet(q.QueryRow(`
+		SELECT `+timesheetColumns+`
+		FROM timesheets t
+		JOIN users u ON t.user_id = u.id
+		WHERE t.id = $1 AND t.company_id = $2 AND ($3::int[] IS NULL OR t.user_id = ANY($3))
+		`+lock,
+		id, companyID, pq.Array(userIDs),
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTimesheetNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// GetTimesheets lists the timesheets of the pay period containing day for
+// every active employee, and anyone who already submitted one, with their
+// totals and leave. userIDs limits the employees; nil means every user in the company.
+// status filters by timesheet status, "" meaning any.
+func GetTimesheets(db *sql.DB, companyID int, userIDs []int, policy *Policy, day time.Time, loc *time.Location, status string) ([]Timesheet, error) {
+	start, end := policy.PayPeriodOf(day)
+
+	rows, err := db.Query(`
+		SELECT t.id, u.id, u.full_name, COALESCE(t.status, 'open'), t.submitted_hours, t.approved_hours,
+		       COALESCE(t.comment, ''), t.submitted_at, t.reviewed_by, t.reviewed_at, COALESCE(t.review_comment, ''),
+		       t.reopened_by, t.reopened_at, COALESCE(t.reopen_comment, ''), t.updated_at
+		FROM users u
+		LEFT JOIN timesheets t ON t.user_id = u.id AND t.period_start = $3
+		WHERE u.company_id = $1 AND ($2::int[] IS NULL OR u.id = ANY($2))
+		  AND (u.is_active = true OR t.id IS NOT NULL)
+		  AND ($4 = '' OR COALESCE(t.status, 'open') = $4)
+		ORDER BY u.full_name, u.id
+	`, companyID, pq.Array(userIDs), start.Format("2006-01-02"), status)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	timesheets := []Timesheet{}
+	for rows.Next() {
+		t := Timesheet{
+			CompanyID:   companyID,
+			PeriodStart: start.Format("2006-01-02"),
+			PeriodEnd:   end.Format("2006-01-02"),
+		}
+		var id sql.NullInt64
+		err := rows.Scan(
+			&id, &t.UserID, &t.FullName, &t.Status, &t.SubmittedHours, &t.ApprovedHours,
+			&t.Comment, &t.SubmittedAt, &t.ReviewedBy, &t.ReviewedAt, &t.ReviewComment,
+			&t.ReopenedBy, &t.ReopenedAt, &t.ReopenComment, &t.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		t.ID = int(id.Int64)
+		timesheets = append(timesheets, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	groups, err := GetShiftReportGroups(db, periodFilter(companyID, userIDs, start, end, loc), GroupByEmployee, "", false, loc.String())
+	if err != nil {
+		return nil, err
+	}
+	totals := make(map[int]ShiftReport, len(groups))
+	for _, g := range groups {
+		if id, err := strconv.Atoi(g.Key); err == nil {
+			totals[id] = g.ShiftReport
+		}
+	}
+	leave, err := leaveHoursByUser(db, companyID, userIDs, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	for i := range timesheets {
+		timesheets[i].Totals = totals[timesheets[i].UserID]
+		timesheets[i].LeaveHours = leave[timesheets[i].UserID]
+	}
+
+	return timesheets, nil
+}
+
+// SubmitTimesheet submits an employee's timesheet for the pay period
+// containing day, from the period's last day on. Open or rejected timesheets
+// can be submitted; the employee's manager is notified.
+func SubmitTimesheet(db *sql.DB, companyID, userID int, policy *Policy, day time.Time, loc *time.Location, comment string) (*Timesheet, error) {
+	start, end := policy.PayPeriodOf(day)
+	if localDay(time.Now(), loc).Before(end) {
+		return nil, errors.New("timesheets can be submitted from the last day of the pay period")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Serialize with manager changes to the employee's shifts
+	if _, err := tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return nil, err
+	}
+
+	totals, err := GetShiftReport(tx, periodFilter(companyID, []int{userID}, start, end, loc))
+	if err != nil {
+		return nil, err
+	}
+	if totals.ActiveShifts > 0 {
+		return nil, errors.New("clock out before submitting the timesheet")
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO timesheets AS t (company_id, user_id, period_start, period_end, status, submitted_hours,
+		                             comment, submitted_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, 'submitted', $5, NULLIF($6, ''), CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, period_start) DO UPDATE
+		SET status = 'submitted', submitted_hours = EXCLUDED.submitted_hours, approved_hours = NULL,
+		    comment = EXCLUDED.comment, submitted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE t.status IN ('open', 'rejected')
+		RETURNING t.id
+	`, companyID, userID, start.Format("2006-01-02"), end.Format("2006-01-02"), totals.TotalHours, comment).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("timesheet has already been submitted")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := getTimesheet(tx, companyID, id, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	leave, err := leaveHoursByUser(tx, companyID, []int{userID}, t.PeriodStart, t.PeriodEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	err = notifications.NotifyManager(tx, companyID, userID, NotificationTimesheetSubmitted,
+		"Timesheet submitted",
+		fmt.Sprintf("%s submitted their timesheet for %s to %s (%.2f hours) for approval.",
+			t.FullName, t.PeriodStart, t.PeriodEnd, totals.TotalHours),
+		map[string]interface{}{"timesheet_id": t.ID, "user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	t.Totals = *totals
+	t.LeaveHours = leave[userID]
+	return t, nil
+}
+
+// ReviewTimesheet approves or rejects a submitted timesheet; rejections need
+// a comment. Approval requires every shift of the period to be closed and
+// reviewed, records the approved hours and locks the period. Reviewers
+// cannot review their own timesheets. The employee is notified either way.
+func ReviewTimesheet(db *sql.DB, companyID, id, reviewerID int, userIDs []int, approve bool, comment string, loc *time.Location) (*Timesheet, error) {
+	if !approve && comment == "" {
+		return nil, errors.New("a comment is required to reject a timesheet")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t, err := getTimesheet(tx, companyID, id, userIDs, true)
+	if err != nil {
+		return nil, err
+	}
+	if t.Status != TimesheetStatusSubmitted {
+		return nil, errors.New("only submitted timesheets can be reviewed")
+	}
+	if t.UserID == reviewerID {
+		return nil, errors.New("you cannot review your own timesheet")
+	}
+
+	start, _ := time.Parse("2006-01-02", t.PeriodStart)
+	end, _ := time.Parse("2006-01-02", t.PeriodEnd)
+	totals, err := GetShiftReport(tx, periodFilter(companyID, []int{t.UserID}, start, end, loc))
+	if err != nil {
+		return nil, err
+	}
+
+	status := TimesheetStatusRejected
+	var approvedHours *float64
+	if approve {
+		if totals.ActiveShifts > 0 {
+			return nil, errors.New("the employee still has a shift in progress in this pay period")
+		}
+		if totals.PendingReview > 0 {
+			return nil, errors.New("review the flagged shifts of this pay period first")
+		}
+		status = TimesheetStatusApproved
+		approvedHours = &totals.TotalHours
+	}
+
+	_, err = tx.Exec(`
+		UPDATE timesheets
+		SET status = $1, approved_hours = $2, reviewed_by = $3, reviewed_at = CURRENT_TIMESTAMP,
+		    review_comment = NULLIF($4, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5
+	`, status, approvedHours, reviewerID, comment, t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if approve {
+		err = events.Publish(tx, companyID, TimesheetApproved{
+			TimesheetID: t.ID,
+			UserID:      t.UserID,
+			CompanyID:   companyID,
+			PeriodStart: t.PeriodStart,
+			PeriodEnd:   t.PeriodEnd,
+			Hours:       totals.TotalHours,
+			ApprovedBy:  reviewerID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	body := fmt.Sprintf("Your timesheet for %s to %s was %s.", t.PeriodStart, t.PeriodEnd, status)
+	if comment != "" {
+		body += " " + comment
+	}
+	err = notifications.Notify(tx, companyID, t.UserID, NotificationTimesheetReviewed,
+		"Timesheet "+status, body,
+		map[string]interface{}{"timesheet_id": t.ID, "status": status})
+	if err != nil {
+		return nil, err
+	}
+
+	reviewed, err := getTimesheet(tx, companyID, t.ID, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	leave, err := leaveHoursByUser(tx, companyID, []int{t.UserID}, t.PeriodStart, t.PeriodEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	reviewed.Totals = *totals
+	reviewed.LeaveHours = leave[t.UserID]
+	return reviewed, nil
+}
+
+// ReopenTimesheet unlocks an approved timesheet's pay period so its shifts
+// can be changed again; the employee must then resubmit it. Submitted
+// timesheets can be reopened too, handing them back to the employee.
+func ReopenTimesheet(db *sql.DB, companyID, id, adminID int, comment string) (*Timesheet, error) {
+	if comment == "" {
+		return nil, errors.New("a comment is required to reopen a timesheet")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t, err := getTimesheet(tx, companyID, id, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if t.Status != TimesheetStatusApproved && t.Status != TimesheetStatusSubmitted {
+		return nil, errors.New("only submitted or approved timesheets can be reopened")
+	}
+
+	_, err = tx.Exec(`
+		UPDATE timesheets
+		SET status = 'open', reopened_by = $1, reopened_at = CURRENT_TIMESTAMP, reopen_comment = $2,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`, adminID, comment, t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Status == TimesheetStatusApproved {
+		err = events.Publish(tx, companyID, TimesheetReopened{
+			TimesheetID: t.ID,
+			UserID:      t.UserID,
+			CompanyID:   companyID,
+			PeriodStart: t.PeriodStart,
+			PeriodEnd:   t.PeriodEnd,
+			ReopenedBy:  adminID,
+			Comment:     comment,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = notifications.Notify(tx, companyID, t.UserID, NotificationTimesheetReopened,
+		"Timesheet reopened",
+		fmt.Sprintf("Your timesheet for %s to %s was reopened and needs to be submitted again. %s",
+			t.PeriodStart, t.PeriodEnd, comment),
+		map[string]interface{}{"timesheet_id": t.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	reopened, err := getTimesheet(tx, companyID, t.ID, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return reopened, nil
+}